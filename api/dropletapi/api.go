@@ -0,0 +1,70 @@
+// Package dropletapi extends the venus market api with the apis which are only provided by droplet
+package dropletapi
+
+import (
+	"context"
+
+	marketapi "github.com/filecoin-project/venus/venus-shared/api/market/v1"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs-force-community/droplet/v2/types"
+)
+
+type IDroplet interface {
+	marketapi.IMarket
+
+	// RetrievalStats lists the retrieval accounting per client, payload and miner
+	RetrievalStats(ctx context.Context, params *types.RetrievalStatsQueryParams) ([]*types.RetrievalStats, error) //perm:read
+
+	// RetrievalListViolations lists the retrieval peers which violated the retrieval limits
+	RetrievalListViolations(ctx context.Context) ([]*types.RetrievalPeerViolations, error) //perm:read
+	// RetrievalUnbanPeer removes the peer from the retrieval ban list
+	RetrievalUnbanPeer(ctx context.Context, p peer.ID) error //perm:admin
+
+	// PiecesLocateCID returns every piece containing the cid and the deals of the pieces, the offsets of the block
+	// are returned when they are known
+	PiecesLocateCID(ctx context.Context, c cid.Cid) ([]*types.CIDLocation, error) //perm:read
+
+	// PieceStorageGC deletes the pieces which are no longer referenced by any non-terminal deal from the piece storages
+	PieceStorageGC(ctx context.Context, params *types.PieceGCParams) (*types.PieceGCReport, error) //perm:admin
+	// PieceStorageHealth returns the read health state of the piece storages
+	PieceStorageHealth(ctx context.Context) ([]*types.PieceStorageHealth, error) //perm:read
+	// PieceStorageUsage returns the space used by the object piece storages
+	PieceStorageUsage(ctx context.Context) ([]*types.PieceStorageUsage, error) //perm:read
+	// PieceStorageUpdate changes the settings of a piece storage without restart, the storage is probed before it's applied
+	PieceStorageUpdate(ctx context.Context, name string, params *types.PieceStorageUpdateParams) error //perm:admin
+	// PieceStorageDrain sets whether a piece storage is draining, a draining storage is read only until it's removed
+	PieceStorageDrain(ctx context.Context, name string, draining bool) error //perm:admin
+
+	// PieceStorageMigrate starts a job to copy the pieces from a piece storage to another
+	PieceStorageMigrate(ctx context.Context, params *types.PieceMigrationParams) (*types.PieceMigrationJob, error) //perm:admin
+	// PieceStorageMigrationList lists the piece migration jobs, the latest first
+	PieceStorageMigrationList(ctx context.Context) ([]*types.PieceMigrationJob, error) //perm:read
+	// PieceStorageMigrationCancel stops a running piece migration job
+	PieceStorageMigrationCancel(ctx context.Context, id string) error //perm:admin
+	// PieceStorageMigrationResume continues a canceled or failed piece migration job
+	PieceStorageMigrationResume(ctx context.Context, id string) error //perm:admin
+
+	// PieceStorageScrubStatus returns the state of the piece scrubber and the pieces which failed to verify
+	PieceStorageScrubStatus(ctx context.Context) (*types.PieceScrubStatus, error) //perm:read
+	// PieceStorageScrubRun starts a round to verify all the pieces in the piece storages
+	PieceStorageScrubRun(ctx context.Context) error //perm:admin
+	// PieceStorageScrubRefetch fetches a corrupt piece again by unsealing it from the sector
+	PieceStorageScrubRefetch(ctx context.Context, storage, resourceID string) error //perm:admin
+
+	// DagstoreShardRecoveries returns the recovery history of the shards which have errored since droplet started
+	DagstoreShardRecoveries(ctx context.Context) ([]*types.ShardRecovery, error) //perm:read
+	// DagstoreReconcile cross-checks the pieces in piece storages, the deals and the shards, and fixes the problems if fix is true
+	DagstoreReconcile(ctx context.Context, fix bool) (*types.ReconcileReport, error) //perm:admin
+	// DagstoreExport exports the shards, the index files and the top index to the directory on the host of droplet,
+	// an interrupted export is resumed by exporting to the same directory again
+	DagstoreExport(ctx context.Context, dir string) (*types.DagstoreExportResult, error) //perm:admin
+	// DagstoreImport verifies and imports the bundle exported by DagstoreExport, an interrupted import is resumed by importing it again
+	DagstoreImport(ctx context.Context, params *types.DagstoreImportParams) (*types.DagstoreImportResult, error) //perm:admin
+
+	// IndexProviderStatus returns the state of the index provider which announces the data of deals to IPNI indexers
+	IndexProviderStatus(ctx context.Context) (*types.IndexProviderStatus, error) //perm:read
+	// IndexProviderSync publishes the advertisements of new active deals and removes the expired or slashed deals at once
+	IndexProviderSync(ctx context.Context) (*types.IndexProviderSyncResult, error) //perm:admin
+}
@@ -0,0 +1,29 @@
+package dropletapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/venus/venus-shared/api"
+	marketapi "github.com/filecoin-project/venus/venus-shared/api/market/v1"
+)
+
+// NewIDropletRPC creates a jsonrpc client of droplet, addr is the endpoint of the v1 api
+func NewIDropletRPC(ctx context.Context, addr string, requestHeader http.Header, opts ...jsonrpc.Option) (IDroplet, jsonrpc.ClientCloser, error) {
+	endpoint, err := api.Endpoint(addr, marketapi.MajorVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid addr %s: %w", addr, err)
+	}
+
+	if requestHeader == nil {
+		requestHeader = http.Header{}
+	}
+	requestHeader.Set(api.VenusAPINamespaceHeader, marketapi.APINamespace)
+
+	var res IDropletStruct
+	closer, err := jsonrpc.NewMergeClient(ctx, endpoint, marketapi.MethodNamespace, api.GetInternalStructs(&res), requestHeader, opts...)
+
+	return &res, closer, err
+}
@@ -0,0 +1,130 @@
+package dropletapi
+
+import (
+	"context"
+
+	marketapi "github.com/filecoin-project/venus/venus-shared/api/market/v1"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var _ IDroplet = (*IDropletStruct)(nil)
+
+type IDropletStruct struct {
+	marketapi.IMarketStruct
+
+	Internal struct {
+		RetrievalStats              func(ctx context.Context, params *types.RetrievalStatsQueryParams) ([]*types.RetrievalStats, error) `perm:"read"`
+		RetrievalListViolations     func(ctx context.Context) ([]*types.RetrievalPeerViolations, error)                                 `perm:"read"`
+		RetrievalUnbanPeer          func(ctx context.Context, p peer.ID) error                                                          `perm:"admin"`
+		PiecesLocateCID             func(ctx context.Context, c cid.Cid) ([]*types.CIDLocation, error)                                  `perm:"read"`
+		PieceStorageGC              func(ctx context.Context, params *types.PieceGCParams) (*types.PieceGCReport, error)                `perm:"admin"`
+		PieceStorageHealth          func(ctx context.Context) ([]*types.PieceStorageHealth, error)                                      `perm:"read"`
+		PieceStorageUsage           func(ctx context.Context) ([]*types.PieceStorageUsage, error)                                       `perm:"read"`
+		PieceStorageUpdate          func(ctx context.Context, name string, params *types.PieceStorageUpdateParams) error                `perm:"admin"`
+		PieceStorageDrain           func(ctx context.Context, name string, draining bool) error                                         `perm:"admin"`
+		PieceStorageMigrate         func(ctx context.Context, params *types.PieceMigrationParams) (*types.PieceMigrationJob, error)     `perm:"admin"`
+		PieceStorageMigrationList   func(ctx context.Context) ([]*types.PieceMigrationJob, error)                                       `perm:"read"`
+		PieceStorageMigrationCancel func(ctx context.Context, id string) error                                                          `perm:"admin"`
+		PieceStorageMigrationResume func(ctx context.Context, id string) error                                                          `perm:"admin"`
+		PieceStorageScrubStatus     func(ctx context.Context) (*types.PieceScrubStatus, error)                                          `perm:"read"`
+		PieceStorageScrubRun        func(ctx context.Context) error                                                                     `perm:"admin"`
+		PieceStorageScrubRefetch    func(ctx context.Context, storage, resourceID string) error                                         `perm:"admin"`
+		DagstoreShardRecoveries     func(ctx context.Context) ([]*types.ShardRecovery, error)                                           `perm:"read"`
+		DagstoreReconcile           func(ctx context.Context, fix bool) (*types.ReconcileReport, error)                                 `perm:"admin"`
+		DagstoreExport              func(ctx context.Context, dir string) (*types.DagstoreExportResult, error)                          `perm:"admin"`
+		DagstoreImport              func(ctx context.Context, params *types.DagstoreImportParams) (*types.DagstoreImportResult, error)  `perm:"admin"`
+		IndexProviderStatus         func(ctx context.Context) (*types.IndexProviderStatus, error)                                       `perm:"read"`
+		IndexProviderSync           func(ctx context.Context) (*types.IndexProviderSyncResult, error)                                   `perm:"admin"`
+	}
+}
+
+func (s *IDropletStruct) RetrievalStats(p0 context.Context, p1 *types.RetrievalStatsQueryParams) ([]*types.RetrievalStats, error) {
+	return s.Internal.RetrievalStats(p0, p1)
+}
+
+func (s *IDropletStruct) RetrievalListViolations(p0 context.Context) ([]*types.RetrievalPeerViolations, error) {
+	return s.Internal.RetrievalListViolations(p0)
+}
+
+func (s *IDropletStruct) RetrievalUnbanPeer(p0 context.Context, p1 peer.ID) error {
+	return s.Internal.RetrievalUnbanPeer(p0, p1)
+}
+
+func (s *IDropletStruct) PiecesLocateCID(p0 context.Context, p1 cid.Cid) ([]*types.CIDLocation, error) {
+	return s.Internal.PiecesLocateCID(p0, p1)
+}
+
+func (s *IDropletStruct) PieceStorageGC(p0 context.Context, p1 *types.PieceGCParams) (*types.PieceGCReport, error) {
+	return s.Internal.PieceStorageGC(p0, p1)
+}
+
+func (s *IDropletStruct) PieceStorageHealth(p0 context.Context) ([]*types.PieceStorageHealth, error) {
+	return s.Internal.PieceStorageHealth(p0)
+}
+
+func (s *IDropletStruct) PieceStorageMigrate(p0 context.Context, p1 *types.PieceMigrationParams) (*types.PieceMigrationJob, error) {
+	return s.Internal.PieceStorageMigrate(p0, p1)
+}
+
+func (s *IDropletStruct) PieceStorageMigrationList(p0 context.Context) ([]*types.PieceMigrationJob, error) {
+	return s.Internal.PieceStorageMigrationList(p0)
+}
+
+func (s *IDropletStruct) PieceStorageMigrationCancel(p0 context.Context, p1 string) error {
+	return s.Internal.PieceStorageMigrationCancel(p0, p1)
+}
+
+func (s *IDropletStruct) PieceStorageMigrationResume(p0 context.Context, p1 string) error {
+	return s.Internal.PieceStorageMigrationResume(p0, p1)
+}
+
+func (s *IDropletStruct) PieceStorageScrubStatus(p0 context.Context) (*types.PieceScrubStatus, error) {
+	return s.Internal.PieceStorageScrubStatus(p0)
+}
+
+func (s *IDropletStruct) PieceStorageScrubRun(p0 context.Context) error {
+	return s.Internal.PieceStorageScrubRun(p0)
+}
+
+func (s *IDropletStruct) PieceStorageScrubRefetch(p0 context.Context, p1 string, p2 string) error {
+	return s.Internal.PieceStorageScrubRefetch(p0, p1, p2)
+}
+
+func (s *IDropletStruct) DagstoreShardRecoveries(p0 context.Context) ([]*types.ShardRecovery, error) {
+	return s.Internal.DagstoreShardRecoveries(p0)
+}
+
+func (s *IDropletStruct) DagstoreReconcile(p0 context.Context, p1 bool) (*types.ReconcileReport, error) {
+	return s.Internal.DagstoreReconcile(p0, p1)
+}
+
+func (s *IDropletStruct) DagstoreExport(p0 context.Context, p1 string) (*types.DagstoreExportResult, error) {
+	return s.Internal.DagstoreExport(p0, p1)
+}
+
+func (s *IDropletStruct) DagstoreImport(p0 context.Context, p1 *types.DagstoreImportParams) (*types.DagstoreImportResult, error) {
+	return s.Internal.DagstoreImport(p0, p1)
+}
+
+func (s *IDropletStruct) IndexProviderStatus(p0 context.Context) (*types.IndexProviderStatus, error) {
+	return s.Internal.IndexProviderStatus(p0)
+}
+
+func (s *IDropletStruct) IndexProviderSync(p0 context.Context) (*types.IndexProviderSyncResult, error) {
+	return s.Internal.IndexProviderSync(p0)
+}
+
+func (s *IDropletStruct) PieceStorageUsage(p0 context.Context) ([]*types.PieceStorageUsage, error) {
+	return s.Internal.PieceStorageUsage(p0)
+}
+
+func (s *IDropletStruct) PieceStorageUpdate(p0 context.Context, p1 string, p2 *types.PieceStorageUpdateParams) error {
+	return s.Internal.PieceStorageUpdate(p0, p1, p2)
+}
+
+func (s *IDropletStruct) PieceStorageDrain(p0 context.Context, p1 string, p2 bool) error {
+	return s.Internal.PieceStorageDrain(p0, p1, p2)
+}
@@ -0,0 +1,154 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs-force-community/sophon-auth/jwtclient"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs-force-community/droplet/v2/api/dropletapi"
+	mdagstore "github.com/ipfs-force-community/droplet/v2/dagstore"
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var _ dropletapi.IDroplet = (*MarketNodeImpl)(nil)
+
+func (m *MarketNodeImpl) RetrievalStats(ctx context.Context, params *mtypes.RetrievalStatsQueryParams) ([]*mtypes.RetrievalStats, error) {
+	if !params.Miner.Empty() {
+		if err := jwtclient.CheckPermissionByMiner(ctx, m.AuthClient, params.Miner); err != nil {
+			return nil, err
+		}
+	}
+	return m.RetrievalStatsRecorder.ListStats(ctx, params)
+}
+
+func (m *MarketNodeImpl) RetrievalListViolations(ctx context.Context) ([]*mtypes.RetrievalPeerViolations, error) {
+	return m.RetrievalGuard.ListViolations(), nil
+}
+
+func (m *MarketNodeImpl) RetrievalUnbanPeer(ctx context.Context, p peer.ID) error {
+	return m.RetrievalGuard.Unban(p)
+}
+
+func (m *MarketNodeImpl) PiecesLocateCID(ctx context.Context, c cid.Cid) ([]*mtypes.CIDLocation, error) {
+	w, ok := m.DAGStoreWrapper.(*mdagstore.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("cid can't be located without dagstore")
+	}
+	return w.CIDResolver().Locate(ctx, c)
+}
+
+func (m *MarketNodeImpl) PieceStorageGC(ctx context.Context, params *mtypes.PieceGCParams) (*mtypes.PieceGCReport, error) {
+	return m.PieceGC.Run(ctx, params)
+}
+
+func (m *MarketNodeImpl) PieceStorageHealth(ctx context.Context) ([]*mtypes.PieceStorageHealth, error) {
+	return m.PieceStorageMgr.ListStorageHealth(), nil
+}
+
+func (m *MarketNodeImpl) PieceStorageUsage(ctx context.Context) ([]*mtypes.PieceStorageUsage, error) {
+	return m.PieceStorageMgr.ListStorageUsage(), nil
+}
+
+func (m *MarketNodeImpl) PieceStorageUpdate(ctx context.Context, name string, params *mtypes.PieceStorageUpdateParams) error {
+	cfg, err := m.Config.PieceStorageConfig(name)
+	if err != nil {
+		return err
+	}
+	if err := piecestorage.ApplyPieceStorageUpdate(cfg, params); err != nil {
+		return err
+	}
+	if err := m.PieceStorageMgr.UpdatePieceStorage(ctx, cfg); err != nil {
+		return err
+	}
+	return m.Config.ReplacePieceStorage(cfg)
+}
+
+func (m *MarketNodeImpl) PieceStorageDrain(ctx context.Context, name string, draining bool) error {
+	return m.PieceStorageMgr.SetDraining(name, draining)
+}
+
+func (m *MarketNodeImpl) PieceStorageMigrate(ctx context.Context, params *mtypes.PieceMigrationParams) (*mtypes.PieceMigrationJob, error) {
+	return m.PieceMigrator.Start(ctx, params)
+}
+
+func (m *MarketNodeImpl) PieceStorageMigrationList(ctx context.Context) ([]*mtypes.PieceMigrationJob, error) {
+	return m.PieceMigrator.List(), nil
+}
+
+func (m *MarketNodeImpl) PieceStorageMigrationCancel(ctx context.Context, id string) error {
+	return m.PieceMigrator.Cancel(id)
+}
+
+func (m *MarketNodeImpl) PieceStorageMigrationResume(ctx context.Context, id string) error {
+	return m.PieceMigrator.Resume(ctx, id)
+}
+
+func (m *MarketNodeImpl) PieceStorageScrubStatus(ctx context.Context) (*mtypes.PieceScrubStatus, error) {
+	return m.PieceScrubber.Status(), nil
+}
+
+func (m *MarketNodeImpl) PieceStorageScrubRun(ctx context.Context) error {
+	return m.PieceScrubber.Run()
+}
+
+func (m *MarketNodeImpl) PieceStorageScrubRefetch(ctx context.Context, storage, resourceID string) error {
+	return m.PieceScrubber.Refetch(ctx, storage, resourceID)
+}
+
+func (m *MarketNodeImpl) DagstoreShardRecoveries(ctx context.Context) ([]*mtypes.ShardRecovery, error) {
+	w, ok := m.DAGStoreWrapper.(*mdagstore.Wrapper)
+	if !ok {
+		return []*mtypes.ShardRecovery{}, nil
+	}
+	return w.ShardRecoveries(), nil
+}
+
+func (m *MarketNodeImpl) DagstoreReconcile(ctx context.Context, fix bool) (*mtypes.ReconcileReport, error) {
+	return m.DAGStoreReconciler.Run(ctx, fix)
+}
+
+func (m *MarketNodeImpl) DagstoreExport(ctx context.Context, dir string) (*mtypes.DagstoreExportResult, error) {
+	w, ok := m.DAGStoreWrapper.(*mdagstore.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("dagstore can't be exported")
+	}
+	return w.ExportBundle(ctx, dir, func(ctx context.Context, pieceCid string) string {
+		st, err := m.PieceStorageMgr.FindStorageForRead(ctx, pieceCid)
+		if err != nil {
+			return ""
+		}
+		return st.GetName()
+	})
+}
+
+func (m *MarketNodeImpl) DagstoreImport(ctx context.Context, params *mtypes.DagstoreImportParams) (*mtypes.DagstoreImportResult, error) {
+	w, ok := m.DAGStoreWrapper.(*mdagstore.Wrapper)
+	if !ok {
+		return nil, fmt.Errorf("dagstore can't be imported")
+	}
+	return w.ImportBundle(ctx, params, func(ctx context.Context, storage, pieceCid string) (bool, error) {
+		st, err := m.PieceStorageMgr.GetPieceStorageByName(storage)
+		if err != nil {
+			return false, err
+		}
+		return st.Has(ctx, pieceCid)
+	})
+}
+
+func (m *MarketNodeImpl) IndexProviderStatus(ctx context.Context) (*mtypes.IndexProviderStatus, error) {
+	if m.IndexProvider == nil {
+		return &mtypes.IndexProviderStatus{Enabled: false}, nil
+	}
+	return m.IndexProvider.Status(ctx)
+}
+
+func (m *MarketNodeImpl) IndexProviderSync(ctx context.Context) (*mtypes.IndexProviderSyncResult, error) {
+	if m.IndexProvider == nil {
+		return nil, fmt.Errorf("index provider is disabled")
+	}
+	return m.IndexProvider.Sync(ctx)
+}
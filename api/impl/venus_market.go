@@ -29,6 +29,8 @@ import (
 
 	clients2 "github.com/ipfs-force-community/droplet/v2/api/clients"
 	"github.com/ipfs-force-community/droplet/v2/config"
+	mdagstore "github.com/ipfs-force-community/droplet/v2/dagstore"
+	"github.com/ipfs-force-community/droplet/v2/indexprovider"
 	"github.com/ipfs-force-community/droplet/v2/minermgr"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
 	"github.com/ipfs-force-community/droplet/v2/network"
@@ -72,7 +74,16 @@ type MarketNodeImpl struct {
 	StorageAsk                                  storageprovider.IStorageAsk
 	DAGStore                                    *dagstore.DAGStore
 	DAGStoreWrapper                             stores.DAGStoreWrapper
+	DAGStoreReconciler                          *mdagstore.Reconciler
+	IndexProvider                               *indexprovider.Provider
 	PieceStorageMgr                             *piecestorage.PieceStorageManager
+	PieceGC                                     *piecestorage.PieceGC
+	PieceMigrator                               *piecestorage.PieceMigrator
+	PieceScrubber                               *piecestorage.PieceScrubber
+	PieceUploader                               *piecestorage.PieceUploader
+	RetrievalStatsRecorder                      *retrievalprovider.RetrievalStatsRecorder
+	HTTPPaymentValidator                        *retrievalprovider.HTTPPaymentValidator
+	RetrievalGuard                              *retrievalprovider.RetrievalGuard
 	UserMgr                                     minermgr.IMinerMgr
 	PaychAPI                                    *paychmgr.PaychAPI
 	Repo                                        repo.Repo
@@ -491,7 +502,15 @@ func (m *MarketNodeImpl) PiecesGetPieceInfo(ctx context.Context, pieceCid cid.Ci
 }
 
 func (m *MarketNodeImpl) PiecesGetCIDInfo(ctx context.Context, payloadCid cid.Cid) (*piecestore.CIDInfo, error) {
-	ci, err := m.Repo.CidInfoRepo().GetCIDInfo(ctx, payloadCid)
+	var (
+		ci  piecestore.CIDInfo
+		err error
+	)
+	if w, ok := m.DAGStoreWrapper.(*mdagstore.Wrapper); ok {
+		ci, err = w.CIDResolver().CIDInfo(ctx, payloadCid)
+	} else {
+		ci, err = m.Repo.CidInfoRepo().GetCIDInfo(ctx, payloadCid)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -901,24 +920,17 @@ func (m *MarketNodeImpl) DagstoreInitializeStorage(ctx context.Context, storageN
 }
 
 func (m *MarketNodeImpl) DagstoreDestroyShard(ctx context.Context, key string) error {
-	opts := dagstore.DestroyOpts{}
-	sr := make(chan dagstore.ShardResult, 1)
-
 	shardKey := shard.KeyFromString(key)
 	if _, err := m.DAGStore.GetShardInfo(shardKey); err != nil {
 		return fmt.Errorf("query shard failed: %v", err)
 	}
-
-	if err := m.DAGStore.DestroyShard(ctx, shardKey, sr, opts); err != nil {
-		return err
+	pieceCid, err := cid.Decode(key)
+	if err != nil {
+		return fmt.Errorf("shard key %s is not a piece cid: %w", key, err)
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case r := <-sr:
-		return r.Error
-	}
+	// the wrapper also removes the shard from top index, and forgets the block locations of the piece
+	return stores.DestroyShardSync(ctx, m.DAGStoreWrapper, pieceCid)
 }
 
 func (m *MarketNodeImpl) dagstoreLoadShards(ctx context.Context, toInitialize []string, concurrency int) (<-chan types.DagstoreInitializeAllEvent, error) {
@@ -1151,12 +1163,15 @@ func (m *MarketNodeImpl) PaychVoucherList(ctx context.Context, pch address.Addre
 	return m.PaychAPI.PaychVoucherList(ctx, pch)
 }
 
-func (m *MarketNodeImpl) AddFsPieceStorage(_ context.Context, name string, path string, readonly bool) error {
+func (m *MarketNodeImpl) AddFsPieceStorage(ctx context.Context, name string, path string, readonly bool) error {
 	ifs := &config.FsPieceStorage{ReadOnly: readonly, Path: path, Name: name}
 	fsps, err := piecestorage.NewFsPieceStorage(ifs)
 	if err != nil {
 		return err
 	}
+	if err := piecestorage.ProbePieceStorage(ctx, fsps); err != nil {
+		return err
+	}
 	// add in memory
 	err = m.PieceStorageMgr.AddPieceStorage(fsps)
 	if err != nil {
@@ -1167,7 +1182,7 @@ func (m *MarketNodeImpl) AddFsPieceStorage(_ context.Context, name string, path
 	return m.Config.AddFsPieceStorage(ifs)
 }
 
-func (m *MarketNodeImpl) AddS3PieceStorage(_ context.Context, name, endpoit, bucket, subdir, accessKeyID, secretAccessKey, token string, readonly bool) error {
+func (m *MarketNodeImpl) AddS3PieceStorage(ctx context.Context, name, endpoit, bucket, subdir, accessKeyID, secretAccessKey, token string, readonly bool) error {
 	ifs := &config.S3PieceStorage{
 		ReadOnly:  readonly,
 		EndPoint:  endpoit,
@@ -1182,6 +1197,9 @@ func (m *MarketNodeImpl) AddS3PieceStorage(_ context.Context, name, endpoit, buc
 	if err != nil {
 		return err
 	}
+	if err := piecestorage.ProbePieceStorage(ctx, s3ps); err != nil {
+		return err
+	}
 	// add in memory
 	err = m.PieceStorageMgr.AddPieceStorage(s3ps)
 	if err != nil {
@@ -1196,8 +1214,8 @@ func (m *MarketNodeImpl) ListPieceStorageInfos(_ context.Context) types.PieceSto
 	return m.PieceStorageMgr.ListStorageInfos()
 }
 
-func (m *MarketNodeImpl) RemovePieceStorage(_ context.Context, name string) error {
-	err := m.PieceStorageMgr.RemovePieceStorage(name)
+func (m *MarketNodeImpl) RemovePieceStorage(ctx context.Context, name string) error {
+	err := m.PieceStorageMgr.RemovePieceStorage(ctx, name)
 	if err != nil {
 		return err
 	}
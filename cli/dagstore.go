@@ -3,6 +3,9 @@ package cli
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	types "github.com/filecoin-project/venus/venus-shared/types/market"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 )
 
 var DagstoreCmd = &cli.Command{
@@ -23,6 +27,9 @@ var DagstoreCmd = &cli.Command{
 		dagstoreInitializeStorageCmd,
 		dagstoreGcCmd,
 		dagStoreDestroyShardCmd,
+		dagstoreReconcileCmd,
+		dagstoreExportCmd,
+		dagstoreImportCmd,
 	},
 }
 
@@ -49,6 +56,10 @@ ShardStateRecovering
 ShardStateUnknown
 `,
 		},
+		&cli.BoolFlag{
+			Name:  "recovery-history",
+			Usage: "print the recovery attempts of the errored shards",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		if cctx.IsSet("color") {
@@ -67,6 +78,21 @@ ShardStateUnknown
 			return err
 		}
 
+		dropletApi, dropletCloser, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer dropletCloser()
+
+		recoveryList, err := dropletApi.DagstoreShardRecoveries(ctx)
+		if err != nil {
+			return err
+		}
+		recoveries := make(map[string]*mtypes.ShardRecovery, len(recoveryList))
+		for _, r := range recoveryList {
+			recoveries[r.Key] = r
+		}
+
 		if len(shards) == 0 {
 			return nil
 		}
@@ -80,6 +106,7 @@ ShardStateUnknown
 			tablewriter.Col("Key"),
 			tablewriter.Col("State"),
 			tablewriter.Col("Error"),
+			tablewriter.Col("Recovery"),
 		)
 
 		colors := map[string]color.Attribute{
@@ -103,13 +130,61 @@ ShardStateUnknown
 				}(),
 				"Error": s.Error,
 			}
+			if r, ok := recoveries[s.Key]; ok {
+				m["Recovery"] = shardRecoveryStatus(r)
+			}
 			tw.Write(m)
 		}
 
-		return tw.Flush(os.Stdout)
+		if err := tw.Flush(os.Stdout); err != nil {
+			return err
+		}
+
+		if !cctx.Bool("recovery-history") {
+			return nil
+		}
+		for _, r := range recoveryList {
+			if len(r.History) == 0 {
+				continue
+			}
+			fmt.Printf("\nShard %s: %s\n", r.Key, shardRecoveryStatus(r))
+			htw := tablewriter.New(
+				tablewriter.Col("Time"),
+				tablewriter.Col("Kind"),
+				tablewriter.Col("Action"),
+				tablewriter.Col("Duration"),
+				tablewriter.Col("Error"),
+			)
+			for _, a := range r.History {
+				htw.Write(map[string]interface{}{
+					"Time":     a.Time.Format(time.RFC3339),
+					"Kind":     a.Kind,
+					"Action":   a.Action,
+					"Duration": a.Duration.Truncate(time.Second),
+					"Error":    a.Error,
+				})
+			}
+			if err := htw.Flush(os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
 	},
 }
 
+func shardRecoveryStatus(r *mtypes.ShardRecovery) string {
+	switch {
+	case !r.RecoveredAt.IsZero():
+		return fmt.Sprintf("recovered at %s", r.RecoveredAt.Format(time.RFC3339))
+	case r.GaveUp:
+		return fmt.Sprintf("%s, gave up after %d attempts", r.Kind, r.Attempts)
+	case r.Recovering:
+		return fmt.Sprintf("%s, recovering (%d failed attempts)", r.Kind, r.Attempts)
+	default:
+		return fmt.Sprintf("%s, %d failed attempts, next at %s", r.Kind, r.Attempts, r.NextAttempt.Format(time.RFC3339))
+	}
+}
+
 var dagstoreInitializeShardCmd = &cli.Command{
 	Name:      "initialize-shard",
 	ArgsUsage: "[key]",
@@ -408,3 +483,184 @@ var dagStoreDestroyShardCmd = &cli.Command{
 		return nil
 	},
 }
+
+var dagstoreReconcileCmd = &cli.Command{
+	Name:  "reconcile",
+	Usage: "Cross-check the pieces in piece storages, the deals and the shards",
+	Description: `The problems are reported in categories:
+missing-shard: the piece of an active deal is in a piece storage, but it has no shard, fixed by registering the shard
+orphan-shard: the piece of the shard is in no piece storage and no deal, fixed by destroying the shard
+missing-piece: the deal is active, but its piece is in no piece storage, fixed by queuing the piece to be unsealed`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "fix",
+			Usage: "fix the problems, otherwise only report them",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		report, err := api.DagstoreReconcile(ctx, cctx.Bool("fix"))
+		if err != nil {
+			return err
+		}
+
+		counts := make(map[mtypes.ReconcileCategory]int)
+		if len(report.Items) > 0 {
+			tw := tablewriter.New(
+				tablewriter.Col("Category"),
+				tablewriter.Col("Piece"),
+				tablewriter.Col("Fix"),
+				tablewriter.Col("Error"),
+			)
+			for _, item := range report.Items {
+				counts[item.Category]++
+				tw.Write(map[string]interface{}{
+					"Category": item.Category,
+					"Piece":    item.PieceCid,
+					"Fix":      item.Fix,
+					"Error":    item.Error,
+				})
+			}
+			if err := tw.Flush(os.Stdout); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+
+		fmt.Printf("Checked %d pieces in storages, %d pieces of deals, %d shards in %s\n", report.Pieces, report.DealPieces,
+			report.Shards, report.FinishedAt.Sub(report.StartedAt).Truncate(time.Millisecond))
+		for _, category := range []mtypes.ReconcileCategory{mtypes.ReconcileMissingShard, mtypes.ReconcileOrphanShard, mtypes.ReconcileMissingPiece} {
+			fmt.Printf("%s: %d\n", category, counts[category])
+		}
+		return nil
+	},
+}
+
+var dagstoreExportCmd = &cli.Command{
+	Name:      "export",
+	Usage:     "Export the shards, the index files and the top index to a directory for moving droplet to another host",
+	ArgsUsage: "<dir>",
+	Description: `The directory is on the host of droplet, the index files are copied to it with their checksums,
+and a manifest is written after everything is exported. An interrupted export is resumed by exporting to the same directory again.`,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("must specify the directory to export to")
+		}
+		dir, err := filepath.Abs(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := api.DagstoreExport(ReqContext(cctx), dir)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Exported %d shards to %s, %d exported this time, %d exported before\n", res.Shards, res.Dir, res.Exported, res.Resumed)
+		fmt.Printf("Top index entries: %d\n", res.TopIndexEntries)
+		if len(res.NoIndex) > 0 {
+			fmt.Printf("%d shards have no index, they are indexed again after imported\n", len(res.NoIndex))
+		}
+		return nil
+	},
+}
+
+var dagstoreImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Import the shards, the index files and the top index exported by 'dagstore export'",
+	ArgsUsage: "<dir>",
+	Description: `The directory is on the host of droplet, the checksums of the files are verified before importing,
+the shards are registered with the imported index files without indexing the pieces again.
+An interrupted import is resumed by importing the same directory again.`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "rewrite-url",
+			Usage: "replace the prefix of the mount urls, eg. --rewrite-url=<old prefix>=<new prefix>",
+		},
+		&cli.StringSliceFlag{
+			Name:  "rename-storage",
+			Usage: "the piece storage renamed on this host, the pieces are checked in it, eg. --rename-storage=<old name>=<new name>",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-only",
+			Usage: "only verify the checksums of the files",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return fmt.Errorf("must specify the directory to import from")
+		}
+		dir, err := filepath.Abs(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		params := &mtypes.DagstoreImportParams{Dir: dir, VerifyOnly: cctx.Bool("verify-only")}
+		if params.URLRewrites, err = parseMapping(cctx.StringSlice("rewrite-url")); err != nil {
+			return err
+		}
+		if params.StorageRenames, err = parseMapping(cctx.StringSlice("rename-storage")); err != nil {
+			return err
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := api.DagstoreImport(ReqContext(cctx), params)
+		if err != nil {
+			return err
+		}
+
+		for _, corrupt := range res.Corrupt {
+			fmt.Printf("corrupt: %s\n", corrupt)
+		}
+		if len(res.Corrupt) > 0 {
+			return fmt.Errorf("%d files of the bundle are corrupt, nothing is imported", len(res.Corrupt))
+		}
+		if params.VerifyOnly {
+			fmt.Printf("Verified %d shards\n", res.Shards)
+			return nil
+		}
+
+		for _, piece := range res.MissingPieces {
+			fmt.Printf("missing piece: %s\n", piece)
+		}
+		for _, e := range res.Errors {
+			fmt.Printf("failed to import: %s\n", e)
+		}
+		fmt.Printf("Imported %d shards, %d skipped, %d failed, %d of %d shards\n", res.Imported, res.Skipped, len(res.Errors),
+			res.Imported+res.Skipped, res.Shards)
+		fmt.Printf("Top index entries: %d\n", res.TopIndexEntries)
+		if len(res.Errors) > 0 {
+			return fmt.Errorf("%d shards failed to be imported, import again to retry them", len(res.Errors))
+		}
+		return nil
+	},
+}
+
+// parseMapping parses the values in the form of <old>=<new>
+func parseMapping(values []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(values))
+	for _, v := range values {
+		from, to, ok := strings.Cut(v, "=")
+		if !ok || len(from) == 0 {
+			return nil, fmt.Errorf("invalid mapping %s, expected <old>=<new>", v)
+		}
+		mapping[from] = to
+	}
+	return mapping, nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var IndexProviderCmd = &cli.Command{
+	Name:  "index-provider",
+	Usage: "Manage the announcement of deal data to IPNI indexers",
+	Description: `The index provider publishes the advertisements of active deals every IndexProvider.Interval if
+IndexProvider.Enable is set, and removes the advertisements of the deals which are expired or slashed.`,
+	Subcommands: []*cli.Command{
+		indexProviderStatusCmd,
+		indexProviderSyncCmd,
+	},
+}
+
+var indexProviderStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "show the state of the index provider",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		status, err := api.IndexProviderStatus(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Enabled:          %t\n", status.Enabled)
+		if !status.Enabled {
+			return nil
+		}
+		lastSync := "-"
+		if !status.LastSync.IsZero() {
+			lastSync = status.LastSync.Format(time.RFC3339)
+		}
+		fmt.Printf("PeerID:           %s\n", status.PeerID)
+		fmt.Printf("Publisher:        %s\n", strings.Join(status.PublisherAddresses, ","))
+		fmt.Printf("AnnounceURLs:     %s\n", strings.Join(status.AnnounceURLs, ","))
+		fmt.Printf("Head:             %s\n", status.Head)
+		fmt.Printf("Announced:        %d\n", status.Announced)
+		fmt.Printf("LastSync:         %s\n", lastSync)
+		if len(status.LastSyncError) > 0 {
+			fmt.Printf("LastSyncError:    %s\n", status.LastSyncError)
+		}
+		return nil
+	},
+}
+
+var indexProviderSyncCmd = &cli.Command{
+	Name:  "sync",
+	Usage: "publish the advertisements of new active deals and remove the expired or slashed deals now",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := api.IndexProviderSync(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Published: %d\n", res.Published)
+		fmt.Printf("Removed:   %d\n", res.Removed)
+		fmt.Printf("Skipped:   %d\n", res.Skipped)
+		fmt.Printf("Head:      %s\n", res.Head)
+		for _, announceErr := range res.AnnounceErrors {
+			fmt.Printf("failed to announce: %s\n", announceErr)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,265 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	"github.com/ipfs-force-community/droplet/v2/storageprovider"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var pieceStorageMigrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "copy pieces between piece storages",
+	Subcommands: []*cli.Command{
+		pieceStorageMigrateStartCmd,
+		pieceStorageMigrateStatusCmd,
+		pieceStorageMigrateCancelCmd,
+		pieceStorageMigrateResumeCmd,
+	},
+}
+
+var pieceStorageMigrateStartCmd = &cli.Command{
+	Name:  "start",
+	Usage: "start a job to copy the pieces from a piece storage to another",
+	Description: `The pieces are copied in the background, and verified by the length in the target storage,
+the job resumes after droplet restarts. If any of --miner, --state and --min-age is set, only the pieces
+which have a deal matching all of them are copied.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "source",
+			Usage:    "the name of the piece storage to copy from",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "target",
+			Usage:    "the name of the piece storage to copy to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "only copy the pieces of deals of the miner",
+		},
+		&cli.StringSliceFlag{
+			Name:  "state",
+			Usage: "only copy the pieces of deals in the states, can be repeated, eg. StorageDealActive",
+		},
+		&cli.DurationFlag{
+			Name:  "min-age",
+			Usage: "only copy the pieces of deals created longer than the duration ago",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-commp",
+			Usage: "verify the commP of pieces in the target storage",
+		},
+		&cli.BoolFlag{
+			Name:  "delete-source",
+			Usage: "delete the pieces from the source storage after they are verified",
+		},
+		&cli.StringFlag{
+			Name:  "rate",
+			Usage: "the maximum bytes copied per second, eg. 100MiB, default unlimited",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		params := &mtypes.PieceMigrationParams{
+			Source:       cctx.String("source"),
+			Target:       cctx.String("target"),
+			MinAge:       cctx.Duration("min-age"),
+			VerifyCommP:  cctx.Bool("verify-commp"),
+			DeleteSource: cctx.Bool("delete-source"),
+		}
+		if cctx.IsSet("miner") {
+			miner, err := address.NewFromString(cctx.String("miner"))
+			if err != nil {
+				return fmt.Errorf("parse miner: %w", err)
+			}
+			params.Miner = miner
+		}
+		for _, s := range cctx.StringSlice("state") {
+			state, ok := storageprovider.StringToStorageState[s]
+			if !ok {
+				return fmt.Errorf("unknown deal state %s", s)
+			}
+			params.DealStates = append(params.DealStates, state)
+		}
+		if cctx.IsSet("rate") {
+			rate, err := units.RAMInBytes(cctx.String("rate"))
+			if err != nil {
+				return fmt.Errorf("parse rate: %w", err)
+			}
+			params.BytesPerSecond = rate
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		job, err := api.PieceStorageMigrate(ReqContext(cctx), params)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("piece migration job %s started, %d pieces to migrate\n", job.ID, job.Total)
+		return nil
+	},
+}
+
+var pieceStorageMigrateStatusCmd = &cli.Command{
+	Name:      "status",
+	Usage:     "show the progress of piece migration jobs",
+	ArgsUsage: "[job id]",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		jobs, err := api.PieceStorageMigrationList(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		if cctx.Args().Present() {
+			id := cctx.Args().First()
+			for _, job := range jobs {
+				if job.ID == id {
+					return printPieceMigrationJob(job)
+				}
+			}
+			return fmt.Errorf("piece migration job %s not found", id)
+		}
+
+		w := tablewriter.New(
+			tablewriter.Col("ID"),
+			tablewriter.Col("Source"),
+			tablewriter.Col("Target"),
+			tablewriter.Col("State"),
+			tablewriter.Col("Progress"),
+			tablewriter.Col("Migrated"),
+			tablewriter.Col("AlreadyInTarget"),
+			tablewriter.Col("Failed"),
+			tablewriter.Col("Copied"),
+			tablewriter.Col("UpdatedAt"),
+			tablewriter.NewLineCol("Error"),
+		)
+		for _, job := range jobs {
+			row := map[string]interface{}{
+				"ID":              job.ID,
+				"Source":          job.Params.Source,
+				"Target":          job.Params.Target,
+				"State":           job.State,
+				"Progress":        fmt.Sprintf("%d/%d", job.Processed, job.Total),
+				"Migrated":        job.Migrated,
+				"AlreadyInTarget": job.AlreadyInTarget,
+				"Failed":          len(job.Failures),
+				"Copied":          types.SizeStr(types.NewInt(uint64(job.BytesCopied))),
+				"UpdatedAt":       job.UpdatedAt.Format(time.RFC3339),
+			}
+			if len(job.Error) > 0 {
+				row["Error"] = job.Error
+			}
+			w.Write(row)
+		}
+		return w.Flush(os.Stdout)
+	},
+}
+
+func printPieceMigrationJob(job *mtypes.PieceMigrationJob) error {
+	var states []string
+	for _, state := range job.Params.DealStates {
+		states = append(states, storagemarket.DealStates[state])
+	}
+	miner := ""
+	if !job.Params.Miner.Empty() {
+		miner = job.Params.Miner.String()
+	}
+	rate := "unlimited"
+	if job.Params.BytesPerSecond > 0 {
+		rate = units.BytesSize(float64(job.Params.BytesPerSecond)) + "/s"
+	}
+
+	fmt.Printf("ID:              %s\n", job.ID)
+	fmt.Printf("Source:          %s\n", job.Params.Source)
+	fmt.Printf("Target:          %s\n", job.Params.Target)
+	fmt.Printf("Miner:           %s\n", miner)
+	fmt.Printf("DealStates:      %s\n", strings.Join(states, ", "))
+	fmt.Printf("MinAge:          %s\n", job.Params.MinAge)
+	fmt.Printf("VerifyCommP:     %t\n", job.Params.VerifyCommP)
+	fmt.Printf("DeleteSource:    %t\n", job.Params.DeleteSource)
+	fmt.Printf("Rate:            %s\n", rate)
+	fmt.Printf("State:           %s\n", job.State)
+	if len(job.Error) > 0 {
+		fmt.Printf("Error:           %s\n", job.Error)
+	}
+	fmt.Printf("CreatedAt:       %s\n", job.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("UpdatedAt:       %s\n", job.UpdatedAt.Format(time.RFC3339))
+	fmt.Printf("Progress:        %d/%d\n", job.Processed, job.Total)
+	fmt.Printf("Migrated:        %d\n", job.Migrated)
+	fmt.Printf("AlreadyInTarget: %d\n", job.AlreadyInTarget)
+	fmt.Printf("Copied:          %s\n", types.SizeStr(types.NewInt(uint64(job.BytesCopied))))
+	fmt.Printf("Failed:          %d\n", len(job.Failures))
+	if len(job.Failures) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tablewriter.New(
+		tablewriter.Col("Piece"),
+		tablewriter.NewLineCol("Error"),
+	)
+	for _, failure := range job.Failures {
+		w.Write(map[string]interface{}{
+			"Piece": failure.ResourceID,
+			"Error": failure.Error,
+		})
+	}
+	return w.Flush(os.Stdout)
+}
+
+var pieceStorageMigrateCancelCmd = &cli.Command{
+	Name:      "cancel",
+	Usage:     "stop a running piece migration job",
+	ArgsUsage: "<job id>",
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() {
+			return fmt.Errorf("job id is required")
+		}
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.PieceStorageMigrationCancel(ReqContext(cctx), cctx.Args().First())
+	},
+}
+
+var pieceStorageMigrateResumeCmd = &cli.Command{
+	Name:      "resume",
+	Usage:     "continue a canceled or failed piece migration job",
+	ArgsUsage: "<job id>",
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() {
+			return fmt.Errorf("job id is required")
+		}
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.PieceStorageMigrationResume(ReqContext(cctx), cctx.Args().First())
+	},
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/venus/venus-shared/types"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+)
+
+var pieceStorageScrubCmd = &cli.Command{
+	Name:  "scrub",
+	Usage: "verify the commP of the pieces in piece storages",
+	Description: `The scrubber verifies the pieces in background if PieceStorage.Scrub.Enable is set,
+the corrupt pieces are quarantined and not read until they are verified ok again.`,
+	Subcommands: []*cli.Command{
+		pieceStorageScrubStatusCmd,
+		pieceStorageScrubRunCmd,
+		pieceStorageScrubRefetchCmd,
+	},
+}
+
+var pieceStorageScrubStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "show the state of the scrubber and the pieces failed to verify",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		status, err := api.PieceStorageScrubStatus(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		formatTime := func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.Format(time.RFC3339)
+		}
+		fmt.Printf("Enabled:          %t\n", status.Enabled)
+		fmt.Printf("Running:          %t\n", status.Running)
+		fmt.Printf("RoundStartedAt:   %s\n", formatTime(status.RoundStartedAt))
+		fmt.Printf("LastRoundFinish:  %s\n", formatTime(status.LastRoundFinishedAt))
+		fmt.Printf("Verified:         %d\n", status.Verified)
+		fmt.Printf("Corrupt:          %d\n", status.Corrupt)
+		fmt.Printf("Errors:           %d\n", status.Errors)
+		fmt.Printf("Scanned:          %s\n", types.SizeStr(types.NewInt(uint64(status.BytesScanned))))
+		if len(status.Problems) == 0 {
+			return nil
+		}
+
+		fmt.Println()
+		w := tablewriter.New(
+			tablewriter.Col("Storage"),
+			tablewriter.Col("Piece"),
+			tablewriter.Col("Result"),
+			tablewriter.Col("Quarantined"),
+			tablewriter.Col("LastVerified"),
+			tablewriter.Col("Refetch"),
+			tablewriter.NewLineCol("Error"),
+		)
+		for _, record := range status.Problems {
+			row := map[string]interface{}{
+				"Storage":      record.Storage,
+				"Piece":        record.ResourceID,
+				"Result":       record.Result,
+				"Quarantined":  record.Quarantined,
+				"LastVerified": formatTime(record.LastVerified),
+				"Refetch":      record.RefetchState,
+			}
+			switch {
+			case len(record.Error) > 0:
+				row["Error"] = record.Error
+			case len(record.RefetchError) > 0:
+				row["Error"] = "refetch: " + record.RefetchError
+			case len(record.Actual) > 0:
+				row["Error"] = "got " + record.Actual
+			}
+			w.Write(row)
+		}
+		return w.Flush(os.Stdout)
+	},
+}
+
+var pieceStorageScrubRunCmd = &cli.Command{
+	Name:  "run",
+	Usage: "start a round to verify all the pieces, including the ones verified recently",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.PieceStorageScrubRun(ReqContext(cctx))
+	},
+}
+
+var pieceStorageScrubRefetchCmd = &cli.Command{
+	Name:      "refetch",
+	Usage:     "fetch a corrupt piece again by unsealing it from the sector",
+	ArgsUsage: "<storage> <piece cid>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return fmt.Errorf("storage and piece cid are required")
+		}
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		return api.PieceStorageScrubRefetch(ReqContext(cctx), cctx.Args().Get(0), cctx.Args().Get(1))
+	},
+}
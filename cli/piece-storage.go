@@ -1,11 +1,17 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	"github.com/filecoin-project/venus/venus-shared/types"
 	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 )
 
 var PieceStorageCmd = &cli.Command{
@@ -17,6 +23,11 @@ var PieceStorageCmd = &cli.Command{
 		pieceStorageAddS3Cmd,
 		pieceStorageListCmd,
 		pieceStorageRemoveCmd,
+		pieceStorageDrainCmd,
+		pieceStorageUpdateCmd,
+		pieceStorageGCCmd,
+		pieceStorageMigrateCmd,
+		pieceStorageScrubCmd,
 	},
 }
 
@@ -108,6 +119,22 @@ var pieceStorageAddS3Cmd = &cli.Command{
 			Aliases: []string{"d"},
 			Usage:   "subdirectory of the S3 bucket to store the pieces in. ",
 		},
+		&cli.StringFlag{
+			Name:  "access-key",
+			Usage: "reference of the access key, like env:NAME or file:/path/to/secret, which is resolved on droplet",
+		},
+		&cli.StringFlag{
+			Name:  "secret-key",
+			Usage: "reference of the secret key, like env:NAME or file:/path/to/secret, which is resolved on droplet",
+		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "reference of the session token, like env:NAME or file:/path/to/secret, which is resolved on droplet",
+		},
+		&cli.BoolFlag{
+			Name:  "default-credentials",
+			Usage: "use the default credential chain of aws on droplet, eg. environment variables, shared credentials file or instance role",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		nodeApi, closer, err := NewMarketNode(cctx)
@@ -118,6 +145,22 @@ var pieceStorageAddS3Cmd = &cli.Command{
 
 		ctx := ReqContext(cctx)
 
+		// get the references of credentials
+		getS3CredentialRefs := func() (string, string, string, error) {
+			refs := make([]string, 0, 3)
+			for _, flag := range []string{"access-key", "secret-key", "token"} {
+				ref := cctx.String(flag)
+				if ref != "" && !config.IsSecretReference(ref) {
+					return "", "", "", fmt.Errorf("%s must be a reference like env:NAME or file:/path/to/secret", flag)
+				}
+				refs = append(refs, ref)
+			}
+			if refs[0] == "" || refs[1] == "" {
+				return "", "", "", fmt.Errorf("both access-key and secret-key are required")
+			}
+			return refs[0], refs[1], refs[2], nil
+		}
+
 		// get access key , secret key ,token interactivelly
 		getS3Credentials := func() (string, string, string, error) {
 			// var accessKey, secretKey, token string
@@ -141,7 +184,14 @@ var pieceStorageAddS3Cmd = &cli.Command{
 			return accessKey, secretKey, token, err
 		}
 
-		accessKey, secretKey, token, err := getS3Credentials()
+		var accessKey, secretKey, token string
+		switch {
+		case cctx.Bool("default-credentials"):
+		case cctx.IsSet("access-key") || cctx.IsSet("secret-key") || cctx.IsSet("token"):
+			accessKey, secretKey, token, err = getS3CredentialRefs()
+		default:
+			accessKey, secretKey, token, err = getS3Credentials()
+		}
 		if err != nil {
 			return err
 		}
@@ -165,7 +215,7 @@ var pieceStorageListCmd = &cli.Command{
 	Name:  "list",
 	Usage: "list piece storages",
 	Action: func(cctx *cli.Context) error {
-		nodeApi, closer, err := NewMarketNode(cctx)
+		nodeApi, closer, err := NewDropletNode(cctx)
 		if err != nil {
 			return err
 		}
@@ -173,30 +223,109 @@ var pieceStorageListCmd = &cli.Command{
 		ctx := ReqContext(cctx)
 
 		storagelist := nodeApi.ListPieceStorageInfos(ctx)
+		healthList, err := nodeApi.PieceStorageHealth(ctx)
+		if err != nil {
+			return err
+		}
+		healths := make(map[string]*mtypes.PieceStorageHealth, len(healthList))
+		for _, h := range healthList {
+			healths[h.Name] = h
+		}
+		usageList, err := nodeApi.PieceStorageUsage(ctx)
+		if err != nil {
+			return err
+		}
+		usages := make(map[string]*mtypes.PieceStorageUsage, len(usageList))
+		for _, u := range usageList {
+			usages[u.Name] = u
+		}
+		sizeStr := func(size int64) string {
+			return types.SizeStr(types.NewInt(uint64(size)))
+		}
 
 		w := tablewriter.New(
 			tablewriter.Col("Name"),
 			tablewriter.Col("ReadOnly"),
 			tablewriter.Col("Type"),
 			tablewriter.Col("Path"),
+			tablewriter.Col("Used"),
+			tablewriter.Col("Available"),
+			tablewriter.Col("Objects"),
+			tablewriter.Col("Health"),
+			tablewriter.Col("ReadPriority"),
+			tablewriter.Col("Latency"),
+			tablewriter.NewLineCol("LastError"),
 		)
+		writeHealth := func(row map[string]interface{}) {
+			h, ok := healths[row["Name"].(string)]
+			if !ok {
+				return
+			}
+			row["Health"] = "healthy"
+			if !h.Healthy {
+				row["Health"] = fmt.Sprintf("unhealthy since %s", h.UnhealthySince.Format(time.RFC3339))
+			}
+			if h.Draining {
+				row["Health"] = fmt.Sprintf("%s, draining (%d in flight)", row["Health"], h.InFlight)
+			}
+			row["ReadPriority"] = h.ReadPriority
+			row["Latency"] = h.Latency.Truncate(time.Microsecond)
+			if len(h.LastError) > 0 {
+				row["LastError"] = fmt.Sprintf("%s (%s)", h.LastError, h.LastErrorAt.Format(time.RFC3339))
+			}
+		}
 
 		for _, storage := range storagelist.FsStorage {
-			w.Write(map[string]interface{}{
+			row := map[string]interface{}{
 				"Name":     storage.Name,
 				"ReadOnly": storage.ReadOnly,
 				"Path":     storage.Path,
 				"Type":     "file system",
-			})
+			}
+			if storage.Status.Capacity > 0 {
+				row["Used"] = sizeStr(storage.Status.Capacity - storage.Status.Available)
+				row["Available"] = sizeStr(storage.Status.Available)
+			}
+			writeHealth(row)
+			w.Write(row)
 		}
 
 		for _, storage := range storagelist.S3Storage {
-			w.Write(map[string]interface{}{
+			row := map[string]interface{}{
 				"Name":     storage.Name,
 				"ReadOnly": storage.ReadOnly,
 				"Path":     storage.EndPoint + "/" + storage.SubDir + storage.Bucket,
 				"Type":     "S3",
-			})
+			}
+			if u, ok := usages[storage.Name]; ok {
+				row["Used"] = sizeStr(u.Used)
+				row["Available"] = "unlimited"
+				if u.Quota > 0 {
+					row["Available"] = sizeStr(u.Available)
+				}
+				row["Objects"] = u.Objects
+				if u.ListedAt.IsZero() {
+					// the first listing of the bucket is not finished
+					row["Objects"] = fmt.Sprintf("%d (listing)", u.Objects)
+				}
+			}
+			writeHealth(row)
+			w.Write(row)
+		}
+
+		// remote storages are not in the storage infos
+		for _, h := range healthList {
+			if h.Type != "remote" {
+				continue
+			}
+			row := map[string]interface{}{
+				"Name":     h.Name,
+				"ReadOnly": h.ReadOnly,
+				"Path":     "-",
+				"Type":     "remote",
+			}
+			writeHealth(row)
+			w.Write(row)
 		}
 
 		return w.Flush(os.Stdout)
@@ -207,6 +336,16 @@ var pieceStorageRemoveCmd = &cli.Command{
 	Name:      "remove",
 	ArgsUsage: "<name>",
 	Usage:     "remove a piece storage",
+	Description: `The piece storage is drained before it's removed, no new pieces are written to it,
+and it's removed after the in-flight reads and writes finish.
+If they don't finish in time, the storage is kept draining, run 'piece-storage drain --cancel' to use it again.`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "the max time to wait for the in-flight operations",
+			Value: 10 * time.Minute,
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		// get idx
 		name := cctx.Args().Get(0)
@@ -219,7 +358,202 @@ var pieceStorageRemoveCmd = &cli.Command{
 			return err
 		}
 		defer closer()
-		ctx := ReqContext(cctx)
+		ctx, cancel := context.WithTimeout(ReqContext(cctx), cctx.Duration("timeout"))
+		defer cancel()
 		return nodeApi.RemovePieceStorage(ctx, name)
 	},
 }
+
+var pieceStorageDrainCmd = &cli.Command{
+	Name:      "drain",
+	ArgsUsage: "<name>",
+	Usage:     "stop writing new pieces to a piece storage, the pieces in it can still be read",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "cancel",
+			Usage: "write pieces to the piece storage again",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		name := cctx.Args().Get(0)
+		if name == "" {
+			return fmt.Errorf("piece storage name is required")
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		return api.PieceStorageDrain(ReqContext(cctx), name, !cctx.Bool("cancel"))
+	},
+}
+
+var pieceStorageUpdateCmd = &cli.Command{
+	Name:      "update",
+	ArgsUsage: "<name>",
+	Usage:     "change the settings of a piece storage without restart",
+	Description: `Only the settings of the flags set are changed, the storage is probed with the new settings
+before they are applied.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "readonly",
+			Usage: "whether the piece storage is only for reading",
+		},
+		&cli.IntFlag{
+			Name:  "priority",
+			Usage: "storages with higher priority are written first in fill-first write strategy",
+		},
+		&cli.IntFlag{
+			Name:  "read-priority",
+			Usage: "storages with higher read priority are preferred when reading a piece",
+		},
+		&cli.Int64Flag{
+			Name:  "quota",
+			Usage: "the max bytes of pieces in the s3 piece storage, 0 means unlimited",
+		},
+		&cli.BoolFlag{
+			Name:  "credentials",
+			Usage: "input the new access key, secret key and token of the s3 piece storage interactively, they can be references like env:NAME or file:/path/to/secret",
+		},
+		&cli.BoolFlag{
+			Name:  "default-credentials",
+			Usage: "use the default credential chain of aws for the s3 piece storage",
+		},
+		&cli.BoolFlag{
+			Name:  "token",
+			Usage: "input the new token of the remote piece storage interactively",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		name := cctx.Args().Get(0)
+		if name == "" {
+			return fmt.Errorf("piece storage name is required")
+		}
+
+		params := &mtypes.PieceStorageUpdateParams{}
+		if cctx.IsSet("readonly") {
+			readOnly := cctx.Bool("readonly")
+			params.ReadOnly = &readOnly
+		}
+		if cctx.IsSet("priority") {
+			priority := cctx.Int("priority")
+			params.Priority = &priority
+		}
+		if cctx.IsSet("read-priority") {
+			readPriority := cctx.Int("read-priority")
+			params.ReadPriority = &readPriority
+		}
+		if cctx.IsSet("quota") {
+			quota := cctx.Int64("quota")
+			params.Quota = &quota
+		}
+		afmt := NewAppFmt(cctx.App)
+		if cctx.Bool("credentials") {
+			accessKey, err := afmt.GetScret("access key:", true)
+			if err != nil {
+				return err
+			}
+			secretKey, err := afmt.GetScret("secret key:", true)
+			if err != nil {
+				return err
+			}
+			token, err := afmt.GetScret("token:", true)
+			if err != nil {
+				return err
+			}
+			params.AccessKey, params.SecretKey, params.Token = &accessKey, &secretKey, &token
+		} else if cctx.Bool("default-credentials") {
+			var empty string
+			params.AccessKey, params.SecretKey, params.Token = &empty, &empty, &empty
+		} else if cctx.Bool("token") {
+			token, err := afmt.GetScret("token:", true)
+			if err != nil {
+				return err
+			}
+			params.Token = &token
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		return api.PieceStorageUpdate(ReqContext(cctx), name, params)
+	},
+}
+
+var pieceStorageGCCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "delete the pieces which are no longer referenced by any non-terminal deal",
+	Description: `A piece is collected when all the deals of it are expired, slashed, rejected or failed,
+or no deal references it, and it stays unreferenced for longer than the grace period.
+Readonly piece storages are never collected.`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "grace",
+			Usage: "only collect the pieces which are unreferenced for longer than the duration",
+			Value: 72 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report the pieces to collect",
+		},
+		&cli.StringSliceFlag{
+			Name:  "storage",
+			Usage: "the name of piece storage to collect, default to all writable piece storages",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		report, err := api.PieceStorageGC(ReqContext(cctx), &mtypes.PieceGCParams{
+			GracePeriod: cctx.Duration("grace"),
+			DryRun:      cctx.Bool("dry-run"),
+			Storages:    cctx.StringSlice("storage"),
+		})
+		if err != nil {
+			return err
+		}
+
+		w := tablewriter.New(
+			tablewriter.Col("Storage"),
+			tablewriter.Col("Piece"),
+			tablewriter.Col("Size"),
+			tablewriter.Col("Reason"),
+			tablewriter.Col("UnreferencedSince"),
+			tablewriter.Col("Deleted"),
+			tablewriter.NewLineCol("Error"),
+		)
+		for _, item := range report.Pieces {
+			row := map[string]interface{}{
+				"Storage":           item.Storage,
+				"Piece":             item.ResourceID,
+				"Size":              types.SizeStr(types.NewInt(uint64(item.Size))),
+				"Reason":            item.Reason,
+				"UnreferencedSince": item.UnreferencedSince.Format(time.RFC3339),
+				"Deleted":           item.Deleted,
+			}
+			if len(item.Error) > 0 {
+				row["Error"] = item.Error
+			}
+			w.Write(row)
+		}
+		if err := w.Flush(os.Stdout); err != nil {
+			return err
+		}
+
+		action := "reclaimed"
+		if report.DryRun {
+			action = "to reclaim (dry run)"
+		}
+		fmt.Printf("\n%s of %d pieces %s, %d pieces in grace period, %d unrecognized resources, took %s\n",
+			types.SizeStr(types.NewInt(uint64(report.ReclaimedBytes))), len(report.Pieces), action,
+			report.InGracePeriod, report.Unrecognized, report.FinishedAt.Sub(report.StartedAt).Truncate(time.Millisecond))
+		return nil
+	},
+}
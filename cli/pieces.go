@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/ipfs/go-cid"
@@ -20,6 +21,7 @@ var PiecesCmd = &cli.Command{
 		piecesListCidInfosCmd,
 		piecesInfoCmd,
 		piecesCidInfoCmd,
+		piecesLocateCmd,
 	},
 }
 
@@ -190,3 +192,73 @@ var piecesCidInfoCmd = &cli.Command{
 		return w.Flush()
 	},
 }
+
+var piecesLocateCmd = &cli.Command{
+	Name:      "locate",
+	Usage:     "list the pieces and the deals containing a cid",
+	ArgsUsage: "<cid>",
+	Description: `The pieces are looked up in the top index of dagstore first, and then in the cid info store for the pieces
+not indexed by dagstore. The offsets are the offsets of the block in the car file of the piece.`,
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() {
+			return ShowHelp(cctx, fmt.Errorf("must specify cid"))
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		c, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		locations, err := api.PiecesLocateCID(ctx, c)
+		if err != nil {
+			return err
+		}
+
+		w := tablewriter.New(tablewriter.Col("Piece"),
+			tablewriter.Col("Source"),
+			tablewriter.Col("BlockOffsets"),
+			tablewriter.Col("Miner"),
+			tablewriter.Col("Deal"),
+			tablewriter.Col("State"),
+			tablewriter.Col("PieceStatus"),
+			tablewriter.Col("Sector"),
+			tablewriter.Col("DealOffset"),
+		)
+		for _, l := range locations {
+			offsets := make([]string, 0, len(l.Offsets))
+			for _, offset := range l.Offsets {
+				offsets = append(offsets, fmt.Sprint(offset))
+			}
+			m := map[string]interface{}{
+				"Piece":        l.PieceCID,
+				"Source":       l.Source,
+				"BlockOffsets": strings.Join(offsets, ","),
+			}
+			if len(l.Deals) == 0 {
+				w.Write(m)
+				continue
+			}
+			for _, deal := range l.Deals {
+				w.Write(map[string]interface{}{
+					"Piece":        m["Piece"],
+					"Source":       m["Source"],
+					"BlockOffsets": m["BlockOffsets"],
+					"Miner":        deal.Miner,
+					"Deal":         deal.DealID,
+					"State":        deal.State,
+					"PieceStatus":  deal.PieceStatus,
+					"Sector":       deal.SectorNumber,
+					"DealOffset":   deal.Offset,
+				})
+			}
+		}
+		return w.Flush(os.Stdout)
+	},
+}
@@ -27,6 +27,8 @@ var RetrievalCmds = &cli.Command{
 		retirevalAsksCmds,
 		retrievalDealSelectionCmds,
 		queryProtocols,
+		retrievalStatsCmd,
+		retrievalGuardCmds,
 	},
 }
 
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var retrievalGuardCmds = &cli.Command{
+	Name:  "guard",
+	Usage: "Manage the peers which violate the retrieval limits",
+	Subcommands: []*cli.Command{
+		retrievalGuardListCmd,
+		retrievalGuardUnbanCmd,
+	},
+}
+
+var retrievalGuardListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the peers which violated the retrieval limits, banned peers first",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		violations, err := api.RetrievalListViolations(ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		kinds := []mtypes.RetrievalViolation{
+			mtypes.RetrievalViolationQueryRate,
+			mtypes.RetrievalViolationConcurrentRetrievals,
+			mtypes.RetrievalViolationSelectorDepth,
+			mtypes.RetrievalViolationSelectorRecursion,
+			mtypes.RetrievalViolationBlocksPerDeal,
+			mtypes.RetrievalViolationBytesPerDeal,
+		}
+		cols := []tablewriter.Column{
+			tablewriter.Col("Peer"),
+			tablewriter.Col("BannedUntil"),
+			tablewriter.Col("Active"),
+			tablewriter.Col("LastViolation"),
+		}
+		for _, kind := range kinds {
+			cols = append(cols, tablewriter.Col(string(kind)))
+		}
+		tw := tablewriter.New(cols...)
+
+		now := time.Now()
+		for _, v := range violations {
+			row := map[string]interface{}{
+				"Peer":          v.Peer.String(),
+				"BannedUntil":   "",
+				"Active":        v.ActiveRetrievals,
+				"LastViolation": v.LastViolation.Format(time.RFC3339),
+			}
+			if v.Banned(now) {
+				row["BannedUntil"] = v.BannedUntil.Format(time.RFC3339)
+			}
+			for _, kind := range kinds {
+				row[string(kind)] = v.Violations[kind]
+			}
+			tw.Write(row)
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+var retrievalGuardUnbanCmd = &cli.Command{
+	Name:      "unban",
+	Usage:     "Remove the peer from the retrieval ban list",
+	ArgsUsage: "<peer id>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return IncorrectNumArgs(cctx)
+		}
+		p, err := peer.Decode(cctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("parse peer id: %w", err)
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := api.RetrievalUnbanPeer(ReqContext(cctx), p); err != nil {
+			return err
+		}
+		fmt.Printf("unban peer %s\n", p)
+		return nil
+	},
+}
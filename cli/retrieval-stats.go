@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var retrievalStatsCmd = &cli.Command{
+	Name:  "stats",
+	Usage: "Show the retrieval accounting per client, payload and miner",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "include the stats since the time, format: 2006-01-02 or RFC3339",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "include the stats before the time, format: 2006-01-02 or RFC3339",
+		},
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "filter by miner address",
+		},
+		&cli.StringFlag{
+			Name:  "client",
+			Usage: "filter by client, the peer id of graphsync client or the host of http client",
+		},
+		&cli.StringFlag{
+			Name:  "payload",
+			Usage: "filter by payload cid, it is the piece cid for http retrieval",
+		},
+		&cli.StringFlag{
+			Name:  "group-by",
+			Usage: "aggregate the stats by client, payload or miner, default to show every hourly bucket",
+		},
+		&cli.BoolFlag{
+			Name:  "csv",
+			Usage: "output in csv format",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		params := mtypes.RetrievalStatsQueryParams{
+			Client: cctx.String("client"),
+		}
+
+		var err error
+		if cctx.IsSet("from") {
+			if params.From, err = parseStatsTime(cctx.String("from")); err != nil {
+				return fmt.Errorf("parse from: %w", err)
+			}
+		}
+		if cctx.IsSet("to") {
+			if params.To, err = parseStatsTime(cctx.String("to")); err != nil {
+				return fmt.Errorf("parse to: %w", err)
+			}
+		}
+		if cctx.IsSet("miner") {
+			if params.Miner, err = address.NewFromString(cctx.String("miner")); err != nil {
+				return fmt.Errorf("parse miner: %w", err)
+			}
+		}
+		if cctx.IsSet("payload") {
+			if params.PayloadCID, err = cid.Decode(cctx.String("payload")); err != nil {
+				return fmt.Errorf("parse payload: %w", err)
+			}
+		}
+		if params.GroupBy, err = mtypes.ParseRetrievalStatsGroupBy(cctx.String("group-by")); err != nil {
+			return err
+		}
+
+		api, closer, err := NewDropletNode(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		stats, err := api.RetrievalStats(ReqContext(cctx), &params)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("csv") {
+			return outputRetrievalStatsCSV(stats)
+		}
+		return outputRetrievalStats(stats, params.GroupBy)
+	},
+}
+
+func parseStatsTime(s string) (int64, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+func retrievalStatsColumns(s *mtypes.RetrievalStats) []string {
+	bucket, miner, payload := "", "", ""
+	if s.Bucket != 0 {
+		bucket = time.Unix(s.Bucket, 0).Format(time.RFC3339)
+	}
+	if !s.Miner.Empty() {
+		miner = s.Miner.String()
+	}
+	if s.PayloadCID.Defined() {
+		payload = s.PayloadCID.String()
+	}
+
+	return []string{
+		bucket,
+		miner,
+		s.Client,
+		payload,
+		strconv.FormatUint(s.GraphsyncBytesSent, 10),
+		strconv.FormatUint(s.HTTPBytesSent, 10),
+		strconv.FormatUint(s.VouchersReceived, 10),
+		statsFunds(s.FundsReceived).String(),
+		statsFunds(s.FundsSettled).String(),
+		strconv.FormatUint(s.Succeeded, 10),
+		strconv.FormatUint(s.Failed, 10),
+		strconv.FormatFloat(s.SuccessRate(), 'f', 4, 64),
+	}
+}
+
+var retrievalStatsHeader = []string{"Bucket", "Miner", "Client", "Payload", "GraphsyncBytes", "HTTPBytes",
+	"Vouchers", "FundsReceived", "FundsSettled", "Succeeded", "Failed", "SuccessRate"}
+
+// statsFunds returns zero for the funds missing in the stats recorded by the old versions
+func statsFunds(amount abi.TokenAmount) abi.TokenAmount {
+	if amount.Int == nil {
+		return big.Zero()
+	}
+	return amount
+}
+
+func outputRetrievalStatsCSV(stats []*mtypes.RetrievalStats) error {
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write(retrievalStatsHeader); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		if err := w.Write(retrievalStatsColumns(s)); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func outputRetrievalStats(stats []*mtypes.RetrievalStats, groupBy mtypes.RetrievalStatsGroupBy) error {
+	var cols []tablewriter.Column
+	for _, name := range retrievalStatsHeader {
+		cols = append(cols, tablewriter.Col(name))
+	}
+	tw := tablewriter.New(cols...)
+
+	for _, s := range stats {
+		row := make(map[string]interface{})
+		for i, v := range retrievalStatsColumns(s) {
+			// the columns of the dimensions which are grouped away are hidden
+			if len(v) > 0 {
+				row[retrievalStatsHeader[i]] = v
+			}
+		}
+		row["GraphsyncBytes"] = types.SizeStr(types.NewInt(s.GraphsyncBytesSent))
+		row["HTTPBytes"] = types.SizeStr(types.NewInt(s.HTTPBytesSent))
+		row["FundsReceived"] = types.FIL(statsFunds(s.FundsReceived)).Short()
+		row["FundsSettled"] = types.FIL(statsFunds(s.FundsSettled)).Short()
+		row["SuccessRate"] = fmt.Sprintf("%.2f%%", s.SuccessRate()*100)
+		tw.Write(row)
+	}
+	if groupBy != mtypes.RetrievalStatsGroupByNone {
+		fmt.Printf("grouped by %s\n", groupBy)
+	}
+	return tw.Flush(os.Stdout)
+}
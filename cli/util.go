@@ -35,6 +35,7 @@ import (
 	"github.com/filecoin-project/go-state-types/crypto"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
+	"github.com/ipfs-force-community/droplet/v2/api/dropletapi"
 	"github.com/ipfs-force-community/droplet/v2/cli/tablewriter"
 	"github.com/ipfs-force-community/droplet/v2/config"
 
@@ -92,27 +93,48 @@ var minerFlag = &cli.StringFlag{
 }
 
 func NewMarketNode(cctx *cli.Context) (marketapi.IMarket, jsonrpc.ClientCloser, error) {
-	homePath, err := GetRepoPath(cctx, "repo", OldMarketRepoPath)
+	apiInfo, err := getMarketAPIInfo(cctx)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	apiUrl, err := os.ReadFile(path.Join(homePath, "api"))
+	addr, err := apiInfo.DialArgs("v0")
 	if err != nil {
 		return nil, nil, err
 	}
 
-	token, err := os.ReadFile(path.Join(homePath, "token"))
+	return marketapi.NewIMarketRPC(cctx.Context, addr, apiInfo.AuthHeader())
+}
+
+// NewDropletNode returns the client of the api which is only provided by droplet
+func NewDropletNode(cctx *cli.Context) (dropletapi.IDroplet, jsonrpc.ClientCloser, error) {
+	apiInfo, err := getMarketAPIInfo(cctx)
 	if err != nil {
 		return nil, nil, err
 	}
-	apiInfo := api.NewAPIInfo(string(apiUrl), string(token))
-	addr, err := apiInfo.DialArgs("v0")
+	addr, err := apiInfo.DialArgs("v1")
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return marketapi.NewIMarketRPC(cctx.Context, addr, apiInfo.AuthHeader())
+	return dropletapi.NewIDropletRPC(cctx.Context, addr, apiInfo.AuthHeader())
+}
+
+func getMarketAPIInfo(cctx *cli.Context) (api.APIInfo, error) {
+	homePath, err := GetRepoPath(cctx, "repo", OldMarketRepoPath)
+	if err != nil {
+		return api.APIInfo{}, err
+	}
+
+	apiUrl, err := os.ReadFile(path.Join(homePath, "api"))
+	if err != nil {
+		return api.APIInfo{}, err
+	}
+
+	token, err := os.ReadFile(path.Join(homePath, "token"))
+	if err != nil {
+		return api.APIInfo{}, err
+	}
+	return api.NewAPIInfo(string(apiUrl), string(token)), nil
 }
 
 func NewMarketClientNode(cctx *cli.Context) (clientapi.IMarketClient, jsonrpc.ClientCloser, error) {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/docker/go-units"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	v1api "github.com/filecoin-project/venus/venus-shared/api/chain/v1"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	"github.com/urfave/cli/v2"
+
+	cli2 "github.com/ipfs-force-community/droplet/v2/cli"
+	"github.com/ipfs-force-community/droplet/v2/retrievalprovider/httpretrieval"
+)
+
+var retrievalHTTPRetrieveCmd = &cli.Command{
+	Name:      "http-retrieve",
+	Usage:     "Retrieve a piece over http, pay with payment channel vouchers if the provider charges",
+	ArgsUsage: "[pieceURL outputPath]",
+	Description: `Retrieve a piece from the http retrieval service of droplet.
+
+If the provider charges for http retrieval, a payment channel to the payment address
+of the provider is created or reused, and the piece is retrieved in chunks, every
+chunk request carries a voucher which pays for it.
+
+Examples:
+
+- Retrieve a piece which costs 0.01 FIL at most
+	$ droplet-client retrieval http-retrieve --maxPrice 0.01fil http://127.0.0.1:41235/piece/baga... piece.car
+`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "address to pay from",
+		},
+		&cli.StringFlag{
+			Name:  "maxPrice",
+			Usage: fmt.Sprintf("maximum price of the whole piece the client is willing to pay (default: %s FIL)", DefaultMaxRetrievePrice),
+		},
+		&cli.StringFlag{
+			Name:  "chunk-size",
+			Usage: "the size of the data paid by every voucher",
+			Value: "32MiB",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			return cli2.IncorrectNumArgs(cctx)
+		}
+		pieceURL, outputPath := cctx.Args().Get(0), cctx.Args().Get(1)
+
+		chunkSize, err := units.RAMInBytes(cctx.String("chunk-size"))
+		if err != nil {
+			return fmt.Errorf("parse chunk size: %w", err)
+		}
+		if chunkSize <= 0 {
+			return fmt.Errorf("chunk size must be positive")
+		}
+		maxPrice := types.MustParseFIL(DefaultMaxRetrievePrice)
+		if cctx.IsSet("maxPrice") {
+			if maxPrice, err = types.ParseFIL(cctx.String("maxPrice")); err != nil {
+				return fmt.Errorf("parsing maxPrice: %w", err)
+			}
+		}
+
+		ctx := cli2.ReqContext(cctx)
+		afmt := cli2.NewAppFmt(cctx.App)
+
+		size, pricePerByte, paymentAddr, err := queryHTTPRetrieval(ctx, pieceURL)
+		if err != nil {
+			return err
+		}
+
+		out, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close() // nolint
+
+		if pricePerByte.IsZero() {
+			afmt.Printf("retrieving %s for free\n", types.SizeStr(types.NewInt(size)))
+			if err := fetchHTTPRange(ctx, pieceURL, nil, out, nil); err != nil {
+				return err
+			}
+			afmt.Println("Success")
+			return nil
+		}
+
+		total := big.Mul(pricePerByte, big.NewIntUnsigned(size))
+		if total.GreaterThan(big.Int(maxPrice)) {
+			return fmt.Errorf("the price of piece %s exceeds maxPrice %s", types.FIL(total), maxPrice)
+		}
+		afmt.Printf("retrieving %s, price %s\n", types.SizeStr(types.NewInt(size)), types.FIL(total))
+
+		payer, err := httpRetrievalPayer(cctx)
+		if err != nil {
+			return err
+		}
+
+		fapi, fcloser, err := cli2.NewFullNode(cctx, cli2.OldClientRepoPath)
+		if err != nil {
+			return err
+		}
+		defer fcloser()
+
+		ch, lane, err := getHTTPRetrievalChannel(ctx, fapi, payer, paymentAddr, total)
+		if err != nil {
+			return err
+		}
+		afmt.Printf("paying with channel %s, lane %d\n", ch, lane)
+
+		paid := big.Zero()
+		for offset := uint64(0); offset < size; offset += uint64(chunkSize) {
+			end := offset + uint64(chunkSize)
+			if end > size {
+				end = size
+			}
+			// vouchers of a lane are accumulative
+			paid = big.Add(paid, big.Mul(pricePerByte, big.NewIntUnsigned(end-offset)))
+			v, err := fapi.PaychVoucherCreate(ctx, ch, paid, lane)
+			if err != nil {
+				return err
+			}
+			if v.Voucher == nil {
+				return fmt.Errorf("could not create voucher: insufficient funds in channel, shortfall: %d", v.Shortfall)
+			}
+			voucher, err := EncodedString(v.Voucher)
+			if err != nil {
+				return err
+			}
+
+			headers := map[string]string{
+				"Range":                            fmt.Sprintf("bytes=%d-%d", offset, end-1),
+				httpretrieval.HeaderPaymentChannel: ch.String(),
+				httpretrieval.HeaderPaymentVoucher: voucher,
+			}
+			if err := fetchHTTPRange(ctx, pieceURL, headers, out, &paid); err != nil {
+				return err
+			}
+			afmt.Printf("Recv %s / %s, Paid %s\n", types.SizeStr(types.NewInt(end)), types.SizeStr(types.NewInt(size)), types.FIL(paid))
+		}
+
+		afmt.Println("Success")
+		return nil
+	},
+}
+
+func httpRetrievalPayer(cctx *cli.Context) (address.Address, error) {
+	if cctx.IsSet("from") {
+		return address.NewFromString(cctx.String("from"))
+	}
+
+	api, closer, err := cli2.NewMarketClientNode(cctx)
+	if err != nil {
+		return address.Undef, err
+	}
+	defer closer()
+	return api.DefaultAddress(cli2.ReqContext(cctx))
+}
+
+// queryHTTPRetrieval returns the size and the price of the piece
+func queryHTTPRetrieval(ctx context.Context, pieceURL string) (uint64, big.Int, address.Address, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, pieceURL, nil)
+	if err != nil {
+		return 0, big.Zero(), address.Undef, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, big.Zero(), address.Undef, err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return 0, big.Zero(), address.Undef, fmt.Errorf("query piece: unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, big.Zero(), address.Undef, fmt.Errorf("query piece: unknown size")
+	}
+
+	price := resp.Header.Get(httpretrieval.HeaderPricePerByte)
+	if len(price) == 0 {
+		return uint64(resp.ContentLength), big.Zero(), address.Undef, nil
+	}
+	pricePerByte, err := big.FromString(price)
+	if err != nil {
+		return 0, big.Zero(), address.Undef, fmt.Errorf("parse price per byte: %w", err)
+	}
+	paymentAddr, err := address.NewFromString(resp.Header.Get(httpretrieval.HeaderPaymentAddress))
+	if err != nil {
+		return 0, big.Zero(), address.Undef, fmt.Errorf("parse payment address: %w", err)
+	}
+
+	return uint64(resp.ContentLength), pricePerByte, paymentAddr, nil
+}
+
+// getHTTPRetrievalChannel gets or creates a channel with enough funds, and allocates a new lane
+func getHTTPRetrievalChannel(ctx context.Context, fapi v1api.FullNode, from, to address.Address, amt big.Int) (address.Address, uint64, error) {
+	info, err := fapi.PaychGet(ctx, from, to, amt, types.PaychGetOpts{OffChain: false})
+	if err != nil {
+		return address.Undef, 0, fmt.Errorf("get payment channel: %w", err)
+	}
+	ch := info.Channel
+	if info.WaitSentinel.Defined() {
+		fmt.Println("waiting for payment channel ready..")
+		if ch, err = fapi.PaychGetWaitReady(ctx, info.WaitSentinel); err != nil {
+			return address.Undef, 0, fmt.Errorf("wait payment channel: %w", err)
+		}
+	}
+
+	lane, err := fapi.PaychAllocateLane(ctx, ch)
+	if err != nil {
+		return address.Undef, 0, fmt.Errorf("allocate lane: %w", err)
+	}
+	return ch, lane, nil
+}
+
+// fetchHTTPRange writes the response of the request to out
+func fetchHTTPRange(ctx context.Context, pieceURL string, headers map[string]string, out io.Writer, paid *big.Int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pieceURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusPaymentRequired && paid != nil {
+			return fmt.Errorf("payment rejected, voucher amount %s, owed %s: %s", types.FIL(*paid),
+				resp.Header.Get(httpretrieval.HeaderPaymentOwed), msg)
+		}
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
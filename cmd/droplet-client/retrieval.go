@@ -27,6 +27,7 @@ var retrievalCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		retrievalFindCmd,
 		clientRetrieveCmd,
+		retrievalHTTPRetrieveCmd,
 		clientQueryRetrievalAskCmd,
 		retrievalCancelCmd,
 		retrievalListCmd,
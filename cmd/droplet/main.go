@@ -99,6 +99,7 @@ func main() {
 			cli2.NetCmd,
 			cli2.DataTransfersCmd,
 			cli2.DagstoreCmd,
+			cli2.IndexProviderCmd,
 			cli2.PieceStorageCmd,
 			cli2.MarketCmds,
 			cli2.StatsCmds,
@@ -14,6 +14,7 @@ import (
 	"github.com/ipfs-force-community/sophon-auth/jwtclient"
 
 	"github.com/ipfs-force-community/droplet/v2/api/clients"
+	"github.com/ipfs-force-community/droplet/v2/api/dropletapi"
 	"github.com/ipfs-force-community/droplet/v2/api/impl"
 	"github.com/ipfs-force-community/droplet/v2/api/impl/v0api"
 	cli2 "github.com/ipfs-force-community/droplet/v2/cli"
@@ -21,6 +22,7 @@ import (
 	"github.com/ipfs-force-community/droplet/v2/config"
 	"github.com/ipfs-force-community/droplet/v2/dagstore"
 	"github.com/ipfs-force-community/droplet/v2/fundmgr"
+	"github.com/ipfs-force-community/droplet/v2/indexprovider"
 	"github.com/ipfs-force-community/droplet/v2/metrics"
 	"github.com/ipfs-force-community/droplet/v2/minermgr"
 	"github.com/ipfs-force-community/droplet/v2/models"
@@ -34,7 +36,6 @@ import (
 	types2 "github.com/ipfs-force-community/droplet/v2/types"
 	"github.com/ipfs-force-community/droplet/v2/utils"
 
-	marketapiV1 "github.com/filecoin-project/venus/venus-shared/api/market/v1"
 	"github.com/filecoin-project/venus/venus-shared/api/permission"
 )
 
@@ -225,6 +226,7 @@ func runDaemon(cctx *cli.Context) error {
 		// Markets
 		storageprovider.StorageProviderOpts(cfg),
 		retrievalprovider.RetrievalProviderOpts(cfg),
+		indexprovider.IndexProviderOpts,
 
 		func(s *builder.Settings) error {
 			s.Invokes[ExtractApiKey] = builder.InvokeOption{
@@ -241,18 +243,18 @@ func runDaemon(cctx *cli.Context) error {
 	finishCh := utils.MonitorShutdown(shutdownChan)
 
 	router := mux.NewRouter()
-	if err = router.Handle("/resource", rpc.NewPieceStorageServer(resAPI.PieceStorageMgr)).GetError(); err != nil {
+	if err = router.Handle("/resource", rpc.NewPieceStorageServer(resAPI.PieceStorageMgr, resAPI.PieceUploader)).GetError(); err != nil {
 		return fmt.Errorf("handle 'resource' failed: %w", err)
 	}
-	httpRetrievalServer, err := httpretrieval.NewServer(&cfg.PieceStorage)
+	httpRetrievalServer, err := httpretrieval.NewServer(&cfg.PieceStorage, resAPI.RetrievalStatsRecorder, resAPI.HTTPPaymentValidator)
 	if err != nil {
 		return err
 	}
 
-	var iMarket marketapiV1.IMarketStruct
-	permission.PermissionProxy(marketapiV1.IMarket(resAPI), &iMarket)
+	var iDroplet dropletapi.IDropletStruct
+	permission.PermissionProxy(dropletapi.IDroplet(resAPI), &iDroplet)
 
-	api := (marketapiV1.IMarket)(&iMarket)
+	api := (dropletapi.IDroplet)(&iDroplet)
 	apiHandles := []rpc.APIHandle{
 		{Path: "/rpc/v1", API: api},
 		{Path: "/rpc/v0", API: v0api.WrapperV1IMarket{IMarket: api}},
@@ -122,6 +122,14 @@ type ProviderConfig struct {
 	// The public multi-address for retrieving deals with droplet.
 	// Note: Must be in multiaddr format, eg /ip4/127.0.0.1/tcp/41235/http
 	HTTPRetrievalMultiaddr string
+
+	// When enabled, the http retrievals of the pieces of the miner are charged by the price per byte
+	// in the retrieval ask, and the client should pay with payment channel vouchers
+	ChargeHTTPRetrieval bool
+
+	// When enabled, the pieces of the miner without shard are registered and indexed on demand when they are retrieved,
+	// or when a cid lookup misses and the cid is the root of a deal of the miner
+	LazyShardRegistration bool
 }
 
 func defaultProviderConfig() *ProviderConfig {
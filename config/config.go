@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/ipfs-force-community/metrics"
 
@@ -61,6 +62,12 @@ type DAGStoreConfig struct {
 	// Default value: 5.
 	MaxConcurrentIndex int
 
+	// The maximum number of pieces indexed on demand simultaneously for the miners
+	// enabling LazyShardRegistration, the retrievals of the other pieces wait.
+	// 0 means unlimited.
+	// Default value: 2.
+	MaxConcurrentLazyIndex int
+
 	// The maximum amount of unsealed deals that can be fetched simultaneously
 	// from the storage subsystem. 0 means unlimited.
 	// Default value: 0 (unlimited).
@@ -79,6 +86,12 @@ type DAGStoreConfig struct {
 	// MongoTopIndex used to config whether to save top index data to mongo
 	MongoTopIndex *MongoTopIndex
 
+	// TopIndex is where to save the top index which maps the multihashes to the shards containing them:
+	// "" saves it to the datastore under RootDir, or to mongo if MongoTopIndex is set,
+	// "mongo" saves it to MongoTopIndex, "mysql" saves it to the database of Mysql, which must be used as the repo,
+	// "badger" saves it to a badger store under RootDir
+	TopIndex string
+
 	// Transient path used to store temp file for retrieval
 	Transient string
 
@@ -87,20 +100,215 @@ type DAGStoreConfig struct {
 
 	// ReadDiretly enable to read piece storage directly skip transient file
 	UseTransient bool
+
+	// MaxTransientSize is the maximum bytes of the transient files when UseTransient is set,
+	// the least recently accessed transients are evicted to make room for a new fetch,
+	// and the periodic dagstore GC is replaced by the eviction. 0 means unlimited.
+	// Default value: 0 (unlimited).
+	MaxTransientSize uint64
+
+	// ShardRecovery configs recovering the errored shards in background
+	ShardRecovery ShardRecovery
+
+	// Reconcile configs cross-checking the pieces in piece storages, the deals and the shards periodically
+	Reconcile DAGStoreReconcile
+
+	// SectorMount configs reading the unsealed pieces of the new shards from the sectors through the sealers
+	SectorMount SectorMount
+}
+
+// SectorMount reads the unsealed pieces directly from the sectors of active deals through the sealers connected
+// to droplet by the market event stream, the pieces are read from the piece storages if no sealer can read them
+type SectorMount struct {
+	// Register the new shards with the sector mount, the shards registered before are not changed
+	// Default value: false
+	Enable bool
+	// The bytes read from the sealer in each request, it's clamped to [64KiB, 64MiB]
+	// Default value: 1048576 (1MiB)
+	ReadSize uint64
+	// The timeout of each read request
+	// Default value: 1m
+	ReadTimeout Duration
+}
+
+const (
+	DefaultSectorMountReadSize = 1 << 20
+	MinSectorMountReadSize     = 64 << 10
+	MaxSectorMountReadSize     = 64 << 20
+)
+
+// ValidReadSize returns ReadSize clamped to [MinSectorMountReadSize, MaxSectorMountReadSize], the default
+// value is used if it's not set
+func (s SectorMount) ValidReadSize() uint64 {
+	switch {
+	case s.ReadSize == 0:
+		return DefaultSectorMountReadSize
+	case s.ReadSize < MinSectorMountReadSize:
+		return MinSectorMountReadSize
+	case s.ReadSize > MaxSectorMountReadSize:
+		return MaxSectorMountReadSize
+	default:
+		return s.ReadSize
+	}
+}
+
+// DAGStoreReconcile finds the pieces of active deals without shard, the orphan shards and the active deals without piece
+type DAGStoreReconcile struct {
+	// The interval to reconcile, 0 means only reconcile by `droplet dagstore reconcile`
+	// Default value: 24h
+	Interval Duration
+	// Fix the problems found by the periodic reconciliation, otherwise they are only reported in log
+	// Default value: false
+	Fix bool
+}
+
+// ShardRecovery retries to recover the errored shards with exponential backoff,
+// the piece of a shard is unsealed from the sector if it's still missing after some attempts
+type ShardRecovery struct {
+	// Default value: true
+	Enable bool
+	// The delay before the first attempt, it's doubled after every failed attempt
+	// Default value: 1m
+	InitialBackoff Duration
+	// Default value: 1h
+	MaxBackoff Duration
+	// Unseal the piece if it's still missing after the attempts, 0 means never unseal
+	// Default value: 3
+	UnsealAfterAttempts int
+	// Stop recovering a shard after the attempts, 0 means unlimited
+	// Default value: 10
+	MaxAttempts int
 }
 
 type MongoTopIndex struct {
 	Url string
 }
 
+// Bitswap configs the optional bitswap retrieval service
+type Bitswap struct {
+	// Enable serving deal data over bitswap
+	Enable bool
+
+	// Libp2p config of a standalone bitswap peer, bitswap shares the libp2p host of
+	// droplet if ListenAddresses is empty
+	Libp2p Libp2p
+
+	// The maximum number of shards kept open for serving blocks
+	// Default value: 20
+	MaxCachedShards int
+
+	// The maximum number of block requests per second from a single peer
+	// 0 means unlimited
+	PeerRequestsPerSecond float64
+
+	// The maximum number of block requests per second from all peers
+	// 0 means unlimited
+	MaxRequestsPerSecond float64
+
+	// The number of workers sending blocks to peers
+	// Default value: 8
+	TaskWorkerCount int
+}
+
+// IndexProvider configs announcing the data of deals to IPNI indexers
+type IndexProvider struct {
+	// Enable publishing advertisements of the data of active deals
+	Enable bool
+
+	// The address the http publisher listens on, indexers pull the advertisements from it
+	// Default value: "0.0.0.0:3104"
+	HttpListenAddress string
+
+	// The multiaddr of the http publisher announced to indexers, e.g. /dns/example.com/tcp/3104/http
+	// The listen address is announced if it's empty
+	PublisherAnnounceAddress string
+
+	// The urls of the indexers notified of new advertisements, e.g. https://cid.contact
+	AnnounceURLs []string
+
+	// The maximum number of multihashes in an entry chunk of advertisement
+	// Default value: 16384
+	EntriesChunkSize int
+
+	// The interval of publishing the advertisements of new active deals,
+	// and the removal advertisements of expired or slashed deals
+	// Default value: 10m
+	Interval Duration
+}
+
+// RetrievalLimits protects the retrieval service from abusive peers
+type RetrievalLimits struct {
+	// The maximum nesting depth of the selector of a retrieval deal
+	// 0 means unlimited, Default value: 32
+	MaxSelectorDepth int
+	// The maximum depth limit of the recursions in the selector of a retrieval deal, a recursion without
+	// depth limit (eg. the selector exploring the whole dag) is rejected too if it's set
+	// 0 means unlimited, Default value: 0
+	MaxRecursionDepth int
+	// The maximum number of blocks sent in a retrieval deal, 0 means unlimited
+	MaxBlocksPerDeal uint64
+	// The maximum number of bytes sent in a retrieval deal, 0 means unlimited
+	MaxBytesPerDeal uint64
+
+	// The maximum number of queries per second from a single peer
+	// 0 means unlimited, Default value: 10
+	PeerQueriesPerSecond float64
+	// The maximum number of ongoing retrieval deals of a single peer
+	// 0 means unlimited, Default value: 10
+	PeerMaxConcurrentRetrievals int
+
+	// A peer is banned for BanDuration once it violates the limits BanThreshold times
+	// within ViolationWindow, the requests of banned peers are rejected
+	// 0 means never ban, Default value: 20
+	BanThreshold uint64
+	// Default value: 10m
+	ViolationWindow Duration
+	// Default value: 1h
+	BanDuration Duration
+}
+
 type PieceStorage struct {
-	Fs []*FsPieceStorage
-	S3 []*S3PieceStorage
+	// WriteStrategy is how to select a storage to write a piece: random, most-free, capacity-weighted,
+	// round-robin or fill-first
+	WriteStrategy string
+	// Affinity keeps the pieces of a miner on the designated storages
+	Affinity []*PieceStorageAffinity
+	// Scrub verifies the pieces in the storages in background
+	Scrub PieceScrub
+
+	Fs     []*FsPieceStorage
+	S3     []*S3PieceStorage
+	Remote []*RemotePieceStorage
+}
+
+// PieceScrub recomputes the commP of the pieces in piece storages and compares it with the piece cid,
+// the corrupt pieces are no longer read
+type PieceScrub struct {
+	// Default value: false
+	Enable bool
+	// Every piece is verified once in the interval
+	// Default value: 168h
+	Interval Duration
+	// The maximum bytes read per second, 0 means unlimited
+	// Default value: 52428800 (50MiB)
+	BytesPerSecond int64
+	// Fetch the corrupt pieces again by unsealing them from the sectors
+	RefetchCorrupt bool
 }
+
+type PieceStorageAffinity struct {
+	Miner    Address
+	Storages []string
+}
+
 type FsPieceStorage struct {
 	Name     string
 	ReadOnly bool
 	Path     string
+	// storages with higher priority are written first in fill-first strategy
+	Priority int
+	// storages with higher read priority are preferred when reading a piece
+	ReadPriority int
 }
 type S3PieceStorage struct {
 	Name     string
@@ -108,12 +316,84 @@ type S3PieceStorage struct {
 	EndPoint string
 	Bucket   string
 	SubDir   string
-
+	// storages with higher priority are written first in fill-first strategy
+	Priority int
+	// storages with higher read priority are preferred when reading a piece
+	ReadPriority int
+	// The max bytes of pieces in the storage, 0 means unlimited,
+	// the usage is tracked by listing the bucket hourly and by the writes and deletes of droplet
+	Quota int64
+
+	// The credentials can be references like "env:NAME" or "file:/path/to/secret", which are read again every minute.
+	// The default credential chain of aws (environment variables, shared credentials file and instance role)
+	// is used if both AccessKey and SecretKey are empty
 	AccessKey string
 	SecretKey string
 	Token     string
 }
 
+const (
+	SecretEnvPrefix  = "env:"
+	SecretFilePrefix = "file:"
+)
+
+// IsSecretReference returns whether the secret refers to an environment variable or a file
+func IsSecretReference(secret string) bool {
+	return strings.HasPrefix(secret, SecretEnvPrefix) || strings.HasPrefix(secret, SecretFilePrefix)
+}
+
+func redactSecret(secret string) string {
+	if secret == "" || IsSecretReference(secret) {
+		return secret
+	}
+	return "xxxxx"
+}
+
+// HasPlainSecrets returns whether the credentials are written in config
+func (s S3PieceStorage) HasPlainSecrets() bool {
+	for _, secret := range []string{s.AccessKey, s.SecretKey, s.Token} {
+		if redactSecret(secret) != secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy whose credentials are hidden except the references
+func (s S3PieceStorage) Redacted() S3PieceStorage {
+	s.AccessKey = redactSecret(s.AccessKey)
+	s.SecretKey = redactSecret(s.SecretKey)
+	s.Token = redactSecret(s.Token)
+	return s
+}
+
+// String hides the credentials when the config is printed
+func (s S3PieceStorage) String() string {
+	type plain S3PieceStorage
+	return fmt.Sprintf("%+v", plain(s.Redacted()))
+}
+
+func (s S3PieceStorage) GoString() string {
+	return s.String()
+}
+
+// RemotePieceStorage accesses the pieces of another droplet through its /resource endpoint
+type RemotePieceStorage struct {
+	Name     string
+	ReadOnly bool
+	// The address of the remote droplet, a url like "http://192.168.1.10:41235" or a multiaddr like "/ip4/192.168.1.10/tcp/41235"
+	Url string
+	// The token to access the remote droplet
+	Token string
+	// The name of the piece storage on the remote droplet to save pieces,
+	// the remote droplet selects one by its write strategy if empty
+	Store string
+	// storages with higher priority are written first in fill-first strategy
+	Priority int
+	// storages with higher read priority are preferred when reading a piece
+	ReadPriority int
+}
+
 type Mysql struct {
 	ConnectionString string
 	MaxOpenConn      int
@@ -155,6 +435,11 @@ type MarketConfig struct {
 
 	PieceStorage PieceStorage
 	DAGStore     DAGStoreConfig
+	Bitswap      Bitswap
+
+	RetrievalLimits RetrievalLimits
+
+	IndexProvider IndexProvider
 
 	CommonProvider *ProviderConfig
 	Miners         []*MinerConfig
@@ -176,9 +461,70 @@ func (m *MarketConfig) RemovePieceStorage(name string) error {
 			return SaveConfig(m)
 		}
 	}
+	for i, s := range m.PieceStorage.Remote {
+		if s.Name == name {
+			m.PieceStorage.Remote = append(m.PieceStorage.Remote[:i], m.PieceStorage.Remote[i+1:]...)
+			return SaveConfig(m)
+		}
+	}
 	return fmt.Errorf("piece storage %s not found", name)
 }
 
+// PieceStorageConfig returns a copy of the config of piece storage, which is *FsPieceStorage, *S3PieceStorage or *RemotePieceStorage
+func (m *MarketConfig) PieceStorageConfig(name string) (interface{}, error) {
+	for _, s := range m.PieceStorage.Fs {
+		if s.Name == name {
+			cfg := *s
+			return &cfg, nil
+		}
+	}
+	for _, s := range m.PieceStorage.S3 {
+		if s.Name == name {
+			cfg := *s
+			return &cfg, nil
+		}
+	}
+	for _, s := range m.PieceStorage.Remote {
+		if s.Name == name {
+			cfg := *s
+			return &cfg, nil
+		}
+	}
+	return nil, fmt.Errorf("piece storage %s not found", name)
+}
+
+// ReplacePieceStorage replaces the config of piece storage with the same name
+func (m *MarketConfig) ReplacePieceStorage(cfg interface{}) error {
+	switch c := cfg.(type) {
+	case *FsPieceStorage:
+		for i, s := range m.PieceStorage.Fs {
+			if s.Name == c.Name {
+				m.PieceStorage.Fs[i] = c
+				return SaveConfig(m)
+			}
+		}
+		return fmt.Errorf("fs piece storage %s not found", c.Name)
+	case *S3PieceStorage:
+		for i, s := range m.PieceStorage.S3 {
+			if s.Name == c.Name {
+				m.PieceStorage.S3[i] = c
+				return SaveConfig(m)
+			}
+		}
+		return fmt.Errorf("s3 piece storage %s not found", c.Name)
+	case *RemotePieceStorage:
+		for i, s := range m.PieceStorage.Remote {
+			if s.Name == c.Name {
+				m.PieceStorage.Remote[i] = c
+				return SaveConfig(m)
+			}
+		}
+		return fmt.Errorf("remote piece storage %s not found", c.Name)
+	default:
+		return fmt.Errorf("unknown piece storage config %T", cfg)
+	}
+}
+
 func (m *MarketConfig) AddFsPieceStorage(fsps *FsPieceStorage) error {
 	m.PieceStorage.Fs = append(m.PieceStorage.Fs, fsps)
 	return SaveConfig(m)
@@ -56,12 +56,60 @@ var DefaultMarketConfig = &MarketConfig{
 		Debug:            false,
 	},
 	PieceStorage: PieceStorage{
-		Fs: []*FsPieceStorage{},
+		WriteStrategy: "random",
+		Affinity:      []*PieceStorageAffinity{},
+		Scrub: PieceScrub{
+			Interval:       Duration(7 * 24 * time.Hour),
+			BytesPerSecond: 50 << 20,
+		},
+		Fs:     []*FsPieceStorage{},
+		Remote: []*RemotePieceStorage{},
 	},
 	DAGStore: DAGStoreConfig{
 		MaxConcurrentIndex:         5,
+		MaxConcurrentLazyIndex:     2,
 		MaxConcurrencyStorageCalls: 100,
 		GCInterval:                 Duration(1 * time.Minute),
+		ShardRecovery: ShardRecovery{
+			Enable:              true,
+			InitialBackoff:      Duration(time.Minute),
+			MaxBackoff:          Duration(time.Hour),
+			UnsealAfterAttempts: 3,
+			MaxAttempts:         10,
+		},
+		Reconcile: DAGStoreReconcile{
+			Interval: Duration(24 * time.Hour),
+		},
+		SectorMount: SectorMount{
+			Enable:      false,
+			ReadSize:    DefaultSectorMountReadSize,
+			ReadTimeout: Duration(time.Minute),
+		},
+	},
+	Bitswap: Bitswap{
+		Enable: false,
+		Libp2p: Libp2p{
+			ListenAddresses:     []string{},
+			AnnounceAddresses:   []string{},
+			NoAnnounceAddresses: []string{},
+		},
+		MaxCachedShards: 20,
+		TaskWorkerCount: 8,
+	},
+	RetrievalLimits: RetrievalLimits{
+		MaxSelectorDepth:            32,
+		PeerQueriesPerSecond:        10,
+		PeerMaxConcurrentRetrievals: 10,
+		BanThreshold:                20,
+		ViolationWindow:             Duration(10 * time.Minute),
+		BanDuration:                 Duration(time.Hour),
+	},
+	IndexProvider: IndexProvider{
+		Enable:            false,
+		HttpListenAddress: "0.0.0.0:3104",
+		AnnounceURLs:      []string{},
+		EntriesChunkSize:  16384,
+		Interval:          Duration(10 * time.Minute),
 	},
 
 	SimultaneousTransfersForRetrieval:        DefaultSimultaneousTransfers,
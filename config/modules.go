@@ -65,6 +65,23 @@ func NewSetConsiderOnlineRetrievalDealsConfigFunc(cfg *MarketConfig) (SetConside
 	}, nil
 }
 
+func NewLazyShardRegistrationConfigFunc(cfg *MarketConfig) (LazyShardRegistrationConfigFunc, error) {
+	return func() ([]address.Address, error) {
+		var miners []address.Address
+		for _, miner := range cfg.Miners {
+			mAddr := address.Address(miner.Addr)
+			pCfg, err := cfg.MinerProviderConfig(mAddr, true)
+			if err != nil {
+				return nil, err
+			}
+			if pCfg != nil && pCfg.LazyShardRegistration {
+				miners = append(miners, mAddr)
+			}
+		}
+		return miners, nil
+	}, nil
+}
+
 func NewStorageDealPieceCidBlocklistConfigFunc(cfg *MarketConfig) (StorageDealPieceCidBlocklistConfigFunc, error) {
 	return func(mAddr address.Address) ([]cid.Cid, error) {
 		pCfg, err := cfg.MinerProviderConfig(mAddr, true)
@@ -432,6 +449,7 @@ var ConfigServerOpts = func(cfg *MarketConfig) builder.Option {
 		builder.Override(new(SetConsiderOnlineStorageDealsConfigFunc), NewSetConsideringOnlineStorageDealsFunc),
 		builder.Override(new(ConsiderOnlineRetrievalDealsConfigFunc), NewConsiderOnlineRetrievalDealsConfigFunc),
 		builder.Override(new(SetConsiderOnlineRetrievalDealsConfigFunc), NewSetConsiderOnlineRetrievalDealsConfigFunc),
+		builder.Override(new(LazyShardRegistrationConfigFunc), NewLazyShardRegistrationConfigFunc),
 		builder.Override(new(StorageDealPieceCidBlocklistConfigFunc), NewStorageDealPieceCidBlocklistConfigFunc),
 		builder.Override(new(SetStorageDealPieceCidBlocklistConfigFunc), NewSetStorageDealPieceCidBlocklistConfigFunc),
 		builder.Override(new(ConsiderOfflineStorageDealsConfigFunc), NewConsiderOfflineStorageDealsConfigFunc),
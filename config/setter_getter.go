@@ -69,6 +69,10 @@ type ConsiderUnverifiedStorageDealsConfigFunc func(address.Address) (bool, error
 // disable or enable unverified piecestorage deal acceptance.
 type SetConsiderUnverifiedStorageDealsConfigFunc func(address.Address, bool) error
 
+// LazyShardRegistrationConfigFunc is a function which reads from miner config
+// to find the miners whose pieces are indexed on demand when they are retrieved.
+type LazyShardRegistrationConfigFunc func() ([]address.Address, error)
+
 type (
 	SetMaxDealStartDelayFunc func(address.Address, time.Duration) error
 	GetMaxDealStartDelayFunc func(address.Address) (time.Duration, error)
@@ -0,0 +1,180 @@
+package dagstore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/dagstore/index"
+	"github.com/filecoin-project/dagstore/shard"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	badger "github.com/ipfs/go-ds-badger2"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ TopIndexRepo = (*BadgerTopIndex)(nil)
+
+var (
+	// /mh/<multihash hex>/<shard key>
+	mhPrefix = ds.NewKey("/mh")
+	// /shard/<shard key>/<multihash hex>, it's used to remove shard
+	shardPrefix = ds.NewKey("/shard")
+)
+
+// BadgerTopIndex saves the top index to a local badger store, a key for each pair of multihash and shard,
+// so that a shard can be added or removed without reading the shards of its multihashes
+type BadgerTopIndex struct {
+	ds ds.Batching
+}
+
+func NewBadgerTopIndex(dir string) (*BadgerTopIndex, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s for top index: %w", dir, err)
+	}
+	dstore, err := badger.NewDatastore(dir, &badger.DefaultOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger top index in %s: %w", dir, err)
+	}
+	return newBadgerTopIndex(dstore), nil
+}
+
+func newBadgerTopIndex(dstore ds.Batching) *BadgerTopIndex {
+	return &BadgerTopIndex{ds: dstore}
+}
+
+func (b *BadgerTopIndex) AddMultihashesForShard(ctx context.Context, mhIter index.MultihashIterator, key shard.Key) error {
+	return addMultihashesForShard(ctx, b, mhIter, key)
+}
+
+func (b *BadgerTopIndex) AddEntries(ctx context.Context, entries []*TopIndexEntry) error {
+	batch, err := b.ds.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create ds batch: %w", err)
+	}
+	for _, entry := range entries {
+		mh := hex.EncodeToString(entry.Multihash)
+		for _, key := range entry.Shards {
+			if err := batch.Put(ctx, mhPrefix.ChildString(mh).ChildString(key.String()), []byte{}); err != nil {
+				return err
+			}
+			if err := batch.Put(ctx, shardPrefix.ChildString(key.String()).ChildString(mh), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	return nil
+}
+
+// queryKeys calls fn with the keys with prefix in order
+func (b *BadgerTopIndex) queryKeys(ctx context.Context, prefix ds.Key, limit int, fn func(key ds.Key) error) error {
+	res, err := b.ds.Query(ctx, query.Query{
+		Prefix:   prefix.String(),
+		KeysOnly: true,
+		Limit:    limit,
+		Orders:   []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close() //nolint
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if err := fn(ds.RawKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BadgerTopIndex) GetShardsForMultihash(ctx context.Context, h multihash.Multihash) ([]shard.Key, error) {
+	var shardKeys []shard.Key
+	err := b.queryKeys(ctx, mhPrefix.ChildString(hex.EncodeToString(h)), 0, func(key ds.Key) error {
+		shardKeys = append(shardKeys, shard.KeyFromString(key.BaseNamespace()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(shardKeys) == 0 {
+		return nil, fmt.Errorf("failed to lookup index for mh %s: %w", h, ds.ErrNotFound)
+	}
+	return shardKeys, nil
+}
+
+func (b *BadgerTopIndex) HasShard(ctx context.Context, key shard.Key) (bool, error) {
+	has := false
+	err := b.queryKeys(ctx, shardPrefix.ChildString(key.String()), 1, func(ds.Key) error {
+		has = true
+		return nil
+	})
+	return has, err
+}
+
+func (b *BadgerTopIndex) RemoveShard(ctx context.Context, key shard.Key) error {
+	batch, err := b.ds.Batch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create ds batch: %w", err)
+	}
+	count := 0
+	if err := b.queryKeys(ctx, shardPrefix.ChildString(key.String()), 0, func(k ds.Key) error {
+		if err := batch.Delete(ctx, k); err != nil {
+			return err
+		}
+		if err := batch.Delete(ctx, mhPrefix.ChildString(k.BaseNamespace()).ChildString(key.String())); err != nil {
+			return err
+		}
+		count++
+		if count%topIndexBatchSize != 0 {
+			return nil
+		}
+		if err := batch.Commit(ctx); err != nil {
+			return err
+		}
+		batch, err = b.ds.Batch(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to remove shard %s from top index: %w", key, err)
+	}
+	return batch.Commit(ctx)
+}
+
+func (b *BadgerTopIndex) ForEach(ctx context.Context, fn func(entry *TopIndexEntry) error) error {
+	var entry *TopIndexEntry
+	var entryMh string
+	if err := b.queryKeys(ctx, mhPrefix, 0, func(key ds.Key) error {
+		// the keys are sorted, so that the keys of a multihash are adjacent
+		mh := key.Parent().BaseNamespace()
+		if entry != nil && mh != entryMh {
+			if err := fn(entry); err != nil {
+				return err
+			}
+			entry = nil
+		}
+		if entry == nil {
+			raw, err := hex.DecodeString(mh)
+			if err != nil {
+				return fmt.Errorf("invalid multihash %s in top index: %w", mh, err)
+			}
+			entry, entryMh = &TopIndexEntry{Multihash: raw}, mh
+		}
+		entry.Shards = append(entry.Shards, shard.KeyFromString(key.BaseNamespace()))
+		return nil
+	}); err != nil {
+		return err
+	}
+	if entry != nil {
+		return fn(entry)
+	}
+	return nil
+}
+
+func (b *BadgerTopIndex) Close() error {
+	return b.ds.Close()
+}
@@ -0,0 +1,606 @@
+package dagstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/index"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipfs/go-cid"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+const (
+	bundleVersion      = 1
+	bundleManifestFile = "manifest.json"
+	bundleIndexDir     = "index"
+	bundleTopIndexFile = "topindex.jsonl"
+	exportJournalFile  = "export.journal"
+	importJournalFile  = "import.journal"
+	indexFileSuffix    = ".full.idx"
+)
+
+// bundleFile is a file in the bundle, its name is relative to the directory of the bundle
+type bundleFile struct {
+	Name   string
+	Size   int64
+	Sha256 string
+}
+
+type bundleShard struct {
+	Key  string
+	URL  string
+	Lazy bool
+	// The piece storage containing the piece when it's exported
+	Storage string `json:",omitempty"`
+	// The index file, nil if the shard isn't indexed
+	Index *bundleFile `json:",omitempty"`
+}
+
+type bundleTopIndex struct {
+	bundleFile
+	Entries int
+}
+
+// bundleManifest is written after all the files are exported, a bundle without manifest is incomplete
+type bundleManifest struct {
+	Version   int
+	CreatedAt time.Time
+	Shards    []*bundleShard
+	// nil if the top index is saved in the datastore of dagstore, it's rebuilt from the index files when imported
+	TopIndex *bundleTopIndex `json:",omitempty"`
+}
+
+// journalRecord is a line of the journal recording the progress of exporting or importing
+type journalRecord struct {
+	Shard    *bundleShard    `json:",omitempty"`
+	TopIndex *bundleTopIndex `json:",omitempty"`
+}
+
+// topIndexLine is a line of the top index file in the bundle
+type topIndexLine struct {
+	Multihash string   `json:"m"`
+	Shards    []string `json:"s"`
+}
+
+// ExportBundle exports the shards, their index files and the top index to dir. The exported shards are recorded
+// in a journal, so an interrupted export is resumed by exporting to the same dir again.
+// storageOf returns the name of the piece storage containing the piece, it's used to check the piece when imported.
+func (w *Wrapper) ExportBundle(ctx context.Context, dir string, storageOf func(ctx context.Context, pieceCid string) string) (*mtypes.DagstoreExportResult, error) {
+	if _, err := os.Stat(filepath.Join(dir, bundleManifestFile)); err == nil {
+		return nil, fmt.Errorf("%s is a complete bundle already", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, bundleIndexDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	jn, records, err := openJournal(filepath.Join(dir, exportJournalFile))
+	if err != nil {
+		return nil, err
+	}
+	defer jn.Close() //nolint
+
+	exported := make(map[string]*bundleShard, len(records))
+	var topIndex *bundleTopIndex
+	for _, rec := range records {
+		if rec.Shard != nil {
+			exported[rec.Shard.Key] = rec.Shard
+		}
+		if rec.TopIndex != nil {
+			topIndex = rec.TopIndex
+		}
+	}
+
+	shards, err := w.shardRepo.ListShards(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Key < shards[j].Key
+	})
+
+	res := &mtypes.DagstoreExportResult{Dir: dir, Shards: len(shards), NoIndex: []string{}}
+	manifest := &bundleManifest{Version: bundleVersion, CreatedAt: time.Now(), Shards: make([]*bundleShard, 0, len(shards))}
+	for _, ps := range shards {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		bs, ok := exported[ps.Key]
+		if ok && bundleFileExists(dir, bs.Index) {
+			res.Resumed++
+		} else {
+			bs = &bundleShard{Key: ps.Key, URL: ps.URL, Lazy: ps.Lazy}
+			if storageOf != nil {
+				bs.Storage = storageOf(ctx, ps.Key)
+			}
+			src := filepath.Join(w.indexDir, ps.Key+indexFileSuffix)
+			if _, err := os.Stat(src); err == nil {
+				name := path.Join(bundleIndexDir, ps.Key+indexFileSuffix)
+				if bs.Index, err = copyWithChecksum(src, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					return nil, fmt.Errorf("failed to export index of shard %s: %w", ps.Key, err)
+				}
+				bs.Index.Name = name
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+			if err := jn.append(&journalRecord{Shard: bs}); err != nil {
+				return nil, err
+			}
+			res.Exported++
+		}
+		if bs.Index == nil {
+			res.NoIndex = append(res.NoIndex, bs.Key)
+		}
+		manifest.Shards = append(manifest.Shards, bs)
+	}
+
+	if topIndex == nil || !bundleFileExists(dir, &topIndex.bundleFile) {
+		if topIndex, err = w.exportTopIndex(ctx, dir); err != nil {
+			return nil, err
+		}
+		if topIndex != nil {
+			if err := jn.append(&journalRecord{TopIndex: topIndex}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if topIndex != nil {
+		manifest.TopIndex = topIndex
+		res.TopIndexEntries = topIndex.Entries
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, bundleManifestFile), data); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	log.Infow("exported dagstore bundle", "dir", dir, "shards", res.Shards, "exported", res.Exported, "resumed", res.Resumed)
+
+	return res, nil
+}
+
+func (w *Wrapper) exportTopIndex(ctx context.Context, dir string) (*bundleTopIndex, error) {
+	topIndex, ok := w.topIndex.(TopIndexRepo)
+	if !ok {
+		log.Info("the top index in the datastore of dagstore can't be exported, it's rebuilt from the index files when imported")
+		return nil, nil
+	}
+
+	target := filepath.Join(dir, bundleTopIndexFile)
+	f, err := os.Create(target + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+
+	h := sha256.New()
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(io.MultiWriter(bw, h))
+	entries := 0
+	err = topIndex.ForEach(ctx, func(entry *TopIndexEntry) error {
+		line := topIndexLine{Multihash: entry.Multihash.HexString(), Shards: make([]string, 0, len(entry.Shards))}
+		for _, s := range entry.Shards {
+			line.Shards = append(line.Shards, s.String())
+		}
+		entries++
+		return enc.Encode(&line)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export top index: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(target + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		return nil, err
+	}
+
+	return &bundleTopIndex{
+		bundleFile: bundleFile{Name: bundleTopIndexFile, Size: info.Size(), Sha256: hex.EncodeToString(h.Sum(nil))},
+		Entries:    entries,
+	}, nil
+}
+
+// ImportBundle imports the bundle exported by ExportBundle. The files are verified by their checksums before importing,
+// and the imported shards are recorded in a journal in the bundle, so an interrupted import is resumed by importing it again.
+// pieceExists checks whether the piece is in the piece storage, it's used to report the pieces missing on the new host.
+func (w *Wrapper) ImportBundle(ctx context.Context,
+	params *mtypes.DagstoreImportParams,
+	pieceExists func(ctx context.Context, storage, pieceCid string) (bool, error),
+) (*mtypes.DagstoreImportResult, error) {
+	dir := params.Dir
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest, the bundle may be incomplete: %w", err)
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if manifest.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	jn, records, err := openJournal(filepath.Join(dir, importJournalFile))
+	if err != nil {
+		return nil, err
+	}
+	defer jn.Close() //nolint
+
+	// the imported shards and their keys on the new host
+	imported := make(map[string]string, len(records))
+	topIndexImported := false
+	for _, rec := range records {
+		if rec.Shard != nil {
+			key, _, err := shardFromURL(rec.Shard.URL)
+			if err != nil {
+				return nil, err
+			}
+			imported[rec.Shard.Key] = key.String()
+		}
+		if rec.TopIndex != nil {
+			topIndexImported = true
+		}
+	}
+
+	res := &mtypes.DagstoreImportResult{
+		Shards:        len(manifest.Shards),
+		Corrupt:       []string{},
+		MissingPieces: []string{},
+		Errors:        []string{},
+	}
+	for _, bs := range manifest.Shards {
+		if _, ok := imported[bs.Key]; ok || bs.Index == nil {
+			continue
+		}
+		if err := verifyBundleFile(dir, bs.Index); err != nil {
+			res.Corrupt = append(res.Corrupt, err.Error())
+		}
+	}
+	if manifest.TopIndex != nil && !topIndexImported {
+		if err := verifyBundleFile(dir, &manifest.TopIndex.bundleFile); err != nil {
+			res.Corrupt = append(res.Corrupt, err.Error())
+		}
+	}
+	if len(res.Corrupt) > 0 || params.VerifyOnly {
+		return res, nil
+	}
+
+	topIndex, ok := w.topIndex.(TopIndexRepo)
+	// the top index is rebuilt from the index files if it isn't in the bundle or it's saved in the datastore
+	copyTopIndex := ok && manifest.TopIndex != nil
+	for _, bs := range manifest.Shards {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if _, ok := imported[bs.Key]; ok {
+			res.Skipped++
+			continue
+		}
+
+		key, err := w.importShard(ctx, dir, bs, params, pieceExists, !copyTopIndex, res)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", bs.Key, err))
+			continue
+		}
+		imported[bs.Key] = key
+		if err := jn.append(&journalRecord{Shard: &bundleShard{Key: bs.Key, URL: rewriteURL(bs.URL, params.URLRewrites)}}); err != nil {
+			return nil, err
+		}
+	}
+
+	// the top index is copied after all the shards are imported, as the keys of the shards may be rewritten
+	if copyTopIndex && !topIndexImported && len(res.Errors) == 0 {
+		if res.TopIndexEntries, err = importTopIndex(ctx, dir, manifest.TopIndex, topIndex, imported); err != nil {
+			return nil, err
+		}
+		if err := jn.append(&journalRecord{TopIndex: manifest.TopIndex}); err != nil {
+			return nil, err
+		}
+	}
+	log.Infow("imported dagstore bundle", "dir", dir, "shards", res.Shards, "imported", res.Imported, "skipped", res.Skipped, "errors", len(res.Errors))
+
+	return res, nil
+}
+
+func (w *Wrapper) importShard(ctx context.Context,
+	dir string,
+	bs *bundleShard,
+	params *mtypes.DagstoreImportParams,
+	pieceExists func(ctx context.Context, storage, pieceCid string) (bool, error),
+	addTopIndex bool,
+	res *mtypes.DagstoreImportResult,
+) (string, error) {
+	key, pieceCid, err := shardFromURL(rewriteURL(bs.URL, params.URLRewrites))
+	if err != nil {
+		return "", err
+	}
+
+	if storage := bs.Storage; len(storage) > 0 && pieceExists != nil {
+		if renamed, ok := params.StorageRenames[storage]; ok {
+			storage = renamed
+		}
+		has, err := pieceExists(ctx, storage, pieceCid.String())
+		if err != nil || !has {
+			res.MissingPieces = append(res.MissingPieces, fmt.Sprintf("%s in %s", pieceCid, storage))
+		}
+	}
+
+	indexPath := filepath.Join(w.indexDir, key.String()+indexFileSuffix)
+	if bs.Index != nil && !bundleFileExists(filepath.Dir(indexPath), &bundleFile{Name: filepath.Base(indexPath), Size: bs.Index.Size}) {
+		copied, err := copyWithChecksum(filepath.Join(dir, filepath.FromSlash(bs.Index.Name)), indexPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to import index: %w", err)
+		}
+		if copied.Sha256 != bs.Index.Sha256 {
+			_ = os.Remove(indexPath)
+			return "", fmt.Errorf("checksum of index %s mismatch", bs.Index.Name)
+		}
+	}
+
+	if _, err := w.dagst.GetShardInfo(key); err == nil {
+		res.Skipped++
+	} else if errors.Is(err, dagstore.ErrShardUnknown) {
+		// the shard becomes available without fetching the piece if its index is imported,
+		// and the top index is imported below
+		resch := make(chan dagstore.ShardResult, 1)
+		if err := w.registerShard(ctx, pieceCid, "", bs.Index != nil, resch, false); err != nil {
+			return "", err
+		}
+		select {
+		case r := <-resch:
+			if r.Error != nil {
+				return "", r.Error
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		res.Imported++
+	} else {
+		return "", err
+	}
+
+	if addTopIndex && bs.Index != nil {
+		f, err := os.Open(indexPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close() //nolint
+		idx, err := carindex.ReadFrom(f)
+		if err != nil {
+			return "", fmt.Errorf("failed to read index: %w", err)
+		}
+		if iterableIdx, ok := idx.(carindex.IterableIndex); ok {
+			if err := w.topIndex.AddMultihashesForShard(ctx, &iterableMultihashes{iterableIdx}, key); err != nil {
+				return "", fmt.Errorf("failed to add shard to top index: %w", err)
+			}
+		}
+	}
+
+	return key.String(), nil
+}
+
+func importTopIndex(ctx context.Context, dir string, file *bundleTopIndex, topIndex TopIndexRepo, keys map[string]string) (int, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Name)))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() //nolint
+
+	batch := &topIndexEntryBatch{
+		flush: func(entries []*TopIndexEntry) error {
+			return topIndex.AddEntries(ctx, entries)
+		},
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	entries := 0
+	for {
+		var line topIndexLine
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, fmt.Errorf("failed to decode top index: %w", err)
+		}
+		mh, err := multihash.FromHexString(line.Multihash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode multihash %s: %w", line.Multihash, err)
+		}
+		entry := &TopIndexEntry{Multihash: mh, Shards: make([]shard.Key, 0, len(line.Shards))}
+		for _, s := range line.Shards {
+			if key, ok := keys[s]; ok {
+				s = key
+			}
+			entry.Shards = append(entry.Shards, shard.KeyFromString(s))
+		}
+		if err := batch.add(entry); err != nil {
+			return 0, fmt.Errorf("failed to import top index: %w", err)
+		}
+		entries++
+	}
+	if err := batch.commit(); err != nil {
+		return 0, fmt.Errorf("failed to import top index: %w", err)
+	}
+	return entries, nil
+}
+
+// shardFromURL returns the key and the piece of the shard from its mount url, the shards registered with
+// the sector mounts are accepted too, the mount is rebuilt by newMount from the templates of this host
+func shardFromURL(mountURL string) (shard.Key, cid.Cid, error) {
+	u, err := url.Parse(mountURL)
+	if err != nil {
+		return shard.Key{}, cid.Undef, fmt.Errorf("failed to parse mount url %s: %w", mountURL, err)
+	}
+	if u.Scheme != marketScheme && u.Scheme != sectorScheme {
+		return shard.Key{}, cid.Undef, fmt.Errorf("unsupported mount url %s", mountURL)
+	}
+	mnt := &PieceMount{}
+	if err := mnt.Deserialize(u); err != nil {
+		return shard.Key{}, cid.Undef, err
+	}
+	return shard.KeyFromCID(mnt.PieceCid), mnt.PieceCid, nil
+}
+
+// rewriteURL replaces the longest prefix of the url in rewrites
+func rewriteURL(mountURL string, rewrites map[string]string) string {
+	prefix := ""
+	for from := range rewrites {
+		if strings.HasPrefix(mountURL, from) && len(from) > len(prefix) {
+			prefix = from
+		}
+	}
+	if len(prefix) == 0 {
+		return mountURL
+	}
+	return rewrites[prefix] + mountURL[len(prefix):]
+}
+
+// iterableMultihashes converts the car index to the iterator required by the top index
+type iterableMultihashes struct {
+	idx carindex.IterableIndex
+}
+
+var _ index.MultihashIterator = (*iterableMultihashes)(nil)
+
+func (it *iterableMultihashes) ForEach(fn func(mh multihash.Multihash) error) error {
+	return it.idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		return fn(mh)
+	})
+}
+
+type journal struct {
+	f *os.File
+}
+
+// openJournal reads the records of the journal and opens it to append, a broken last record written when
+// it's interrupted is ignored
+func openJournal(path string) (*journal, []*journalRecord, error) {
+	var records []*journalRecord
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			var rec journalRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				log.Warnw("ignore broken journal record", "path", path, "error", err)
+				continue
+			}
+			records = append(records, &rec)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &journal{f: f}, records, nil
+}
+
+func (j *journal) append(rec *journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// copyWithChecksum copies the file to a temporary file and renames it, and returns its size and checksum
+func copyWithChecksum(src, dst string) (*bundleFile, error) {
+	from, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close() //nolint
+
+	to, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(to, h), from)
+	if err != nil {
+		_ = to.Close()
+		return nil, err
+	}
+	if err := to.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(dst+".tmp", dst); err != nil {
+		return nil, err
+	}
+	return &bundleFile{Name: filepath.Base(dst), Size: size, Sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func verifyBundleFile(dir string, file *bundleFile) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Name)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name, err)
+	}
+	defer f.Close() //nolint
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name, err)
+	}
+	if size != file.Size {
+		return fmt.Errorf("%s: size %d mismatch, expected %d", file.Name, size, file.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != file.Sha256 {
+		return fmt.Errorf("%s: checksum %s mismatch, expected %s", file.Name, sum, file.Sha256)
+	}
+	return nil
+}
+
+// bundleFileExists returns whether the file has been written, it's true if file is nil
+func bundleFileExists(dir string, file *bundleFile) bool {
+	if file == nil {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file.Name)))
+	return err == nil && info.Size() == file.Size
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,203 @@
+package dagstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipfs/go-cid"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func newBundleTestWrapper(ctx context.Context, t *testing.T) *Wrapper {
+	return newBundleTestWrapperWithSectors(ctx, t, nil)
+}
+
+// newBundleTestWrapperWithSectors creates a wrapper registering the shards with the sector mounts if sectors isn't nil
+func newBundleTestWrapperWithSectors(ctx context.Context, t *testing.T, sectors SectorReader) *Wrapper {
+	db, err := badger.NewDatastore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	_, w, err := NewDAGStore(ctx, &config.DAGStoreConfig{
+		RootDir:     t.TempDir(),
+		GCInterval:  config.Duration(time.Hour),
+		TopIndex:    TopIndexBadger,
+		SectorMount: config.SectorMount{Enable: sectors != nil},
+	}, mockLotusMount{}, badger.WrapDbToRepo(db), sectors, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Start(ctx))
+	t.Cleanup(func() {
+		_ = w.Close()
+	})
+	return w
+}
+
+func firstMultihash(t *testing.T, path string) multihash.Multihash {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close() //nolint
+	idx, err := carindex.ReadFrom(f)
+	require.NoError(t, err)
+
+	var first multihash.Multihash
+	require.NoError(t, idx.(carindex.IterableIndex).ForEach(func(mh multihash.Multihash, _ uint64) error {
+		if first == nil {
+			first = mh
+		}
+		return nil
+	}))
+	return first
+}
+
+func TestExportImportBundle(t *testing.T) {
+	ctx := context.Background()
+	pieces := []string{
+		"baga6ea4seaqd6cvb2padh74lthhiay4jtlwqhj2qetbj5cipna6jlkmcrdljulq",
+		"baga6ea4seaqgm6bzduzi2qj5sbnkqiw33r5ijcip5yx6d4iljsw36szsw3mjwpq",
+	}
+
+	// the shards are available with the index files, and added to the top index after registered
+	src := newBundleTestWrapper(ctx, t)
+	for _, piece := range pieces {
+		data, err := os.ReadFile(filepath.Join("fixtures/index", piece+indexFileSuffix))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(src.indexDir, piece+indexFileSuffix), data, 0o644))
+
+		pieceCid, err := cid.Decode(piece)
+		require.NoError(t, err)
+		resch := make(chan dagstore.ShardResult, 1)
+		require.NoError(t, src.RegisterShard(ctx, pieceCid, "", true, resch))
+		require.NoError(t, (<-resch).Error)
+	}
+
+	dir := t.TempDir()
+	exportRes, err := src.ExportBundle(ctx, dir, func(context.Context, string) string { return "old" })
+	require.NoError(t, err)
+	assert.Equal(t, 2, exportRes.Shards)
+	assert.Equal(t, 2, exportRes.Exported)
+	assert.Empty(t, exportRes.NoIndex)
+	assert.Greater(t, exportRes.TopIndexEntries, 0)
+
+	// the complete bundle can't be exported again
+	_, err = src.ExportBundle(ctx, dir, nil)
+	assert.Error(t, err)
+
+	// the corrupt file is found before importing
+	corruptDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(corruptDir, bundleIndexDir), 0o755))
+	for _, name := range []string{bundleManifestFile, bundleTopIndexFile, filepath.Join(bundleIndexDir, pieces[0]+indexFileSuffix), filepath.Join(bundleIndexDir, pieces[1]+indexFileSuffix)} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(corruptDir, name), data, 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(corruptDir, bundleIndexDir, pieces[0]+indexFileSuffix), []byte("corrupt"), 0o644))
+
+	dst := newBundleTestWrapper(ctx, t)
+	importRes, err := dst.ImportBundle(ctx, &mtypes.DagstoreImportParams{Dir: corruptDir}, nil)
+	require.NoError(t, err)
+	assert.Len(t, importRes.Corrupt, 1)
+	assert.Equal(t, 0, importRes.Imported)
+
+	params := &mtypes.DagstoreImportParams{Dir: dir, StorageRenames: map[string]string{"old": "new"}}
+	checked := make(map[string]string)
+	pieceExists := func(_ context.Context, storage, pieceCid string) (bool, error) {
+		checked[pieceCid] = storage
+		return pieceCid == pieces[0], nil
+	}
+	importRes, err = dst.ImportBundle(ctx, params, pieceExists)
+	require.NoError(t, err)
+	assert.Empty(t, importRes.Corrupt)
+	assert.Empty(t, importRes.Errors)
+	assert.Equal(t, 2, importRes.Imported)
+	assert.Equal(t, exportRes.TopIndexEntries, importRes.TopIndexEntries)
+	assert.Equal(t, map[string]string{pieces[0]: "new", pieces[1]: "new"}, checked)
+	assert.Equal(t, []string{pieces[1] + " in new"}, importRes.MissingPieces)
+
+	for _, piece := range pieces {
+		key := shard.KeyFromString(piece)
+		info, err := dst.dagst.GetShardInfo(key)
+		require.NoError(t, err)
+		assert.Equal(t, dagstore.ShardStateAvailable, info.ShardState)
+
+		shards, err := dst.topIndex.GetShardsForMultihash(ctx, firstMultihash(t, filepath.Join(dst.indexDir, piece+indexFileSuffix)))
+		require.NoError(t, err)
+		assert.Contains(t, shards, key)
+	}
+
+	// the imported shards are skipped when it's imported again
+	importRes, err = dst.ImportBundle(ctx, params, pieceExists)
+	require.NoError(t, err)
+	assert.Equal(t, 0, importRes.Imported)
+	assert.Equal(t, 2, importRes.Skipped)
+}
+
+func TestExportImportSectorBundle(t *testing.T) {
+	ctx := context.Background()
+	piece := "baga6ea4seaqd6cvb2padh74lthhiay4jtlwqhj2qetbj5cipna6jlkmcrdljulq"
+	pieceCid, err := cid.Decode(piece)
+	require.NoError(t, err)
+	key := shard.KeyFromCID(pieceCid)
+
+	src := newBundleTestWrapperWithSectors(ctx, t, &sectorTestReader{})
+	data, err := os.ReadFile(filepath.Join("fixtures/index", piece+indexFileSuffix))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(src.indexDir, piece+indexFileSuffix), data, 0o644))
+	resch := make(chan dagstore.ShardResult, 1)
+	require.NoError(t, src.RegisterShard(ctx, pieceCid, "", true, resch))
+	require.NoError(t, (<-resch).Error)
+
+	shards, err := src.shardRepo.ListShards(ctx)
+	require.NoError(t, err)
+	require.Len(t, shards, 1)
+	assert.True(t, strings.HasPrefix(shards[0].URL, sectorScheme+"://"))
+
+	// the shard is registered with the sector mount if it's enabled, otherwise with the market mount
+	for _, sectors := range []SectorReader{&sectorTestReader{}, nil} {
+		// the bundle records the import progress, so every host imports its own bundle
+		dir := t.TempDir()
+		exportRes, err := src.ExportBundle(ctx, dir, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, exportRes.Exported)
+
+		dst := newBundleTestWrapperWithSectors(ctx, t, sectors)
+		importRes, err := dst.ImportBundle(ctx, &mtypes.DagstoreImportParams{Dir: dir}, nil)
+		require.NoError(t, err)
+		assert.Empty(t, importRes.Errors)
+		assert.Equal(t, 1, importRes.Imported)
+		assert.Equal(t, exportRes.TopIndexEntries, importRes.TopIndexEntries)
+
+		info, err := dst.dagst.GetShardInfo(key)
+		require.NoError(t, err)
+		assert.Equal(t, dagstore.ShardStateAvailable, info.ShardState)
+
+		shards, err := dst.shardRepo.ListShards(ctx)
+		require.NoError(t, err)
+		require.Len(t, shards, 1)
+		scheme := marketScheme
+		if sectors != nil {
+			scheme = sectorScheme
+		}
+		assert.True(t, strings.HasPrefix(shards[0].URL, scheme+"://"), shards[0].URL)
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	rewrites := map[string]string{"market://": "market://", "market://baga": "market://bafk"}
+	assert.Equal(t, "market://bafkxx", rewriteURL("market://bagaxx", rewrites))
+	assert.Equal(t, "market://bafyxx", rewriteURL("market://bafyxx", rewrites))
+	assert.Equal(t, "other://xx", rewriteURL("other://xx", nil))
+}
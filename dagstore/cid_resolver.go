@@ -0,0 +1,241 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/index"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	carindex "github.com/ipld/go-car/v2/index"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// shardIndexGetter gets the state and the index of a shard, it's implemented by dagstore.Interface
+type shardIndexGetter interface {
+	GetShardInfo(k shard.Key) (dagstore.ShardInfo, error)
+	GetIterableIndex(key shard.Key) (carindex.IterableIndex, error)
+}
+
+var allDealStatuses = func() []storagemarket.StorageDealStatus {
+	statuses := make([]storagemarket.StorageDealStatus, 0, len(storagemarket.DealStates))
+	for status := range storagemarket.DealStates {
+		statuses = append(statuses, status)
+	}
+	return statuses
+}()
+
+// CIDResolver resolves the pieces containing a cid for the retrieval and the piece apis.
+// The top index of dagstore is preferred, the cid info repo is only used for the pieces
+// which are not indexed by dagstore, so the two sources never disagree on a piece.
+type CIDResolver struct {
+	topIndex index.Inverted
+	shards   shardIndexGetter
+	cidInfos repo.ICidInfoRepo
+	dealRepo repo.StorageDealRepo
+}
+
+func newCIDResolver(topIndex index.Inverted, shards shardIndexGetter, cidInfos repo.ICidInfoRepo, dealRepo repo.StorageDealRepo) *CIDResolver {
+	return &CIDResolver{
+		topIndex: topIndex,
+		shards:   shards,
+		cidInfos: cidInfos,
+		dealRepo: dealRepo,
+	}
+}
+
+// PiecesContainingBlock returns the pieces containing the block, the error wraps repo.ErrNotFound if there is none
+func (r *CIDResolver) PiecesContainingBlock(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+	locations, err := r.resolve(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	pieces := make([]cid.Cid, 0, len(locations))
+	for _, l := range locations {
+		pieces = append(pieces, l.PieceCID)
+	}
+	return pieces, nil
+}
+
+// Locate returns every piece containing the cid with the offsets of the block in the pieces, and the deals of the pieces
+func (r *CIDResolver) Locate(ctx context.Context, c cid.Cid) ([]*mtypes.CIDLocation, error) {
+	locations, err := r.resolve(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range locations {
+		if l.Source == mtypes.CIDLocationTopIndex {
+			l.Offsets = r.offsets(l.PieceCID, c)
+		}
+
+		deals, err := r.dealRepo.GetDealsByPieceCidAndStatus(ctx, l.PieceCID, allDealStatuses...)
+		if err != nil && !errors.Is(err, repo.ErrNotFound) {
+			return nil, fmt.Errorf("failed to get deals of piece %s: %w", l.PieceCID, err)
+		}
+		l.Deals = make([]*mtypes.CIDDeal, 0, len(deals))
+		for _, deal := range deals {
+			l.Deals = append(l.Deals, &mtypes.CIDDeal{
+				ProposalCid:  deal.ProposalCid,
+				DealID:       deal.DealID,
+				Miner:        deal.Proposal.Provider,
+				State:        storagemarket.DealStates[deal.State],
+				PieceStatus:  string(deal.PieceStatus),
+				SectorNumber: deal.SectorNumber,
+				Offset:       deal.Offset,
+			})
+		}
+	}
+
+	return locations, nil
+}
+
+// CIDInfo returns the locations of the cid in the format of the piecestore, a location without offset
+// is returned for the piece whose offsets are unknown
+func (r *CIDResolver) CIDInfo(ctx context.Context, c cid.Cid) (piecestore.CIDInfo, error) {
+	locations, err := r.resolve(ctx, c)
+	if err != nil {
+		return piecestore.CIDInfo{}, err
+	}
+
+	ci := piecestore.CIDInfo{CID: c}
+	for _, l := range locations {
+		if l.Source == mtypes.CIDLocationTopIndex {
+			l.Offsets = r.offsets(l.PieceCID, c)
+		}
+		if len(l.Offsets) == 0 {
+			ci.PieceBlockLocations = append(ci.PieceBlockLocations, piecestore.PieceBlockLocation{PieceCID: l.PieceCID})
+			continue
+		}
+		for _, offset := range l.Offsets {
+			ci.PieceBlockLocations = append(ci.PieceBlockLocations, piecestore.PieceBlockLocation{
+				BlockLocation: piecestore.BlockLocation{RelOffset: offset, BlockSize: l.BlockSize},
+				PieceCID:      l.PieceCID,
+			})
+		}
+	}
+	return ci, nil
+}
+
+// resolve looks up the pieces in the top index first, the pieces found in the cid info repo are only
+// used if dagstore hasn't indexed them, the offsets of the pieces in the top index are not filled
+func (r *CIDResolver) resolve(ctx context.Context, c cid.Cid) ([]*mtypes.CIDLocation, error) {
+	var locations []*mtypes.CIDLocation
+	found := make(map[cid.Cid]*mtypes.CIDLocation)
+
+	keys, err := r.topIndex.GetShardsForMultihash(ctx, c.Hash())
+	if err != nil && !isTopIndexNotFound(err) {
+		return nil, fmt.Errorf("failed to get pieces containing %s from top index: %w", c, err)
+	}
+	for _, k := range keys {
+		// the shards destroyed can't be removed from the top index in datastore
+		if _, err := r.shards.GetShardInfo(k); errors.Is(err, dagstore.ErrShardUnknown) {
+			continue
+		}
+		pieceCid, err := cid.Parse(k.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert shard key %s to piece cid: %w", k, err)
+		}
+		if _, ok := found[pieceCid]; ok {
+			continue
+		}
+		l := &mtypes.CIDLocation{PieceCID: pieceCid, Source: mtypes.CIDLocationTopIndex}
+		found[pieceCid] = l
+		locations = append(locations, l)
+	}
+
+	ci, err := r.cidInfos.GetCIDInfo(ctx, c)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get cid info of %s: %w", c, err)
+	}
+	for _, pbl := range ci.PieceBlockLocations {
+		if !pbl.PieceCID.Defined() {
+			continue
+		}
+		if l, ok := found[pbl.PieceCID]; ok {
+			if l.Source == mtypes.CIDLocationCIDInfo {
+				l.Offsets = append(l.Offsets, pbl.RelOffset)
+			}
+			continue
+		}
+		// the top index is complete for the pieces indexed by dagstore
+		if r.indexed(pbl.PieceCID) {
+			continue
+		}
+		l := &mtypes.CIDLocation{
+			PieceCID:  pbl.PieceCID,
+			Source:    mtypes.CIDLocationCIDInfo,
+			Offsets:   []uint64{pbl.RelOffset},
+			BlockSize: pbl.BlockSize,
+		}
+		found[pbl.PieceCID] = l
+		locations = append(locations, l)
+	}
+
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no piece contains %s: %w", c, repo.ErrNotFound)
+	}
+	return locations, nil
+}
+
+// indexed returns whether the piece has been indexed by dagstore
+func (r *CIDResolver) indexed(pieceCid cid.Cid) bool {
+	info, err := r.shards.GetShardInfo(shard.KeyFromCID(pieceCid))
+	if err != nil {
+		return false
+	}
+	return info.ShardState == dagstore.ShardStateAvailable || info.ShardState == dagstore.ShardStateServing
+}
+
+// offsets reads the offsets of the block from the index of the shard, nil if the index isn't available
+func (r *CIDResolver) offsets(pieceCid cid.Cid, c cid.Cid) []uint64 {
+	idx, err := r.shards.GetIterableIndex(shard.KeyFromCID(pieceCid))
+	if err != nil {
+		log.Debugf("failed to get index of piece %s: %v", pieceCid, err)
+		return nil
+	}
+	var offsets []uint64
+	if err := idx.GetAll(c, func(offset uint64) bool {
+		offsets = append(offsets, offset)
+		return true
+	}); err != nil && !errors.Is(err, carindex.ErrNotFound) {
+		log.Debugf("failed to get offsets of %s in piece %s: %v", c, pieceCid, err)
+	}
+	return offsets
+}
+
+// syncTopIndex adds the shard registered with an existing index to the top index, dagstore
+// only adds the shards it indexes itself
+func (r *CIDResolver) syncTopIndex(ctx context.Context, key shard.Key) error {
+	topIndex, ok := r.topIndex.(TopIndexRepo)
+	if !ok {
+		return nil
+	}
+	has, err := topIndex.HasShard(ctx, key)
+	if err != nil || has {
+		return err
+	}
+	idx, err := r.shards.GetIterableIndex(key)
+	if err != nil {
+		return fmt.Errorf("failed to get index of shard %s: %w", key, err)
+	}
+	return addMultihashesForShard(ctx, topIndex, &iterableMultihashes{idx}, key)
+}
+
+// forgetPiece removes the locations of the destroyed piece from the cid info repo, the piece
+// would be returned by the fallback otherwise
+func (r *CIDResolver) forgetPiece(ctx context.Context, pieceCid cid.Cid) error {
+	return r.cidInfos.RemovePieceBlockLocations(ctx, pieceCid)
+}
+
+func isTopIndexNotFound(err error) bool {
+	return errors.Is(err, ds.ErrNotFound) || errors.Is(err, mongo.ErrNoDocuments)
+}
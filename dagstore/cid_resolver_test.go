@@ -0,0 +1,79 @@
+package dagstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/go-fil-markets/piecestore"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func TestCIDResolver(t *testing.T) {
+	ctx := context.Background()
+	w := newBundleTestWrapper(ctx, t)
+	cidInfos := w.resolver.cidInfos
+
+	piece := "baga6ea4seaqd6cvb2padh74lthhiay4jtlwqhj2qetbj5cipna6jlkmcrdljulq"
+	pieceCid, err := cid.Decode(piece)
+	require.NoError(t, err)
+	legacyPiece, err := cid.Decode("baga6ea4seaqgm6bzduzi2qj5sbnkqiw33r5ijcip5yx6d4iljsw36szsw3mjwpq")
+	require.NoError(t, err)
+
+	indexPath := filepath.Join(w.indexDir, piece+indexFileSuffix)
+	data, err := os.ReadFile(filepath.Join("fixtures/index", piece+indexFileSuffix))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(indexPath, data, 0o644))
+	block := cid.NewCidV1(cid.Raw, firstMultihash(t, indexPath))
+
+	// the cid info repo only knows the legacy piece and a stale location of the registered piece
+	require.NoError(t, cidInfos.AddPieceBlockLocations(ctx, legacyPiece, map[cid.Cid]piecestore.BlockLocation{block: {RelOffset: 10, BlockSize: 5}}))
+	require.NoError(t, cidInfos.AddPieceBlockLocations(ctx, pieceCid, map[cid.Cid]piecestore.BlockLocation{block: {RelOffset: 1}}))
+
+	// the piece registered with an existing index is added to the top index
+	resch := make(chan dagstore.ShardResult, 1)
+	require.NoError(t, w.RegisterShard(ctx, pieceCid, "", true, resch))
+	require.NoError(t, (<-resch).Error)
+
+	pieces, err := w.GetPiecesContainingBlock(block)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{pieceCid, legacyPiece}, pieces)
+
+	locations, err := w.CIDResolver().Locate(ctx, block)
+	require.NoError(t, err)
+	require.Len(t, locations, 2)
+	assert.Equal(t, mtypes.CIDLocationTopIndex, locations[0].Source)
+	assert.NotEmpty(t, locations[0].Offsets)
+	assert.NotEqual(t, []uint64{1}, locations[0].Offsets)
+	assert.Equal(t, &mtypes.CIDLocation{
+		PieceCID:  legacyPiece,
+		Source:    mtypes.CIDLocationCIDInfo,
+		Offsets:   []uint64{10},
+		BlockSize: 5,
+		Deals:     []*mtypes.CIDDeal{},
+	}, locations[1])
+
+	ci, err := w.CIDResolver().CIDInfo(ctx, block)
+	require.NoError(t, err)
+	assert.Len(t, ci.PieceBlockLocations, len(locations[0].Offsets)+1)
+
+	// the destroyed piece is removed from both sources
+	resch = make(chan dagstore.ShardResult, 1)
+	require.NoError(t, w.DestroyShard(ctx, pieceCid, resch))
+	require.NoError(t, (<-resch).Error)
+
+	pieces, err = w.GetPiecesContainingBlock(block)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{legacyPiece}, pieces)
+
+	require.NoError(t, cidInfos.RemovePieceBlockLocations(ctx, legacyPiece))
+	_, err = w.GetPiecesContainingBlock(block)
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+}
@@ -0,0 +1,272 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+)
+
+const lazyMissCacheSize = 10000
+
+// the cid lookups which found no piece to index are not looked up in the deals again for a while,
+// as it's expensive to query the deals by the root cid
+var lazyMissTTL = 10 * time.Minute
+
+// the retrievals wait for the pieces to be indexed on demand for a while at most, the indexing goes on
+// after that and the following retrievals find the pieces in the index
+var lazyIndexWaitTimeout = time.Minute
+
+// lazyIndexer registers and indexes the pieces of the miners enabling lazy shard registration on demand,
+// the retrievals of a piece being indexed wait for the same indexing
+type lazyIndexer struct {
+	w        *Wrapper
+	dealRepo repo.StorageDealRepo
+	enabled  config.LazyShardRegistrationConfigFunc
+	throttle chan struct{}
+	missed   *lru.Cache[cid.Cid, time.Time]
+	// the pieces whose miners don't enable lazy shard registration, they are not looked up in the deals
+	// again for a while
+	disabled *lru.Cache[cid.Cid, time.Time]
+
+	lk       sync.Mutex
+	indexing map[cid.Cid]*lazyIndexing
+}
+
+type lazyIndexing struct {
+	done chan struct{}
+	err  error
+}
+
+func newLazyIndexer(w *Wrapper, dealRepo repo.StorageDealRepo, enabled config.LazyShardRegistrationConfigFunc, concurrency int) (*lazyIndexer, error) {
+	missed, err := lru.New[cid.Cid, time.Time](lazyMissCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := lru.New[cid.Cid, time.Time](lazyMissCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	li := &lazyIndexer{
+		w:        w,
+		dealRepo: dealRepo,
+		enabled:  enabled,
+		missed:   missed,
+		disabled: disabled,
+		indexing: make(map[cid.Cid]*lazyIndexing),
+	}
+	if concurrency > 0 {
+		li.throttle = make(chan struct{}, concurrency)
+	}
+	return li, nil
+}
+
+// enabledFor returns whether a miner storing the piece enables lazy shard registration
+func (li *lazyIndexer) enabledFor(ctx context.Context, pieceCid cid.Cid) bool {
+	if disabled, ok := li.disabled.Get(pieceCid); ok && time.Since(disabled) < lazyMissTTL {
+		return false
+	}
+
+	deals, err := li.dealRepo.GetDealsByPieceCidAndStatus(ctx, pieceCid, allDealStatuses...)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			li.disabled.Add(pieceCid, time.Now())
+		}
+		return false
+	}
+	if !li.anyEnabled(deals) {
+		li.disabled.Add(pieceCid, time.Now())
+		return false
+	}
+	return true
+}
+
+func (li *lazyIndexer) anyEnabled(deals []*markettypes.MinerDeal) bool {
+	miners, err := li.enabled()
+	if err != nil {
+		log.Warnf("failed to get the miners enabling lazy shard registration: %v", err)
+		return false
+	}
+	for _, deal := range deals {
+		for _, miner := range miners {
+			if deal.Proposal.Provider == miner {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// indexPiecesOf indexes the pieces of the deals whose root is the cid and which are not indexed yet,
+// it returns the number of the pieces indexed
+func (li *lazyIndexer) indexPiecesOf(ctx context.Context, c cid.Cid) int {
+	if missed, ok := li.missed.Get(c); ok && time.Since(missed) < lazyMissTTL {
+		return 0
+	}
+
+	pieces, err := li.candidates(ctx, c)
+	if err != nil {
+		log.Warnf("failed to find pieces to index for %s: %v", c, err)
+		return 0
+	}
+	if len(pieces) == 0 {
+		li.missed.Add(c, time.Now())
+		return 0
+	}
+
+	var (
+		wg      sync.WaitGroup
+		lk      sync.Mutex
+		indexed int
+	)
+	for _, pieceCid := range pieces {
+		wg.Add(1)
+		go func(pieceCid cid.Cid) {
+			defer wg.Done()
+			if err := li.index(ctx, pieceCid); err != nil {
+				log.Warnf("failed to index piece %s on demand: %v", pieceCid, err)
+				return
+			}
+			lk.Lock()
+			indexed++
+			lk.Unlock()
+		}(pieceCid)
+	}
+	wg.Wait()
+
+	// the pieces may be indexed later if the retrieval gave up waiting for them
+	if indexed == 0 && ctx.Err() == nil {
+		li.missed.Add(c, time.Now())
+	}
+	return indexed
+}
+
+// candidates returns the pieces of the miners enabling lazy shard registration, which have a deal
+// whose root is the cid and haven't been indexed
+func (li *lazyIndexer) candidates(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+	miners, err := li.enabled()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the miners enabling lazy shard registration: %w", err)
+	}
+
+	var pieces []cid.Cid
+	found := make(map[cid.Cid]struct{})
+	// only the deals of the miners enabling lazy shard registration are queried
+	for _, miner := range miners {
+		deals, err := li.dealRepo.GetDealsByDataCidAndDealStatus(ctx, miner, c, []markettypes.PieceStatus{markettypes.Proving})
+		if err != nil {
+			if errors.Is(err, repo.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		for _, deal := range deals {
+			pieceCid := deal.Proposal.PieceCID
+			if _, ok := found[pieceCid]; ok {
+				continue
+			}
+			found[pieceCid] = struct{}{}
+			if li.w.resolver.indexed(pieceCid) {
+				continue
+			}
+			pieces = append(pieces, pieceCid)
+		}
+	}
+	return pieces, nil
+}
+
+// index registers and indexes the piece, and waits for the result, the piece is indexed only once
+// if it's requested by several retrievals at the same time
+func (li *lazyIndexer) index(ctx context.Context, pieceCid cid.Cid) error {
+	li.lk.Lock()
+	call, ok := li.indexing[pieceCid]
+	if !ok {
+		call = &lazyIndexing{done: make(chan struct{})}
+		li.indexing[pieceCid] = call
+		// the indexing goes on after the retrieval is canceled, the other retrievals may wait for it
+		go func() {
+			call.err = li.doIndex(li.w.ctx, pieceCid)
+			li.lk.Lock()
+			delete(li.indexing, pieceCid)
+			li.lk.Unlock()
+			close(call.done)
+		}()
+	}
+	li.lk.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (li *lazyIndexer) doIndex(ctx context.Context, pieceCid cid.Cid) error {
+	if li.throttle != nil {
+		select {
+		case li.throttle <- struct{}{}:
+			defer func() { <-li.throttle }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	key := shard.KeyFromCID(pieceCid)
+	log.Infof("indexing piece %s on demand", pieceCid)
+	start := time.Now()
+
+	info, err := li.w.dagst.GetShardInfo(key)
+	switch {
+	case errors.Is(err, dagstore.ErrShardUnknown):
+		resch := make(chan dagstore.ShardResult, 1)
+		if err := li.w.registerShard(ctx, pieceCid, "", true, resch, false); err != nil {
+			return err
+		}
+		select {
+		case res := <-resch:
+			if res.Error != nil {
+				return fmt.Errorf("failed to register shard: %w", res.Error)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get shard info: %w", err)
+	case info.ShardState == dagstore.ShardStateAvailable || info.ShardState == dagstore.ShardStateServing:
+		return nil
+	default:
+		// the shard registered lazily is initialized when it's acquired for the first time
+		resch := make(chan dagstore.ShardResult, 1)
+		if err := li.w.dagst.AcquireShard(ctx, key, resch, dagstore.AcquireOpts{}); err != nil {
+			return fmt.Errorf("failed to acquire shard: %w", err)
+		}
+		var res dagstore.ShardResult
+		select {
+		case res = <-resch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if res.Error != nil {
+			return fmt.Errorf("failed to acquire shard: %w", res.Error)
+		}
+		_ = res.Accessor.Close()
+	}
+
+	if err := li.w.resolver.syncTopIndex(ctx, key); err != nil {
+		return fmt.Errorf("failed to add shard to top index: %w", err)
+	}
+	log.Infof("indexed piece %s on demand, took %v", pieceCid, time.Since(start))
+	return nil
+}
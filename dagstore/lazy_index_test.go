@@ -0,0 +1,183 @@
+package dagstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	blocks "github.com/ipfs/go-libipfs/blocks"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+)
+
+// lazyTestAPI serves every piece with the same car file
+type lazyTestAPI struct {
+	path string
+}
+
+func (a lazyTestAPI) Start(context.Context) error {
+	return nil
+}
+
+func (a lazyTestAPI) FetchFromPieceStorage(context.Context, cid.Cid) (mount.Reader, error) {
+	return os.Open(a.path)
+}
+
+func (a lazyTestAPI) GetUnpaddedCARSize(context.Context, cid.Cid) (uint64, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
+
+func (a lazyTestAPI) IsUnsealed(context.Context, cid.Cid) (bool, error) {
+	return true, nil
+}
+
+// writeTestCar writes a car file with a few blocks, and returns the path and the root
+func writeTestCar(t *testing.T) (string, cid.Cid) {
+	bgen := blocksutil.NewBlockGenerator()
+	blks := bgen.Blocks(5)
+	root := blks[0].Cid()
+	path := filepath.Join(t.TempDir(), "test.car")
+	bs, err := carblockstore.OpenReadWrite(path, []cid.Cid{root}, carblockstore.WriteAsCarV1(true))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, bs.Put(context.Background(), blk))
+	}
+	require.NoError(t, bs.Finalize())
+	return path, root
+}
+
+func TestLazyShardRegistration(t *testing.T) {
+	ctx := context.Background()
+	path, root := writeTestCar(t)
+
+	db, err := badger.NewDatastore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	r := badger.WrapDbToRepo(db)
+
+	// the miner of deal 0, 2 and 3 enables lazy shard registration, the miner of deal 1 doesn't
+	newAddr := address.NewForTestGetter()
+	lazyMiner, otherMiner := newAddr(), newAddr()
+	deals := make([]markettypes.MinerDeal, 4)
+	testutil.Provide(t, &deals)
+	for i := range deals {
+		deals[i].State = storagemarket.StorageDealActive
+		deals[i].PieceStatus = markettypes.Proving
+		deals[i].Ref = &storagemarket.DataRef{Root: root}
+		deals[i].Proposal.Provider = lazyMiner
+	}
+	deals[1].Proposal.Provider = otherMiner
+	deals[2].Ref.Root = deals[2].Proposal.PieceCID
+	deals[3].Ref.Root = deals[3].Proposal.PieceCID
+	for i := range deals {
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+
+	_, w, err := NewDAGStore(ctx, &config.DAGStoreConfig{
+		RootDir:                t.TempDir(),
+		GCInterval:             config.Duration(time.Hour),
+		TopIndex:               TopIndexBadger,
+		MaxConcurrentLazyIndex: 1,
+	}, lazyTestAPI{path: path}, r, nil, func() ([]address.Address, error) {
+		return []address.Address{lazyMiner}, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.Start(ctx))
+	t.Cleanup(func() {
+		_ = w.Close()
+	})
+
+	// the lookup which doesn't index pieces on demand finds nothing
+	_, err = w.IndexedPiecesContainingBlock(ctx, root)
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+	assert.False(t, w.resolver.indexed(deals[0].Proposal.PieceCID))
+
+	// the lookup of the root indexes the piece of the deal whose miner enables lazy shard registration
+	pieces, err := w.GetPiecesContainingBlock(root)
+	require.NoError(t, err)
+	assert.Equal(t, []cid.Cid{deals[0].Proposal.PieceCID}, pieces)
+	assert.True(t, w.resolver.indexed(deals[0].Proposal.PieceCID))
+	assert.False(t, w.resolver.indexed(deals[1].Proposal.PieceCID))
+
+	// the miss of a cid which is not a root is cached
+	missing := blocks.NewBlock([]byte("missing")).Cid()
+	_, err = w.GetPiecesContainingBlock(missing)
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+	_, ok := w.lazy.missed.Get(missing)
+	assert.True(t, ok)
+
+	// the piece whose miner doesn't enable lazy shard registration is not looked up in the deals again
+	assert.False(t, w.lazy.enabledFor(ctx, deals[1].Proposal.PieceCID))
+	_, ok = w.lazy.disabled.Get(deals[1].Proposal.PieceCID)
+	assert.True(t, ok)
+	assert.True(t, w.lazy.enabledFor(ctx, deals[2].Proposal.PieceCID))
+	_, ok = w.lazy.disabled.Get(deals[2].Proposal.PieceCID)
+	assert.False(t, ok)
+
+	// the retrieval of a piece without shard waits for it to be indexed
+	bs, err := w.LoadShard(ctx, deals[2].Proposal.PieceCID)
+	require.NoError(t, err)
+	has, err := bs.Has(ctx, root)
+	require.NoError(t, err)
+	assert.True(t, has)
+	require.NoError(t, bs.Close())
+	assert.True(t, w.resolver.indexed(deals[2].Proposal.PieceCID))
+
+	pieces, err = w.GetPiecesContainingBlock(root)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []cid.Cid{deals[0].Proposal.PieceCID, deals[2].Proposal.PieceCID}, pieces)
+
+	// the miss is not cached if the retrieval gives up waiting for the indexing
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = w.PiecesContainingBlock(cctx, deals[3].Proposal.PieceCID)
+	assert.Error(t, err)
+	_, ok = w.lazy.missed.Get(deals[3].Proposal.PieceCID)
+	assert.False(t, ok)
+}
+
+func TestLazyShardRegistrationDisabled(t *testing.T) {
+	ctx := context.Background()
+	path, _ := writeTestCar(t)
+
+	db, err := badger.NewDatastore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// the lookups which miss the index don't query the deals if no miner enables lazy shard registration
+	_, w, err := NewDAGStore(ctx, &config.DAGStoreConfig{
+		RootDir:    t.TempDir(),
+		GCInterval: config.Duration(time.Hour),
+		TopIndex:   TopIndexBadger,
+	}, lazyTestAPI{path: path}, badger.WrapDbToRepo(db), nil, func() ([]address.Address, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, w.Start(ctx))
+	t.Cleanup(func() {
+		_ = w.Close()
+	})
+	assert.Nil(t, w.lazy)
+}
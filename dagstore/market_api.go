@@ -14,6 +14,7 @@ import (
 
 	"github.com/filecoin-project/dagstore/mount"
 	"github.com/filecoin-project/dagstore/throttle"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
 	"github.com/filecoin-project/go-padreader"
 	gatewayAPIV2 "github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
 
@@ -40,7 +41,10 @@ type marketAPI struct {
 	throttle throttle.Throttler
 }
 
-var _ MarketAPI = (*marketAPI)(nil)
+var (
+	_ MarketAPI     = (*marketAPI)(nil)
+	_ pieceUnsealer = (*marketAPI)(nil)
+)
 
 func NewMarketAPI(
 	ctx metrics.MetricsCtx,
@@ -120,6 +124,29 @@ func (m *marketAPI) FetchFromPieceStorage(ctx context.Context, pieceCid cid.Cid)
 	return utils.NewAlgnZeroMountReader(r, int(payloadSize), int(pieceSize)), nil
 }
 
+// UnsealPiece unseals the piece from the sector of an active deal to a piece storage, it blocks until the unsealing finishes
+func (m *marketAPI) UnsealPiece(ctx context.Context, pieceCid cid.Cid) error {
+	if m.gatewayMarketClient == nil {
+		return fmt.Errorf("no gateway to unseal piece %s", pieceCid)
+	}
+	deals, err := m.pieceRepo.GetDealsByPieceCidAndStatus(ctx, pieceCid, storagemarket.StorageDealActive)
+	if err != nil {
+		return fmt.Errorf("failed to get active deals of piece %s: %w", pieceCid, err)
+	}
+	if len(deals) == 0 {
+		return fmt.Errorf("no active deal to unseal piece %s", pieceCid)
+	}
+	deal := deals[0]
+
+	st, release, err := m.pieceStorageMgr.FindStorageForWrite(deal.Proposal.Provider, int64(deal.Proposal.PieceSize))
+	if err != nil {
+		return fmt.Errorf("failed to find storage to unseal piece %s: %w", pieceCid, err)
+	}
+	defer release()
+
+	return piecestorage.UnsealPiece(ctx, m.gatewayMarketClient, st, deal)
+}
+
 func (m *marketAPI) GetUnpaddedCARSize(ctx context.Context, pieceCid cid.Cid) (uint64, error) {
 	pieceInfo, err := m.pieceRepo.GetPieceInfo(ctx, pieceCid)
 	if err != nil {
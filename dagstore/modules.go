@@ -59,6 +59,8 @@ func NewWrapperDAGStore(ctx metrics.MetricsCtx,
 	cfg *config.DAGStoreConfig,
 	minerAPI MarketAPI,
 	repo repo.Repo,
+	sectors SectorReader,
+	lazyShardRegistration config.LazyShardRegistrationConfigFunc,
 ) (*dagstore.DAGStore, stores.DAGStoreWrapper, error) {
 	// fall back to default root directory if not explicitly set in the config.
 	if cfg.RootDir == "" {
@@ -73,7 +75,7 @@ func NewWrapperDAGStore(ctx metrics.MetricsCtx,
 		}
 	}
 
-	dagst, w, err := NewDAGStore(ctx, cfg, minerAPI, repo)
+	dagst, w, err := NewDAGStore(ctx, cfg, minerAPI, repo, sectors, lazyShardRegistration)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create DAG store: %w", err)
 	}
@@ -93,4 +95,5 @@ func NewWrapperDAGStore(ctx metrics.MetricsCtx,
 var DagstoreOpts = builder.Options(
 	builder.Override(new(MarketAPI), CreateAndStartMarketAPI),
 	builder.Override(DAGStoreKey, NewWrapperDAGStore),
+	builder.Override(new(*Reconciler), NewReconciler),
 )
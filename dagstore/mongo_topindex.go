@@ -14,7 +14,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var _ index.Inverted = (*MongoTopIndex)(nil)
+var _ TopIndexRepo = (*MongoTopIndex)(nil)
 
 type TopIndex struct {
 	Id     string   `bson:"_id"`
@@ -38,21 +38,32 @@ func NewMongoTopIndex(ctx context.Context, url string) (*MongoTopIndex, error) {
 }
 
 func (mongoTopIndex *MongoTopIndex) AddMultihashesForShard(ctx context.Context, mhIter index.MultihashIterator, s shard.Key) error {
-	var models []mongo.WriteModel
-	if err := mhIter.ForEach(func(mh multihash.Multihash) error {
-		updateOne := mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": mh.HexString()}).SetUpdate(bson.M{
+	return addMultihashesForShard(ctx, mongoTopIndex, mhIter, s)
+}
+
+func (mongoTopIndex *MongoTopIndex) AddEntries(ctx context.Context, entries []*TopIndexEntry) error {
+	models := make([]mongo.WriteModel, 0, len(entries))
+	for _, entry := range entries {
+		pieces := make([]string, 0, len(entry.Shards))
+		for _, s := range entry.Shards {
+			pieces = append(pieces, s.String())
+		}
+		updateOne := mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": entry.Multihash.HexString()}).SetUpdate(bson.M{
 			"$addToSet": bson.M{
-				"pieces": s.String(),
+				"pieces": bson.M{"$each": pieces},
 			},
 		}).SetUpsert(true)
 		models = append(models, updateOne)
+	}
+	if len(models) == 0 {
 		return nil
-	}); err != nil {
-		return fmt.Errorf("failed to build mongo insert model: %w", err)
 	}
 	res, err := mongoTopIndex.indexCol.BulkWrite(ctx, models)
-	log.Infow("bulk write shard to mongo", "insert", res.InsertedCount, "update", res.ModifiedCount, "upsert", res.UpsertedCount)
-	return err
+	if err != nil {
+		return err
+	}
+	log.Debugw("bulk write shard to mongo", "insert", res.InsertedCount, "update", res.ModifiedCount, "upsert", res.UpsertedCount)
+	return nil
 }
 
 // GetShardsForMultihash returns keys for all the shards that has the given multihash.
@@ -81,3 +92,39 @@ func (mongoTopIndex *MongoTopIndex) HasShard(ctx context.Context, shard shard.Ke
 
 	return count > 0, nil
 }
+
+func (mongoTopIndex *MongoTopIndex) RemoveShard(ctx context.Context, shard shard.Key) error {
+	if _, err := mongoTopIndex.indexCol.UpdateMany(ctx, bson.M{"pieces": shard.String()}, bson.M{
+		"$pull": bson.M{"pieces": shard.String()},
+	}); err != nil {
+		return err
+	}
+	_, err := mongoTopIndex.indexCol.DeleteMany(ctx, bson.M{"pieces": bson.M{"$size": 0}})
+	return err
+}
+
+func (mongoTopIndex *MongoTopIndex) ForEach(ctx context.Context, fn func(entry *TopIndexEntry) error) error {
+	cursor, err := mongoTopIndex.indexCol.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx) //nolint
+	for cursor.Next(ctx) {
+		var topIndex TopIndex
+		if err := cursor.Decode(&topIndex); err != nil {
+			return err
+		}
+		mh, err := multihash.FromHexString(topIndex.Id)
+		if err != nil {
+			return fmt.Errorf("invalid multihash %s in top index: %w", topIndex.Id, err)
+		}
+		entry := &TopIndexEntry{Multihash: mh}
+		for _, r := range topIndex.Pieces {
+			entry.Shards = append(entry.Shards, shard.KeyFromString(r))
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/dagstore/shard"
 )
 
 const marketScheme = "market"
@@ -35,6 +36,8 @@ type PieceMount struct {
 	API          MarketAPI
 	PieceCid     cid.Cid
 	UseTransient bool // must use public, dagstore reflect field and set value from template
+	// Transients reserves the space for the piece before it's copied to the transients directory
+	Transients *TransientCache
 }
 
 func NewPieceMount(pieceCid cid.Cid, useTransient bool, api MarketAPI) (*PieceMount, error) {
@@ -62,6 +65,33 @@ func (l *PieceMount) Deserialize(u *url.URL) error {
 }
 
 func (l *PieceMount) Fetch(ctx context.Context) (mount.Reader, error) {
+	return l.fetch(ctx, l.fetchFromPieceStorage)
+}
+
+// fetch opens the piece with open, the space of the piece is reserved in the transients directory first
+// if the piece is going to be copied there
+func (l *PieceMount) fetch(ctx context.Context, open func(ctx context.Context) (mount.Reader, error)) (mount.Reader, error) {
+	if !l.UseTransient || l.Transients == nil {
+		return open(ctx)
+	}
+
+	size, err := l.API.GetUnpaddedCARSize(ctx, l.PieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch piece size for piece %s: %w", l.PieceCid, err)
+	}
+	key := shard.KeyFromCID(l.PieceCid)
+	if err := l.Transients.reserve(ctx, key, int64(size)); err != nil {
+		return nil, err
+	}
+	r, err := open(ctx)
+	if err != nil {
+		l.Transients.done(key, false)
+		return nil, err
+	}
+	return &transientReader{Reader: r, cache: l.Transients, key: key}, nil
+}
+
+func (l *PieceMount) fetchFromPieceStorage(ctx context.Context) (mount.Reader, error) {
 	r, err := l.API.FetchFromPieceStorage(ctx, l.PieceCid)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch unsealed piece %s: %w", l.PieceCid, err)
@@ -0,0 +1,370 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var (
+	// the max number of pieces waiting to be unsealed
+	reconcileUnsealQueueSize = 1000
+	// the number of pieces unsealed at the same time
+	reconcileUnsealWorkers = 4
+)
+
+// shardLister lists the shards in dagstore, it's implemented by dagstore.Interface
+type shardLister interface {
+	AllShardsInfo() dagstore.AllShardsInfo
+}
+
+// Reconciler cross-checks the pieces in piece storages, the deals and the dagstore shards,
+// and fixes them by registering the missing shards, destroying the orphan shards or unsealing the missing pieces
+type Reconciler struct {
+	cfg      config.DAGStoreReconcile
+	mgr      *piecestorage.PieceStorageManager
+	dealRepo repo.StorageDealRepo
+	shards   shardLister
+	wrapper  stores.DAGStoreWrapper
+	unsealer pieceUnsealer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lk      sync.Mutex
+	running bool
+	// the pieces queued or being unsealed
+	unsealing map[cid.Cid]struct{}
+	unsealCh  chan cid.Cid
+}
+
+func NewReconciler(
+	lc fx.Lifecycle,
+	cfg *config.DAGStoreConfig,
+	r repo.Repo,
+	mgr *piecestorage.PieceStorageManager,
+	dagst *dagstore.DAGStore,
+	wrapper stores.DAGStoreWrapper,
+	minerAPI MarketAPI,
+) *Reconciler {
+	unsealer, _ := minerAPI.(pieceUnsealer)
+	rc := newReconciler(cfg.Reconcile, mgr, r.StorageDealRepo(), dagst, wrapper, unsealer)
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			rc.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			rc.Close()
+			return nil
+		},
+	})
+	return rc
+}
+
+func newReconciler(
+	cfg config.DAGStoreReconcile,
+	mgr *piecestorage.PieceStorageManager,
+	dealRepo repo.StorageDealRepo,
+	shards shardLister,
+	wrapper stores.DAGStoreWrapper,
+	unsealer pieceUnsealer,
+) *Reconciler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reconciler{
+		cfg:       cfg,
+		mgr:       mgr,
+		dealRepo:  dealRepo,
+		shards:    shards,
+		wrapper:   wrapper,
+		unsealer:  unsealer,
+		ctx:       ctx,
+		cancel:    cancel,
+		unsealing: make(map[cid.Cid]struct{}),
+		unsealCh:  make(chan cid.Cid, reconcileUnsealQueueSize),
+	}
+}
+
+// Start runs the workers to unseal the missing pieces, and reconciles periodically if Interval is set
+func (rc *Reconciler) Start() {
+	for i := 0; i < reconcileUnsealWorkers; i++ {
+		rc.wg.Add(1)
+		go rc.unsealLoop()
+	}
+	if rc.cfg.Interval > 0 {
+		rc.wg.Add(1)
+		go rc.loop()
+	}
+}
+
+func (rc *Reconciler) Close() {
+	rc.cancel()
+	rc.wg.Wait()
+}
+
+func (rc *Reconciler) loop() {
+	defer rc.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(rc.cfg.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report, err := rc.Run(rc.ctx, rc.cfg.Fix)
+			if err != nil {
+				log.Errorf("failed to reconcile pieces, deals and shards: %v", err)
+				continue
+			}
+			for _, item := range report.Items {
+				log.Warnw("pieces, deals and shards disagree", "category", item.Category, "piece", item.PieceCid,
+					"fix", item.Fix, "error", item.Error)
+			}
+			log.Infow("reconciled pieces, deals and shards", "pieces", report.Pieces, "deal pieces", report.DealPieces,
+				"shards", report.Shards, "problems", len(report.Items), "took", report.FinishedAt.Sub(report.StartedAt))
+		case <-rc.ctx.Done():
+			return
+		}
+	}
+}
+
+// Run reconciles the pieces, deals and shards once, the problems are fixed if fix is true,
+// only one reconciliation runs at the same time
+func (rc *Reconciler) Run(ctx context.Context, fix bool) (*mtypes.ReconcileReport, error) {
+	rc.lk.Lock()
+	if rc.running {
+		rc.lk.Unlock()
+		return nil, fmt.Errorf("reconciliation is already running")
+	}
+	rc.running = true
+	rc.lk.Unlock()
+	defer func() {
+		rc.lk.Lock()
+		rc.running = false
+		rc.lk.Unlock()
+	}()
+
+	report := &mtypes.ReconcileReport{
+		StartedAt: time.Now(),
+		Fix:       fix,
+	}
+	// the deals are listed before the pieces and the shards, so that the pieces and shards of new deals are
+	// not treated as orphans
+	activeDeals, err := rc.dealRepo.GetDealByAddrAndStatus(ctx, address.Undef, storagemarket.StorageDealActive)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return nil, fmt.Errorf("failed to list active deals: %w", err)
+	}
+	dealPieces, err := rc.dealRepo.ListPieceInfoKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pieces of deals: %w", err)
+	}
+	referenced := make(map[cid.Cid]struct{}, len(dealPieces))
+	for _, pieceCid := range dealPieces {
+		referenced[pieceCid] = struct{}{}
+	}
+	report.DealPieces = len(referenced)
+
+	stored, unlisted, err := rc.storedPieces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Pieces = len(stored)
+	// the pieces not listed are looked up in the storages which can't be listed, eg. remote storages,
+	// a piece is taken as stored if the lookup fails, so that it's not fixed by mistake
+	isStored := func(resourceID string) bool {
+		if _, ok := stored[resourceID]; ok {
+			return true
+		}
+		for _, st := range unlisted {
+			has, err := st.Has(ctx, resourceID)
+			if err != nil {
+				log.Warnf("failed to check piece %s in piece storage %s: %v", resourceID, st.GetName(), err)
+				return true
+			}
+			if has {
+				return true
+			}
+		}
+		return false
+	}
+
+	shards := rc.shards.AllShardsInfo()
+	report.Shards = len(shards)
+
+	active := make(map[cid.Cid]struct{}, len(activeDeals))
+	for _, deal := range activeDeals {
+		pieceCid := deal.Proposal.PieceCID
+		if _, ok := active[pieceCid]; ok {
+			continue
+		}
+		active[pieceCid] = struct{}{}
+
+		if !isStored(pieceCid.String()) {
+			report.Items = append(report.Items, &mtypes.ReconcileItem{Category: mtypes.ReconcileMissingPiece, PieceCid: pieceCid.String()})
+			continue
+		}
+		if _, ok := shards[shard.KeyFromCID(pieceCid)]; !ok {
+			report.Items = append(report.Items, &mtypes.ReconcileItem{Category: mtypes.ReconcileMissingShard, PieceCid: pieceCid.String()})
+		}
+	}
+	for key := range shards {
+		if pieceCid, err := cid.Parse(key.String()); err == nil {
+			if _, ok := referenced[pieceCid]; ok {
+				continue
+			}
+		}
+		if isStored(key.String()) {
+			continue
+		}
+		report.Items = append(report.Items, &mtypes.ReconcileItem{Category: mtypes.ReconcileOrphanShard, PieceCid: key.String()})
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		if report.Items[i].Category != report.Items[j].Category {
+			return report.Items[i].Category < report.Items[j].Category
+		}
+		return report.Items[i].PieceCid < report.Items[j].PieceCid
+	})
+
+	if fix {
+		for _, item := range report.Items {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err := rc.fix(ctx, item); err != nil {
+				log.Warnf("failed to fix %s of piece %s: %v", item.Category, item.PieceCid, err)
+				item.Error = err.Error()
+			}
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+// storedPieces returns the resources in the piece storages, and the remote storages which can't be listed,
+// the storages are listed out of the lock of manager
+func (rc *Reconciler) storedPieces(ctx context.Context) (map[string]struct{}, []piecestorage.IPieceStorage, error) {
+	var storages, unlisted []piecestorage.IPieceStorage
+	_ = rc.mgr.EachPieceStorage(func(st piecestorage.IPieceStorage) error {
+		if st.Type() == piecestorage.Remote {
+			unlisted = append(unlisted, st)
+		} else {
+			storages = append(storages, st)
+		}
+		return nil
+	})
+
+	stored := make(map[string]struct{})
+	for _, st := range storages {
+		// a piece storage which fails to be listed makes all its pieces look missing, so give up
+		resourceIDs, err := st.ListResourceIds(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list resources of piece storage %s: %w", st.GetName(), err)
+		}
+		for _, resourceID := range resourceIDs {
+			stored[resourceID] = struct{}{}
+		}
+	}
+	return stored, unlisted, nil
+}
+
+func (rc *Reconciler) fix(ctx context.Context, item *mtypes.ReconcileItem) error {
+	pieceCid, err := cid.Parse(item.PieceCid)
+	if err != nil {
+		return fmt.Errorf("shard key is not a piece cid: %w", err)
+	}
+
+	resch := make(chan dagstore.ShardResult, 1)
+	switch item.Category {
+	case mtypes.ReconcileMissingShard:
+		if err := rc.wrapper.RegisterShard(ctx, pieceCid, "", false, resch); err != nil {
+			// registered after the shards are listed
+			if errors.Is(err, dagstore.ErrShardExists) {
+				item.Fix = mtypes.ReconcileFixRegisterShard
+				return nil
+			}
+			return err
+		}
+		item.Fix = mtypes.ReconcileFixRegisterShard
+	case mtypes.ReconcileOrphanShard:
+		if err := rc.wrapper.DestroyShard(ctx, pieceCid, resch); err != nil {
+			return err
+		}
+		item.Fix = mtypes.ReconcileFixDestroyShard
+	case mtypes.ReconcileMissingPiece:
+		if err := rc.queueUnseal(pieceCid); err != nil {
+			return err
+		}
+		item.Fix = mtypes.ReconcileFixQueueUnseal
+		return nil
+	default:
+		return fmt.Errorf("unknown category %s", item.Category)
+	}
+
+	select {
+	case res := <-resch:
+		if res.Error != nil {
+			item.Fix = ""
+		}
+		return res.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queueUnseal queues the piece to be unsealed from the sector of an active deal
+func (rc *Reconciler) queueUnseal(pieceCid cid.Cid) error {
+	if rc.unsealer == nil {
+		return fmt.Errorf("unsealing piece is not supported")
+	}
+
+	rc.lk.Lock()
+	defer rc.lk.Unlock()
+	if _, ok := rc.unsealing[pieceCid]; ok {
+		return nil
+	}
+	select {
+	case rc.unsealCh <- pieceCid:
+		rc.unsealing[pieceCid] = struct{}{}
+		return nil
+	default:
+		return fmt.Errorf("unseal queue is full")
+	}
+}
+
+func (rc *Reconciler) unsealLoop() {
+	defer rc.wg.Done()
+
+	for {
+		select {
+		case pieceCid := <-rc.unsealCh:
+			log.Infof("unsealing missing piece %s", pieceCid)
+			if err := rc.unsealer.UnsealPiece(rc.ctx, pieceCid); err != nil {
+				log.Errorf("failed to unseal missing piece %s: %v", pieceCid, err)
+			} else {
+				log.Infof("unsealed missing piece %s", pieceCid)
+			}
+			rc.lk.Lock()
+			delete(rc.unsealing, pieceCid)
+			rc.lk.Unlock()
+		case <-rc.ctx.Done():
+			return
+		}
+	}
+}
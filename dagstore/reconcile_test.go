@@ -0,0 +1,176 @@
+package dagstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func init() {
+	testutil.MustRegisterDefaultValueProvier(func(t *testing.T) types.DealLabel {
+		l, err := types.NewLabelFromBytes([]byte{})
+		assert.NoError(t, err)
+		return l
+	})
+}
+
+type reconcileTestShards dagstore.AllShardsInfo
+
+func (s reconcileTestShards) AllShardsInfo() dagstore.AllShardsInfo {
+	return dagstore.AllShardsInfo(s)
+}
+
+type reconcileTestUnsealer struct {
+	lk       sync.Mutex
+	unsealed []cid.Cid
+	done     chan struct{}
+}
+
+func (u *reconcileTestUnsealer) UnsealPiece(_ context.Context, pieceCid cid.Cid) error {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	u.unsealed = append(u.unsealed, pieceCid)
+	u.done <- struct{}{}
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	// deal 0 is fine, the piece of deal 1 has no shard, the piece of deal 2 is missing,
+	// deal 3 is expired and its piece is missing, deal 4 is not saved, its piece has an orphan shard
+	deals := make([]markettypes.MinerDeal, 5)
+	testutil.Provide(t, &deals)
+	for i := 0; i < 3; i++ {
+		deals[i].State = storagemarket.StorageDealActive
+	}
+	deals[3].State = storagemarket.StorageDealExpired
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+	pieceOf := func(i int) cid.Cid {
+		return deals[i].Proposal.PieceCID
+	}
+
+	psm, err := piecestorage.NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := piecestorage.NewMemPieceStore("mem", nil)
+	psm.AddMemPieceStorage(store)
+	for _, i := range []int{0, 1} {
+		_, err := store.SaveTo(ctx, pieceOf(i).String(), bytes.NewReader([]byte("piece data")))
+		require.NoError(t, err)
+	}
+
+	shards := reconcileTestShards{}
+	for _, i := range []int{0, 2, 3, 4} {
+		shards[shard.KeyFromCID(pieceOf(i))] = dagstore.ShardInfo{ShardState: dagstore.ShardStateAvailable}
+	}
+	wrapper := NewMockDagStoreWrapper()
+	unsealer := &reconcileTestUnsealer{done: make(chan struct{}, 1)}
+	rc := newReconciler(config.DAGStoreReconcile{}, psm, r.StorageDealRepo(), shards, wrapper, unsealer)
+	rc.Start()
+	defer rc.Close()
+
+	report, err := rc.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Pieces)
+	assert.Equal(t, 4, report.DealPieces)
+	assert.Equal(t, 4, report.Shards)
+	assert.Equal(t, []*mtypes.ReconcileItem{
+		{Category: mtypes.ReconcileMissingPiece, PieceCid: pieceOf(2).String()},
+		{Category: mtypes.ReconcileMissingShard, PieceCid: pieceOf(1).String()},
+		{Category: mtypes.ReconcileOrphanShard, PieceCid: pieceOf(4).String()},
+	}, report.Items)
+	assert.Equal(t, 0, wrapper.LenRegistrations())
+
+	report, err = rc.Run(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, report.Items, 3)
+	for _, item := range report.Items {
+		assert.Empty(t, item.Error)
+	}
+	assert.Equal(t, mtypes.ReconcileFixQueueUnseal, report.Items[0].Fix)
+	assert.Equal(t, mtypes.ReconcileFixRegisterShard, report.Items[1].Fix)
+	assert.Equal(t, mtypes.ReconcileFixDestroyShard, report.Items[2].Fix)
+
+	registration, ok := wrapper.GetRegistration(pieceOf(1))
+	require.True(t, ok)
+	assert.False(t, registration.EagerInit)
+
+	<-unsealer.done
+	unsealer.lk.Lock()
+	assert.Equal(t, []cid.Cid{pieceOf(2)}, unsealer.unsealed)
+	unsealer.lk.Unlock()
+}
+
+// reconcileTestRemoteStore is a remote piece storage which can't be listed
+type reconcileTestRemoteStore struct {
+	*piecestorage.MemPieceStore
+}
+
+func (s *reconcileTestRemoteStore) Type() piecestorage.Protocol {
+	return piecestorage.Remote
+}
+
+func (s *reconcileTestRemoteStore) ListResourceIds(context.Context) ([]string, error) {
+	return nil, errors.New("list resources of remote piece storage is not supported")
+}
+
+func TestReconcileRemoteStorage(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	// the piece of deal 0 is in the remote storage, the piece of deal 1 is missing,
+	// deal 2 is not saved, its piece is in the remote storage
+	deals := make([]markettypes.MinerDeal, 3)
+	testutil.Provide(t, &deals)
+	for i := 0; i < 2; i++ {
+		deals[i].State = storagemarket.StorageDealActive
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+	pieceOf := func(i int) cid.Cid {
+		return deals[i].Proposal.PieceCID
+	}
+
+	psm, err := piecestorage.NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	psm.AddMemPieceStorage(piecestorage.NewMemPieceStore("mem", nil))
+	remote := &reconcileTestRemoteStore{MemPieceStore: piecestorage.NewMemPieceStore("remote", nil)}
+	psm.AddMemPieceStorage(remote)
+	for _, i := range []int{0, 2} {
+		_, err := remote.SaveTo(ctx, pieceOf(i).String(), bytes.NewReader([]byte("piece data")))
+		require.NoError(t, err)
+	}
+
+	shards := reconcileTestShards{}
+	for _, i := range []int{0, 1, 2} {
+		shards[shard.KeyFromCID(pieceOf(i))] = dagstore.ShardInfo{ShardState: dagstore.ShardStateAvailable}
+	}
+	rc := newReconciler(config.DAGStoreReconcile{}, psm, r.StorageDealRepo(), shards, NewMockDagStoreWrapper(), &reconcileTestUnsealer{done: make(chan struct{}, 1)})
+
+	report, err := rc.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, []*mtypes.ReconcileItem{
+		{Category: mtypes.ReconcileMissingPiece, PieceCid: pieceOf(1).String()},
+	}, report.Items)
+}
@@ -0,0 +1,270 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var (
+	// the interval to check whether there are errored shards to recover
+	recoveryCheckInterval = 10 * time.Second
+	// the number of the latest attempts kept for a shard
+	maxRecoveryHistory = 10
+)
+
+// pieceUnsealer is implemented by the MarketAPI which can unseal the piece of a shard from the sector
+type pieceUnsealer interface {
+	UnsealPiece(ctx context.Context, pieceCid cid.Cid) error
+}
+
+// classifyShardError determines the cause of a shard failure from its error, the error of the shards
+// restored from the shard repo is only a message
+func classifyShardError(err error) mtypes.ShardErrorKind {
+	if errors.Is(err, piecestorage.ErrorNotFoundForRead) {
+		return mtypes.ShardErrorPieceMissing
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, piecestorage.ErrorNotFoundForRead.Error()), strings.Contains(msg, "no storage deals found"):
+		return mtypes.ShardErrorPieceMissing
+	case strings.Contains(msg, "index"):
+		return mtypes.ShardErrorIndexCorrupt
+	default:
+		return mtypes.ShardErrorStorageUnavailable
+	}
+}
+
+type shardRecoveryState struct {
+	mtypes.ShardRecovery
+	// the piece is unsealed at most once until the shard is recovered
+	unsealed bool
+}
+
+// shardRecoverer recovers the errored shards with exponential backoff, the piece of a shard is unsealed
+// if it's still missing after cfg.UnsealAfterAttempts attempts
+type shardRecoverer struct {
+	cfg      config.ShardRecovery
+	dagst    dagstore.Interface
+	unsealer pieceUnsealer
+	now      func() time.Time
+
+	lk     sync.Mutex
+	shards map[shard.Key]*shardRecoveryState
+	wg     sync.WaitGroup
+}
+
+func newShardRecoverer(cfg config.ShardRecovery, dagst dagstore.Interface, minerAPI MarketAPI) *shardRecoverer {
+	r := &shardRecoverer{
+		cfg:    cfg,
+		dagst:  dagst,
+		now:    time.Now,
+		shards: make(map[shard.Key]*shardRecoveryState),
+	}
+	if unsealer, ok := minerAPI.(pieceUnsealer); ok {
+		r.unsealer = unsealer
+	}
+	return r
+}
+
+// run consumes the shard failures and recovers the errored shards until ctx is done
+func (r *shardRecoverer) run(ctx context.Context, failureCh <-chan dagstore.ShardResult) {
+	ticker := time.NewTicker(recoveryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case res := <-failureCh:
+			if res.Error != nil {
+				r.onFailure(res.Key, res.Error)
+			}
+		case <-ticker.C:
+			r.recoverDue(ctx)
+		case <-ctx.Done():
+			r.wg.Wait()
+			return
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt after the failed attempts
+func (r *shardRecoverer) backoff(attempts int) time.Duration {
+	delay := time.Duration(r.cfg.InitialBackoff)
+	maxDelay := time.Duration(r.cfg.MaxBackoff)
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if maxDelay > 0 && delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+func (r *shardRecoverer) onFailure(key shard.Key, err error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	state, ok := r.shards[key]
+	if !ok {
+		state = &shardRecoveryState{ShardRecovery: mtypes.ShardRecovery{Key: key.String()}}
+		r.shards[key] = state
+	}
+	state.LastError = err.Error()
+	state.Kind = classifyShardError(err)
+	if ok && state.RecoveredAt.IsZero() {
+		// it's being recovered, or it's the failure of the last attempt
+		return
+	}
+
+	state.Attempts, state.GaveUp, state.unsealed = 0, false, false
+	state.RecoveredAt = time.Time{}
+	state.NextAttempt = r.now().Add(r.backoff(0))
+	log.Warnw("shard errored, will recover it", "shard", key, "kind", state.Kind, "next", state.NextAttempt, "error", err)
+}
+
+// recoverDue starts the attempts of the shards whose next attempt is due
+func (r *shardRecoverer) recoverDue(ctx context.Context) {
+	now := r.now()
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	for key, state := range r.shards {
+		if state.Recovering || state.GaveUp || !state.RecoveredAt.IsZero() || now.Before(state.NextAttempt) {
+			continue
+		}
+
+		info, err := r.dagst.GetShardInfo(key)
+		if err != nil {
+			if errors.Is(err, dagstore.ErrShardUnknown) {
+				delete(r.shards, key)
+			}
+			continue
+		}
+		if info.ShardState != dagstore.ShardStateErrored {
+			// recovered by others, eg. retrieval or the recover-shard command
+			state.RecoveredAt = now
+			continue
+		}
+
+		action := mtypes.ShardRecoveryRetry
+		if state.Kind == mtypes.ShardErrorPieceMissing && r.unsealer != nil && !state.unsealed &&
+			r.cfg.UnsealAfterAttempts > 0 && state.Attempts >= r.cfg.UnsealAfterAttempts {
+			action = mtypes.ShardRecoveryUnseal
+			state.unsealed = true
+		}
+		state.Recovering = true
+
+		attempt := &mtypes.ShardRecoveryAttempt{Time: now, Kind: state.Kind, Action: action}
+		r.wg.Add(1)
+		go func(key shard.Key) {
+			defer r.wg.Done()
+			err := r.attempt(ctx, key, action)
+			r.finish(key, attempt, err)
+		}(key)
+	}
+}
+
+func (r *shardRecoverer) attempt(ctx context.Context, key shard.Key, action mtypes.ShardRecoveryAction) error {
+	if action == mtypes.ShardRecoveryUnseal {
+		pieceCid, err := cid.Parse(key.String())
+		if err != nil {
+			return fmt.Errorf("shard key is not a piece cid: %w", err)
+		}
+		log.Infow("piece of shard is still missing, unseal it", "shard", key)
+		if err := r.unsealer.UnsealPiece(ctx, pieceCid); err != nil {
+			return fmt.Errorf("failed to unseal piece: %w", err)
+		}
+	}
+
+	resCh := make(chan dagstore.ShardResult, 1)
+	if err := r.dagst.RecoverShard(ctx, key, resCh, dagstore.RecoverOpts{}); err != nil {
+		return fmt.Errorf("failed to recover shard: %w", err)
+	}
+	select {
+	case res := <-resCh:
+		return res.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *shardRecoverer) finish(key shard.Key, attempt *mtypes.ShardRecoveryAttempt, err error) {
+	now := r.now()
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	state, ok := r.shards[key]
+	if !ok {
+		return
+	}
+	state.Recovering = false
+	attempt.Duration = now.Sub(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	state.History = append(state.History, attempt)
+	if len(state.History) > maxRecoveryHistory {
+		state.History = state.History[len(state.History)-maxRecoveryHistory:]
+	}
+
+	if err == nil {
+		state.RecoveredAt, state.NextAttempt = now, time.Time{}
+		log.Infow("recovered shard", "shard", key, "attempts", state.Attempts+1, "action", attempt.Action)
+		return
+	}
+
+	state.Attempts++
+	state.LastError = err.Error()
+	state.Kind = classifyShardError(err)
+	if r.cfg.MaxAttempts > 0 && state.Attempts >= r.cfg.MaxAttempts {
+		state.GaveUp, state.NextAttempt = true, time.Time{}
+		log.Errorw("give up recovering shard", "shard", key, "attempts", state.Attempts, "error", err)
+		return
+	}
+	state.NextAttempt = now.Add(r.backoff(state.Attempts))
+	log.Warnw("failed to recover shard", "shard", key, "attempts", state.Attempts, "kind", state.Kind,
+		"next", state.NextAttempt, "error", err)
+}
+
+// forget drops the recovery state of a destroyed shard
+func (r *shardRecoverer) forget(key shard.Key) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	delete(r.shards, key)
+}
+
+// list returns the recovery state of the shards which have errored, ordered by key
+func (r *shardRecoverer) list() []*mtypes.ShardRecovery {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	out := make([]*mtypes.ShardRecovery, 0, len(r.shards))
+	for _, state := range r.shards {
+		recovery := state.ShardRecovery
+		recovery.History = make([]*mtypes.ShardRecoveryAttempt, 0, len(state.History))
+		for _, attempt := range state.History {
+			a := *attempt
+			recovery.History = append(recovery.History, &a)
+		}
+		out = append(out, &recovery)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
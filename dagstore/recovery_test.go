@@ -0,0 +1,150 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/dagstore"
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+type recoveryTestDagstore struct {
+	dagstore.Interface
+
+	lk        sync.Mutex
+	state     dagstore.ShardState
+	recovered int
+	// the piece is found after it's unsealed
+	unsealed bool
+}
+
+func (d *recoveryTestDagstore) GetShardInfo(k shard.Key) (dagstore.ShardInfo, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return dagstore.ShardInfo{ShardState: d.state}, nil
+}
+
+func (d *recoveryTestDagstore) RecoverShard(_ context.Context, key shard.Key, out chan dagstore.ShardResult, _ dagstore.RecoverOpts) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.recovered++
+	if !d.unsealed {
+		out <- dagstore.ShardResult{Key: key, Error: fmt.Errorf("failed to recover shard: %w", piecestorage.ErrorNotFoundForRead)}
+		return nil
+	}
+	d.state = dagstore.ShardStateAvailable
+	out <- dagstore.ShardResult{Key: key}
+	return nil
+}
+
+func (d *recoveryTestDagstore) UnsealPiece(_ context.Context, _ cid.Cid) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.unsealed = true
+	return nil
+}
+
+func TestClassifyShardError(t *testing.T) {
+	assert.Equal(t, mtypes.ShardErrorPieceMissing, classifyShardError(fmt.Errorf("find piece for read: %w", piecestorage.ErrorNotFoundForRead)))
+	assert.Equal(t, mtypes.ShardErrorPieceMissing, classifyShardError(errors.New("failed to acquire reader of mount on initialization: find piece for read: not found for read")))
+	assert.Equal(t, mtypes.ShardErrorIndexCorrupt, classifyShardError(errors.New("failed to read/generate CAR Index: invalid header")))
+	assert.Equal(t, mtypes.ShardErrorStorageUnavailable, classifyShardError(errors.New("failed to acquire reader of mount on initialization: connection refused")))
+}
+
+func TestShardRecoveryBackoff(t *testing.T) {
+	r := &shardRecoverer{cfg: config.ShardRecovery{InitialBackoff: config.Duration(time.Minute), MaxBackoff: config.Duration(5 * time.Minute)}}
+	assert.Equal(t, time.Minute, r.backoff(0))
+	assert.Equal(t, 2*time.Minute, r.backoff(1))
+	assert.Equal(t, 4*time.Minute, r.backoff(2))
+	assert.Equal(t, 5*time.Minute, r.backoff(3))
+	assert.Equal(t, 5*time.Minute, r.backoff(100))
+}
+
+func TestShardRecoveryUnsealMissingPiece(t *testing.T) {
+	dagst := &recoveryTestDagstore{state: dagstore.ShardStateErrored}
+	r := newShardRecoverer(config.ShardRecovery{
+		Enable:              true,
+		InitialBackoff:      config.Duration(time.Minute),
+		MaxBackoff:          config.Duration(time.Hour),
+		UnsealAfterAttempts: 2,
+	}, dagst, nil)
+	r.unsealer = dagst
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	key := shard.KeyFromString("baga6ea4seaqecmtz7iak33dsfshi627abz4i4665dfuzr3qfs4bmad6dx3iigdq")
+	r.onFailure(key, fmt.Errorf("failed to acquire reader of mount on initialization: %w", piecestorage.ErrorNotFoundForRead))
+
+	// not due yet
+	r.recoverDue(ctx)
+	r.wg.Wait()
+	assert.Equal(t, 0, dagst.recovered)
+
+	for i := 1; i <= 2; i++ {
+		now = now.Add(time.Hour)
+		r.recoverDue(ctx)
+		r.wg.Wait()
+		// the failure of the attempt doesn't restart the recovery
+		r.onFailure(key, fmt.Errorf("failed to recover shard: %w", piecestorage.ErrorNotFoundForRead))
+		recoveries := r.list()
+		require.Len(t, recoveries, 1)
+		assert.Equal(t, i, recoveries[0].Attempts)
+		assert.Equal(t, mtypes.ShardErrorPieceMissing, recoveries[0].Kind)
+		assert.False(t, dagst.unsealed)
+	}
+
+	now = now.Add(time.Hour)
+	r.recoverDue(ctx)
+	r.wg.Wait()
+	assert.True(t, dagst.unsealed)
+	recoveries := r.list()
+	require.Len(t, recoveries, 1)
+	assert.False(t, recoveries[0].RecoveredAt.IsZero())
+	require.Len(t, recoveries[0].History, 3)
+	assert.Equal(t, mtypes.ShardRecoveryRetry, recoveries[0].History[0].Action)
+	assert.NotEmpty(t, recoveries[0].History[0].Error)
+	assert.Equal(t, mtypes.ShardRecoveryUnseal, recoveries[0].History[2].Action)
+	assert.Empty(t, recoveries[0].History[2].Error)
+
+	r.forget(key)
+	assert.Empty(t, r.list())
+}
+
+func TestShardRecoveryGiveUp(t *testing.T) {
+	dagst := &recoveryTestDagstore{state: dagstore.ShardStateErrored}
+	r := newShardRecoverer(config.ShardRecovery{
+		Enable:         true,
+		InitialBackoff: config.Duration(time.Minute),
+		MaxAttempts:    2,
+	}, dagst, nil)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	key := shard.KeyFromString("shard")
+	r.onFailure(key, errors.New("failed to acquire reader of mount on initialization: connection refused"))
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Hour)
+		r.recoverDue(ctx)
+		r.wg.Wait()
+	}
+	// never unseal without unsealer
+	assert.False(t, dagst.unsealed)
+	assert.Equal(t, 2, dagst.recovered)
+	recoveries := r.list()
+	require.Len(t, recoveries, 1)
+	assert.True(t, recoveries[0].GaveUp)
+	assert.Equal(t, 2, recoveries[0].Attempts)
+}
@@ -0,0 +1,266 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+
+	sharedTypes "github.com/filecoin-project/venus/venus-shared/types"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+const sectorScheme = "sector"
+
+// the size of the read to check the sealers are able to read the piece, it's the unpadded size of the smallest
+// padded unit, so that the sealers don't read more than needed
+const sectorProbeSize = 127
+
+var _ mount.Mount = (*SectorMount)(nil)
+
+// SectorReader reads a range of the unsealed piece from the sector, it's implemented by the market event stream
+// which sends the requests to the sealers
+type SectorReader interface {
+	SectorsReadPiece(ctx context.Context, req *mtypes.SectorReadRequest) ([]byte, error)
+}
+
+func sectorMountTemplate(piece PieceMount, sectors SectorReader, dealRepo repo.StorageDealRepo, readSize uint64, readTimeout time.Duration) *SectorMount {
+	return &SectorMount{
+		PieceMount:  piece,
+		Sectors:     sectors,
+		DealRepo:    dealRepo,
+		ReadSize:    readSize,
+		ReadTimeout: readTimeout,
+	}
+}
+
+// SectorMount is a DAGStore mount implementation that reads the unsealed piece from the sector of an active deal
+// through the sealers, it falls back to the piece storage if no sealer can read the piece.
+type SectorMount struct {
+	PieceMount // must be a value, the fields are copied from the template by dagstore
+
+	Sectors     SectorReader
+	DealRepo    repo.StorageDealRepo
+	ReadSize    uint64
+	ReadTimeout time.Duration
+}
+
+func (l *SectorMount) Fetch(ctx context.Context) (mount.Reader, error) {
+	return l.fetch(ctx, func(ctx context.Context) (mount.Reader, error) {
+		r, err := l.openSector(ctx)
+		if err == nil {
+			return r, nil
+		}
+		log.Infof("read piece %s from piece storage: %v", l.PieceCid, err)
+		return l.fetchFromPieceStorage(ctx)
+	})
+}
+
+func (l *SectorMount) Stat(ctx context.Context) (mount.Stat, error) {
+	stat, err := l.PieceMount.Stat(ctx)
+	if err != nil || stat.Ready {
+		return stat, err
+	}
+	// the piece can be read from the sector without unsealing it to a piece storage
+	deals, err := l.sectorDeals(ctx)
+	if err != nil {
+		return mount.Stat{}, err
+	}
+	stat.Ready = len(deals) > 0
+	return stat, nil
+}
+
+// sectorDeals returns the active deals of the piece, which have been packed into sectors
+func (l *SectorMount) sectorDeals(ctx context.Context) ([]*markettypes.MinerDeal, error) {
+	if l.Sectors == nil || l.DealRepo == nil {
+		return nil, nil
+	}
+	deals, err := l.DealRepo.GetDealsByPieceCidAndStatus(ctx, l.PieceCid, storagemarket.StorageDealActive)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return nil, fmt.Errorf("failed to get active deals of piece %s: %w", l.PieceCid, err)
+	}
+	return deals, nil
+}
+
+// openSector returns a reader of the piece in the sector of the first deal whose sealers can read it
+func (l *SectorMount) openSector(ctx context.Context) (mount.Reader, error) {
+	deals, err := l.sectorDeals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(deals) == 0 {
+		return nil, fmt.Errorf("no active deal of piece %s to read from sector", l.PieceCid)
+	}
+
+	var lastErr error
+	for _, deal := range deals {
+		r := &sectorPieceReader{
+			sectors: l.Sectors,
+			req: mtypes.SectorReadRequest{
+				Miner:       deal.Proposal.Provider,
+				PieceCid:    l.PieceCid,
+				Sid:         deal.SectorNumber,
+				PieceOffset: sharedTypes.UnpaddedByteIndex(deal.Offset.Unpadded()),
+			},
+			size:     int64(deal.Proposal.PieceSize.Unpadded()),
+			readSize: int64(l.ReadSize),
+			timeout:  l.ReadTimeout,
+		}
+		if r.readSize <= 0 {
+			r.readSize = config.DefaultSectorMountReadSize
+		}
+		if err := r.probe(ctx); err != nil {
+			lastErr = err
+			continue
+		}
+		return r, nil
+	}
+	return nil, lastErr
+}
+
+// sectorPieceReader reads the unpadded piece from the sector by chunks, the last chunk read is kept
+// as the blocks are usually read in order
+type sectorPieceReader struct {
+	sectors  SectorReader
+	req      mtypes.SectorReadRequest
+	size     int64
+	readSize int64
+	timeout  time.Duration
+
+	lk       sync.Mutex
+	pos      int64
+	bufStart int64
+	buf      []byte
+}
+
+var _ mount.Reader = (*sectorPieceReader)(nil)
+
+// probe reads a few bytes at the start of piece to check the sealers are able to read the piece
+func (r *sectorPieceReader) probe(ctx context.Context) error {
+	size := int64(sectorProbeSize)
+	if size > r.size {
+		size = r.size
+	}
+	_, err := r.read(ctx, 0, size)
+	return err
+}
+
+// read requests the range of piece from the sealers, the reply must be of the size requested
+func (r *sectorPieceReader) read(ctx context.Context, start, size int64) ([]byte, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	req := r.req
+	req.Offset = uint64(start)
+	req.Size = uint64(size)
+	buf, err := r.sectors.SectorsReadPiece(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) != size {
+		return nil, fmt.Errorf("read %d bytes of piece %s at %d, expect %d", len(buf), r.req.PieceCid, start, size)
+	}
+	return buf, nil
+}
+
+// chunk returns the chunk starting at start
+func (r *sectorPieceReader) chunk(ctx context.Context, start int64) ([]byte, error) {
+	r.lk.Lock()
+	if r.buf != nil && r.bufStart == start {
+		buf := r.buf
+		r.lk.Unlock()
+		return buf, nil
+	}
+	r.lk.Unlock()
+
+	size := r.readSize
+	if start+size > r.size {
+		size = r.size - start
+	}
+	buf, err := r.read(ctx, start, size)
+	if err != nil {
+		return nil, err
+	}
+
+	r.lk.Lock()
+	r.bufStart, r.buf = start, buf
+	r.lk.Unlock()
+	return buf, nil
+}
+
+func (r *sectorPieceReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		start := off - off%r.readSize
+		buf, err := r.chunk(context.Background(), start)
+		if err != nil {
+			return n, err
+		}
+		if off-start >= int64(len(buf)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		copied := copy(p[n:], buf[off-start:])
+		if copied == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (r *sectorPieceReader) Read(p []byte) (int, error) {
+	r.lk.Lock()
+	pos := r.pos
+	r.lk.Unlock()
+
+	n, err := r.ReadAt(p, pos)
+	r.lk.Lock()
+	r.pos = pos + int64(n)
+	r.lk.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sectorPieceReader) Seek(offset int64, whence int) (int64, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *sectorPieceReader) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package dagstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	mock_dagstore2 "github.com/ipfs-force-community/droplet/v2/dagstore/mocks"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+type sectorTestReader struct {
+	lk    sync.Mutex
+	piece []byte
+	fail  bool
+	// reply one byte less than requested
+	short bool
+	reqs  []mtypes.SectorReadRequest
+}
+
+func (s *sectorTestReader) SectorsReadPiece(_ context.Context, req *mtypes.SectorReadRequest) ([]byte, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.reqs = append(s.reqs, *req)
+	if s.fail {
+		return nil, errors.New("no sealer")
+	}
+	if s.short {
+		return s.piece[req.Offset : req.Offset+req.Size-1], nil
+	}
+	return s.piece[req.Offset : req.Offset+req.Size], nil
+}
+
+func TestSectorMount(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	var deal markettypes.MinerDeal
+	testutil.Provide(t, &deal)
+	deal.State = storagemarket.StorageDealActive
+	deal.Proposal.PieceSize = abi.PaddedPieceSize(2048)
+	deal.SectorNumber = 10
+	deal.Offset = abi.PaddedPieceSize(4096)
+	require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deal))
+	pieceCid := deal.Proposal.PieceCID
+
+	piece := make([]byte, deal.Proposal.PieceSize.Unpadded())
+	rand.New(rand.NewSource(1)).Read(piece) //nolint:gosec
+	sectors := &sectorTestReader{piece: piece}
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	api := mock_dagstore2.NewMockLotusAccessor(mockCtrl)
+
+	registry := mount.NewRegistry()
+	require.NoError(t, registry.Register(sectorScheme, sectorMountTemplate(*mountTemplate(api, false), sectors, r.StorageDealRepo(), 500, time.Minute)))
+	u, err := url.Parse(sectorScheme + "://" + pieceCid.String())
+	require.NoError(t, err)
+	mnt, err := registry.Instantiate(u)
+	require.NoError(t, err)
+
+	t.Run("read from sector", func(t *testing.T) {
+		rd, err := mnt.Fetch(ctx)
+		require.NoError(t, err)
+		bz, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		require.Equal(t, piece, bz)
+
+		buf := make([]byte, 300)
+		n, err := rd.ReadAt(buf, 900)
+		require.NoError(t, err)
+		require.Equal(t, 300, n)
+		require.Equal(t, piece[900:1200], buf)
+
+		n, err = rd.ReadAt(buf, int64(len(piece))-100)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, 100, n)
+		require.NoError(t, rd.Close())
+
+		// the sealers are probed by a small read
+		require.EqualValues(t, sectorProbeSize, sectors.reqs[0].Size)
+		for _, req := range sectors.reqs {
+			require.Equal(t, deal.Proposal.Provider, req.Miner)
+			require.Equal(t, deal.SectorNumber, req.Sid)
+			require.EqualValues(t, deal.Offset.Unpadded(), req.PieceOffset)
+			require.LessOrEqual(t, req.Size, uint64(500))
+		}
+	})
+
+	t.Run("short reply", func(t *testing.T) {
+		rd := &sectorPieceReader{sectors: &sectorTestReader{piece: piece, short: true}, size: int64(len(piece)), readSize: 500}
+		require.Error(t, rd.probe(ctx))
+		n, err := rd.ReadAt(make([]byte, 100), 0)
+		require.Error(t, err)
+		require.Equal(t, 0, n)
+	})
+
+	t.Run("fall back to piece storage", func(t *testing.T) {
+		sectors.fail = true
+		api.EXPECT().FetchFromPieceStorage(gomock.Any(), pieceCid).Return(testReader(), nil).Times(1)
+
+		rd, err := mnt.Fetch(ctx)
+		require.NoError(t, err)
+		bz, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		require.NoError(t, rd.Close())
+		require.Equal(t, []byte("testing"), bz)
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		api.EXPECT().GetUnpaddedCARSize(gomock.Any(), pieceCid).Return(uint64(100), nil).Times(1)
+		api.EXPECT().IsUnsealed(gomock.Any(), pieceCid).Return(false, nil).Times(1)
+
+		stat, err := mnt.Stat(ctx)
+		require.NoError(t, err)
+		require.True(t, stat.Ready)
+		require.EqualValues(t, 100, stat.Size)
+	})
+}
@@ -0,0 +1,126 @@
+package dagstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/dagstore/index"
+	"github.com/filecoin-project/dagstore/shard"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var _ TopIndexRepo = (*SQLTopIndex)(nil)
+
+const topIndexTableName = "top_index"
+
+type topIndexRow struct {
+	Multihash []byte `gorm:"column:multihash;primaryKey;type:varbinary(128)"`
+	ShardKey  string `gorm:"column:shard_key;primaryKey;type:varchar(128);index"`
+}
+
+func (r *topIndexRow) TableName() string {
+	return topIndexTableName
+}
+
+// SQLTopIndex saves the top index to mysql, a row for each pair of multihash and shard
+type SQLTopIndex struct {
+	db *gorm.DB
+}
+
+func NewSQLTopIndex(db *gorm.DB) (*SQLTopIndex, error) {
+	if err := db.AutoMigrate(&topIndexRow{}); err != nil {
+		return nil, fmt.Errorf("failed to create top index table: %w", err)
+	}
+	return &SQLTopIndex{db: db}, nil
+}
+
+func (s *SQLTopIndex) AddMultihashesForShard(ctx context.Context, mhIter index.MultihashIterator, key shard.Key) error {
+	return addMultihashesForShard(ctx, s, mhIter, key)
+}
+
+func (s *SQLTopIndex) AddEntries(ctx context.Context, entries []*TopIndexEntry) error {
+	rows := make([]*topIndexRow, 0, len(entries))
+	for _, entry := range entries {
+		for _, key := range entry.Shards {
+			rows = append(rows, &topIndexRow{Multihash: entry.Multihash, ShardKey: key.String()})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	// the existed rows are ignored
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 1000).Error
+}
+
+func (s *SQLTopIndex) GetShardsForMultihash(ctx context.Context, h multihash.Multihash) ([]shard.Key, error) {
+	var keys []string
+	if err := s.db.WithContext(ctx).Model(&topIndexRow{}).Where("multihash = ?", []byte(h)).
+		Pluck("shard_key", &keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("failed to lookup index for mh %s: %w", h, ds.ErrNotFound)
+	}
+	shardKeys := make([]shard.Key, 0, len(keys))
+	for _, key := range keys {
+		shardKeys = append(shardKeys, shard.KeyFromString(key))
+	}
+	return shardKeys, nil
+}
+
+func (s *SQLTopIndex) HasShard(ctx context.Context, key shard.Key) (bool, error) {
+	var row topIndexRow
+	err := s.db.WithContext(ctx).Take(&row, "shard_key = ?", key.String()).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SQLTopIndex) RemoveShard(ctx context.Context, key shard.Key) error {
+	return s.db.WithContext(ctx).Where("shard_key = ?", key.String()).Delete(&topIndexRow{}).Error
+}
+
+func (s *SQLTopIndex) ForEach(ctx context.Context, fn func(entry *TopIndexEntry) error) error {
+	var entry *TopIndexEntry
+	var last *topIndexRow
+	for {
+		var rows []*topIndexRow
+		query := s.db.WithContext(ctx).Order("multihash, shard_key").Limit(topIndexBatchSize)
+		if last != nil {
+			query = query.Where("multihash > ? OR (multihash = ? AND shard_key > ?)", last.Multihash, last.Multihash, last.ShardKey)
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			// the rows of a multihash may be in different pages
+			if entry != nil && !bytes.Equal(entry.Multihash, row.Multihash) {
+				if err := fn(entry); err != nil {
+					return err
+				}
+				entry = nil
+			}
+			if entry == nil {
+				entry = &TopIndexEntry{Multihash: row.Multihash}
+			}
+			entry.Shards = append(entry.Shards, shard.KeyFromString(row.ShardKey))
+		}
+		if len(rows) < topIndexBatchSize {
+			break
+		}
+		last = rows[len(rows)-1]
+	}
+	if entry != nil {
+		return fn(entry)
+	}
+	return nil
+}
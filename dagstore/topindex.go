@@ -0,0 +1,137 @@
+package dagstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/filecoin-project/dagstore/index"
+	"github.com/filecoin-project/dagstore/shard"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+	"gorm.io/gorm"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+)
+
+const (
+	TopIndexDatastore = ""
+	TopIndexMongo     = "mongo"
+	TopIndexMysql     = "mysql"
+	TopIndexBadger    = "badger"
+)
+
+// topIndexBatchSize is the number of entries written to the top index in a batch
+var topIndexBatchSize = 10000
+
+// TopIndexEntry is a multihash and the shards containing it
+type TopIndexEntry struct {
+	Multihash multihash.Multihash
+	Shards    []shard.Key
+}
+
+// TopIndexRepo is a persistent top index which maps the multihashes to the shards containing them
+type TopIndexRepo interface {
+	index.Inverted
+	// HasShard returns whether the multihashes of the shard are in the index
+	HasShard(ctx context.Context, s shard.Key) (bool, error)
+	// RemoveShard removes the shard from the entries of its multihashes
+	RemoveShard(ctx context.Context, s shard.Key) error
+	// ForEach calls fn with all the entries in the index
+	ForEach(ctx context.Context, fn func(entry *TopIndexEntry) error) error
+	// AddEntries adds the shards to the entries of multihashes, it's used to migrate the index
+	AddEntries(ctx context.Context, entries []*TopIndexEntry) error
+}
+
+// gormRepo is implemented by the repo saved in mysql
+type gormRepo interface {
+	GetDb() *gorm.DB
+}
+
+// newTopIndex creates the top index selected by config, the top index in the datastore of dagstore is not a TopIndexRepo
+func newTopIndex(ctx context.Context, cfg *config.DAGStoreConfig, dstore ds.Batching, r repo.Repo) (index.Inverted, error) {
+	kind := cfg.TopIndex
+	if kind == TopIndexDatastore && cfg.MongoTopIndex != nil {
+		kind = TopIndexMongo
+	}
+
+	switch kind {
+	case TopIndexDatastore:
+		return index.NewInverted(dstore), nil
+	case TopIndexMongo:
+		if cfg.MongoTopIndex == nil {
+			return nil, fmt.Errorf("MongoTopIndex must be set to save top index to mongo")
+		}
+		return NewMongoTopIndex(ctx, cfg.MongoTopIndex.Url)
+	case TopIndexMysql:
+		db, ok := r.(gormRepo)
+		if !ok {
+			return nil, fmt.Errorf("top index can be saved to mysql only when the repo is mysql")
+		}
+		return NewSQLTopIndex(db.GetDb())
+	case TopIndexBadger:
+		return NewBadgerTopIndex(filepath.Join(cfg.RootDir, "topindex"))
+	default:
+		return nil, fmt.Errorf("unknown top index %s", cfg.TopIndex)
+	}
+}
+
+// topIndexEntryBatch collects the entries and flushes them in batches
+type topIndexEntryBatch struct {
+	entries []*TopIndexEntry
+	flush   func(entries []*TopIndexEntry) error
+}
+
+func (b *topIndexEntryBatch) add(entry *TopIndexEntry) error {
+	b.entries = append(b.entries, entry)
+	if len(b.entries) < topIndexBatchSize {
+		return nil
+	}
+	return b.commit()
+}
+
+func (b *topIndexEntryBatch) commit() error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+	err := b.flush(b.entries)
+	b.entries = b.entries[:0]
+	return err
+}
+
+// addMultihashesForShard adds the multihashes of shard to the top index in batches
+func addMultihashesForShard(ctx context.Context, topIndex TopIndexRepo, mhIter index.MultihashIterator, s shard.Key) error {
+	batch := &topIndexEntryBatch{
+		flush: func(entries []*TopIndexEntry) error {
+			return topIndex.AddEntries(ctx, entries)
+		},
+	}
+	if err := mhIter.ForEach(func(mh multihash.Multihash) error {
+		return batch.add(&TopIndexEntry{Multihash: mh, Shards: []shard.Key{s}})
+	}); err != nil {
+		return fmt.Errorf("failed to add multihashes of shard %s to top index: %w", s, err)
+	}
+	return batch.commit()
+}
+
+// MigrateTopIndex copies all the entries of top index from src to dst, fn is called after each batch is copied
+func MigrateTopIndex(ctx context.Context, src, dst TopIndexRepo, fn func(copied int)) error {
+	copied := 0
+	batch := &topIndexEntryBatch{
+		flush: func(entries []*TopIndexEntry) error {
+			if err := dst.AddEntries(ctx, entries); err != nil {
+				return err
+			}
+			copied += len(entries)
+			if fn != nil {
+				fn(copied)
+			}
+			return nil
+		},
+	}
+	if err := src.ForEach(ctx, batch.add); err != nil {
+		return err
+	}
+	return batch.commit()
+}
@@ -0,0 +1,111 @@
+package dagstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/dagstore/shard"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type mhSlice []multihash.Multihash
+
+func (s mhSlice) ForEach(fn func(mh multihash.Multihash) error) error {
+	for _, mh := range s {
+		if err := fn(mh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testMultihashes(t *testing.T, n int) mhSlice {
+	mhs := make(mhSlice, 0, n)
+	for i := 0; i < n; i++ {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("block-%d", i)), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		mhs = append(mhs, mh)
+	}
+	return mhs
+}
+
+func newTestSQLTopIndex(t *testing.T) *SQLTopIndex {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sqlite.db")))
+	require.NoError(t, err)
+	topIndex, err := NewSQLTopIndex(db)
+	require.NoError(t, err)
+	return topIndex
+}
+
+func newTestBadgerTopIndex() *BadgerTopIndex {
+	return newBadgerTopIndex(dssync.MutexWrap(ds.NewMapDatastore()))
+}
+
+func TestTopIndexRepo(t *testing.T) {
+	// the entries are written and listed in several batches
+	batchSize := topIndexBatchSize
+	topIndexBatchSize = 3
+	defer func() {
+		topIndexBatchSize = batchSize
+	}()
+
+	ctx := context.Background()
+	mhs := testMultihashes(t, 10)
+	shardA, shardB := shard.KeyFromString("shard-a"), shard.KeyFromString("shard-b")
+
+	for name, topIndex := range map[string]TopIndexRepo{
+		"mysql":  newTestSQLTopIndex(t),
+		"badger": newTestBadgerTopIndex(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, topIndex.AddMultihashesForShard(ctx, mhs[:7], shardA))
+			require.NoError(t, topIndex.AddMultihashesForShard(ctx, mhs[5:], shardB))
+			// adding again is ignored
+			require.NoError(t, topIndex.AddMultihashesForShard(ctx, mhs[5:], shardB))
+
+			keys, err := topIndex.GetShardsForMultihash(ctx, mhs[0])
+			require.NoError(t, err)
+			assert.Equal(t, []shard.Key{shardA}, keys)
+			keys, err = topIndex.GetShardsForMultihash(ctx, mhs[6])
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []shard.Key{shardA, shardB}, keys)
+
+			entries := make(map[string][]shard.Key)
+			require.NoError(t, topIndex.ForEach(ctx, func(entry *TopIndexEntry) error {
+				assert.NotContains(t, entries, entry.Multihash.String())
+				entries[entry.Multihash.String()] = entry.Shards
+				return nil
+			}))
+			assert.Len(t, entries, 10)
+			assert.Len(t, entries[mhs[5].String()], 2)
+
+			// copy to another top index
+			dst := newTestBadgerTopIndex()
+			require.NoError(t, MigrateTopIndex(ctx, topIndex, dst, nil))
+			keys, err = dst.GetShardsForMultihash(ctx, mhs[5])
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []shard.Key{shardA, shardB}, keys)
+
+			require.NoError(t, topIndex.RemoveShard(ctx, shardA))
+			has, err := topIndex.HasShard(ctx, shardA)
+			require.NoError(t, err)
+			assert.False(t, has)
+			has, err = topIndex.HasShard(ctx, shardB)
+			require.NoError(t, err)
+			assert.True(t, has)
+			_, err = topIndex.GetShardsForMultihash(ctx, mhs[0])
+			assert.ErrorIs(t, err, ds.ErrNotFound)
+			keys, err = topIndex.GetShardsForMultihash(ctx, mhs[6])
+			require.NoError(t, err)
+			assert.Equal(t, []shard.Key{shardB}, keys)
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package dagstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/dagstore/shard"
+
+	marketMetrics "github.com/ipfs-force-community/droplet/v2/metrics"
+)
+
+const (
+	transientPrefix         = "transient-"
+	transientCompleteSuffix = ".complete"
+)
+
+type transientEntry struct {
+	size int64
+	// complete is set once the piece is fully copied to the transient file
+	complete bool
+	// fetching is set while the piece is being copied, the space is reserved but can't be evicted
+	fetching bool
+	// refs is the number of the accessors of the shard loaded by LoadShard
+	refs       int
+	lastAccess time.Time
+}
+
+// TransientCache keeps the transient files, which are copied from the piece storages when
+// DAGStoreConfig.UseTransient is set, under DAGStoreConfig.MaxTransientSize.
+// The space of a piece is reserved before it's fetched, and the least recently accessed
+// transients are evicted to make room for it, the fetch waits until the transients in use are released
+// if there isn't enough space.
+type TransientCache struct {
+	dir        string
+	maxSize    int64
+	metricsCtx context.Context
+
+	lk      sync.Mutex
+	entries map[shard.Key]*transientEntry
+	used    int64
+	// freed is closed and replaced when some space is freed or a transient is released
+	freed chan struct{}
+}
+
+func NewTransientCache(ctx context.Context, dir string, maxSize uint64) *TransientCache {
+	return &TransientCache{
+		dir:        dir,
+		maxSize:    int64(maxSize),
+		metricsCtx: ctx,
+		entries:    make(map[shard.Key]*transientEntry),
+		freed:      make(chan struct{}),
+	}
+}
+
+// load tracks the complete transient files left in the transients directory, and evicts the
+// least recently modified ones if they exceed the max size.
+func (c *TransientCache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read transients directory %s: %w", c.dir, err)
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, transientPrefix) || !strings.HasSuffix(name, transientCompleteSuffix) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key := shard.KeyFromString(strings.TrimSuffix(strings.TrimPrefix(name, transientPrefix), transientCompleteSuffix))
+		c.entries[key] = &transientEntry{size: info.Size(), complete: true, lastAccess: info.ModTime()}
+		c.used += info.Size()
+	}
+	if c.maxSize > 0 && c.used > c.maxSize {
+		c.evictLocked(c.used-c.maxSize, shard.Key{})
+	}
+	c.recordUsageLocked()
+	log.Infow("loaded transients", "dir", c.dir, "count", len(c.entries), "bytes", c.used)
+
+	return nil
+}
+
+// acquire marks the transient of the shard in use, the transient can't be evicted until it's released.
+// It returns whether the piece has been copied to the transient.
+func (c *TransientCache) acquire(key shard.Key) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.entries[key]
+	hit := ok && e.complete
+	if !ok {
+		e = &transientEntry{}
+		c.entries[key] = e
+	}
+	e.refs++
+	e.lastAccess = time.Now()
+
+	access := "miss"
+	if hit {
+		access = "hit"
+	}
+	_ = stats.RecordWithTags(c.metricsCtx, []tag.Mutator{tag.Upsert(marketMetrics.TransientAccessTag, access)}, marketMetrics.DagStoreTransientAccess.M(1))
+
+	return hit
+}
+
+// release drops a reference acquired by acquire.
+func (c *TransientCache) release(key shard.Key) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if e.refs > 0 {
+		e.refs--
+	}
+	e.lastAccess = time.Now()
+	if e.refs == 0 && !e.complete && !e.fetching {
+		delete(c.entries, key)
+	}
+	c.notifyLocked()
+}
+
+// reserve reserves the space for the piece of the shard before it's fetched to the transients directory,
+// it waits until enough transients are released if the space can't be freed by eviction.
+func (c *TransientCache) reserve(ctx context.Context, key shard.Key, size int64) error {
+	if c.maxSize > 0 && size > c.maxSize {
+		return fmt.Errorf("piece size %d of shard %s exceeds the max transient size %d", size, key, c.maxSize)
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &transientEntry{}
+		c.entries[key] = e
+	}
+	// the space of a transient being refetched is reserved again
+	if e.complete || e.fetching {
+		c.used -= e.size
+	}
+	e.size, e.complete, e.fetching = 0, false, true
+
+	for c.maxSize > 0 && c.used+size > c.maxSize {
+		if c.evictLocked(c.used+size-c.maxSize, key) {
+			continue
+		}
+
+		log.Debugw("waiting for transients to be released", "shard", key, "size", size, "used", c.used)
+		freed := c.freed
+		c.lk.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+		}
+		c.lk.Lock()
+		if ctx.Err() != nil {
+			e.fetching = false
+			if e.refs == 0 {
+				delete(c.entries, key)
+			}
+			return fmt.Errorf("waiting for transient space of shard %s: %w", key, ctx.Err())
+		}
+	}
+
+	e.size = size
+	e.lastAccess = time.Now()
+	c.used += size
+	c.recordUsageLocked()
+
+	return nil
+}
+
+// done is called when the fetch of the shard finishes, the reserved space is returned if it failed.
+func (c *TransientCache) done(key shard.Key, success bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !e.fetching {
+		return
+	}
+	e.fetching = false
+	e.lastAccess = time.Now()
+	if success {
+		e.complete = true
+		return
+	}
+
+	c.used -= e.size
+	e.size = 0
+	if e.refs == 0 {
+		delete(c.entries, key)
+	}
+	c.recordUsageLocked()
+	c.notifyLocked()
+}
+
+// forget drops the transient of the shard which is destroyed or garbage collected by dagstore
+func (c *TransientCache) forget(key shard.Key) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if e, ok := c.entries[key]; ok && !e.fetching {
+		c.used -= e.size
+		delete(c.entries, key)
+		c.recordUsageLocked()
+		c.notifyLocked()
+	}
+}
+
+// sync drops the transients whose files are removed out of the cache.
+func (c *TransientCache) sync() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	for key, e := range c.entries {
+		if !e.complete || e.fetching {
+			continue
+		}
+		if _, err := os.Stat(c.transientPath(key)); os.IsNotExist(err) {
+			c.used -= e.size
+			delete(c.entries, key)
+		}
+	}
+	c.recordUsageLocked()
+	c.notifyLocked()
+}
+
+// Used returns the bytes of the transients and the reserved space
+func (c *TransientCache) Used() int64 {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.used
+}
+
+// evictLocked removes the least recently accessed transients not in use until the space needed is freed,
+// it returns false if nothing can be evicted.
+func (c *TransientCache) evictLocked(need int64, except shard.Key) bool {
+	candidates := make([]shard.Key, 0, len(c.entries))
+	for key, e := range c.entries {
+		if key != except && e.complete && !e.fetching && e.refs == 0 {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return c.entries[candidates[i]].lastAccess.Before(c.entries[candidates[j]].lastAccess)
+	})
+
+	var freed int64
+	for _, key := range candidates {
+		if freed >= need {
+			break
+		}
+		e := c.entries[key]
+		// the transient is refetched by the upgrader of dagstore when it's missing
+		if err := os.Remove(c.transientPath(key)); err != nil && !os.IsNotExist(err) {
+			log.Warnw("failed to evict transient", "shard", key, "error", err)
+			continue
+		}
+		log.Debugw("evicted transient", "shard", key, "size", e.size)
+		freed += e.size
+		c.used -= e.size
+		delete(c.entries, key)
+		stats.Record(c.metricsCtx, marketMetrics.DagStoreTransientEvicted.M(1))
+	}
+	c.recordUsageLocked()
+
+	return freed > 0
+}
+
+func (c *TransientCache) notifyLocked() {
+	close(c.freed)
+	c.freed = make(chan struct{})
+}
+
+func (c *TransientCache) recordUsageLocked() {
+	stats.Record(c.metricsCtx, marketMetrics.DagStoreTransientBytes.M(c.used))
+}
+
+func (c *TransientCache) transientPath(key shard.Key) string {
+	return filepath.Join(c.dir, transientPrefix+key.String()+transientCompleteSuffix)
+}
+
+// transientReader reports the fetch of a piece to the transient cache when it's closed,
+// the upgrader of dagstore reads the piece to the end before closing it if the fetch succeeds.
+type transientReader struct {
+	mount.Reader
+
+	cache *TransientCache
+	key   shard.Key
+	eof   bool
+	once  sync.Once
+}
+
+func (r *transientReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *transientReader) Close() error {
+	err := r.Reader.Close()
+	r.once.Do(func() {
+		r.cache.done(r.key, r.eof && err == nil)
+	})
+	return err
+}
@@ -0,0 +1,102 @@
+package dagstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchTransient(t *testing.T, c *TransientCache, key shard.Key, size int64) {
+	require.NoError(t, c.reserve(context.Background(), key, size))
+	require.NoError(t, os.WriteFile(c.transientPath(key), make([]byte, size), 0o644))
+	c.done(key, true)
+}
+
+func TestTransientCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := NewTransientCache(ctx, dir, 100)
+	require.NoError(t, c.load())
+
+	a, b, d := shard.KeyFromString("a"), shard.KeyFromString("b"), shard.KeyFromString("d")
+
+	assert.False(t, c.acquire(a))
+	fetchTransient(t, c, a, 40)
+	c.release(a)
+	assert.False(t, c.acquire(b))
+	fetchTransient(t, c, b, 40)
+	c.release(b)
+	assert.EqualValues(t, 80, c.Used())
+
+	// a is accessed later than b, so b is evicted
+	assert.True(t, c.acquire(a))
+	c.release(a)
+	fetchTransient(t, c, d, 50)
+	assert.EqualValues(t, 90, c.Used())
+	_, err := os.Stat(c.transientPath(b))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(c.transientPath(a))
+	assert.NoError(t, err)
+
+	// the piece larger than the max size is never fetched
+	assert.Error(t, c.reserve(ctx, shard.KeyFromString("e"), 101))
+
+	// the transients are loaded after restarting
+	c2 := NewTransientCache(ctx, dir, 100)
+	require.NoError(t, c2.load())
+	assert.EqualValues(t, 90, c2.Used())
+	assert.True(t, c2.acquire(d))
+}
+
+func TestTransientCacheReserveWait(t *testing.T) {
+	ctx := context.Background()
+	c := NewTransientCache(ctx, t.TempDir(), 100)
+
+	a, b := shard.KeyFromString("a"), shard.KeyFromString("b")
+	c.acquire(a)
+	fetchTransient(t, c, a, 60)
+
+	// a is in use, the fetch of b waits until it's released
+	reserved := make(chan error, 1)
+	go func() {
+		reserved <- c.reserve(ctx, b, 60)
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("reserved the space of a transient in use")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.release(a)
+	select {
+	case err := <-reserved:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reservation")
+	}
+	assert.EqualValues(t, 60, c.Used())
+
+	// the space is returned if the fetch fails
+	c.done(b, false)
+	assert.EqualValues(t, 0, c.Used())
+
+	// the wait is canceled with the context
+	c.acquire(a)
+	fetchTransient(t, c, a, 60)
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.reserve(cctx, b, 60), context.DeadlineExceeded)
+	assert.EqualValues(t, 60, c.Used())
+
+	// the transients removed by dagstore are dropped
+	c.release(a)
+	require.NoError(t, os.Remove(filepath.Join(c.dir, transientPrefix+a.String()+transientCompleteSuffix)))
+	c.sync()
+	assert.EqualValues(t, 0, c.Used())
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -21,6 +22,7 @@ import (
 	"github.com/ipfs-force-community/droplet/v2/config"
 	"github.com/ipfs-force-community/droplet/v2/models/badger"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 	carindex "github.com/ipld/go-car/v2/index"
 
 	"github.com/filecoin-project/dagstore"
@@ -51,6 +53,16 @@ type Wrapper struct {
 	failureCh  chan dagstore.ShardResult
 	traceCh    chan dagstore.Trace
 	gcInterval time.Duration
+	topIndex   index.Inverted
+	recoverer  *shardRecoverer
+	transients *TransientCache
+	shardRepo  dagstore.ShardRepo
+	indexDir   string
+	resolver   *CIDResolver
+	// the template of the sector mounts, nil if the pieces are only read from the piece storage
+	sectorTemplate *SectorMount
+	// indexes the pieces on demand for the miners enabling lazy shard registration
+	lazy *lazyIndexer
 }
 
 var _ stores.DAGStoreWrapper = (*Wrapper)(nil)
@@ -59,10 +71,44 @@ func NewDAGStore(ctx context.Context,
 	cfg *config.DAGStoreConfig,
 	marketApi MarketAPI,
 	repo repo.Repo,
+	sectors SectorReader,
+	lazyShardRegistration config.LazyShardRegistrationConfigFunc,
 ) (*dagstore.DAGStore, *Wrapper, error) {
+	var (
+		transientsDir = filepath.Join(cfg.RootDir, "transients")
+		datastoreDir  = filepath.Join(cfg.RootDir, "datastore")
+		indexDir      = filepath.Join(cfg.RootDir, "index")
+	)
+
+	if len(cfg.Transient) != 0 {
+		transientsDir = cfg.Transient
+	}
+
+	var transients *TransientCache
+	if cfg.UseTransient {
+		transients = NewTransientCache(ctx, transientsDir, cfg.MaxTransientSize)
+	}
+
 	// construct the DAG Store.
 	registry := mount.NewRegistry()
-	if err := registry.Register(marketScheme, mountTemplate(marketApi, cfg.UseTransient)); err != nil {
+	template := mountTemplate(marketApi, cfg.UseTransient)
+	template.Transients = transients
+	if err := registry.Register(marketScheme, template); err != nil {
+		return nil, nil, fmt.Errorf("failed to create registry: %w", err)
+	}
+	// the sector mounts are always registered to restore the shards registered with them, they
+	// fall back to the piece storage if the sector mount is disabled later
+	var sectorTemplate *SectorMount
+	if cfg.SectorMount.Enable {
+		readSize := cfg.SectorMount.ValidReadSize()
+		if readSize != cfg.SectorMount.ReadSize {
+			log.Warnf("read size %d of sector mount is out of range, use %d", cfg.SectorMount.ReadSize, readSize)
+		}
+		sectorTemplate = sectorMountTemplate(*template, sectors, repo.StorageDealRepo(), readSize, time.Duration(cfg.SectorMount.ReadTimeout))
+	} else {
+		sectorTemplate = sectorMountTemplate(*template, nil, nil, 0, 0)
+	}
+	if err := registry.Register(sectorScheme, sectorTemplate); err != nil {
 		return nil, nil, fmt.Errorf("failed to create registry: %w", err)
 	}
 
@@ -72,16 +118,6 @@ func NewDAGStore(ctx context.Context,
 	// The dagstore will write Trace events to the `traceCh` here.
 	traceCh := make(chan dagstore.Trace, 32)
 
-	var (
-		transientsDir = filepath.Join(cfg.RootDir, "transients")
-		datastoreDir  = filepath.Join(cfg.RootDir, "datastore")
-		indexDir      = filepath.Join(cfg.RootDir, "index")
-	)
-
-	if len(cfg.Transient) != 0 {
-		transientsDir = cfg.Transient
-	}
-
 	if len(cfg.Index) != 0 {
 		indexDir = cfg.Index
 	}
@@ -118,13 +154,9 @@ func NewDAGStore(ctx context.Context,
 		RecoverOnStart:            dagstore.RecoverNow,
 	}
 
-	if cfg.MongoTopIndex != nil {
-		dCfg.TopLevelIndex, err = NewMongoTopIndex(ctx, cfg.MongoTopIndex.Url)
-		if err != nil {
-			return nil, nil, err
-		}
-	} else {
-		dCfg.TopLevelIndex = index.NewInverted(dstore)
+	dCfg.TopLevelIndex, err = newTopIndex(ctx, cfg, dstore, repo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create top index: %w", err)
 	}
 
 	dagst, err := dagstore.NewDAGStore(dCfg)
@@ -132,6 +164,13 @@ func NewDAGStore(ctx context.Context,
 		return nil, nil, fmt.Errorf("failed to create DAG store: %w", err)
 	}
 
+	// the orphaned transients are cleared by dagstore when it's created
+	if transients != nil {
+		if err := transients.load(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	w := &Wrapper{
 		cfg:        cfg,
 		dagst:      dagst,
@@ -139,6 +178,31 @@ func NewDAGStore(ctx context.Context,
 		failureCh:  failureCh,
 		traceCh:    traceCh,
 		gcInterval: time.Duration(cfg.GCInterval),
+		topIndex:   dCfg.TopLevelIndex,
+		transients: transients,
+		shardRepo:  shardRepo,
+		indexDir:   indexDir,
+		resolver:   newCIDResolver(dCfg.TopLevelIndex, dagst, repo.CidInfoRepo(), repo.StorageDealRepo()),
+	}
+	if cfg.SectorMount.Enable && sectors != nil {
+		w.sectorTemplate = sectorTemplate
+	}
+	if lazyShardRegistration != nil {
+		// the lookups which miss the index query the deals, they are not worth it if no miner enables lazy shard registration
+		miners, err := lazyShardRegistration()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get the miners enabling lazy shard registration: %w", err)
+		}
+		if len(miners) > 0 {
+			log.Infof("lazy shard registration is enabled for %v", miners)
+			w.lazy, err = newLazyIndexer(w, repo.StorageDealRepo(), lazyShardRegistration, cfg.MaxConcurrentLazyIndex)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if cfg.ShardRecovery.Enable {
+		w.recoverer = newShardRecoverer(cfg.ShardRecovery, dagst, marketApi)
 	}
 
 	return dagst, w, nil
@@ -179,7 +243,13 @@ func (w *Wrapper) Start(ctx context.Context) error {
 	go w.traceLoop()
 
 	// Run a go-routine for shard recovery
-	if dss, ok := w.dagst.(*dagstore.DAGStore); ok {
+	if w.recoverer != nil {
+		w.backgroundWg.Add(1)
+		go func() {
+			defer w.backgroundWg.Done()
+			w.recoverer.run(w.ctx, w.failureCh)
+		}()
+	} else if dss, ok := w.dagst.(*dagstore.DAGStore); ok {
 		w.backgroundWg.Add(1)
 		go dagstore.RecoverImmediately(w.ctx, dss, w.failureCh, maxRecoverAttempts, w.backgroundWg.Done)
 	}
@@ -215,7 +285,7 @@ func (w *Wrapper) gcLoop() {
 		select {
 		// GC the DAG store on every tick
 		case <-ticker.C:
-			_, _ = w.dagst.GC(w.ctx)
+			w.gc()
 
 		// Exit when the DAG store wrapper is shutdown
 		case <-w.ctx.Done():
@@ -224,6 +294,27 @@ func (w *Wrapper) gcLoop() {
 	}
 }
 
+// gc runs the GC of dagstore, which removes the transients of all the shards not acquired,
+// it's skipped if the size of the transients is limited, they are evicted by access instead.
+func (w *Wrapper) gc() {
+	if w.transients == nil {
+		_, _ = w.dagst.GC(w.ctx)
+		return
+	}
+
+	if w.cfg.MaxTransientSize == 0 {
+		res, err := w.dagst.GC(w.ctx)
+		if err == nil {
+			for key, err := range res.Shards {
+				if err == nil {
+					w.transients.forget(key)
+				}
+			}
+		}
+	}
+	w.transients.sync()
+}
+
 func (w *Wrapper) LoadShard(ctx context.Context, pieceCid cid.Cid) (stores.ClosableBlockstore, error) {
 	log := log.With("piece-cid", pieceCid)
 	log.Debug("acquiring shard")
@@ -242,6 +333,13 @@ func (w *Wrapper) LoadShard(ctx context.Context, pieceCid cid.Cid) (stores.Closa
 		sInfo, err = w.dagst.GetShardInfo(key)
 		if err != nil {
 			if errors.Is(err, dagstore.ErrShardUnknown) {
+				if w.lazy != nil && w.lazy.enabledFor(ctx, pieceCid) {
+					log.Info("shard not found, index it on demand")
+					if err := w.lazy.index(ctx, pieceCid); err != nil {
+						return nil, fmt.Errorf("failed to index piece %s on demand: %w", pieceCid, err)
+					}
+					continue
+				}
 				log.Warn("shard not found, try to re-register")
 				if err := stores.RegisterShardSync(ctx, w, pieceCid, "", false); err != nil {
 					return nil, fmt.Errorf("failed to re-register shard during loading pieceCID %s: %w", pieceCid, err)
@@ -274,11 +372,18 @@ func (w *Wrapper) LoadShard(ctx context.Context, pieceCid cid.Cid) (stores.Closa
 		}
 	}
 
+	// keep the transient from being evicted until the blockstore is closed
+	if w.transients != nil {
+		hit := w.transients.acquire(key)
+		log.Debugf("transient hit: %t", hit)
+	}
+
 	resCh := make(chan dagstore.ShardResult, 1)
 	err = w.dagst.AcquireShard(ctx, key, resCh, dagstore.AcquireOpts{})
 	log.Debugf("sent message to acquire shard for piece CID %s", pieceCid)
 
 	if err != nil {
+		w.releaseTransient(key)
 		return nil, fmt.Errorf("failed to acquire shard for piece CID %s: %w", pieceCid, err)
 	}
 
@@ -289,26 +394,74 @@ func (w *Wrapper) LoadShard(ctx context.Context, pieceCid cid.Cid) (stores.Closa
 	var res dagstore.ShardResult
 	select {
 	case <-ctx.Done():
+		w.releaseTransient(key)
 		return nil, ctx.Err()
 	case res = <-resCh:
 		if res.Error != nil {
+			w.releaseTransient(key)
 			return nil, fmt.Errorf("failed to acquire shard for piece CID %s: %w", pieceCid, res.Error)
 		}
 	}
 
 	bs, err := res.Accessor.Blockstore()
 	if err != nil {
+		_ = res.Accessor.Close()
+		w.releaseTransient(key)
 		return nil, err
 	}
 
 	log.Debugf("successfully loaded blockstore for piece CID %s", pieceCid)
-	return &Blockstore{ReadBlockstore: bs, Closer: res.Accessor}, nil
+	var closer io.Closer = res.Accessor
+	if w.transients != nil {
+		closer = &transientCloser{Closer: res.Accessor, release: func() { w.transients.release(key) }}
+	}
+	return &Blockstore{ReadBlockstore: bs, Closer: closer}, nil
+}
+
+func (w *Wrapper) releaseTransient(key shard.Key) {
+	if w.transients != nil {
+		w.transients.release(key)
+	}
+}
+
+// transientCloser releases the transient of the shard after the accessor is closed
+type transientCloser struct {
+	io.Closer
+	release func()
+	once    sync.Once
+}
+
+func (c *transientCloser) Close() error {
+	err := c.Closer.Close()
+	c.once.Do(c.release)
+	return err
 }
 
 func (w *Wrapper) RegisterShard(ctx context.Context, pieceCid cid.Cid, carPath string, eagerInit bool, resch chan dagstore.ShardResult) error {
+	return w.registerShard(ctx, pieceCid, carPath, eagerInit, resch, eagerInit)
+}
+
+// newMount creates the mount of the piece, the sector mount is used if it's enabled
+func (w *Wrapper) newMount(pieceCid cid.Cid) (mount.Mount, error) {
+	mt, err := NewPieceMount(pieceCid, w.cfg.UseTransient, w.minerAPI)
+	if err != nil {
+		return nil, err
+	}
+	mt.Transients = w.transients
+	if w.sectorTemplate == nil {
+		return mt, nil
+	}
+
+	sm := *w.sectorTemplate
+	sm.PieceMount = *mt
+	return &sm, nil
+}
+
+// registerShard registers the shard, and adds it to the top index after it's initialized if syncTopIndex is true
+func (w *Wrapper) registerShard(ctx context.Context, pieceCid cid.Cid, carPath string, eagerInit bool, resch chan dagstore.ShardResult, syncTopIndex bool) error {
 	// Create a lotus mount with the piece CID
 	key := shard.KeyFromCID(pieceCid)
-	mt, err := NewPieceMount(pieceCid, w.cfg.UseTransient, w.minerAPI)
+	mt, err := w.newMount(pieceCid)
 	if err != nil {
 		return fmt.Errorf("failed to create lotus mount for piece CID %s: %w", pieceCid, err)
 	}
@@ -318,10 +471,32 @@ func (w *Wrapper) RegisterShard(ctx context.Context, pieceCid cid.Cid, carPath s
 		ExistingTransient:  carPath,
 		LazyInitialization: !eagerInit,
 	}
-	err = w.dagst.RegisterShard(ctx, key, mt, resch, opts)
+	regCh := resch
+	if syncTopIndex {
+		regCh = make(chan dagstore.ShardResult, 1)
+	}
+	err = w.dagst.RegisterShard(ctx, key, mt, regCh, opts)
 	if err != nil {
 		return fmt.Errorf("failed to schedule register shard for piece CID %s: %w", pieceCid, err)
 	}
+	if syncTopIndex {
+		go func() {
+			var res dagstore.ShardResult
+			select {
+			case res = <-regCh:
+			case <-ctx.Done():
+				return
+			}
+			if res.Error == nil {
+				if err := w.resolver.syncTopIndex(ctx, key); err != nil {
+					log.Warnf("failed to add shard %s to top index: %v", key, err)
+				}
+			}
+			if resch != nil {
+				resch <- res
+			}
+		}()
+	}
 	log.Debugf("successfully submitted Register Shard request for piece CID %s with eagerInit=%t", pieceCid, eagerInit)
 
 	return nil
@@ -332,15 +507,65 @@ func (w *Wrapper) DestroyShard(ctx context.Context, pieceCid cid.Cid, resch chan
 
 	opts := dagstore.DestroyOpts{}
 
-	err := w.dagst.DestroyShard(ctx, key, resch, opts)
+	// remove the shard from top index after it's destroyed
+	destroyCh := make(chan dagstore.ShardResult, 1)
+	err := w.dagst.DestroyShard(ctx, key, destroyCh, opts)
 	if err != nil {
 		return fmt.Errorf("failed to schedule destroy shard for piece CID %s: %w", pieceCid, err)
 	}
+	go func() {
+		var res dagstore.ShardResult
+		select {
+		case res = <-destroyCh:
+		case <-ctx.Done():
+			return
+		}
+		if res.Error == nil {
+			if err := w.RemoveShardFromTopIndex(ctx, key); err != nil {
+				log.Warnf("failed to remove shard %s from top index: %v", key, err)
+			}
+			if err := w.resolver.forgetPiece(ctx, pieceCid); err != nil {
+				log.Warnf("failed to remove piece %s from cid info repo: %v", pieceCid, err)
+			}
+			w.ForgetShardRecovery(key)
+			if w.transients != nil {
+				w.transients.forget(key)
+			}
+		}
+		if resch != nil {
+			resch <- res
+		}
+	}()
 	log.Debugf("successfully submitted destroy Shard request for piece CID %s", pieceCid)
 
 	return nil
 }
 
+// RemoveShardFromTopIndex removes the destroyed shard from top index, the shards in the top index of
+// datastore can't be removed
+func (w *Wrapper) RemoveShardFromTopIndex(ctx context.Context, key shard.Key) error {
+	topIndex, ok := w.topIndex.(TopIndexRepo)
+	if !ok {
+		return nil
+	}
+	return topIndex.RemoveShard(ctx, key)
+}
+
+// ForgetShardRecovery drops the recovery history of the destroyed shard
+func (w *Wrapper) ForgetShardRecovery(key shard.Key) {
+	if w.recoverer != nil {
+		w.recoverer.forget(key)
+	}
+}
+
+// ShardRecoveries returns the recovery history of the shards which have errored since started
+func (w *Wrapper) ShardRecoveries() []*mtypes.ShardRecovery {
+	if w.recoverer == nil {
+		return []*mtypes.ShardRecovery{}
+	}
+	return w.recoverer.list()
+}
+
 func (w *Wrapper) MigrateDeals(ctx context.Context, deals []storagemarket.MinerDeal) (bool, error) {
 	log := log.Named("migrator")
 
@@ -472,25 +697,55 @@ func (w *Wrapper) markRegistrationComplete() error {
 
 // Get all the pieces that contain a block
 func (w *Wrapper) GetPiecesContainingBlock(blockCID cid.Cid) ([]cid.Cid, error) {
-	// Pieces are stored as "shards" in the DAG store
-	shardKeys, err := w.dagst.ShardsContainingMultihash(w.ctx, blockCID.Hash())
-	if err != nil {
-		return nil, fmt.Errorf("getting pieces containing block %s: %w", blockCID, err)
+	return w.PiecesContainingBlock(w.ctx, blockCID)
+}
+
+// PiecesContainingBlock gets all the pieces that contain a block, it waits for the pieces indexed on demand
+// until the context is done or lazyIndexWaitTimeout passes
+func (w *Wrapper) PiecesContainingBlock(ctx context.Context, blockCID cid.Cid) ([]cid.Cid, error) {
+	pieces, err := w.resolver.PiecesContainingBlock(ctx, blockCID)
+	if err == nil || w.lazy == nil || !errors.Is(err, repo.ErrNotFound) {
+		return pieces, err
+	}
+	// the pieces of the deals whose root is the cid may not be indexed yet
+	waitCtx, cancel := context.WithTimeout(ctx, lazyIndexWaitTimeout)
+	defer cancel()
+	if w.lazy.indexPiecesOf(waitCtx, blockCID) == 0 {
+		return nil, err
 	}
+	return w.resolver.PiecesContainingBlock(ctx, blockCID)
+}
 
-	// Convert from shard key to cid
-	pieceCids := make([]cid.Cid, 0, len(shardKeys))
-	for _, k := range shardKeys {
-		c, err := cid.Parse(k.String())
-		if err != nil {
-			prefix := fmt.Sprintf("getting pieces containing block %s:", blockCID)
-			return nil, fmt.Errorf("%s converting shard key %s to piece cid: %w", prefix, k, err)
-		}
+// IndexedPiecesContainingBlock gets the pieces that contain a block from the index, the pieces not indexed yet are
+// not indexed on demand
+func (w *Wrapper) IndexedPiecesContainingBlock(ctx context.Context, blockCID cid.Cid) ([]cid.Cid, error) {
+	return w.resolver.PiecesContainingBlock(ctx, blockCID)
+}
 
-		pieceCids = append(pieceCids, c)
+// PiecesContainingBlock gets all the pieces that contain a block with the context if the dagstore wrapper supports it
+func PiecesContainingBlock(ctx context.Context, dagStore stores.DAGStoreWrapper, blockCID cid.Cid) ([]cid.Cid, error) {
+	if w, ok := dagStore.(interface {
+		PiecesContainingBlock(context.Context, cid.Cid) ([]cid.Cid, error)
+	}); ok {
+		return w.PiecesContainingBlock(ctx, blockCID)
 	}
+	return dagStore.GetPiecesContainingBlock(blockCID)
+}
+
+// IndexedPiecesContainingBlock gets the pieces that contain a block without indexing any piece on demand if the
+// dagstore wrapper supports it, it's used by the lookups which anyone is able to send without a retrieval deal
+func IndexedPiecesContainingBlock(ctx context.Context, dagStore stores.DAGStoreWrapper, blockCID cid.Cid) ([]cid.Cid, error) {
+	if w, ok := dagStore.(interface {
+		IndexedPiecesContainingBlock(context.Context, cid.Cid) ([]cid.Cid, error)
+	}); ok {
+		return w.IndexedPiecesContainingBlock(ctx, blockCID)
+	}
+	return dagStore.GetPiecesContainingBlock(blockCID)
+}
 
-	return pieceCids, nil
+// CIDResolver returns the resolver which finds the pieces containing a cid from the top index and the cid info repo
+func (w *Wrapper) CIDResolver() *CIDResolver {
+	return w.resolver
 }
 
 func (w *Wrapper) GetIterableIndexForPiece(pieceCid cid.Cid) (carindex.IterableIndex, error) {
@@ -508,6 +763,12 @@ func (w *Wrapper) Close() error {
 	}
 	log.Info("dagstore closed")
 
+	if closer, ok := w.topIndex.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("failed to close top index: %w", err)
+		}
+	}
+
 	// Wait for the background go routine to exit
 	log.Info("waiting for dagstore background wrapper goroutines to exit")
 	w.backgroundWg.Wait()
@@ -37,7 +37,7 @@ func TestWrapperLoadShard(t *testing.T) {
 	dagst, w, err := NewDAGStore(ctx, &config.DAGStoreConfig{
 		RootDir:    t.TempDir(),
 		GCInterval: config.Duration(1 * time.Millisecond),
-	}, mockLotusMount{}, badger.NewBadgerRepo(badger.BadgerDSParams{}))
+	}, mockLotusMount{}, badger.NewBadgerRepo(badger.BadgerDSParams{}), nil, nil)
 	require.NoError(t, err)
 
 	defer dagst.Close() //nolint:errcheck
@@ -102,7 +102,7 @@ func TestWrapperBackground(t *testing.T) {
 	dagst, w, err := NewDAGStore(ctx, &config.DAGStoreConfig{
 		RootDir:    t.TempDir(),
 		GCInterval: config.Duration(1 * time.Millisecond),
-	}, mockLotusMount{}, badger.NewBadgerRepo(badger.BadgerDSParams{}))
+	}, mockLotusMount{}, badger.NewBadgerRepo(badger.BadgerDSParams{}), nil, nil)
 	require.NoError(t, err)
 
 	defer dagst.Close() //nolint:errcheck
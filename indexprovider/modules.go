@@ -0,0 +1,9 @@
+package indexprovider
+
+import (
+	"github.com/ipfs-force-community/venus-common-utils/builder"
+)
+
+var IndexProviderOpts = builder.Options(
+	builder.Override(new(*Provider), NewProvider),
+)
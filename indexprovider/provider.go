@@ -0,0 +1,574 @@
+package indexprovider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/filecoin-project/index-provider/metadata"
+	ingesthttpclient "github.com/filecoin-project/storetheindex/api/v0/ingest/client/http"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/dagsync/httpsync"
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"go.uber.org/fx"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var log = logging.Logger("index-provider")
+
+var (
+	headKey       = datastore.NewKey("/head")
+	blockPrefix   = datastore.NewKey("/blocks")
+	contextPrefix = datastore.NewKey("/contexts")
+)
+
+// transportIpfsGatewayHttp is the multicodec of the http retrieval transport in the metadata of advertisements,
+// it's not in the version of go-multicodec used
+const transportIpfsGatewayHttp = multicodec.Code(0x0920)
+
+// publisher serves the advertisements to indexers, it's implemented by httpsync publisher
+type publisher interface {
+	Address() multiaddr.Multiaddr
+	SetRoot(ctx context.Context, c cid.Cid) error
+	Close() error
+}
+
+// Provider announces the data of the active deals to IPNI indexers. It builds an advertisement from the shard index
+// of each piece of each miner, chains and signs the advertisements with the libp2p identity of droplet, serves the chain
+// over http for indexers to pull, and publishes removal advertisements for the deals which are expired or slashed.
+type Provider struct {
+	cfg       *config.MarketConfig
+	peerID    peer.ID
+	privKey   crypto.PrivKey
+	addrs     func() []multiaddr.Multiaddr
+	dealRepo  repo.StorageDealRepo
+	wrapper   stores.DAGStoreWrapper
+	ds        datastore.Batching
+	lsys      ipld.LinkSystem
+	publisher publisher
+	// the addresses of the publisher announced to indexers
+	publisherAddrs []multiaddr.Multiaddr
+	indexers       []*ingesthttpclient.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// only one sync runs at the same time
+	syncLk sync.Mutex
+	// the head is announced to indexers at least once after started
+	headAnnounced bool
+
+	lk     sync.Mutex
+	status mtypes.IndexProviderStatus
+}
+
+// NewProvider creates the index provider, it returns nil if the index provider is disabled in the config
+func NewProvider(
+	lc fx.Lifecycle,
+	cfg *config.MarketConfig,
+	h host.Host,
+	privKey crypto.PrivKey,
+	r repo.Repo,
+	wrapper stores.DAGStoreWrapper,
+	ds badger.IndexProviderDS,
+) (*Provider, error) {
+	if !cfg.IndexProvider.Enable {
+		return nil, nil
+	}
+
+	p, err := newProvider(cfg, privKey, h.Addrs, r.StorageDealRepo(), wrapper, ds)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			p.Start()
+			log.Infof("index provider started, peer id: %s, publisher addresses: %v", p.peerID, p.publisherAddrs)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return p.Close()
+		},
+	})
+	return p, nil
+}
+
+func newProvider(
+	cfg *config.MarketConfig,
+	privKey crypto.PrivKey,
+	addrs func() []multiaddr.Multiaddr,
+	dealRepo repo.StorageDealRepo,
+	wrapper stores.DAGStoreWrapper,
+	ds datastore.Batching,
+) (*Provider, error) {
+	peerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("get peer id from private key: %w", err)
+	}
+
+	p := &Provider{
+		cfg:      cfg,
+		peerID:   peerID,
+		privKey:  privKey,
+		addrs:    addrs,
+		dealRepo: dealRepo,
+		wrapper:  wrapper,
+		ds:       ds,
+	}
+	p.lsys = p.linkSystem()
+
+	for _, u := range cfg.IndexProvider.AnnounceURLs {
+		client, err := ingesthttpclient.New(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announce url %s: %w", u, err)
+		}
+		p.indexers = append(p.indexers, client)
+	}
+
+	head, err := p.head(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := httpsync.NewPublisher(cfg.IndexProvider.HttpListenAddress, p.lsys, peerID, privKey)
+	if err != nil {
+		return nil, fmt.Errorf("create http publisher: %w", err)
+	}
+	p.publisher = pub
+	if head.Defined() {
+		if err := pub.SetRoot(context.Background(), head); err != nil {
+			_ = pub.Close()
+			return nil, err
+		}
+	}
+	p.publisherAddrs = []multiaddr.Multiaddr{pub.Address()}
+	if len(cfg.IndexProvider.PublisherAnnounceAddress) > 0 {
+		maddr, err := multiaddr.NewMultiaddr(cfg.IndexProvider.PublisherAnnounceAddress)
+		if err != nil {
+			_ = pub.Close()
+			return nil, fmt.Errorf("could not parse '%s' as multiaddr: %w", cfg.IndexProvider.PublisherAnnounceAddress, err)
+		}
+		p.publisherAddrs = []multiaddr.Multiaddr{maddr}
+	}
+
+	p.status = mtypes.IndexProviderStatus{
+		Enabled:      true,
+		PeerID:       peerID.String(),
+		AnnounceURLs: cfg.IndexProvider.AnnounceURLs,
+	}
+	for _, maddr := range p.publisherAddrs {
+		p.status.PublisherAddresses = append(p.status.PublisherAddresses, maddr.String())
+	}
+	if head.Defined() {
+		p.status.Head = head.String()
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	return p, nil
+}
+
+// linkSystem stores the advertisements and entry chunks in the datastore
+func (p *Provider) linkSystem() ipld.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		ctx := lctx.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		val, err := p.ds.Get(ctx, blockPrefix.ChildString(lnk.String()))
+		if err != nil {
+			if errors.Is(err, datastore.ErrNotFound) {
+				return nil, ipld.ErrNotExists{}
+			}
+			return nil, err
+		}
+		return bytes.NewReader(val), nil
+	}
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		ctx := lctx.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			return p.ds.Put(ctx, blockPrefix.ChildString(lnk.String()), buf.Bytes())
+		}, nil
+	}
+	return lsys
+}
+
+// Start syncs the advertisements with the deals at once and then every Interval
+func (p *Provider) Start() {
+	p.wg.Add(1)
+	go p.loop()
+}
+
+func (p *Provider) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	return p.publisher.Close()
+}
+
+func (p *Provider) loop() {
+	defer p.wg.Done()
+
+	interval := time.Duration(p.cfg.IndexProvider.Interval)
+	if interval <= 0 {
+		interval = time.Duration(config.DefaultMarketConfig.IndexProvider.Interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := p.Sync(p.ctx)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return
+			}
+			log.Errorf("failed to sync advertisements: %v", err)
+		} else if res.Published > 0 || res.Removed > 0 || res.Skipped > 0 {
+			log.Infow("synced advertisements", "published", res.Published, "removed", res.Removed, "skipped", res.Skipped, "head", res.Head)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync publishes the advertisements of the new active deals and the removal advertisements of the deals
+// which are expired or slashed, then notifies the indexers of the new head
+func (p *Provider) Sync(ctx context.Context) (*mtypes.IndexProviderSyncResult, error) {
+	p.syncLk.Lock()
+	defer p.syncLk.Unlock()
+
+	res, err := p.sync(ctx)
+	p.lk.Lock()
+	p.status.LastSync = time.Now()
+	p.status.LastSyncError = ""
+	if err != nil {
+		p.status.LastSyncError = err.Error()
+	}
+	p.lk.Unlock()
+
+	return res, err
+}
+
+func (p *Provider) sync(ctx context.Context) (*mtypes.IndexProviderSyncResult, error) {
+	deals, err := p.dealRepo.GetDealByAddrAndStatus(ctx, address.Undef, storagemarket.StorageDealActive)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		return nil, fmt.Errorf("failed to list active deals: %w", err)
+	}
+	announced, err := p.announced(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &mtypes.IndexProviderSyncResult{}
+	active := make(map[datastore.Key]struct{}, len(deals))
+	for _, deal := range deals {
+		key := contextKey(deal.Proposal.Provider, deal.Proposal.PieceCID)
+		if _, ok := active[key]; ok {
+			continue
+		}
+		active[key] = struct{}{}
+		if _, ok := announced[key]; ok {
+			continue
+		}
+
+		if err := p.publish(ctx, deal); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Warnf("failed to publish advertisement of piece %s of miner %s: %v", deal.Proposal.PieceCID, deal.Proposal.Provider, err)
+			res.Skipped++
+			continue
+		}
+		res.Published++
+	}
+
+	removed := make([]datastore.Key, 0)
+	for key := range announced {
+		if _, ok := active[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].Less(removed[j])
+	})
+	for _, key := range removed {
+		if err := p.remove(ctx, key, announced[key]); err != nil {
+			return nil, fmt.Errorf("failed to publish removal advertisement of %s: %w", key, err)
+		}
+		res.Removed++
+	}
+
+	head, err := p.head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if head.Defined() {
+		res.Head = head.String()
+		if res.Published > 0 || res.Removed > 0 || !p.headAnnounced {
+			res.AnnounceErrors = p.announce(ctx, head)
+			p.headAnnounced = len(res.AnnounceErrors) == 0
+		}
+	}
+
+	return res, nil
+}
+
+// publish publishes the advertisement of the piece of the deal
+func (p *Provider) publish(ctx context.Context, deal *market.MinerDeal) error {
+	pieceCid := deal.Proposal.PieceCID
+	entries, err := p.storeEntries(ctx, pieceCid)
+	if err != nil {
+		return err
+	}
+
+	httpAddr, err := p.httpRetrievalAddr(deal.Proposal.Provider)
+	if err != nil {
+		return err
+	}
+	protocols := []metadata.Protocol{
+		&metadata.GraphsyncFilecoinV1{
+			PieceCID:      pieceCid,
+			VerifiedDeal:  deal.Proposal.VerifiedDeal,
+			FastRetrieval: deal.FastRetrieval,
+		},
+	}
+	if httpAddr != nil {
+		protocols = append(protocols, &metadata.Unknown{
+			Code:    transportIpfsGatewayHttp,
+			Payload: varint.ToUvarint(uint64(transportIpfsGatewayHttp)),
+		})
+	}
+	md := metadata.Default.New(protocols...)
+	mdBytes, err := md.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	ctxID := contextID(deal.Proposal.Provider, pieceCid)
+	ad := &schema.Advertisement{
+		Entries:   entries,
+		ContextID: ctxID,
+		Metadata:  mdBytes,
+	}
+	if err := p.publishAd(ctx, ad, httpAddr); err != nil {
+		return err
+	}
+	return p.ds.Put(ctx, contextKey(deal.Proposal.Provider, pieceCid), ctxID)
+}
+
+// remove publishes the removal advertisement of the context
+func (p *Provider) remove(ctx context.Context, key datastore.Key, contextID []byte) error {
+	ad := &schema.Advertisement{
+		Entries:   schema.NoEntries,
+		ContextID: contextID,
+		IsRm:      true,
+	}
+	if err := p.publishAd(ctx, ad, nil); err != nil {
+		return err
+	}
+	return p.ds.Delete(ctx, key)
+}
+
+// publishAd links the advertisement to the head, signs it and makes it the new head
+func (p *Provider) publishAd(ctx context.Context, ad *schema.Advertisement, httpAddr multiaddr.Multiaddr) error {
+	head, err := p.head(ctx)
+	if err != nil {
+		return err
+	}
+	if head.Defined() {
+		ad.PreviousID = cidlink.Link{Cid: head}
+	}
+	ad.Provider = p.peerID.String()
+	for _, maddr := range p.addrs() {
+		ad.Addresses = append(ad.Addresses, maddr.String())
+	}
+	if httpAddr != nil {
+		ad.Addresses = append(ad.Addresses, httpAddr.String())
+	}
+	if err := ad.Sign(p.privKey); err != nil {
+		return fmt.Errorf("sign advertisement: %w", err)
+	}
+	if err := ad.Validate(); err != nil {
+		return fmt.Errorf("invalid advertisement: %w", err)
+	}
+
+	node, err := ad.ToNode()
+	if err != nil {
+		return err
+	}
+	lnk, err := p.lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, node)
+	if err != nil {
+		return fmt.Errorf("store advertisement: %w", err)
+	}
+	adCid := lnk.(cidlink.Link).Cid
+	if err := p.ds.Put(ctx, headKey, adCid.Bytes()); err != nil {
+		return fmt.Errorf("save head: %w", err)
+	}
+	if err := p.publisher.SetRoot(ctx, adCid); err != nil {
+		return err
+	}
+
+	p.lk.Lock()
+	p.status.Head = adCid.String()
+	p.lk.Unlock()
+	return nil
+}
+
+// storeEntries stores the multihashes in the shard index of the piece as a chain of entry chunks,
+// and returns the link of the first chunk
+func (p *Provider) storeEntries(ctx context.Context, pieceCid cid.Cid) (ipld.Link, error) {
+	idx, err := p.wrapper.GetIterableIndexForPiece(pieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("get index of piece: %w", err)
+	}
+	var mhs []multihash.Multihash
+	if err := idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		mhs = append(mhs, mh)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("iterate index of piece: %w", err)
+	}
+	if len(mhs) == 0 {
+		return nil, fmt.Errorf("index of piece is empty")
+	}
+
+	chunkSize := p.cfg.IndexProvider.EntriesChunkSize
+	if chunkSize <= 0 {
+		chunkSize = config.DefaultMarketConfig.IndexProvider.EntriesChunkSize
+	}
+	// the chunks are stored from the last one, so that every chunk can link to the next one
+	var next ipld.Link
+	for start := (len(mhs) - 1) / chunkSize * chunkSize; start >= 0; start -= chunkSize {
+		end := start + chunkSize
+		if end > len(mhs) {
+			end = len(mhs)
+		}
+		chunk := schema.EntryChunk{Entries: mhs[start:end], Next: next}
+		node, err := chunk.ToNode()
+		if err != nil {
+			return nil, err
+		}
+		if next, err = p.lsys.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, node); err != nil {
+			return nil, fmt.Errorf("store entry chunk: %w", err)
+		}
+	}
+	return next, nil
+}
+
+// httpRetrievalAddr returns the http retrieval address of the miner, nil if it's not configured
+func (p *Provider) httpRetrievalAddr(miner address.Address) (multiaddr.Multiaddr, error) {
+	// the common config is used if the miner is not configured
+	pCfg, err := p.cfg.MinerProviderConfig(miner, true)
+	if err != nil {
+		pCfg = p.cfg.CommonProvider
+	}
+	if pCfg == nil {
+		return nil, nil
+	}
+	addr := pCfg.HTTPRetrievalMultiaddr
+	if len(addr) == 0 {
+		return nil, nil
+	}
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse '%s' as multiaddr: %w", addr, err)
+	}
+	return maddr, nil
+}
+
+// announce notifies the indexers of the new head, and returns the errors of the indexers failed
+func (p *Provider) announce(ctx context.Context, head cid.Cid) []string {
+	var errs []string
+	addrInfo := &peer.AddrInfo{ID: p.peerID, Addrs: p.publisherAddrs}
+	for i, indexer := range p.indexers {
+		if err := indexer.Announce(ctx, addrInfo, head); err != nil {
+			log.Warnf("failed to announce %s to %s: %v", head, p.cfg.IndexProvider.AnnounceURLs[i], err)
+			errs = append(errs, fmt.Sprintf("%s: %v", p.cfg.IndexProvider.AnnounceURLs[i], err))
+		}
+	}
+	return errs
+}
+
+func (p *Provider) head(ctx context.Context) (cid.Cid, error) {
+	val, err := p.ds.Get(ctx, headKey)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return cid.Undef, nil
+		}
+		return cid.Undef, fmt.Errorf("get head: %w", err)
+	}
+	return cid.Cast(val)
+}
+
+// announced returns the context ids of the pieces announced and not removed
+func (p *Provider) announced(ctx context.Context) (map[datastore.Key][]byte, error) {
+	results, err := p.ds.Query(ctx, query.Query{Prefix: contextPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("query announced pieces: %w", err)
+	}
+	defer results.Close() //nolint:errcheck
+
+	announced := make(map[datastore.Key][]byte)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("query announced pieces: %w", r.Error)
+		}
+		announced[datastore.NewKey(r.Key)] = r.Value
+	}
+	return announced, nil
+}
+
+// Status returns the state of the index provider
+func (p *Provider) Status(ctx context.Context) (*mtypes.IndexProviderStatus, error) {
+	announced, err := p.announced(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	status := p.status
+	status.Announced = len(announced)
+	return &status, nil
+}
+
+// contextID identifies the piece of the miner in advertisements
+func contextID(miner address.Address, pieceCid cid.Cid) []byte {
+	return append(miner.Bytes(), pieceCid.Bytes()...)
+}
+
+func contextKey(miner address.Address, pieceCid cid.Cid) datastore.Key {
+	return contextPrefix.ChildString(miner.String()).ChildString(pieceCid.String())
+}
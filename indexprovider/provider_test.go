@@ -0,0 +1,271 @@
+package indexprovider
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/filecoin-project/index-provider/metadata"
+	"github.com/filecoin-project/storetheindex/announce/gossiptopic"
+	"github.com/filecoin-project/storetheindex/api/v0/ingest/schema"
+	"github.com/filecoin-project/storetheindex/dagsync/httpsync"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+)
+
+func init() {
+	testutil.MustRegisterDefaultValueProvier(func(t *testing.T) types.DealLabel {
+		l, err := types.NewLabelFromBytes([]byte{})
+		assert.NoError(t, err)
+		return l
+	})
+}
+
+type testIndexWrapper struct {
+	stores.DAGStoreWrapper
+	indices map[cid.Cid]carindex.IterableIndex
+}
+
+func (w *testIndexWrapper) GetIterableIndexForPiece(pieceCid cid.Cid) (carindex.IterableIndex, error) {
+	idx, ok := w.indices[pieceCid]
+	if !ok {
+		return nil, fmt.Errorf("shard %s not found", pieceCid)
+	}
+	return idx, nil
+}
+
+func testIndex(t *testing.T, n int) (carindex.IterableIndex, []multihash.Multihash) {
+	var records []carindex.Record
+	for i := 0; i < n; i++ {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("block %d", i)), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		records = append(records, carindex.Record{Cid: cid.NewCidV1(cid.Raw, mh), Offset: uint64(i)})
+	}
+	idx := carindex.NewMultihashSorted()
+	require.NoError(t, idx.Load(records))
+
+	var mhs []multihash.Multihash
+	require.NoError(t, idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		mhs = append(mhs, mh)
+		return nil
+	}))
+	return idx, mhs
+}
+
+// testIndexer is a stub indexer, it records the announcements and pulls the advertisements from the publisher
+type testIndexer struct {
+	t         *testing.T
+	server    *httptest.Server
+	announces chan gossiptopic.Message
+	lsys      ipld.LinkSystem
+}
+
+func newTestIndexer(t *testing.T) *testIndexer {
+	idx := &testIndexer{t: t, announces: make(chan gossiptopic.Message, 10)}
+	idx.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/ingest/announce" {
+			http.NotFound(w, r)
+			return
+		}
+		var msg gossiptopic.Message
+		if err := msg.UnmarshalCBOR(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		idx.announces <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	store := &memstore.Store{}
+	idx.lsys = cidlink.DefaultLinkSystem()
+	idx.lsys.SetReadStorage(store)
+	idx.lsys.SetWriteStorage(store)
+	return idx
+}
+
+// lastAnnounce returns the publisher and the head of the last announcement
+func (idx *testIndexer) lastAnnounce() (*peer.AddrInfo, cid.Cid) {
+	var msg gossiptopic.Message
+	select {
+	case msg = <-idx.announces:
+	default:
+		idx.t.Fatal("no announcement")
+	}
+	addrs, err := msg.GetAddrs()
+	require.NoError(idx.t, err)
+	require.Len(idx.t, addrs, 1)
+	addrInfo, err := peer.AddrInfoFromP2pAddr(addrs[0])
+	require.NoError(idx.t, err)
+	return addrInfo, msg.Cid
+}
+
+func (idx *testIndexer) pull(ctx context.Context, publisher *peer.AddrInfo, c cid.Cid, proto ipld.NodePrototype) ipld.Node {
+	syncer, err := httpsync.NewSync(idx.lsys, nil, nil).NewSyncer(publisher.ID, publisher.Addrs[0], nil)
+	require.NoError(idx.t, err)
+	require.NoError(idx.t, syncer.Sync(ctx, c, selectorparse.CommonSelector_MatchPoint))
+	node, err := idx.lsys.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, proto)
+	require.NoError(idx.t, err)
+	return node
+}
+
+// head returns the head signed by the publisher
+func (idx *testIndexer) head(ctx context.Context, publisher *peer.AddrInfo) cid.Cid {
+	syncer, err := httpsync.NewSync(idx.lsys, nil, nil).NewSyncer(publisher.ID, publisher.Addrs[0], nil)
+	require.NoError(idx.t, err)
+	head, err := syncer.GetHead(ctx)
+	require.NoError(idx.t, err)
+	return head
+}
+
+func (idx *testIndexer) pullAd(ctx context.Context, publisher *peer.AddrInfo, c cid.Cid) *schema.Advertisement {
+	ad, err := schema.UnwrapAdvertisement(idx.pull(ctx, publisher, c, schema.AdvertisementPrototype))
+	require.NoError(idx.t, err)
+	signer, err := ad.VerifySignature()
+	require.NoError(idx.t, err)
+	require.Equal(idx.t, publisher.ID, signer)
+	return ad
+}
+
+func (idx *testIndexer) pullEntries(ctx context.Context, publisher *peer.AddrInfo, lnk ipld.Link) []multihash.Multihash {
+	var mhs []multihash.Multihash
+	for lnk != nil {
+		chunk, err := schema.UnwrapEntryChunk(idx.pull(ctx, publisher, lnk.(cidlink.Link).Cid, schema.EntryChunkPrototype))
+		require.NoError(idx.t, err)
+		mhs = append(mhs, chunk.Entries...)
+		lnk = chunk.Next
+	}
+	return mhs
+}
+
+func TestIndexProvider(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	// deal 0 and 1 are active, the piece of deal 1 has no index yet, deal 2 is expired
+	deals := make([]market.MinerDeal, 3)
+	testutil.Provide(t, &deals)
+	for i := range deals {
+		deals[i].State = storagemarket.StorageDealActive
+		deals[i].Proposal.Provider, err = address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+	}
+	deals[2].State = storagemarket.StorageDealExpired
+	for i := range deals {
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+
+	idx0, mhs0 := testIndex(t, 5)
+	idx1, mhs1 := testIndex(t, 3)
+	wrapper := &testIndexWrapper{indices: map[cid.Cid]carindex.IterableIndex{deals[0].Proposal.PieceCID: idx0}}
+
+	indexer := newTestIndexer(t)
+	defer indexer.server.Close()
+
+	httpAddr := "/ip4/127.0.0.1/tcp/41235/http"
+	cfg := &config.MarketConfig{
+		CommonProvider: &config.ProviderConfig{HTTPRetrievalMultiaddr: httpAddr},
+		IndexProvider: config.IndexProvider{
+			Enable:            true,
+			HttpListenAddress: "127.0.0.1:0",
+			AnnounceURLs:      []string{indexer.server.URL},
+			EntriesChunkSize:  2,
+		},
+	}
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	libp2pAddr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/58418")
+	addrs := func() []multiaddr.Multiaddr { return []multiaddr.Multiaddr{libp2pAddr} }
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	p, err := newProvider(cfg, privKey, addrs, r.StorageDealRepo(), wrapper, ds)
+	require.NoError(t, err)
+	defer p.Close() //nolint:errcheck
+
+	res, err := p.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Published)
+	assert.Equal(t, 1, res.Skipped)
+	assert.Empty(t, res.AnnounceErrors)
+
+	publisher, head := indexer.lastAnnounce()
+	assert.Equal(t, p.peerID, publisher.ID)
+	assert.Equal(t, res.Head, head.String())
+	assert.Equal(t, head, indexer.head(ctx, publisher))
+	ad0 := indexer.pullAd(ctx, publisher, head)
+	assert.Nil(t, ad0.PreviousID)
+	assert.False(t, ad0.IsRm)
+	assert.Equal(t, contextID(deals[0].Proposal.Provider, deals[0].Proposal.PieceCID), ad0.ContextID)
+	assert.Equal(t, []string{libp2pAddr.String(), httpAddr}, ad0.Addresses)
+	assert.Equal(t, mhs0, indexer.pullEntries(ctx, publisher, ad0.Entries))
+
+	// the metadata lists graphsync and http transports
+	gs, err := (&metadata.GraphsyncFilecoinV1{
+		PieceCID:      deals[0].Proposal.PieceCID,
+		VerifiedDeal:  deals[0].Proposal.VerifiedDeal,
+		FastRetrieval: deals[0].FastRetrieval,
+	}).MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, append(gs, varint.ToUvarint(uint64(transportIpfsGatewayHttp))...), ad0.Metadata)
+
+	// the piece of deal 1 is indexed
+	wrapper.indices[deals[1].Proposal.PieceCID] = idx1
+	res, err = p.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Published)
+	assert.Equal(t, 0, res.Skipped)
+	_, head1 := indexer.lastAnnounce()
+	ad1 := indexer.pullAd(ctx, publisher, head1)
+	assert.Equal(t, cidlink.Link{Cid: head}, ad1.PreviousID)
+	assert.Equal(t, contextID(deals[1].Proposal.Provider, deals[1].Proposal.PieceCID), ad1.ContextID)
+	assert.Equal(t, mhs1, indexer.pullEntries(ctx, publisher, ad1.Entries))
+
+	// deal 0 is slashed
+	deals[0].State = storagemarket.StorageDealSlashed
+	require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[0]))
+	res, err = p.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Published)
+	assert.Equal(t, 1, res.Removed)
+	_, head2 := indexer.lastAnnounce()
+	ad2 := indexer.pullAd(ctx, publisher, head2)
+	assert.Equal(t, cidlink.Link{Cid: head1}, ad2.PreviousID)
+	assert.True(t, ad2.IsRm)
+	assert.Equal(t, ad0.ContextID, ad2.ContextID)
+	assert.Equal(t, schema.NoEntries, ad2.Entries)
+
+	// nothing changes, nothing is announced
+	res, err = p.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, head2.String(), res.Head)
+	assert.Len(t, indexer.announces, 0)
+
+	status, err := p.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Announced)
+	assert.Equal(t, head2.String(), status.Head)
+	assert.Empty(t, status.LastSyncError)
+}
@@ -9,7 +9,10 @@ import (
 
 // Global Tags
 var (
-	StorageNameTag, _ = tag.NewKey("storage")
+	StorageNameTag, _        = tag.NewKey("storage")
+	RetrievalViolationTag, _ = tag.NewKey("violation")
+	PieceScrubResultTag, _   = tag.NewKey("result")
+	TransientAccessTag, _    = tag.NewKey("access")
 )
 
 var (
@@ -29,9 +32,18 @@ var (
 
 	DagStorePRInitCount      = stats.Int64("dagstore/pr_init_count", "Retrieval init count", stats.UnitDimensionless)
 	DagStorePRBytesRequested = stats.Int64("dagstore/pr_requested_bytes", "Retrieval requested bytes", stats.UnitBytes)
+	DagStoreTransientBytes   = stats.Int64("dagstore/transient_bytes", "bytes of the transients and the space reserved for fetching", stats.UnitBytes)
+	DagStoreTransientAccess  = stats.Int64("dagstore/transient_access", "number of shard loads hitting or missing the transients", stats.UnitDimensionless)
+	DagStoreTransientEvicted = stats.Int64("dagstore/transient_evicted", "number of transients evicted", stats.UnitDimensionless)
 
 	StorageRetrievalHitCount = stats.Int64("piecestorage/retrieval_hit", "PieceStorage hit count for retrieval", stats.UnitDimensionless)
 	StorageSaveHitCount      = stats.Int64("piecestorage/save_hit", "PieceStorage hit count for save piece data", stats.UnitDimensionless)
+	PieceScrubVerifiedCount  = stats.Int64("piecestorage/scrub_verified", "number of pieces verified by the scrubber", stats.UnitDimensionless)
+	PieceScrubBytes          = stats.Int64("piecestorage/scrub_bytes", "bytes read by the scrubber", stats.UnitBytes)
+	PieceQuarantinedCount    = stats.Int64("piecestorage/quarantined", "number of corrupt pieces which are not read", stats.UnitDimensionless)
+
+	RetrievalViolationCount = stats.Int64("retrieval/violations", "number of retrieval requests violating the limits", stats.UnitDimensionless)
+	RetrievalBannedPeers    = stats.Int64("retrieval/banned_peers", "number of peers banned from retrieval", stats.UnitDimensionless)
 )
 
 var (
@@ -94,6 +106,19 @@ var (
 		Measure:     DagStorePRBytesRequested,
 		Aggregation: view.Sum(),
 	}
+	DagStoreTransientBytesView = &view.View{
+		Measure:     DagStoreTransientBytes,
+		Aggregation: view.LastValue(),
+	}
+	DagStoreTransientAccessView = &view.View{
+		Measure:     DagStoreTransientAccess,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{TransientAccessTag},
+	}
+	DagStoreTransientEvictedView = &view.View{
+		Measure:     DagStoreTransientEvicted,
+		Aggregation: view.Count(),
+	}
 
 	// piece storage
 	StorageRetrievalHitCountView = &view.View{
@@ -106,6 +131,31 @@ var (
 		Aggregation: view.Count(),
 		TagKeys:     []tag.Key{StorageNameTag},
 	}
+	PieceScrubVerifiedCountView = &view.View{
+		Measure:     PieceScrubVerifiedCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{StorageNameTag, PieceScrubResultTag},
+	}
+	PieceScrubBytesView = &view.View{
+		Measure:     PieceScrubBytes,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{StorageNameTag},
+	}
+	PieceQuarantinedCountView = &view.View{
+		Measure:     PieceQuarantinedCount,
+		Aggregation: view.LastValue(),
+	}
+
+	// retrieval
+	RetrievalViolationCountView = &view.View{
+		Measure:     RetrievalViolationCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{RetrievalViolationTag},
+	}
+	RetrievalBannedPeersView = &view.View{
+		Measure:     RetrievalBannedPeers,
+		Aggregation: view.LastValue(),
+	}
 )
 
 var views = append([]*view.View{
@@ -124,7 +174,16 @@ var views = append([]*view.View{
 
 	DagStorePRInitCountView,
 	DagStorePRBytesRequestedView,
+	DagStoreTransientBytesView,
+	DagStoreTransientAccessView,
+	DagStoreTransientEvictedView,
 
 	StorageRetrievalHitCountView,
 	StorageSaveHitCountView,
+	PieceScrubVerifiedCountView,
+	PieceScrubBytesView,
+	PieceQuarantinedCountView,
+
+	RetrievalViolationCountView,
+	RetrievalBannedPeersView,
 }, metrics.DefaultViews...)
@@ -2,19 +2,33 @@ package badger
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/ipfs-force-community/droplet/v2/models/badger/statestore"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 )
 
-func NewBadgerCidInfoRepo(cidInfoDs CIDInfoDS) repo.ICidInfoRepo {
-	return &badgerCidInfoRepo{cidInfos: statestore.New(cidInfoDs)}
+// the key marks that the cid infos saved before the piece blocks index exists have been indexed
+var pieceBlocksIndexedKey = datastore.NewKey("/indexed")
+
+func NewBadgerCidInfoRepo(cidInfoDs CIDInfoDS, pieceBlocksDs PieceBlocksDS) repo.ICidInfoRepo {
+	return &badgerCidInfoRepo{cidInfos: statestore.New(cidInfoDs), pieceBlocks: pieceBlocksDs}
 }
 
 type badgerCidInfoRepo struct {
 	cidInfos *statestore.StateStore
+	// the index from the piece to the payload cids of its blocks, the key is /<piece cid>/<payload cid>,
+	// the cid infos are scanned to remove the locations of a piece if it's nil
+	pieceBlocks datastore.Batching
+
+	indexLk sync.Mutex
+	indexed bool
 }
 
 var _ repo.ICidInfoRepo = (*badgerCidInfoRepo)(nil)
@@ -34,10 +48,19 @@ func (ps *badgerCidInfoRepo) AddPieceBlockLocations(ctx context.Context, pieceCI
 		if err != nil {
 			return err
 		}
+		if ps.pieceBlocks != nil {
+			if err := ps.pieceBlocks.Put(ctx, pieceBlockKey(pieceCID, c), nil); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+func pieceBlockKey(pieceCID, payloadCID cid.Cid) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{pieceCID.String(), payloadCID.String()})
+}
+
 func (ps *badgerCidInfoRepo) ListCidInfoKeys(ctx context.Context) ([]cid.Cid, error) {
 	var cis []piecestore.CIDInfo
 	if err := ps.cidInfos.List(ctx, &cis); err != nil {
@@ -52,6 +75,113 @@ func (ps *badgerCidInfoRepo) ListCidInfoKeys(ctx context.Context) ([]cid.Cid, er
 	return out, nil
 }
 
+func (ps *badgerCidInfoRepo) RemovePieceBlockLocations(ctx context.Context, pieceCID cid.Cid) error {
+	if ps.pieceBlocks == nil {
+		return ps.removePieceBlockLocationsByScan(ctx, pieceCID)
+	}
+	if err := ps.indexPieceBlocks(ctx); err != nil {
+		return err
+	}
+
+	res, err := ps.pieceBlocks.Query(ctx, query.Query{Prefix: datastore.NewKey(pieceCID.String()).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key := datastore.RawKey(entry.Key)
+		payloadCID, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			return fmt.Errorf("invalid piece block key %s: %w", key, err)
+		}
+		var ci piecestore.CIDInfo
+		if err := ps.cidInfos.Get(payloadCID).Get(ctx, &ci); err != nil {
+			if !errors.Is(err, repo.ErrNotFound) {
+				return err
+			}
+		} else if err := ps.removeLocations(ctx, ci, pieceCID); err != nil {
+			return err
+		}
+		if err := ps.pieceBlocks.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexPieceBlocks adds the cid infos saved before the piece blocks index exists to the index, it runs once
+func (ps *badgerCidInfoRepo) indexPieceBlocks(ctx context.Context) error {
+	ps.indexLk.Lock()
+	defer ps.indexLk.Unlock()
+	if ps.indexed {
+		return nil
+	}
+	if has, err := ps.pieceBlocks.Has(ctx, pieceBlocksIndexedKey); err != nil {
+		return err
+	} else if has {
+		ps.indexed = true
+		return nil
+	}
+
+	var cis []piecestore.CIDInfo
+	if err := ps.cidInfos.List(ctx, &cis); err != nil {
+		return err
+	}
+	batch, err := ps.pieceBlocks.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ci := range cis {
+		for _, pbl := range ci.PieceBlockLocations {
+			if err := batch.Put(ctx, pieceBlockKey(pbl.PieceCID, ci.CID), nil); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Put(ctx, pieceBlocksIndexedKey, nil); err != nil {
+		return err
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	ps.indexed = true
+	return nil
+}
+
+func (ps *badgerCidInfoRepo) removePieceBlockLocationsByScan(ctx context.Context, pieceCID cid.Cid) error {
+	var cis []piecestore.CIDInfo
+	if err := ps.cidInfos.List(ctx, &cis); err != nil {
+		return err
+	}
+	for _, ci := range cis {
+		if err := ps.removeLocations(ctx, ci, pieceCID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLocations removes the locations in the piece from the cid info, the cid info is deleted if no location left
+func (ps *badgerCidInfoRepo) removeLocations(ctx context.Context, ci piecestore.CIDInfo, pieceCID cid.Cid) error {
+	locations := make([]piecestore.PieceBlockLocation, 0, len(ci.PieceBlockLocations))
+	for _, pbl := range ci.PieceBlockLocations {
+		if !pbl.PieceCID.Equals(pieceCID) {
+			locations = append(locations, pbl)
+		}
+	}
+	if len(locations) == len(ci.PieceBlockLocations) {
+		return nil
+	}
+	if len(locations) == 0 {
+		return ps.cidInfos.Get(ci.CID).End(ctx)
+	}
+	ci.PieceBlockLocations = locations
+	return ps.cidInfos.Save(ctx, ci.CID, &ci)
+}
+
 // Retrieve the CIDInfo associated with `pieceCID` from the CID info store.
 func (ps *badgerCidInfoRepo) GetCIDInfo(ctx context.Context, payloadCID cid.Cid) (piecestore.CIDInfo, error) {
 	var out piecestore.CIDInfo
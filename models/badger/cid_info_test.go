@@ -6,6 +6,7 @@ import (
 
 	"github.com/filecoin-project/go-fil-markets/piecestore"
 	"github.com/filecoin-project/venus/venus-shared/testutil"
+	"github.com/ipfs-force-community/droplet/v2/models/badger/statestore"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
 	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/assert"
@@ -58,6 +59,76 @@ func TestListCidInfoKeys(t *testing.T) {
 	}
 }
 
+func TestRemovePieceBlockLocations(t *testing.T) {
+	ctx, r, cidInfoCases := prepareCidInfoTest(t)
+
+	inSertCidInfo(ctx, t, r, cidInfoCases...)
+
+	removed := cidInfoCases[0].PieceBlockLocations[0].PieceCID
+	assert.NoError(t, r.RemovePieceBlockLocations(ctx, removed))
+
+	for _, info := range cidInfoCases {
+		var left []piecestore.PieceBlockLocation
+		for _, location := range info.PieceBlockLocations {
+			if !location.PieceCID.Equals(removed) {
+				left = append(left, location)
+			}
+		}
+
+		res, err := r.GetCIDInfo(ctx, info.CID)
+		if len(left) == 0 {
+			assert.ErrorIs(t, err, repo.ErrNotFound)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, left, res.PieceBlockLocations)
+	}
+}
+
+func TestRemovePieceBlockLocationsOfLegacyCidInfos(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDatastore("")
+	assert.NoError(t, err)
+	cidInfoDs, pieceBlocksDs := NewCidInfoDs(NewPieceMetaDs(db)), NewPieceBlocksDs(NewPieceMetaDs(db))
+
+	// the cid infos saved before the piece blocks index exists
+	cidInfoCases := make([]piecestore.CIDInfo, 10)
+	testutil.Provide(t, &cidInfoCases)
+	for i := range cidInfoCases {
+		assert.NoError(t, statestore.New(cidInfoDs).Save(ctx, cidInfoCases[i].CID, &cidInfoCases[i]))
+	}
+
+	r := NewBadgerCidInfoRepo(cidInfoDs, pieceBlocksDs)
+	removed := cidInfoCases[0].PieceBlockLocations[0].PieceCID
+	assert.NoError(t, r.RemovePieceBlockLocations(ctx, removed))
+	_, err = r.GetCIDInfo(ctx, cidInfoCases[0].CID)
+	if len(cidInfoCases[0].PieceBlockLocations) == 1 {
+		assert.ErrorIs(t, err, repo.ErrNotFound)
+	} else {
+		assert.NoError(t, err)
+	}
+
+	// the index of removed piece is dropped, and the other pieces are indexed
+	has, err := pieceBlocksDs.Has(ctx, pieceBlockKey(removed, cidInfoCases[0].CID))
+	assert.NoError(t, err)
+	assert.False(t, has)
+	other := cidInfoCases[1].PieceBlockLocations[0].PieceCID
+	has, err = pieceBlocksDs.Has(ctx, pieceBlockKey(other, cidInfoCases[1].CID))
+	assert.NoError(t, err)
+	assert.True(t, has)
+
+	// the locations of pieces are removed by the index once it's built
+	assert.NoError(t, r.RemovePieceBlockLocations(ctx, other))
+	res, err := r.GetCIDInfo(ctx, cidInfoCases[1].CID)
+	if err == nil {
+		for _, location := range res.PieceBlockLocations {
+			assert.False(t, location.PieceCID.Equals(other))
+		}
+	} else {
+		assert.ErrorIs(t, err, repo.ErrNotFound)
+	}
+}
+
 func prepareCidInfoTest(t *testing.T) (context.Context, repo.ICidInfoRepo, []piecestore.CIDInfo) {
 	repo := setup(t)
 	r := repo.CidInfoRepo()
@@ -22,13 +22,18 @@ const (
 	fundmgr           = "/fundmgr/"
 	piecemeta         = "/storagemarket"
 	cidinfo           = "/cid-infos"
+	pieceBlocks       = "/piece-blocks"
 	retrievalProvider = "/retrievals/provider"
 	retrievalAsk      = "/retrieval-ask"
 	retrievalDeals    = "/deals"
+	retrievalStats    = "/stats"
 	storageProvider   = "/storage/provider"
 	storageDeals      = "/deals"
 	storageAsk        = "/storage-ask"
 	paych             = "/paych/"
+	pieceMigration    = "/piece-migration"
+	pieceScrub        = "/piece-scrub"
+	indexProvider     = "/index-provider"
 
 	// client
 	dealClient      = "/deals/client"
@@ -50,6 +55,9 @@ type PieceMetaDs datastore.Batching
 // /metadata/storagemarket/cid-infos
 type CIDInfoDS datastore.Batching
 
+// /metadata/storagemarket/piece-blocks
+type PieceBlocksDS datastore.Batching
+
 // /metadata/storagemarket/pieces
 type PieceInfoDS datastore.Batching
 
@@ -62,9 +70,21 @@ type RetrievalDealsDS datastore.Batching
 // /metadata/retrievals/provider/retrieval-ask
 type RetrievalAskDS datastore.Batching // key = latest
 
+// /metadata/retrievals/provider/stats
+type RetrievalStatsDS datastore.Batching
+
 // /metadata/datatransfer/provider/transfers
 type DagTransferDS datastore.Batching
 
+// /metadata/piece-migration
+type PieceMigrationDS datastore.Batching
+
+// /metadata/piece-scrub
+type PieceScrubDS datastore.Batching
+
+// /metadata/index-provider
+type IndexProviderDS datastore.Batching
+
 // /metadata/storage/provider
 type StorageProviderDS datastore.Batching
 
@@ -128,6 +148,10 @@ func NewCidInfoDs(ds PieceMetaDs) CIDInfoDS {
 	return namespace.Wrap(ds, datastore.NewKey(cidinfo))
 }
 
+func NewPieceBlocksDs(ds PieceMetaDs) PieceBlocksDS {
+	return namespace.Wrap(ds, datastore.NewKey(pieceBlocks))
+}
+
 func NewRetrievalProviderDS(ds MetadataDS) RetrievalProviderDS {
 	return namespace.Wrap(ds, datastore.NewKey(retrievalProvider))
 }
@@ -140,6 +164,10 @@ func NewRetrievalAskDS(ds RetrievalProviderDS) RetrievalAskDS {
 	return namespace.Wrap(ds, datastore.NewKey(retrievalAsk))
 }
 
+func NewRetrievalStatsDS(ds RetrievalProviderDS) RetrievalStatsDS {
+	return namespace.Wrap(ds, datastore.NewKey(retrievalStats))
+}
+
 func NewStorageProviderDS(ds MetadataDS) StorageProviderDS {
 	return namespace.Wrap(ds, datastore.NewKey(storageProvider))
 }
@@ -202,7 +230,9 @@ type BadgerDSParams struct {
 	AskDS            StorageAskDS     `optional:"true"`
 	RetrAskDs        RetrievalAskDS   `optional:"true"`
 	CidInfoDs        CIDInfoDS        `optional:"true"`
+	PieceBlocksDs    PieceBlocksDS    `optional:"true"`
 	RetrievalDealsDs RetrievalDealsDS `optional:"true"`
+	RetrievalStatsDs RetrievalStatsDS `optional:"true"`
 }
 
 func NewBadgerRepo(params BadgerDSParams) repo.Repo {
@@ -241,13 +271,17 @@ func (r *BadgerRepo) RetrievalAskRepo() repo.IRetrievalAskRepo {
 }
 
 func (r *BadgerRepo) CidInfoRepo() repo.ICidInfoRepo {
-	return NewBadgerCidInfoRepo(r.dsParams.CidInfoDs)
+	return NewBadgerCidInfoRepo(r.dsParams.CidInfoDs, r.dsParams.PieceBlocksDs)
 }
 
 func (r *BadgerRepo) RetrievalDealRepo() repo.IRetrievalDealRepo {
 	return NewRetrievalDealRepo(r.dsParams.RetrievalDealsDs)
 }
 
+func (r *BadgerRepo) RetrievalStatsRepo() repo.IRetrievalStatsRepo {
+	return NewRetrievalStatsRepo(r.dsParams.RetrievalStatsDs)
+}
+
 func (r *BadgerRepo) ShardRepo() repo.IShardRepo {
 	return NewShardRepo()
 }
@@ -314,6 +348,18 @@ func NewDagTransferDS(ds MetadataDS) DagTransferDS {
 	return namespace.Wrap(ds, datastore.NewKey(transfer))
 }
 
+func NewPieceMigrationDS(ds MetadataDS) PieceMigrationDS {
+	return namespace.Wrap(ds, datastore.NewKey(pieceMigration))
+}
+
+func NewPieceScrubDS(ds MetadataDS) PieceScrubDS {
+	return namespace.Wrap(ds, datastore.NewKey(pieceScrub))
+}
+
+func NewIndexProviderDS(ds MetadataDS) IndexProviderDS {
+	return namespace.Wrap(ds, datastore.NewKey(indexProvider))
+}
+
 func NewStagingDS(mctx metrics.MetricsCtx, lc fx.Lifecycle, homeDir *config.HomeDir) (StagingDS, error) {
 	db, err := badger.NewDatastore(path.Join(string(*homeDir), staging), &badger.DefaultOptions)
 	if err != nil {
@@ -0,0 +1,140 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+type retrievalStatsRepo struct {
+	lk sync.Mutex
+	ds datastore.Batching
+}
+
+var _ repo.IRetrievalStatsRepo = (*retrievalStatsRepo)(nil)
+
+func NewRetrievalStatsRepo(ds RetrievalStatsDS) repo.IRetrievalStatsRepo {
+	return &retrievalStatsRepo{ds: ds}
+}
+
+func keyFromRetrievalStats(key *mtypes.RetrievalStatsKey) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		strconv.FormatInt(key.Bucket, 10),
+		key.Miner.String(),
+		key.Client,
+		key.PayloadCID.String(),
+	})
+}
+
+func (r *retrievalStatsRepo) AddStats(ctx context.Context, stats []*mtypes.RetrievalStats) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for _, s := range stats {
+		key := keyFromRetrievalStats(&s.RetrievalStatsKey)
+		record := &mtypes.RetrievalStats{RetrievalStatsKey: s.RetrievalStatsKey}
+		data, err := r.ds.Get(ctx, key)
+		if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+		}
+		record.Add(s)
+
+		data, err = json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := r.ds.Put(ctx, key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListStats reads the buckets in the time range of the params one by one, the keys of a bucket share
+// the prefix of the bucket, and the miner if it's filtered
+func (r *retrievalStatsRepo) ListStats(ctx context.Context, params *mtypes.RetrievalStatsQueryParams) ([]*mtypes.RetrievalStats, error) {
+	from, to := params.From, params.To
+	if to == 0 {
+		// no stats are recorded in the future buckets
+		to = mtypes.RetrievalStatsBucket(time.Now()) + 1
+	}
+	if from == 0 {
+		earliest, ok, err := r.earliestBucket(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+		from = earliest
+	}
+
+	var stats []*mtypes.RetrievalStats
+	step := int64(mtypes.RetrievalStatsBucketDuration / time.Second)
+	for bucket := from - from%step; bucket < to; bucket += step {
+		namespaces := []string{strconv.FormatInt(bucket, 10)}
+		if !params.Miner.Empty() {
+			namespaces = append(namespaces, params.Miner.String())
+		}
+		res, err := r.ds.Query(ctx, query.Query{Prefix: datastore.KeyWithNamespaces(namespaces).String()})
+		if err != nil {
+			return nil, err
+		}
+		for entry := range res.Next() {
+			if entry.Error != nil {
+				_ = res.Close()
+				return nil, entry.Error
+			}
+			var s mtypes.RetrievalStats
+			if err := json.Unmarshal(entry.Value, &s); err != nil {
+				_ = res.Close()
+				return nil, err
+			}
+			if params.Match(&s.RetrievalStatsKey) {
+				stats = append(stats, &s)
+			}
+		}
+		if err := res.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// earliestBucket returns the first bucket of the stats, the buckets have the same number of digits,
+// so the first key in order is in the earliest bucket
+func (r *retrievalStatsRepo) earliestBucket(ctx context.Context) (int64, bool, error) {
+	res, err := r.ds.Query(ctx, query.Query{KeysOnly: true, Orders: []query.Order{query.OrderByKey{}}, Limit: 1})
+	if err != nil {
+		return 0, false, err
+	}
+	defer res.Close() //nolint:errcheck
+
+	entry, ok := res.NextSync()
+	if !ok {
+		return 0, false, nil
+	}
+	if entry.Error != nil {
+		return 0, false, entry.Error
+	}
+	bucket, err := strconv.ParseInt(datastore.NewKey(entry.Key).List()[0], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parse bucket of key %s: %w", entry.Key, err)
+	}
+	return bucket, true, nil
+}
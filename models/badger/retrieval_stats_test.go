@@ -0,0 +1,72 @@
+package badger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func TestRetrievalStats(t *testing.T) {
+	ctx := context.Background()
+	r := setup(t).RetrievalStatsRepo()
+
+	var miners [2]address.Address
+	var payloads [2]cid.Cid
+	testutil.Provide(t, &miners)
+	testutil.Provide(t, &payloads)
+
+	newStats := func(bucket int64, miner address.Address, client string, payload cid.Cid) *mtypes.RetrievalStats {
+		return &mtypes.RetrievalStats{
+			RetrievalStatsKey: mtypes.RetrievalStatsKey{
+				Bucket:     bucket,
+				Miner:      miner,
+				Client:     client,
+				PayloadCID: payload,
+			},
+			GraphsyncBytesSent: 100,
+			HTTPBytesSent:      10,
+			VouchersReceived:   1,
+			FundsReceived:      big.NewInt(1000),
+			FundsSettled:       big.NewInt(10),
+			Succeeded:          1,
+		}
+	}
+
+	stats := []*mtypes.RetrievalStats{
+		newStats(3600, miners[0], "client1", payloads[0]),
+		newStats(3600, miners[1], "client2", payloads[1]),
+		newStats(7200, miners[0], "client1", payloads[0]),
+	}
+	assert.NoError(t, r.AddStats(ctx, stats))
+	// the values of the same key are accumulated
+	assert.NoError(t, r.AddStats(ctx, stats[:1]))
+
+	res, err := r.ListStats(ctx, &mtypes.RetrievalStatsQueryParams{})
+	assert.NoError(t, err)
+	assert.Len(t, res, 3)
+
+	res, err = r.ListStats(ctx, &mtypes.RetrievalStatsQueryParams{From: 3600, To: 7200, Client: "client1"})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	expect := newStats(3600, miners[0], "client1", payloads[0])
+	expect.Add(stats[0])
+	assert.Equal(t, expect, res[0])
+
+	res, err = r.ListStats(ctx, &mtypes.RetrievalStatsQueryParams{Miner: miners[0]})
+	assert.NoError(t, err)
+	agg := mtypes.AggregateRetrievalStats(res, mtypes.RetrievalStatsGroupByMiner)
+	assert.Len(t, agg, 1)
+	assert.Equal(t, miners[0], agg[0].Miner)
+	assert.Equal(t, uint64(300), agg[0].GraphsyncBytesSent)
+	assert.Equal(t, uint64(3), agg[0].Succeeded)
+	assert.Equal(t, big.NewInt(3000), agg[0].FundsReceived)
+	assert.Equal(t, big.NewInt(30), agg[0].FundsSettled)
+	assert.Equal(t, 1.0, agg[0].SuccessRate())
+}
@@ -34,7 +34,9 @@ func WrapDbToRepo(db datastore.Batching) repo.Repo {
 		AskDS:            NewStorageAskDS(NewStorageProviderDS(db)),
 		RetrAskDs:        NewRetrievalAskDS(NewRetrievalProviderDS(db)),
 		CidInfoDs:        NewCidInfoDs(NewPieceMetaDs(db)),
+		PieceBlocksDs:    NewPieceBlocksDs(NewPieceMetaDs(db)),
 		RetrievalDealsDs: NewRetrievalDealsDS(NewRetrievalProviderDS(db)),
+		RetrievalStatsDs: NewRetrievalStatsDS(NewRetrievalProviderDS(db)),
 	})
 }
 
@@ -15,7 +15,7 @@ import (
 
 func TestCIDInfoBadger(t *testing.T) {
 	db := BadgerDB(t)
-	doTestCidinfo(t, badger.NewBadgerCidInfoRepo(db))
+	doTestCidinfo(t, badger.NewBadgerCidInfoRepo(badger.NewCidInfoDs(db), badger.NewPieceBlocksDs(db)))
 }
 
 func TestCIDInfoMysql(t *testing.T) {
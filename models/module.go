@@ -20,6 +20,9 @@ var DBOptions = func(server bool, mysqlCfg *config.Mysql) builder.Option {
 			builder.Override(new(badger2.StagingDS), badger2.NewStagingDS),
 			builder.Override(new(badger2.StagingBlockstore), badger2.NewStagingBlockStore),
 			builder.Override(new(badger2.DagTransferDS), badger2.NewDagTransferDS),
+			builder.Override(new(badger2.PieceMigrationDS), badger2.NewPieceMigrationDS),
+			builder.Override(new(badger2.PieceScrubDS), badger2.NewPieceScrubDS),
+			builder.Override(new(badger2.IndexProviderDS), badger2.NewIndexProviderDS),
 			builder.ApplyIfElse(func(s *builder.Settings) bool {
 				return mysqlCfg != nil && len(mysqlCfg.ConnectionString) > 0
 			}, builder.Options(
@@ -32,6 +35,7 @@ var DBOptions = func(server bool, mysqlCfg *config.Mysql) builder.Option {
 					// if mysql is not configured, use badger
 					builder.Override(new(badger2.PieceMetaDs), badger2.NewPieceMetaDs),
 					builder.Override(new(badger2.CIDInfoDS), badger2.NewCidInfoDs),
+					builder.Override(new(badger2.PieceBlocksDS), badger2.NewPieceBlocksDs),
 					builder.Override(new(badger2.RetrievalProviderDS), badger2.NewRetrievalProviderDS),
 					builder.Override(new(badger2.RetrievalAskDS), badger2.NewRetrievalAskDS),
 					builder.Override(new(badger2.StorageProviderDS), badger2.NewStorageProviderDS),
@@ -42,6 +46,7 @@ var DBOptions = func(server bool, mysqlCfg *config.Mysql) builder.Option {
 					builder.Override(new(badger2.PayChanMsgDs), badger2.NewPayChanMsgDs),
 					builder.Override(new(badger2.FundMgrDS), badger2.NewFundMgrDS),
 					builder.Override(new(badger2.RetrievalDealsDS), badger2.NewRetrievalDealsDS),
+					builder.Override(new(badger2.RetrievalStatsDS), badger2.NewRetrievalStatsDS),
 					builder.Override(new(repo.Repo), badger2.NewMigratedBadgerRepo),
 				),
 			),
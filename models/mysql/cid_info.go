@@ -76,19 +76,25 @@ func (m *mysqlCidInfoRepo) AddPieceBlockLocations(ctx context.Context, pieceCID
 }
 
 func (m *mysqlCidInfoRepo) GetCIDInfo(ctx context.Context, payloadCID cid.Cid) (piecestore.CIDInfo, error) {
-	cidInfo := cidInfo{}
-	if err := m.Model(&cidInfo).Find(&cidInfo, "payload_cid = ?", DBCid(payloadCID).String()).Error; err != nil {
+	var cidInfos []cidInfo
+	if err := m.WithContext(ctx).Model(&cidInfo{}).Find(&cidInfos, "payload_cid = ?", DBCid(payloadCID).String()).Error; err != nil {
 		return piecestore.CIDInfo{}, err
 	}
-	return piecestore.CIDInfo{
-		CID: payloadCID,
-		PieceBlockLocations: []piecestore.PieceBlockLocation{
-			{
-				BlockLocation: piecestore.BlockLocation(cidInfo.BlockLocation),
-				PieceCID:      cid.Cid(cidInfo.PieceCid),
-			},
-		},
-	}, nil
+	if len(cidInfos) == 0 {
+		return piecestore.CIDInfo{}, repo.ErrNotFound
+	}
+
+	out := piecestore.CIDInfo{
+		CID:                 payloadCID,
+		PieceBlockLocations: make([]piecestore.PieceBlockLocation, 0, len(cidInfos)),
+	}
+	for _, info := range cidInfos {
+		out.PieceBlockLocations = append(out.PieceBlockLocations, piecestore.PieceBlockLocation{
+			BlockLocation: piecestore.BlockLocation(info.BlockLocation),
+			PieceCID:      cid.Cid(info.PieceCid),
+		})
+	}
+	return out, nil
 }
 
 func (m *mysqlCidInfoRepo) ListCidInfoKeys(ctx context.Context) ([]cid.Cid, error) {
@@ -108,4 +114,8 @@ func (m *mysqlCidInfoRepo) ListCidInfoKeys(ctx context.Context) ([]cid.Cid, erro
 	return cids, nil
 }
 
+func (m *mysqlCidInfoRepo) RemovePieceBlockLocations(ctx context.Context, pieceCID cid.Cid) error {
+	return m.WithContext(ctx).Table(cidInfoTableName).Where("piece_cid = ?", DBCid(pieceCID).String()).Delete(&cidInfo{}).Error
+}
+
 var _ repo.ICidInfoRepo = (*mysqlCidInfoRepo)(nil)
@@ -45,7 +45,7 @@ func TestGetCIDInfo(t *testing.T) {
 	rows, err := getFullRows(cidInfoCase)
 	assert.NoError(t, err)
 
-	sql, vars, err := getSQL(db.Model(&cidInfo{}).Find(&cidInfo{}, "payload_cid = ?", DBCid(cidInfoCase.PayloadCid.cid()).String()))
+	sql, vars, err := getSQL(db.Model(&cidInfo{}).Find(&[]cidInfo{}, "payload_cid = ?", DBCid(cidInfoCase.PayloadCid.cid()).String()))
 	assert.NoError(t, err)
 
 	mock.ExpectQuery(regexp.QuoteMeta(sql)).WithArgs(vars...).WillReturnRows(rows)
@@ -114,3 +114,19 @@ func TestAddPieceBlockLocations(t *testing.T) {
 	err = r.CidInfoRepo().AddPieceBlockLocations(context.Background(), cid3, blockLocationCase)
 	assert.NoError(t, err)
 }
+
+func TestRemovePieceBlockLocations(t *testing.T) {
+	r, mock, _, done := prepareCIDInfoTest(t)
+	defer done()
+
+	pieceCid, err := getTestCid()
+	assert.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `cid_infos` WHERE piece_cid = ?")).WithArgs(pieceCid.String()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = r.CidInfoRepo().RemovePieceBlockLocations(context.Background(), pieceCid)
+	assert.NoError(t, err)
+}
@@ -62,6 +62,10 @@ func (r MysqlRepo) RetrievalDealRepo() repo.IRetrievalDealRepo {
 	return NewRetrievalDealRepo(r.GetDb())
 }
 
+func (r MysqlRepo) RetrievalStatsRepo() repo.IRetrievalStatsRepo {
+	return NewRetrievalStatsRepo(r.GetDb())
+}
+
 func (r MysqlRepo) ShardRepo() repo.IShardRepo {
 	return NewShardRepo(r.GetDb())
 }
@@ -76,7 +80,7 @@ func (r MysqlRepo) Close() error {
 
 func (r MysqlRepo) Migrate() error {
 	return r.AutoMigrate(retrievalAsk{}, cidInfo{}, storageAsk{}, fundedAddressState{}, storageDeal{},
-		channelInfo{}, msgInfo{}, retrievalDeal{}, shard{})
+		channelInfo{}, msgInfo{}, retrievalDeal{}, shard{}, retrievalStats{})
 }
 
 func (r MysqlRepo) Transaction(cb func(txRepo repo.TxRepo) error) error {
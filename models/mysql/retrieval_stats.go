@@ -0,0 +1,139 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs-force-community/sophon-messager/models/mtypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	types "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+const retrievalStatsTableName = "retrieval_stats"
+
+type retrievalStats struct {
+	ID                 uint       `gorm:"primary_key"`
+	Bucket             int64      `gorm:"column:bucket;type:bigint;uniqueIndex:idx_retrieval_stats_key;NOT NULL;"`
+	Miner              DBAddress  `gorm:"column:miner;type:varchar(128);uniqueIndex:idx_retrieval_stats_key;"`
+	Client             string     `gorm:"column:client;type:varchar(128);uniqueIndex:idx_retrieval_stats_key;"`
+	PayloadCID         DBCid      `gorm:"column:payload_cid;type:varchar(128);uniqueIndex:idx_retrieval_stats_key;"`
+	GraphsyncBytesSent uint64     `gorm:"column:graphsync_bytes_sent;type:bigint unsigned;NOT NULL;"`
+	HTTPBytesSent      uint64     `gorm:"column:http_bytes_sent;type:bigint unsigned;NOT NULL;"`
+	VouchersReceived   uint64     `gorm:"column:vouchers_received;type:bigint unsigned;NOT NULL;"`
+	FundsReceived      mtypes.Int `gorm:"column:funds_received;type:varchar(256);default:0"`
+	FundsSettled       mtypes.Int `gorm:"column:funds_settled;type:varchar(256);default:0"`
+	Succeeded          uint64     `gorm:"column:succeeded;type:bigint unsigned;NOT NULL;"`
+	Failed             uint64     `gorm:"column:failed;type:bigint unsigned;NOT NULL;"`
+	TimeStampOrm
+}
+
+func (s *retrievalStats) TableName() string {
+	return retrievalStatsTableName
+}
+
+func fromRetrievalStats(src *types.RetrievalStats) *retrievalStats {
+	return &retrievalStats{
+		Bucket:             src.Bucket,
+		Miner:              DBAddress(src.Miner),
+		Client:             src.Client,
+		PayloadCID:         DBCid(src.PayloadCID),
+		GraphsyncBytesSent: src.GraphsyncBytesSent,
+		HTTPBytesSent:      src.HTTPBytesSent,
+		VouchersReceived:   src.VouchersReceived,
+		FundsReceived:      mtypes.SafeFromGo(src.FundsReceived.Int),
+		FundsSettled:       mtypes.SafeFromGo(src.FundsSettled.Int),
+		Succeeded:          src.Succeeded,
+		Failed:             src.Failed,
+	}
+}
+
+func (s *retrievalStats) toRetrievalStats() *types.RetrievalStats {
+	return &types.RetrievalStats{
+		RetrievalStatsKey: types.RetrievalStatsKey{
+			Bucket:     s.Bucket,
+			Miner:      s.Miner.addr(),
+			Client:     s.Client,
+			PayloadCID: s.PayloadCID.cid(),
+		},
+		GraphsyncBytesSent: s.GraphsyncBytesSent,
+		HTTPBytesSent:      s.HTTPBytesSent,
+		VouchersReceived:   s.VouchersReceived,
+		FundsReceived:      big.Int(mtypes.SafeFromGo(s.FundsReceived.Int)),
+		FundsSettled:       big.Int(mtypes.SafeFromGo(s.FundsSettled.Int)),
+		Succeeded:          s.Succeeded,
+		Failed:             s.Failed,
+	}
+}
+
+type retrievalStatsRepo struct {
+	*gorm.DB
+}
+
+var _ repo.IRetrievalStatsRepo = (*retrievalStatsRepo)(nil)
+
+func NewRetrievalStatsRepo(db *gorm.DB) repo.IRetrievalStatsRepo {
+	return &retrievalStatsRepo{db}
+}
+
+func (r *retrievalStatsRepo) AddStats(ctx context.Context, stats []*types.RetrievalStats) error {
+	return r.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, s := range stats {
+			key := fromRetrievalStats(s)
+			var record retrievalStats
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&record, "bucket = ? AND miner = ? AND client = ? AND payload_cid = ?",
+				key.Bucket, key.Miner.String(), key.Client, key.PayloadCID.String()).Error
+			if err != nil && !errors.Is(err, repo.ErrNotFound) {
+				return err
+			}
+
+			merged := &types.RetrievalStats{RetrievalStatsKey: s.RetrievalStatsKey}
+			if err == nil {
+				merged = record.toRetrievalStats()
+			}
+			merged.Add(s)
+
+			newRecord := fromRetrievalStats(merged)
+			newRecord.ID = record.ID
+			newRecord.TimeStampOrm = record.TimeStampOrm
+			newRecord.Refresh()
+			if err := tx.Save(newRecord).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *retrievalStatsRepo) ListStats(ctx context.Context, params *types.RetrievalStatsQueryParams) ([]*types.RetrievalStats, error) {
+	query := r.WithContext(ctx).Table(retrievalStatsTableName)
+	if params.From != 0 {
+		query = query.Where("bucket >= ?", params.From)
+	}
+	if params.To != 0 {
+		query = query.Where("bucket < ?", params.To)
+	}
+	if !params.Miner.Empty() {
+		query = query.Where("miner = ?", DBAddress(params.Miner).String())
+	}
+	if len(params.Client) > 0 {
+		query = query.Where("client = ?", params.Client)
+	}
+	if params.PayloadCID.Defined() {
+		query = query.Where("payload_cid = ?", params.PayloadCID.String())
+	}
+
+	var records []*retrievalStats
+	if err := query.Order("bucket").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]*types.RetrievalStats, 0, len(records))
+	for _, record := range records {
+		stats = append(stats, record.toRetrievalStats())
+	}
+	return stats, nil
+}
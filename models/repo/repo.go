@@ -20,6 +20,8 @@ import (
 	types2 "github.com/filecoin-project/venus/venus-shared/types/market/client"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/peer"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 )
 
 type FundRepo interface {
@@ -62,6 +64,13 @@ type IRetrievalDealRepo interface {
 	GroupRetrievalDealNumberByStatus(ctx context.Context, mAddr address.Address) (map[retrievalmarket.DealStatus]int64, error)
 }
 
+type IRetrievalStatsRepo interface {
+	// AddStats adds the values of the stats to the records which have the same key
+	AddStats(ctx context.Context, stats []*mtypes.RetrievalStats) error
+	// ListStats lists the records match the filters of params, GroupBy of params is ignored
+	ListStats(ctx context.Context, params *mtypes.RetrievalStatsQueryParams) ([]*mtypes.RetrievalStats, error)
+}
+
 type PaychMsgInfoRepo interface {
 	GetMessage(ctx context.Context, mcid cid.Cid) (*types.MsgInfo, error)
 	SaveMessage(ctx context.Context, info *types.MsgInfo) error
@@ -96,6 +105,8 @@ type ICidInfoRepo interface {
 	AddPieceBlockLocations(ctx context.Context, pieceCID cid.Cid, blockLocations map[cid.Cid]piecestore.BlockLocation) error
 	GetCIDInfo(ctx context.Context, payloadCID cid.Cid) (piecestore.CIDInfo, error)
 	ListCidInfoKeys(ctx context.Context) ([]cid.Cid, error)
+	// RemovePieceBlockLocations removes the locations of the blocks in the piece, it's called after the shard of the piece is destroyed
+	RemovePieceBlockLocations(ctx context.Context, pieceCID cid.Cid) error
 }
 
 type IShardRepo interface {
@@ -112,6 +123,7 @@ type Repo interface {
 	RetrievalAskRepo() IRetrievalAskRepo
 	CidInfoRepo() ICidInfoRepo
 	RetrievalDealRepo() IRetrievalDealRepo
+	RetrievalStatsRepo() IRetrievalStatsRepo
 	ShardRepo() IShardRepo
 	Close() error
 	Migrate() error
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/ipfs-force-community/droplet/v2/config"
 	"github.com/ipfs-force-community/droplet/v2/version"
 	"github.com/ipfs-force-community/metrics"
 	"github.com/libp2p/go-libp2p"
@@ -53,3 +54,31 @@ func Host(mctx metrics.MetricsCtx, lc fx.Lifecycle, params P2PHostIn) (host.Host
 
 	return h, nil
 }
+
+// StandaloneHost creates a libp2p host which is independent of the droplet host,
+// and starts listening on the configured addresses
+func StandaloneHost(home config.IHome, cfg *config.Libp2p) (host.Host, error) {
+	pkey, err := PrivKey(home, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	addrsFactory, err := makeAddrsFactory(cfg.AnnounceAddresses, cfg.NoAnnounceAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	listenAddrs, err := listenAddresses(cfg.ListenAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	return libp2p.New(
+		libp2p.Identity(pkey),
+		libp2p.ListenAddrs(listenAddrs...),
+		libp2p.AddrsFactory(addrsFactory),
+		libp2p.DisableRelay(),
+		libp2p.Ping(true),
+		libp2p.UserAgent("droplet"+version.UserVersion()),
+	)
+}
@@ -59,6 +59,10 @@ type VoucherCreateResult struct {
 	Shortfall big.Int
 }
 
+// VoucherRedeemedFunc is called with the amount redeemed from an inbound payment channel,
+// after the message submitting a voucher is executed successfully
+type VoucherRedeemedFunc func(ctx context.Context, ch address.Address, redeemed big.Int)
+
 // managerAPI defines all methods needed by the manager
 type managerAPI interface {
 	IStateManager
@@ -84,6 +88,9 @@ type Manager struct {
 
 	lk       sync.RWMutex
 	channels map[string]*channelAccessor
+
+	redeemedLk   sync.Mutex
+	redeemedSubs []VoucherRedeemedFunc
 }
 type ManagerParams struct {
 	MPoolAPI     IMessagePush
@@ -126,6 +133,23 @@ func newManager(ctx context.Context, r repo.Repo, pchapi managerAPI) (*Manager,
 	return pm, pm.Start(ctx)
 }
 
+// SubscribeVoucherRedeemed registers a callback which is called when a voucher of an inbound channel is redeemed
+func (pm *Manager) SubscribeVoucherRedeemed(cb VoucherRedeemedFunc) {
+	pm.redeemedLk.Lock()
+	defer pm.redeemedLk.Unlock()
+	pm.redeemedSubs = append(pm.redeemedSubs, cb)
+}
+
+func (pm *Manager) voucherRedeemed(ctx context.Context, ch address.Address, redeemed big.Int) {
+	pm.redeemedLk.Lock()
+	subs := append([]VoucherRedeemedFunc{}, pm.redeemedSubs...)
+	pm.redeemedLk.Unlock()
+
+	for _, cb := range subs {
+		cb(ctx, ch, redeemed)
+	}
+}
+
 // Start restarts tracking of any messages that were sent to chain.
 func (pm *Manager) Start(ctx context.Context) error {
 	return pm.restartPending(ctx)
@@ -70,6 +70,7 @@ type channelAccessor struct {
 	lk              *channelLock
 	fundsReqQueue   []*fundsReq
 	msgListeners    msgListeners
+	onRedeemed      VoucherRedeemedFunc
 }
 
 func newChannelAccessor(pm *Manager, from address.Address, to address.Address) *channelAccessor {
@@ -83,6 +84,7 @@ func newChannelAccessor(pm *Manager, from address.Address, to address.Address) *
 		msgInfoRepo:     pm.msgInfoRepo,
 		lk:              &channelLock{globalLock: &pm.lk},
 		msgListeners:    newMsgListeners(),
+		onRedeemed:      pm.voucherRedeemed,
 	}
 }
 
@@ -447,6 +449,9 @@ func (ca *channelAccessor) submitVoucher(ctx context.Context, ch address.Address
 		return cid.Undef, err
 	}
 
+	// the vouchers of a lane are cumulative, only the amount above the submitted ones is redeemed
+	redeemed := big.Sub(sv.Amount, submittedAmount(ci, sv.Lane))
+
 	// If the channel didn't already have the voucher
 	if !has {
 		// Add the voucher to the channel
@@ -465,9 +470,40 @@ func (ca *channelAccessor) submitVoucher(ctx context.Context, ch address.Address
 		return cid.Undef, err
 	}
 
+	if ci.Direction == types.DirInbound && redeemed.GreaterThan(big.Zero()) {
+		go ca.waitVoucherRedeemed(ch, msgId, redeemed)
+	}
+
 	return msgId, nil
 }
 
+// submittedAmount returns the largest amount of the vouchers submitted in the lane
+func submittedAmount(ci *types.ChannelInfo, lane uint64) big.Int {
+	amount := big.Zero()
+	for _, vi := range ci.Vouchers {
+		if vi.Submitted && vi.Voucher.Lane == lane && vi.Voucher.Amount.GreaterThan(amount) {
+			amount = vi.Voucher.Amount
+		}
+	}
+	return amount
+}
+
+// waitVoucherRedeemed notifies the amount redeemed once the message submitting the voucher is executed
+func (ca *channelAccessor) waitVoucherRedeemed(ch address.Address, mcid cid.Cid, redeemed big.Int) {
+	mwait, err := ca.api.WaitMsg(ca.chctx, mcid, 1)
+	if err != nil {
+		log.Warnf("wait for voucher submit message %s of channel %s: %v", mcid, ch, err)
+		return
+	}
+	if mwait.Receipt.ExitCode != 0 {
+		log.Warnf("voucher submit message %s of channel %s failed with exit code %d", mcid, ch, mwait.Receipt.ExitCode)
+		return
+	}
+	if ca.onRedeemed != nil {
+		ca.onRedeemed(ca.chctx, ch, redeemed)
+	}
+}
+
 func (ca *channelAccessor) allocateLane(ctx context.Context, ch address.Address) (uint64, error) {
 	ca.lk.Lock()
 	defer ca.lk.Unlock()
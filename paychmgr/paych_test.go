@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/specs-actors/v7/actors/builtin"
 	tutils "github.com/filecoin-project/specs-actors/v7/support/testing"
 
@@ -703,6 +705,46 @@ func TestSubmitVoucher(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestSubmitVoucherRedeemed(t *testing.T) {
+	ctx := context.Background()
+
+	// Set up a manager with a single inbound payment channel
+	s := testSetupMgrWithChannel(t)
+	ci, err := s.mgr.channelInfoRepo.GetChannelByAddress(ctx, s.ch)
+	require.NoError(t, err)
+	ci.Direction = types.DirInbound
+	require.NoError(t, s.mgr.channelInfoRepo.SaveChannel(ctx, ci))
+
+	redeemed := make(chan big.Int, 1)
+	s.mgr.SubscribeVoucherRedeemed(func(_ context.Context, ch address.Address, amt big.Int) {
+		if ch == s.ch {
+			redeemed <- amt
+		}
+	})
+
+	// The vouchers of a lane are cumulative, so only the increase is redeemed
+	voucherLane := uint64(1)
+	submit := func(nonce uint64, amount int64, exitCode exitcode.ExitCode) {
+		voucher := createTestVoucher(t, s.ch, voucherLane, nonce, big.NewInt(amount), s.fromKeyPrivate)
+		submitCid, err := s.mgr.SubmitVoucher(ctx, s.ch, voucher, nil, nil)
+		require.NoError(t, err)
+		s.mock.receiveMsgResponse(submitCid, types2.MessageReceipt{ExitCode: exitCode})
+	}
+
+	submit(1, 1, 0)
+	require.Equal(t, big.NewInt(1), <-redeemed)
+	submit(2, 3, 0)
+	require.Equal(t, big.NewInt(2), <-redeemed)
+
+	// The voucher whose message fails is not redeemed
+	submit(3, 5, 1)
+	select {
+	case amt := <-redeemed:
+		t.Fatalf("unexpected redeemed amount %s", amt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 type testScaffold struct {
 	mgr            *Manager
 	mock           *mockManagerAPI
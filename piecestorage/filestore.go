@@ -111,6 +111,18 @@ func (f *fsPieceStorage) Has(_ context.Context, resourceId string) (bool, error)
 	return true, nil
 }
 
+func (f *fsPieceStorage) Delete(_ context.Context, resourceId string) error {
+	if f.fsCfg.ReadOnly {
+		return fmt.Errorf("do not delete from a 'readonly' piece store")
+	}
+
+	err := os.Remove(path.Join(f.baseUrl, resourceId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (f *fsPieceStorage) Validate(_ string) error {
 	st, err := os.Stat(f.baseUrl)
 	if err != nil {
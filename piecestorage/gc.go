@@ -0,0 +1,217 @@
+package piecestorage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// terminalDealStates are the states from which a deal never reads its piece again
+var terminalDealStates = map[storagemarket.StorageDealStatus]struct{}{
+	storagemarket.StorageDealProposalNotFound: {},
+	storagemarket.StorageDealProposalRejected: {},
+	storagemarket.StorageDealExpired:          {},
+	storagemarket.StorageDealSlashed:          {},
+	storagemarket.StorageDealRejecting:        {},
+	storagemarket.StorageDealFailing:          {},
+	storagemarket.StorageDealError:            {},
+}
+
+// PieceGC deletes the pieces which are no longer referenced by any non-terminal deal from the piece storages
+type PieceGC struct {
+	mgr      *PieceStorageManager
+	dealRepo repo.StorageDealRepo
+
+	lk      sync.Mutex
+	running bool
+	// the first time a piece without deal was found, it's kept in memory,
+	// so the grace period of such pieces restarts after droplet restarts
+	firstSeen map[cid.Cid]time.Time
+}
+
+func NewPieceGC(mgr *PieceStorageManager, r repo.Repo) *PieceGC {
+	return &PieceGC{
+		mgr:       mgr,
+		dealRepo:  r.StorageDealRepo(),
+		firstSeen: make(map[cid.Cid]time.Time),
+	}
+}
+
+type pieceRef struct {
+	// referenced by a non-terminal deal
+	active      bool
+	lastUpdated time.Time
+}
+
+// Run collects the unreferenced pieces of the piece storages, only one collection runs at the same time
+func (gc *PieceGC) Run(ctx context.Context, params *mtypes.PieceGCParams) (*mtypes.PieceGCReport, error) {
+	gc.lk.Lock()
+	if gc.running {
+		gc.lk.Unlock()
+		return nil, fmt.Errorf("piece gc is already running")
+	}
+	gc.running = true
+	gc.lk.Unlock()
+	defer func() {
+		gc.lk.Lock()
+		gc.running = false
+		gc.lk.Unlock()
+	}()
+
+	storages, err := gc.selectStorages(params.Storages)
+	if err != nil {
+		return nil, err
+	}
+	report := &mtypes.PieceGCReport{
+		StartedAt: time.Now(),
+		DryRun:    params.DryRun,
+	}
+	// deals are listed before resources, so a piece saved for a new deal during gc is treated as without deal,
+	// and is protected by the grace period
+	refs, err := gc.pieceReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := make(map[cid.Cid]struct{})
+	for _, st := range storages {
+		resourceIds, err := st.ListResourceIds(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list resources of piece storage %s: %w", st.GetName(), err)
+		}
+
+		for _, resourceId := range resourceIds {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			pieceCid, err := cid.Decode(resourceId)
+			if err != nil {
+				report.Unrecognized++
+				continue
+			}
+
+			item := &mtypes.PieceGCItem{
+				Storage:    st.GetName(),
+				ResourceID: resourceId,
+			}
+			ref, ok := refs[pieceCid]
+			switch {
+			case !ok:
+				orphans[pieceCid] = struct{}{}
+				item.Reason = mtypes.PieceGCReasonNoDeals
+				item.UnreferencedSince = gc.firstSeenTime(pieceCid, report.StartedAt)
+			case ref.active:
+				continue
+			default:
+				item.Reason = mtypes.PieceGCReasonTerminalDeals
+				item.UnreferencedSince = ref.lastUpdated
+			}
+			if report.StartedAt.Sub(item.UnreferencedSince) < params.GracePeriod {
+				report.InGracePeriod++
+				continue
+			}
+
+			if size, err := st.Len(ctx, resourceId); err == nil {
+				item.Size = size
+			}
+			if !params.DryRun {
+				if err := st.Delete(ctx, resourceId); err != nil {
+					log.Warnf("gc piece %s from %s: %v", resourceId, st.GetName(), err)
+					item.Error = err.Error()
+				} else {
+					log.Infof("gc piece %s from %s, reason %s", resourceId, st.GetName(), item.Reason)
+					gc.mgr.invalidateLocation(resourceId)
+					item.Deleted = true
+				}
+			}
+			if len(item.Error) == 0 {
+				report.ReclaimedBytes += item.Size
+			}
+			report.Pieces = append(report.Pieces, item)
+		}
+	}
+
+	gc.lk.Lock()
+	for pieceCid := range gc.firstSeen {
+		// a piece may be kept in the storages not scanned this time
+		if _, ok := orphans[pieceCid]; !ok && (len(params.Storages) == 0 || refs[pieceCid] != nil) {
+			delete(gc.firstSeen, pieceCid)
+		}
+	}
+	gc.lk.Unlock()
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (gc *PieceGC) firstSeenTime(pieceCid cid.Cid, now time.Time) time.Time {
+	gc.lk.Lock()
+	defer gc.lk.Unlock()
+
+	since, ok := gc.firstSeen[pieceCid]
+	if !ok {
+		since = now
+		gc.firstSeen[pieceCid] = since
+	}
+	return since
+}
+
+func (gc *PieceGC) selectStorages(names []string) ([]IPieceStorage, error) {
+	var storages []IPieceStorage
+	if len(names) == 0 {
+		_ = gc.mgr.EachPieceStorage(func(st IPieceStorage) error {
+			// the pieces of remote storages are collected by the remote droplet
+			if !st.ReadOnly() && st.Type() != Remote {
+				storages = append(storages, st)
+			}
+			return nil
+		})
+		return storages, nil
+	}
+
+	for _, name := range names {
+		st, err := gc.mgr.GetPieceStorageByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if st.ReadOnly() {
+			return nil, fmt.Errorf("piece storage %s is readonly", name)
+		}
+		storages = append(storages, st)
+	}
+	return storages, nil
+}
+
+// pieceReferences returns the reference state of all the pieces of storage deals
+func (gc *PieceGC) pieceReferences(ctx context.Context) (map[cid.Cid]*pieceRef, error) {
+	deals, err := gc.dealRepo.ListDeal(ctx, &types.StorageDealQueryParams{Page: types.Page{Limit: math.MaxInt32}})
+	if err != nil {
+		return nil, fmt.Errorf("list storage deals: %w", err)
+	}
+
+	refs := make(map[cid.Cid]*pieceRef)
+	for _, deal := range deals {
+		pieceCid := deal.Proposal.PieceCID
+		ref, ok := refs[pieceCid]
+		if !ok {
+			ref = &pieceRef{}
+			refs[pieceCid] = ref
+		}
+		if _, ok := terminalDealStates[deal.State]; !ok {
+			ref.active = true
+		}
+		if updated := time.Unix(int64(deal.UpdatedAt), 0); updated.After(ref.lastUpdated) {
+			ref.lastUpdated = updated
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,109 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func init() {
+	testutil.MustRegisterDefaultValueProvier(func(t *testing.T) types.DealLabel {
+		l, err := types.NewLabelFromBytes([]byte{})
+		assert.NoError(t, err)
+		return l
+	})
+}
+
+func TestPieceGC(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	deals := make([]markettypes.MinerDeal, 5)
+	testutil.Provide(t, &deals)
+	// piece 0 is active, piece 1 is expired, piece 2 is shared by an expired deal and a sealing deal,
+	// piece 3 has no deal
+	deals[0].State = storagemarket.StorageDealActive
+	deals[1].State = storagemarket.StorageDealExpired
+	deals[2].State = storagemarket.StorageDealExpired
+	deals[3].State = storagemarket.StorageDealSealing
+	deals[3].Proposal.PieceCID = deals[2].Proposal.PieceCID
+	for i := 0; i < 4; i++ {
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := NewMemPieceStore("mem", nil)
+	psm.AddMemPieceStorage(store)
+	for _, id := range []string{
+		deals[0].Proposal.PieceCID.String(),
+		deals[1].Proposal.PieceCID.String(),
+		deals[2].Proposal.PieceCID.String(),
+		deals[4].Proposal.PieceCID.String(),
+		"not-a-piece",
+	} {
+		_, err := store.SaveTo(ctx, id, bytes.NewReader([]byte("piece data")))
+		require.NoError(t, err)
+	}
+	expired, orphan := deals[1].Proposal.PieceCID.String(), deals[4].Proposal.PieceCID.String()
+
+	gc := NewPieceGC(psm, r)
+
+	report, err := gc.Run(ctx, &mtypes.PieceGCParams{GracePeriod: time.Hour, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, report.Pieces)
+	assert.Equal(t, 2, report.InGracePeriod)
+	assert.Equal(t, 1, report.Unrecognized)
+
+	report, err = gc.Run(ctx, &mtypes.PieceGCParams{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, report.Pieces, 2)
+	assert.Equal(t, int64(20), report.ReclaimedBytes)
+	reasons := map[string]mtypes.PieceGCReason{}
+	for _, item := range report.Pieces {
+		assert.False(t, item.Deleted)
+		reasons[item.ResourceID] = item.Reason
+	}
+	assert.Equal(t, map[string]mtypes.PieceGCReason{
+		expired: mtypes.PieceGCReasonTerminalDeals,
+		orphan:  mtypes.PieceGCReasonNoDeals,
+	}, reasons)
+	has, err := store.Has(ctx, expired)
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	// the piece without deal was first found by the previous runs
+	gc.firstSeen[deals[4].Proposal.PieceCID] = time.Now().Add(-2 * time.Hour)
+	report, err = gc.Run(ctx, &mtypes.PieceGCParams{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	require.Len(t, report.Pieces, 1)
+	assert.Equal(t, orphan, report.Pieces[0].ResourceID)
+	assert.True(t, report.Pieces[0].Deleted)
+
+	report, err = gc.Run(ctx, &mtypes.PieceGCParams{})
+	require.NoError(t, err)
+	require.Len(t, report.Pieces, 1)
+	assert.Equal(t, expired, report.Pieces[0].ResourceID)
+
+	ids, err := store.ListResourceIds(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		deals[0].Proposal.PieceCID.String(),
+		deals[2].Proposal.PieceCID.String(),
+		"not-a-piece",
+	}, ids)
+	assert.Empty(t, gc.firstSeen)
+}
@@ -0,0 +1,264 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/google/uuid"
+)
+
+// drainCheckInterval is the interval to check whether the in-flight operations of a draining storage are finished
+var drainCheckInterval = 100 * time.Millisecond
+
+// managedStorage wraps the storages in manager, it tracks the in-flight operations of storage,
+// so that a storage can be drained and removed after the operations finish
+type managedStorage struct {
+	IPieceStorage
+
+	// the operations and the opened readers of storage
+	inflight int64
+
+	lk       sync.Mutex
+	draining bool
+
+	// onReadError is called when reading a resource from the storage fails, the manager drops the
+	// cached location of the resource, so that the resource is looked up again in next read
+	onReadError func(resourceId string)
+}
+
+func newManagedStorage(st IPieceStorage) *managedStorage {
+	if m, ok := st.(*managedStorage); ok {
+		return m
+	}
+	return &managedStorage{IPieceStorage: st}
+}
+
+// unwrapStorage returns the underlying storage, which is used to check the type of storage
+func unwrapStorage(st IPieceStorage) IPieceStorage {
+	if m, ok := st.(*managedStorage); ok {
+		return m.IPieceStorage
+	}
+	return st
+}
+
+func (m *managedStorage) begin() func() {
+	atomic.AddInt64(&m.inflight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&m.inflight, -1)
+		})
+	}
+}
+
+// readFailed reports the failed read of resource, the error caused by the canceled context is ignored
+func (m *managedStorage) readFailed(resourceId string, err error) {
+	if m.onReadError == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	m.onReadError(resourceId)
+}
+
+func (m *managedStorage) inFlight() int64 {
+	return atomic.LoadInt64(&m.inflight)
+}
+
+func (m *managedStorage) isDraining() bool {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return m.draining
+}
+
+func (m *managedStorage) setDraining(draining bool) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.draining = draining
+}
+
+// waitIdle waits until all the in-flight operations of storage are finished
+func (m *managedStorage) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for m.inFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for %d in-flight operations of %s: %w", m.inFlight(), m.GetName(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// ReadOnly returns true if the storage is draining, so that no new pieces are written to it
+func (m *managedStorage) ReadOnly() bool {
+	return m.IPieceStorage.ReadOnly() || m.isDraining()
+}
+
+func (m *managedStorage) SaveTo(ctx context.Context, resourceId string, r io.Reader) (int64, error) {
+	// the operation is counted before checking draining, so that it's waited by the drain which starts
+	// after the check
+	defer m.begin()()
+	if m.isDraining() {
+		return 0, fmt.Errorf("piece storage %s is draining", m.GetName())
+	}
+	return m.IPieceStorage.SaveTo(ctx, resourceId, r)
+}
+
+func (m *managedStorage) Len(ctx context.Context, resourceId string) (int64, error) {
+	defer m.begin()()
+	return m.IPieceStorage.Len(ctx, resourceId)
+}
+
+func (m *managedStorage) ListResourceIds(ctx context.Context) ([]string, error) {
+	defer m.begin()()
+	return m.IPieceStorage.ListResourceIds(ctx)
+}
+
+func (m *managedStorage) GetReaderCloser(ctx context.Context, resourceId string) (io.ReadCloser, error) {
+	end := m.begin()
+	r, err := m.IPieceStorage.GetReaderCloser(ctx, resourceId)
+	if err != nil {
+		end()
+		m.readFailed(resourceId, err)
+		return nil, err
+	}
+	return &trackedReadCloser{ReadCloser: r, end: end, onErr: func(err error) { m.readFailed(resourceId, err) }}, nil
+}
+
+func (m *managedStorage) GetMountReader(ctx context.Context, resourceId string) (mount.Reader, error) {
+	end := m.begin()
+	r, err := m.IPieceStorage.GetMountReader(ctx, resourceId)
+	if err != nil {
+		end()
+		m.readFailed(resourceId, err)
+		return nil, err
+	}
+	return &trackedMountReader{Reader: r, end: end, onErr: func(err error) { m.readFailed(resourceId, err) }}, nil
+}
+
+func (m *managedStorage) GetRedirectUrl(ctx context.Context, resourceId string) (string, error) {
+	defer m.begin()()
+	return m.IPieceStorage.GetRedirectUrl(ctx, resourceId)
+}
+
+func (m *managedStorage) Has(ctx context.Context, resourceId string) (bool, error) {
+	defer m.begin()()
+	return m.IPieceStorage.Has(ctx, resourceId)
+}
+
+func (m *managedStorage) Delete(ctx context.Context, resourceId string) error {
+	defer m.begin()()
+	return m.IPieceStorage.Delete(ctx, resourceId)
+}
+
+func (m *managedStorage) GetPieceTransfer(ctx context.Context, pieceCid string) (string, error) {
+	defer m.begin()()
+	if m.isDraining() {
+		return "", fmt.Errorf("piece storage %s is draining", m.GetName())
+	}
+	return m.IPieceStorage.GetPieceTransfer(ctx, pieceCid)
+}
+
+// trackedReadCloser finishes the operation when it's closed, and reports the read errors by onErr
+type trackedReadCloser struct {
+	io.ReadCloser
+	end   func()
+	onErr func(error)
+}
+
+func (t *trackedReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		t.onErr(err)
+	}
+	return n, err
+}
+
+func (t *trackedReadCloser) Close() error {
+	defer t.end()
+	return t.ReadCloser.Close()
+}
+
+var _ mount.Reader = (*trackedMountReader)(nil)
+
+type trackedMountReader struct {
+	mount.Reader
+	end   func()
+	onErr func(error)
+}
+
+func (t *trackedMountReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		t.onErr(err)
+	}
+	return n, err
+}
+
+func (t *trackedMountReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.Reader.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		t.onErr(err)
+	}
+	return n, err
+}
+
+func (t *trackedMountReader) Close() error {
+	defer t.end()
+	return t.Reader.Close()
+}
+
+// ProbePieceStorage checks the storage works, a writable storage is checked by writing, reading and deleting a probe
+func ProbePieceStorage(ctx context.Context, st IPieceStorage) error {
+	if err := st.Validate(""); err != nil {
+		return fmt.Errorf("validate piece storage %s: %w", st.GetName(), err)
+	}
+	// the resources of remote storage can't be deleted
+	if st.ReadOnly() || unwrapStorage(st).Type() == Remote {
+		if _, err := st.Has(ctx, "droplet-probe"); err != nil {
+			return fmt.Errorf("read from piece storage %s: %w", st.GetName(), err)
+		}
+		return nil
+	}
+
+	data := make([]byte, 1024)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	resourceID := fmt.Sprintf("droplet-probe-%s", uuid.New().String())
+	if _, err := st.SaveTo(ctx, resourceID, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("write to piece storage %s: %w", st.GetName(), err)
+	}
+	if err := probeRead(ctx, st, resourceID, data); err != nil {
+		_ = st.Delete(ctx, resourceID)
+		return err
+	}
+	if err := st.Delete(ctx, resourceID); err != nil {
+		return fmt.Errorf("delete from piece storage %s: %w", st.GetName(), err)
+	}
+	return nil
+}
+
+func probeRead(ctx context.Context, st IPieceStorage, resourceID string, data []byte) error {
+	r, err := st.GetReaderCloser(ctx, resourceID)
+	if err != nil {
+		return fmt.Errorf("read from piece storage %s: %w", st.GetName(), err)
+	}
+	defer r.Close() // nolint
+	read, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read from piece storage %s: %w", st.GetName(), err)
+	}
+	if !bytes.Equal(read, data) {
+		return fmt.Errorf("the data read from piece storage %s mismatches the data written", st.GetName())
+	}
+	return nil
+}
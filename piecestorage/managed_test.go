@@ -0,0 +1,115 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func TestProbePieceStorage(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemPieceStore("mem", nil)
+	require.NoError(t, ProbePieceStorage(ctx, st))
+	ids, err := st.ListResourceIds(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	fs, err := NewFsPieceStorage(&config.FsPieceStorage{Name: "fs", Path: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, ProbePieceStorage(ctx, fs))
+}
+
+func TestDrainPieceStorage(t *testing.T) {
+	ctx := context.Background()
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	psm.AddMemPieceStorage(NewMemPieceStore("mem", &market.StorageStatus{Capacity: 1 << 30, Available: 1 << 30}))
+	st, err := psm.GetPieceStorageByName("mem")
+	require.NoError(t, err)
+	_, err = st.SaveTo(ctx, "piece", bytes.NewReader([]byte("mock piece")))
+	require.NoError(t, err)
+
+	require.NoError(t, psm.SetDraining("mem", true))
+	_, err = st.SaveTo(ctx, "other", bytes.NewReader([]byte("mock piece")))
+	assert.Error(t, err)
+	_, err = st.GetPieceTransfer(ctx, "other")
+	assert.Error(t, err)
+	// the rejected operations are finished
+	assert.Equal(t, int64(0), st.(*managedStorage).inFlight())
+	_, _, err = psm.FindStorageForWrite(address.Undef, 10)
+	assert.Error(t, err)
+	has, err := st.Has(ctx, "piece")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	// the draining state is kept after the settings are changed
+	require.NoError(t, psm.ReplacePieceStorage(NewMemPieceStore("mem", &market.StorageStatus{Capacity: 1 << 30, Available: 1 << 30})))
+	_, _, err = psm.FindStorageForWrite(address.Undef, 10)
+	assert.Error(t, err)
+	health := psm.ListStorageHealth()
+	require.Len(t, health, 1)
+	assert.True(t, health[0].Draining)
+
+	require.NoError(t, psm.SetDraining("mem", false))
+	_, _, err = psm.FindStorageForWrite(address.Undef, 10)
+	assert.NoError(t, err)
+}
+
+func TestRemovePieceStorageWaitsInFlight(t *testing.T) {
+	ctx := context.Background()
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	psm.AddMemPieceStorage(NewMemPieceStore("mem", nil))
+	st, err := psm.GetPieceStorageByName("mem")
+	require.NoError(t, err)
+	_, err = st.SaveTo(ctx, "piece", bytes.NewReader([]byte("mock piece")))
+	require.NoError(t, err)
+
+	r, err := st.GetReaderCloser(ctx, "piece")
+	require.NoError(t, err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, psm.RemovePieceStorage(timeoutCtx, "mem"), context.DeadlineExceeded)
+	// the storage is kept draining
+	_, err = psm.GetPieceStorageByName("mem")
+	require.NoError(t, err)
+	assert.True(t, st.ReadOnly())
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = r.Close()
+	}()
+	require.NoError(t, psm.RemovePieceStorage(ctx, "mem"))
+	_, err = psm.GetPieceStorageByName("mem")
+	assert.Error(t, err)
+}
+
+func TestApplyPieceStorageUpdate(t *testing.T) {
+	readOnly, priority := true, 10
+	quota, secret := int64(1<<30), "secret"
+
+	fs := &config.FsPieceStorage{Name: "fs"}
+	require.NoError(t, ApplyPieceStorageUpdate(fs, &mtypes.PieceStorageUpdateParams{ReadOnly: &readOnly, Priority: &priority}))
+	assert.True(t, fs.ReadOnly)
+	assert.Equal(t, 10, fs.Priority)
+	assert.Error(t, ApplyPieceStorageUpdate(fs, &mtypes.PieceStorageUpdateParams{Quota: &quota}))
+
+	s3 := &config.S3PieceStorage{Name: "s3", SecretKey: "old"}
+	require.NoError(t, ApplyPieceStorageUpdate(s3, &mtypes.PieceStorageUpdateParams{Quota: &quota, SecretKey: &secret}))
+	assert.Equal(t, quota, s3.Quota)
+	assert.Equal(t, secret, s3.SecretKey)
+	assert.False(t, s3.ReadOnly)
+
+	remote := &config.RemotePieceStorage{Name: "remote"}
+	assert.Error(t, ApplyPieceStorageUpdate(remote, &mtypes.PieceStorageUpdateParams{SecretKey: &secret}))
+}
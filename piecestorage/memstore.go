@@ -20,6 +20,8 @@ type MemPieceStore struct {
 	dataLk            *sync.RWMutex
 	status            *market.StorageStatus // status for testing
 	RedirectResources map[string]bool
+	Priority          int
+	ReadPriority      int
 }
 
 func NewMemPieceStore(name string, status *market.StorageStatus) *MemPieceStore {
@@ -91,6 +93,13 @@ func (m *MemPieceStore) Has(ctx context.Context, resourceId string) (bool, error
 	return ok, nil
 }
 
+func (m *MemPieceStore) Delete(ctx context.Context, resourceId string) error {
+	m.dataLk.Lock()
+	defer m.dataLk.Unlock()
+	delete(m.data, resourceId)
+	return nil
+}
+
 func (m *MemPieceStore) GetStorageStatus() (market.StorageStatus, error) {
 	if m.status != nil {
 		return *m.status, nil
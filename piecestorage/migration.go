@@ -0,0 +1,529 @@
+package piecestorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"go.uber.org/fx"
+	"golang.org/x/time/rate"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var (
+	migrationJobPrefix    = datastore.NewKey("/jobs")
+	migrationPiecesPrefix = datastore.NewKey("/pieces")
+)
+
+// PieceMigrator copies pieces between piece storages in background jobs, the progress of jobs is persisted,
+// and the running jobs resume after droplet restarts
+type PieceMigrator struct {
+	mgr      *PieceStorageManager
+	dealRepo repo.StorageDealRepo
+	ds       datastore.Batching
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lk   sync.Mutex
+	jobs map[string]*mtypes.PieceMigrationJob
+	// the cancel functions of running jobs
+	running map[string]context.CancelFunc
+}
+
+func NewPieceMigrator(lc fx.Lifecycle, mgr *PieceStorageManager, r repo.Repo, ds badger.PieceMigrationDS) (*PieceMigrator, error) {
+	m, err := newPieceMigrator(mgr, r.StorageDealRepo(), ds)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			m.resumeRunning()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			m.Close()
+			return nil
+		},
+	})
+	return m, nil
+}
+
+func newPieceMigrator(mgr *PieceStorageManager, dealRepo repo.StorageDealRepo, ds datastore.Batching) (*PieceMigrator, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &PieceMigrator{
+		mgr:      mgr,
+		dealRepo: dealRepo,
+		ds:       ds,
+		ctx:      ctx,
+		cancel:   cancel,
+		jobs:     make(map[string]*mtypes.PieceMigrationJob),
+		running:  make(map[string]context.CancelFunc),
+	}
+
+	res, err := ds.Query(ctx, query.Query{Prefix: migrationJobPrefix.String()})
+	if err != nil {
+		return nil, fmt.Errorf("query piece migration jobs: %w", err)
+	}
+	defer res.Close() // nolint
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		job := &mtypes.PieceMigrationJob{}
+		if err := json.Unmarshal(r.Value, job); err != nil {
+			return nil, fmt.Errorf("unmarshal piece migration job %s: %w", r.Key, err)
+		}
+		m.jobs[job.ID] = job
+	}
+	return m, nil
+}
+
+// Close stops the running jobs, they resume when the migrator starts next time
+func (m *PieceMigrator) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *PieceMigrator) resumeRunning() {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	for _, job := range m.jobs {
+		if job.State == mtypes.PieceMigrationRunning {
+			log.Infof("resume piece migration job %s from %d/%d", job.ID, job.Processed, job.Total)
+			m.launchLocked(job)
+		}
+	}
+}
+
+// Start creates a job to migrate the pieces selected by params, only one job runs at the same time
+func (m *PieceMigrator) Start(ctx context.Context, params *mtypes.PieceMigrationParams) (*mtypes.PieceMigrationJob, error) {
+	src, _, err := m.storages(params)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	if len(m.running) > 0 {
+		return nil, fmt.Errorf("another piece migration job is running")
+	}
+
+	pieces, err := m.selectPieces(ctx, src, params)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &mtypes.PieceMigrationJob{
+		ID:        uuid.New().String(),
+		Params:    *params,
+		State:     mtypes.PieceMigrationRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Total:     len(pieces),
+	}
+	data, err := json.Marshal(pieces)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.ds.Put(ctx, migrationPiecesPrefix.ChildString(job.ID), data); err != nil {
+		return nil, fmt.Errorf("save pieces of migration job: %w", err)
+	}
+	if err := m.saveJob(ctx, job); err != nil {
+		return nil, err
+	}
+	m.jobs[job.ID] = job
+	log.Infof("start piece migration job %s, migrate %d pieces from %s to %s", job.ID, job.Total, params.Source, params.Target)
+
+	m.launchLocked(job)
+	return copyMigrationJob(job), nil
+}
+
+// Cancel stops a running job, it can be resumed by Resume
+func (m *PieceMigrator) Cancel(id string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	cancel, ok := m.running[id]
+	if !ok {
+		return fmt.Errorf("piece migration job %s is not running", id)
+	}
+	m.jobs[id].State = mtypes.PieceMigrationCanceled
+	cancel()
+	return nil
+}
+
+// Resume continues a canceled or failed job from the first unprocessed piece
+func (m *PieceMigrator) Resume(ctx context.Context, id string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return fmt.Errorf("piece migration job %s not found", id)
+	}
+	if job.State != mtypes.PieceMigrationCanceled && job.State != mtypes.PieceMigrationFailed {
+		return fmt.Errorf("piece migration job %s is %s", id, job.State)
+	}
+	if len(m.running) > 0 {
+		return fmt.Errorf("another piece migration job is running")
+	}
+
+	job.State = mtypes.PieceMigrationRunning
+	job.Error = ""
+	job.UpdatedAt = time.Now()
+	if err := m.saveJob(ctx, job); err != nil {
+		return err
+	}
+	m.launchLocked(job)
+	return nil
+}
+
+// List returns the jobs, the latest first
+func (m *PieceMigrator) List() []*mtypes.PieceMigrationJob {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	out := make([]*mtypes.PieceMigrationJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		out = append(out, copyMigrationJob(job))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+func (m *PieceMigrator) launchLocked(job *mtypes.PieceMigrationJob) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.running[job.ID] = cancel
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		m.run(ctx, job)
+	}()
+}
+
+func (m *PieceMigrator) run(ctx context.Context, job *mtypes.PieceMigrationJob) {
+	finish := func(state mtypes.PieceMigrationState, err error) {
+		m.lk.Lock()
+		defer m.lk.Unlock()
+
+		delete(m.running, job.ID)
+		// the job is canceled by user, or droplet is stopping
+		if ctx.Err() != nil {
+			if job.State != mtypes.PieceMigrationCanceled {
+				return
+			}
+			state, err = mtypes.PieceMigrationCanceled, nil
+		}
+		job.State = state
+		if err != nil {
+			job.Error = err.Error()
+			log.Errorf("piece migration job %s failed: %v", job.ID, err)
+		} else {
+			log.Infof("piece migration job %s %s, %d/%d processed", job.ID, state, job.Processed, job.Total)
+		}
+		job.UpdatedAt = time.Now()
+		if err := m.saveJob(context.Background(), job); err != nil {
+			log.Errorf("save piece migration job %s: %v", job.ID, err)
+		}
+	}
+
+	src, dst, err := m.storages(&job.Params)
+	if err != nil {
+		finish(mtypes.PieceMigrationFailed, err)
+		return
+	}
+	data, err := m.ds.Get(ctx, migrationPiecesPrefix.ChildString(job.ID))
+	if err != nil {
+		finish(mtypes.PieceMigrationFailed, fmt.Errorf("get pieces of migration job: %w", err))
+		return
+	}
+	var pieces []string
+	if err := json.Unmarshal(data, &pieces); err != nil {
+		finish(mtypes.PieceMigrationFailed, fmt.Errorf("unmarshal pieces of migration job: %w", err))
+		return
+	}
+
+	var limiter *rate.Limiter
+	if job.Params.BytesPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(job.Params.BytesPerSecond), int(job.Params.BytesPerSecond))
+	}
+
+	for i := job.Processed; i < len(pieces); i++ {
+		resourceID := pieces[i]
+		copied, alreadyInTarget, err := m.migratePiece(ctx, &job.Params, src, dst, resourceID, limiter)
+		if ctx.Err() != nil {
+			// the piece is migrated again after resuming
+			finish(mtypes.PieceMigrationCanceled, nil)
+			return
+		}
+
+		m.lk.Lock()
+		switch {
+		case err != nil:
+			log.Warnf("migrate piece %s from %s to %s: %v", resourceID, src.GetName(), dst.GetName(), err)
+			job.Failures = append(job.Failures, mtypes.PieceMigrationFailure{ResourceID: resourceID, Error: err.Error()})
+		case alreadyInTarget:
+			job.AlreadyInTarget++
+		default:
+			job.Migrated++
+		}
+		job.BytesCopied += copied
+		job.Processed = i + 1
+		job.UpdatedAt = time.Now()
+		err = m.saveJob(ctx, job)
+		m.lk.Unlock()
+		if err != nil {
+			finish(mtypes.PieceMigrationFailed, err)
+			return
+		}
+	}
+	finish(mtypes.PieceMigrationCompleted, nil)
+}
+
+// migratePiece copies the piece to the target storage if it's not there, verifies it, and deletes the source
+// if required, the location cache of the piece is invalidated after migration
+func (m *PieceMigrator) migratePiece(ctx context.Context,
+	params *mtypes.PieceMigrationParams,
+	src, dst IPieceStorage,
+	resourceID string,
+	limiter *rate.Limiter,
+) (int64, bool, error) {
+	srcLen, err := src.Len(ctx, resourceID)
+	if err != nil {
+		return 0, false, fmt.Errorf("get length from source: %w", err)
+	}
+
+	alreadyInTarget := false
+	if has, err := dst.Has(ctx, resourceID); err != nil {
+		return 0, false, fmt.Errorf("check target: %w", err)
+	} else if has {
+		dstLen, err := dst.Len(ctx, resourceID)
+		alreadyInTarget = err == nil && dstLen == srcLen
+	}
+
+	var copied int64
+	if !alreadyInTarget {
+		r, err := src.GetReaderCloser(ctx, resourceID)
+		if err != nil {
+			return 0, false, fmt.Errorf("read from source: %w", err)
+		}
+		copied, err = dst.SaveTo(ctx, resourceID, newThrottledReader(ctx, r, limiter))
+		_ = r.Close()
+		if err != nil {
+			return copied, false, fmt.Errorf("save to target: %w", err)
+		}
+	}
+
+	if err := m.verify(ctx, params, dst, resourceID, srcLen); err != nil {
+		m.mgr.invalidateLocation(resourceID)
+		if !alreadyInTarget {
+			if err := dst.Delete(ctx, resourceID); err != nil {
+				log.Warnf("delete broken piece %s from %s: %v", resourceID, dst.GetName(), err)
+			}
+		}
+		return copied, alreadyInTarget, err
+	}
+	m.mgr.moveLocation(resourceID, src.GetName(), dst.GetName(), false)
+
+	if params.DeleteSource {
+		if err := src.Delete(ctx, resourceID); err != nil {
+			return copied, alreadyInTarget, fmt.Errorf("delete from source: %w", err)
+		}
+		m.mgr.moveLocation(resourceID, src.GetName(), dst.GetName(), true)
+	}
+	return copied, alreadyInTarget, nil
+}
+
+func (m *PieceMigrator) verify(ctx context.Context, params *mtypes.PieceMigrationParams, st IPieceStorage, resourceID string, expectLen int64) error {
+	length, err := st.Len(ctx, resourceID)
+	if err != nil {
+		return fmt.Errorf("get length from target: %w", err)
+	}
+	if length != expectLen {
+		return fmt.Errorf("length mismatch, source %d, target %d", expectLen, length)
+	}
+	if !params.VerifyCommP {
+		return nil
+	}
+
+	pieceCid, err := cid.Decode(resourceID)
+	if err != nil {
+		return fmt.Errorf("resource is not a piece cid: %w", err)
+	}
+	r, err := st.GetReaderCloser(ctx, resourceID)
+	if err != nil {
+		return fmt.Errorf("read from target: %w", err)
+	}
+	defer r.Close() // nolint
+
+	commP, err := calcCommP(r)
+	if err != nil {
+		return err
+	}
+	if _, dealSize, err := m.dealRepo.GetPieceSize(ctx, pieceCid); err == nil && uint64(dealSize) > commP.paddedSize {
+		if commP.raw, err = commp.PadCommP(commP.raw, commP.paddedSize, uint64(dealSize)); err != nil {
+			return err
+		}
+	}
+	c, err := commcid.DataCommitmentV1ToCID(commP.raw)
+	if err != nil {
+		return err
+	}
+	if !c.Equals(pieceCid) {
+		return fmt.Errorf("commP mismatch, got %s", c)
+	}
+	return nil
+}
+
+type rawCommP struct {
+	raw        []byte
+	paddedSize uint64
+}
+
+func calcCommP(r io.Reader) (*rawCommP, error) {
+	cp := &commp.Calc{}
+	if _, err := io.Copy(cp, r); err != nil {
+		return nil, fmt.Errorf("calculate commP: %w", err)
+	}
+	raw, paddedSize, err := cp.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("calculate commP: %w", err)
+	}
+	return &rawCommP{raw: raw, paddedSize: paddedSize}, nil
+}
+
+func (m *PieceMigrator) storages(params *mtypes.PieceMigrationParams) (IPieceStorage, IPieceStorage, error) {
+	if params.Source == params.Target {
+		return nil, nil, fmt.Errorf("source and target are the same storage")
+	}
+	src, err := m.mgr.GetPieceStorageByName(params.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := m.mgr.GetPieceStorageByName(params.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dst.ReadOnly() {
+		return nil, nil, fmt.Errorf("target storage %s is readonly", dst.GetName())
+	}
+	if params.DeleteSource && src.ReadOnly() {
+		return nil, nil, fmt.Errorf("can not delete pieces from readonly storage %s", src.GetName())
+	}
+	return src, dst, nil
+}
+
+// selectPieces returns the pieces of the source storage which have a deal matching the filters
+func (m *PieceMigrator) selectPieces(ctx context.Context, src IPieceStorage, params *mtypes.PieceMigrationParams) ([]string, error) {
+	resourceIDs, err := src.ListResourceIds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list resources of %s: %w", src.GetName(), err)
+	}
+	if params.Miner.Empty() && len(params.DealStates) == 0 && params.MinAge == 0 {
+		return resourceIDs, nil
+	}
+
+	deals, err := m.dealRepo.ListDeal(ctx, &types.StorageDealQueryParams{Page: types.Page{Limit: math.MaxInt32}})
+	if err != nil {
+		return nil, fmt.Errorf("list storage deals: %w", err)
+	}
+	states := make(map[storagemarket.StorageDealStatus]struct{}, len(params.DealStates))
+	for _, state := range params.DealStates {
+		states[state] = struct{}{}
+	}
+	createdBefore := time.Now().Add(-params.MinAge)
+
+	matched := make(map[cid.Cid]struct{})
+	for _, deal := range deals {
+		if !params.Miner.Empty() && deal.Proposal.Provider != params.Miner {
+			continue
+		}
+		if _, ok := states[deal.State]; len(states) > 0 && !ok {
+			continue
+		}
+		if time.Unix(int64(deal.CreatedAt), 0).After(createdBefore) {
+			continue
+		}
+		matched[deal.Proposal.PieceCID] = struct{}{}
+	}
+
+	var pieces []string
+	for _, resourceID := range resourceIDs {
+		pieceCid, err := cid.Decode(resourceID)
+		if err != nil {
+			continue
+		}
+		if _, ok := matched[pieceCid]; ok {
+			pieces = append(pieces, resourceID)
+		}
+	}
+	return pieces, nil
+}
+
+func (m *PieceMigrator) saveJob(ctx context.Context, job *mtypes.PieceMigrationJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := m.ds.Put(ctx, migrationJobPrefix.ChildString(job.ID), data); err != nil {
+		return fmt.Errorf("save piece migration job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func copyMigrationJob(job *mtypes.PieceMigrationJob) *mtypes.PieceMigrationJob {
+	out := *job
+	out.Params.DealStates = append([]storagemarket.StorageDealStatus(nil), job.Params.DealStates...)
+	out.Failures = append([]mtypes.PieceMigrationFailure(nil), job.Failures...)
+	return &out
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,235 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func randPiece(t *testing.T) ([]byte, cid.Cid, abi.PaddedPieceSize) {
+	data := make([]byte, 2000)
+	rand.Read(data) //nolint:gosec
+	commP, err := calcCommP(bytes.NewReader(data))
+	require.NoError(t, err)
+	c, err := commcid.DataCommitmentV1ToCID(commP.raw)
+	require.NoError(t, err)
+	return data, c, abi.PaddedPieceSize(commP.paddedSize)
+}
+
+func waitMigration(t *testing.T, m *PieceMigrator, id string) *mtypes.PieceMigrationJob {
+	var job *mtypes.PieceMigrationJob
+	require.Eventually(t, func() bool {
+		for _, j := range m.List() {
+			if j.ID == id {
+				job = j
+			}
+		}
+		return job != nil && job.State != mtypes.PieceMigrationRunning
+	}, 10*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestPieceMigration(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+	ds, err := badger.NewDatastore("")
+	require.NoError(t, err)
+
+	newAddr := address.NewForTestGetter()
+	minerA, minerB := newAddr(), newAddr()
+	deals := make([]markettypes.MinerDeal, 3)
+	testutil.Provide(t, &deals)
+	pieces := make([][]byte, 4)
+	for i := range pieces {
+		var pieceCid cid.Cid
+		var size abi.PaddedPieceSize
+		pieces[i], pieceCid, size = randPiece(t)
+		if i < len(deals) {
+			deals[i].Proposal.PieceCID = pieceCid
+			deals[i].Proposal.PieceSize = size
+			deals[i].State = storagemarket.StorageDealActive
+			deals[i].CreatedAt = uint64(time.Now().Add(-48 * time.Hour).Unix())
+		}
+	}
+	// piece 0 and 1 belong to minerA, piece 2 belongs to minerB and it's broken in the source storage,
+	// piece 3 has no deal
+	deals[0].Proposal.Provider = minerA
+	deals[1].Proposal.Provider = minerA
+	deals[2].Proposal.Provider = minerB
+	for i := range deals {
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+	id := func(i int) string {
+		if i < len(deals) {
+			return deals[i].Proposal.PieceCID.String()
+		}
+		commP, err := calcCommP(bytes.NewReader(pieces[i]))
+		require.NoError(t, err)
+		c, err := commcid.DataCommitmentV1ToCID(commP.raw)
+		require.NoError(t, err)
+		return c.String()
+	}
+
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	src, dst := NewMemPieceStore("src", nil), NewMemPieceStore("dst", nil)
+	psm.AddMemPieceStorage(src)
+	psm.AddMemPieceStorage(dst)
+	for i, data := range pieces {
+		if i == 2 {
+			data = append([]byte{}, data...)
+			data[0]++
+		}
+		_, err := src.SaveTo(ctx, id(i), bytes.NewReader(data))
+		require.NoError(t, err)
+	}
+	// piece 1 is in the target storage already
+	_, err = dst.SaveTo(ctx, id(1), bytes.NewReader(pieces[1]))
+	require.NoError(t, err)
+
+	m, err := newPieceMigrator(psm, r.StorageDealRepo(), ds)
+	require.NoError(t, err)
+	defer m.Close()
+
+	t.Run("invalid params", func(t *testing.T) {
+		_, err := m.Start(ctx, &mtypes.PieceMigrationParams{Source: "src", Target: "src"})
+		assert.Error(t, err)
+		_, err = m.Start(ctx, &mtypes.PieceMigrationParams{Source: "src", Target: "not-exist"})
+		assert.Error(t, err)
+	})
+
+	// the location of piece 0 is cached before migration
+	st, err := psm.FindStorageForRead(ctx, id(0))
+	require.NoError(t, err)
+	assert.Equal(t, "src", st.GetName())
+
+	job, err := m.Start(ctx, &mtypes.PieceMigrationParams{
+		Source:         "src",
+		Target:         "dst",
+		Miner:          minerA,
+		DealStates:     []storagemarket.StorageDealStatus{storagemarket.StorageDealActive},
+		MinAge:         time.Hour,
+		VerifyCommP:    true,
+		DeleteSource:   true,
+		BytesPerSecond: 1 << 20,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, job.Total)
+	job = waitMigration(t, m, job.ID)
+	assert.Equal(t, mtypes.PieceMigrationCompleted, job.State)
+	assert.Equal(t, 2, job.Processed)
+	assert.Equal(t, 1, job.Migrated)
+	assert.Equal(t, 1, job.AlreadyInTarget)
+	assert.Empty(t, job.Failures)
+	assert.Equal(t, int64(len(pieces[0])), job.BytesCopied)
+
+	for _, i := range []int{0, 1} {
+		has, err := src.Has(ctx, id(i))
+		require.NoError(t, err)
+		assert.False(t, has)
+		has, err = dst.Has(ctx, id(i))
+		require.NoError(t, err)
+		assert.True(t, has)
+	}
+	// the new location is cached after the piece moved
+	loc, ok := psm.locations.Get(id(0))
+	require.True(t, ok)
+	assert.Equal(t, []string{"dst"}, loc.storages)
+	st, err = psm.FindStorageForRead(ctx, id(0))
+	require.NoError(t, err)
+	assert.Equal(t, "dst", st.GetName())
+
+	// the broken piece fails to verify and is kept in the source storage
+	job, err = m.Start(ctx, &mtypes.PieceMigrationParams{Source: "src", Target: "dst", VerifyCommP: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, job.Total)
+	job = waitMigration(t, m, job.ID)
+	assert.Equal(t, mtypes.PieceMigrationCompleted, job.State)
+	assert.Equal(t, 1, job.Migrated)
+	require.Len(t, job.Failures, 1)
+	assert.Equal(t, id(2), job.Failures[0].ResourceID)
+	has, err := dst.Has(ctx, id(2))
+	require.NoError(t, err)
+	assert.False(t, has)
+	has, err = src.Has(ctx, id(2))
+	require.NoError(t, err)
+	assert.True(t, has)
+	_, ok = psm.locations.Get(id(2))
+	assert.False(t, ok)
+	// the source storage is kept in the location of piece which isn't deleted from it
+	loc, ok = psm.locations.Get(id(3))
+	require.True(t, ok)
+	assert.Equal(t, []string{"dst", "src"}, loc.storages)
+
+	// the jobs are loaded after restart
+	reloaded, err := newPieceMigrator(psm, r.StorageDealRepo(), ds)
+	require.NoError(t, err)
+	jobs := reloaded.List()
+	require.Len(t, jobs, 2)
+	assert.Equal(t, job.ID, jobs[0].ID)
+	assert.Equal(t, minerA, jobs[1].Params.Miner)
+	assert.True(t, jobs[0].Params.Miner.Empty())
+	assert.Error(t, reloaded.Resume(ctx, jobs[0].ID))
+}
+
+func TestPieceMigrationCancelAndResume(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+	ds, err := badger.NewDatastore("")
+	require.NoError(t, err)
+
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	src, dst := NewMemPieceStore("src", nil), NewMemPieceStore("dst", nil)
+	psm.AddMemPieceStorage(src)
+	psm.AddMemPieceStorage(dst)
+	for i := 0; i < 3; i++ {
+		data, pieceCid, _ := randPiece(t)
+		_, err := src.SaveTo(ctx, pieceCid.String(), bytes.NewReader(data))
+		require.NoError(t, err)
+	}
+
+	m, err := newPieceMigrator(psm, r.StorageDealRepo(), ds)
+	require.NoError(t, err)
+	defer m.Close()
+
+	// copy a piece per second
+	job, err := m.Start(ctx, &mtypes.PieceMigrationParams{Source: "src", Target: "dst", BytesPerSecond: 2000})
+	require.NoError(t, err)
+	_, err = m.Start(ctx, &mtypes.PieceMigrationParams{Source: "src", Target: "dst"})
+	assert.Error(t, err)
+
+	require.NoError(t, m.Cancel(job.ID))
+	job = waitMigration(t, m, job.ID)
+	assert.Equal(t, mtypes.PieceMigrationCanceled, job.State)
+	assert.Less(t, job.Processed, 3)
+
+	require.NoError(t, m.Resume(ctx, job.ID))
+	job = waitMigration(t, m, job.ID)
+	assert.Equal(t, mtypes.PieceMigrationCompleted, job.State)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 3, job.Migrated+job.AlreadyInTarget)
+
+	ids, err := dst.ListResourceIds(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ids, 3)
+}
@@ -1,9 +1,14 @@
 package piecestorage
 
 import (
+	gatewayAPIV2 "github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
+	"github.com/ipfs-force-community/metrics"
 	"github.com/ipfs-force-community/venus-common-utils/builder"
+	"go.uber.org/fx"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
 )
 
 var SetupPieceStorageMetricsKey = builder.NextInvoke()
@@ -14,5 +19,18 @@ var PieceStorageOpts = func(cfg *config.PieceStorage) builder.Option {
 		builder.Override(new(*PieceStorageManager), func() (*PieceStorageManager, error) {
 			return NewPieceStorageManager(cfg)
 		}),
+		builder.Override(new(*PieceGC), NewPieceGC),
+		builder.Override(new(*PieceMigrator), NewPieceMigrator),
+		builder.Override(new(*PieceUploader), NewPieceUploader),
+		builder.Override(new(*PieceScrubber), func(
+			mCtx metrics.MetricsCtx,
+			lc fx.Lifecycle,
+			mgr *PieceStorageManager,
+			r repo.Repo,
+			ds badger.PieceScrubDS,
+			gatewayMarketClient gatewayAPIV2.IMarketClient,
+		) (*PieceScrubber, error) {
+			return NewPieceScrubber(mCtx, lc, &cfg.Scrub, mgr, r, ds, gatewayMarketClient)
+		}),
 	)
 }
@@ -0,0 +1,280 @@
+package piecestorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var (
+	// a storage is marked unhealthy after the number of consecutive errors
+	unhealthyThreshold = 3
+	// an unhealthy storage is probed again after the interval, and becomes healthy if the probe succeeds
+	unhealthyRecheckInterval = time.Minute
+	// the locations of pieces are cached for the duration
+	locationCacheTTL = 10 * time.Minute
+	hasProbeTimeout  = 30 * time.Second
+)
+
+const (
+	locationCacheSize = 100000
+	// the weight of the latest sample in the moving average of latency
+	latencyEWMAWeight = 0.2
+)
+
+type storageHealth struct {
+	consecutiveErrors int
+	lastError         string
+	lastErrorAt       time.Time
+	// zero if the storage is healthy
+	unhealthySince time.Time
+	lastProbe      time.Time
+	// the moving average of the latency of Has probes
+	latency time.Duration
+}
+
+type pieceLocation struct {
+	storages []string
+	expireAt time.Time
+}
+
+// FindStorageForRead returns the storage to read the piece, it prefers the storages with higher read priority,
+// then the ones with lower latency, unhealthy storages are skipped
+func (p *PieceStorageManager) FindStorageForRead(ctx context.Context, s string) (IPieceStorage, error) {
+	if loc, ok := p.locations.Get(s); ok && time.Now().Before(loc.expireAt) {
+		if st := p.bestStorageForRead(loc.storages); st != nil {
+			return st, nil
+		}
+	}
+
+	var storages []IPieceStorage
+	_ = p.EachPieceStorage(func(st IPieceStorage) error {
+		if p.shouldProbe(st.GetName()) {
+			storages = append(storages, st)
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	hits := make([]bool, len(storages))
+	for i, st := range storages {
+		wg.Add(1)
+		go func(i int, st IPieceStorage) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, hasProbeTimeout)
+			defer cancel()
+			start := time.Now()
+			has, err := st.Has(probeCtx, s)
+			if err != nil && ctx.Err() != nil {
+				// canceled by caller, not a failure of storage
+				return
+			}
+			p.recordProbe(st.GetName(), time.Since(start), err)
+			if err != nil {
+				log.Warnf("got error while check avaibale in storage %s: %s", st.GetName(), err.Error())
+				return
+			}
+			hits[i] = has
+		}(i, st)
+	}
+	wg.Wait()
+
+	var found []string
+	for i, st := range storages {
+		if hits[i] && !p.isQuarantined(st.GetName(), s) {
+			found = append(found, st.GetName())
+		}
+	}
+	if len(found) > 0 {
+		p.locations.Add(s, pieceLocation{storages: found, expireAt: time.Now().Add(locationCacheTTL)})
+	}
+	if st := p.bestStorageForRead(found); st != nil {
+		return st, nil
+	}
+	return nil, fmt.Errorf("unable to find piece %s: %w", s, ErrorNotFoundForRead)
+}
+
+// invalidateLocation removes the cached location of the resource, it's called when the resource is deleted
+// or reading it from the cached storage fails
+func (p *PieceStorageManager) invalidateLocation(resourceId string) {
+	p.locations.Remove(resourceId)
+}
+
+// moveLocation updates the cached location of the resource after it's copied from one storage to another,
+// the source storage is dropped from the location if the resource has been deleted from it
+func (p *PieceStorageManager) moveLocation(resourceId, from, to string, deleted bool) {
+	storages := []string{to}
+	if !deleted {
+		storages = append(storages, from)
+	}
+	if loc, ok := p.locations.Get(resourceId); ok && time.Now().Before(loc.expireAt) {
+		for _, name := range loc.storages {
+			if name != from && name != to {
+				storages = append(storages, name)
+			}
+		}
+	}
+
+	found := storages[:0]
+	for _, name := range storages {
+		if !p.isQuarantined(name, resourceId) {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 0 {
+		p.invalidateLocation(resourceId)
+		return
+	}
+	p.locations.Add(resourceId, pieceLocation{storages: found, expireAt: time.Now().Add(locationCacheTTL)})
+}
+
+// quarantine stops reading the resource from the storage, the copy of resource in the storage is corrupt
+func (p *PieceStorageManager) quarantine(storage, resourceId string) {
+	p.healthLk.Lock()
+	storages, ok := p.quarantined[resourceId]
+	if !ok {
+		storages = make(map[string]struct{})
+		p.quarantined[resourceId] = storages
+	}
+	storages[storage] = struct{}{}
+	p.healthLk.Unlock()
+
+	p.invalidateLocation(resourceId)
+}
+
+func (p *PieceStorageManager) unquarantine(storage, resourceId string) {
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+
+	delete(p.quarantined[resourceId], storage)
+	if len(p.quarantined[resourceId]) == 0 {
+		delete(p.quarantined, resourceId)
+	}
+}
+
+func (p *PieceStorageManager) isQuarantined(storage, resourceId string) bool {
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+
+	_, ok := p.quarantined[resourceId][storage]
+	return ok
+}
+
+func (p *PieceStorageManager) bestStorageForRead(names []string) IPieceStorage {
+	p.lk.RLock()
+	var storages []IPieceStorage
+	for _, name := range names {
+		if st, ok := p.storages[name]; ok {
+			storages = append(storages, st)
+		}
+	}
+	p.lk.RUnlock()
+
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+
+	var candidates []IPieceStorage
+	for _, st := range storages {
+		if h, ok := p.health[st.GetName()]; !ok || h.unhealthySince.IsZero() {
+			candidates = append(candidates, st)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := storageReadPriority(candidates[i]), storageReadPriority(candidates[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return p.latencyLocked(candidates[i].GetName()) < p.latencyLocked(candidates[j].GetName())
+	})
+	return candidates[0]
+}
+
+func (p *PieceStorageManager) latencyLocked(name string) time.Duration {
+	if h, ok := p.health[name]; ok {
+		return h.latency
+	}
+	return 0
+}
+
+// shouldProbe returns false if the storage is unhealthy and it's not the time to recheck
+func (p *PieceStorageManager) shouldProbe(name string) bool {
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+
+	h, ok := p.health[name]
+	return !ok || h.unhealthySince.IsZero() || time.Since(h.lastProbe) > unhealthyRecheckInterval
+}
+
+func (p *PieceStorageManager) recordProbe(name string, latency time.Duration, err error) {
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+
+	h, ok := p.health[name]
+	if !ok {
+		h = &storageHealth{latency: latency}
+		p.health[name] = h
+	}
+	h.lastProbe = time.Now()
+
+	if err != nil {
+		h.consecutiveErrors++
+		h.lastError = err.Error()
+		h.lastErrorAt = h.lastProbe
+		if h.consecutiveErrors >= unhealthyThreshold && h.unhealthySince.IsZero() {
+			h.unhealthySince = h.lastProbe
+			log.Warnf("piece storage %s is unhealthy after %d errors: %v", name, h.consecutiveErrors, err)
+		}
+		return
+	}
+
+	if !h.unhealthySince.IsZero() {
+		log.Infof("piece storage %s recovered", name)
+	}
+	h.consecutiveErrors = 0
+	h.unhealthySince = time.Time{}
+	h.latency = time.Duration(latencyEWMAWeight*float64(latency) + (1-latencyEWMAWeight)*float64(h.latency))
+}
+
+// ListStorageHealth returns the read health state of storages
+func (p *PieceStorageManager) ListStorageHealth() []*mtypes.PieceStorageHealth {
+	var out []*mtypes.PieceStorageHealth
+	_ = p.EachPieceStorage(func(st IPieceStorage) error {
+		out = append(out, &mtypes.PieceStorageHealth{
+			Name:         st.GetName(),
+			Type:         string(st.Type()),
+			ReadOnly:     unwrapStorage(st).ReadOnly(),
+			Draining:     st.(*managedStorage).isDraining(),
+			InFlight:     st.(*managedStorage).inFlight(),
+			Healthy:      true,
+			ReadPriority: storageReadPriority(st),
+		})
+		return nil
+	})
+
+	p.healthLk.Lock()
+	defer p.healthLk.Unlock()
+	for _, sh := range out {
+		h, ok := p.health[sh.Name]
+		if !ok {
+			continue
+		}
+		sh.Healthy = h.unhealthySince.IsZero()
+		sh.UnhealthySince = h.unhealthySince
+		sh.ConsecutiveErrors = h.consecutiveErrors
+		sh.LastError = h.lastError
+		sh.LastErrorAt = h.lastErrorAt
+		sh.Latency = h.latency
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
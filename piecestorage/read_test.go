@@ -0,0 +1,133 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+)
+
+type flakyPieceStore struct {
+	*MemPieceStore
+	fail     atomic.Bool
+	failRead atomic.Bool
+	calls    atomic.Int32
+}
+
+func (f *flakyPieceStore) GetReaderCloser(ctx context.Context, resourceId string) (io.ReadCloser, error) {
+	if f.failRead.Load() {
+		return nil, errors.New("read failed")
+	}
+	return f.MemPieceStore.GetReaderCloser(ctx, resourceId)
+}
+
+func (f *flakyPieceStore) Has(ctx context.Context, resourceId string) (bool, error) {
+	f.calls.Add(1)
+	if f.fail.Load() {
+		return false, errors.New("storage is down")
+	}
+	return f.MemPieceStore.Has(ctx, resourceId)
+}
+
+func TestFindStorageForRead(t *testing.T) {
+	ctx := context.Background()
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+
+	low := NewMemPieceStore("low", nil)
+	low.ReadPriority = -1
+	high := &flakyPieceStore{MemPieceStore: NewMemPieceStore("high", nil)}
+	psm.AddMemPieceStorage(low)
+	psm.AddMemPieceStorage(high)
+
+	save := func(id string) {
+		for _, st := range []IPieceStorage{low, high} {
+			_, err := st.SaveTo(ctx, id, bytes.NewReader([]byte("piece data")))
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("read priority", func(t *testing.T) {
+		save("piece")
+		st, err := psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		assert.Equal(t, "high", st.GetName())
+
+		_, err = psm.FindStorageForRead(ctx, "not-exist")
+		assert.ErrorIs(t, err, ErrorNotFoundForRead)
+	})
+
+	t.Run("location cache", func(t *testing.T) {
+		calls := high.calls.Load()
+		st, err := psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		assert.Equal(t, "high", st.GetName())
+		assert.Equal(t, calls, high.calls.Load())
+
+		psm.invalidateLocation("piece")
+		_, err = psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		assert.Equal(t, calls+1, high.calls.Load())
+	})
+
+	t.Run("invalidate location on read error", func(t *testing.T) {
+		st, err := psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		calls := high.calls.Load()
+
+		high.failRead.Store(true)
+		_, err = st.GetReaderCloser(ctx, "piece")
+		assert.Error(t, err)
+		high.failRead.Store(false)
+
+		// the piece is looked up again after the failed read
+		_, err = psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		assert.Equal(t, calls+1, high.calls.Load())
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		recheck := unhealthyRecheckInterval
+		defer func() { unhealthyRecheckInterval = recheck }()
+		unhealthyRecheckInterval = 100 * time.Millisecond
+
+		high.fail.Store(true)
+		for i := 0; i < unhealthyThreshold; i++ {
+			id := fmt.Sprintf("unhealthy-%d", i)
+			save(id)
+			st, err := psm.FindStorageForRead(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, "low", st.GetName())
+		}
+		healths := psm.ListStorageHealth()
+		require.Len(t, healths, 2)
+		assert.Equal(t, "high", healths[0].Name)
+		assert.False(t, healths[0].Healthy)
+		assert.Equal(t, unhealthyThreshold, healths[0].ConsecutiveErrors)
+		assert.True(t, healths[1].Healthy)
+
+		// the cached location of unhealthy storage is skipped, and the storage isn't probed
+		calls := high.calls.Load()
+		st, err := psm.FindStorageForRead(ctx, "piece")
+		require.NoError(t, err)
+		assert.Equal(t, "low", st.GetName())
+		assert.Equal(t, calls, high.calls.Load())
+
+		high.fail.Store(false)
+		time.Sleep(unhealthyRecheckInterval * 2)
+		save("recovered")
+		st, err = psm.FindStorageForRead(ctx, "recovered")
+		require.NoError(t, err)
+		assert.Equal(t, "high", st.GetName())
+		assert.True(t, psm.ListStorageHealth()[0].Healthy)
+	})
+}
@@ -0,0 +1,327 @@
+package piecestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/dagstore/mount"
+	"github.com/filecoin-project/venus/venus-shared/api"
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+)
+
+var errRemoteNotFound = errors.New("resource not found in remote droplet")
+
+// remotePieceStorage reads and writes the pieces of another droplet through its /resource endpoint
+type remotePieceStorage struct {
+	cfg      *config.RemotePieceStorage
+	apiInfo  api.APIInfo
+	endpoint string
+	client   *http.Client
+}
+
+func NewRemotePieceStorage(cfg *config.RemotePieceStorage) (IPieceStorage, error) {
+	endpoint, err := parseRemoteEndpoint(cfg.Url)
+	if err != nil {
+		return nil, fmt.Errorf("parse url of remote piece storage %s: %w", cfg.Name, err)
+	}
+	return &remotePieceStorage{
+		cfg:      cfg,
+		apiInfo:  api.NewAPIInfo(cfg.Url, cfg.Token),
+		endpoint: endpoint,
+		client:   http.DefaultClient,
+	}, nil
+}
+
+// parseRemoteEndpoint returns the url of /resource of the droplet at the address, which is a url or a multiaddr
+func parseRemoteEndpoint(addr string) (string, error) {
+	if _, err := multiaddr.NewMultiaddr(addr); err == nil {
+		host, err := api.NewAPIInfo(addr, "").Host()
+		if err != nil {
+			return "", err
+		}
+		return "http://" + host + "/resource", nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return u.Scheme + "://" + u.Host + "/resource", nil
+}
+
+func (r *remotePieceStorage) resourceURL(resourceId string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("resource-id", resourceId)
+	return r.endpoint + "?" + params.Encode()
+}
+
+func (r *remotePieceStorage) do(ctx context.Context, method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.apiInfo.SetAuthHeader(req.Header)
+	return r.client.Do(req)
+}
+
+// responseError consumes and closes the body of a failed response
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusNotFound {
+		return errRemoteNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("remote droplet responds %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+func (r *remotePieceStorage) head(ctx context.Context, resourceId string) (int64, error) {
+	resp, err := r.do(ctx, http.MethodHead, r.resourceURL(resourceId, nil), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError(resp)
+	}
+	_ = resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("remote droplet responds without content length")
+	}
+	return resp.ContentLength, nil
+}
+
+func (r *remotePieceStorage) Type() Protocol {
+	return Remote
+}
+
+func (r *remotePieceStorage) ReadOnly() bool {
+	return r.cfg.ReadOnly
+}
+
+func (r *remotePieceStorage) GetName() string {
+	return r.cfg.Name
+}
+
+func (r *remotePieceStorage) SaveTo(ctx context.Context, resourceId string, reader io.Reader) (int64, error) {
+	if r.cfg.ReadOnly {
+		return 0, fmt.Errorf("do not write to a 'readonly' piece store")
+	}
+
+	params := url.Values{}
+	if len(r.cfg.Store) > 0 {
+		params.Set("store", r.cfg.Store)
+	} else {
+		// the size is used to select a storage with enough space in the remote droplet
+		var size int64
+		if l, ok := reader.(interface{ Len() int }); ok {
+			size = int64(l.Len())
+		}
+		params.Set("size", strconv.FormatInt(size, 10))
+	}
+
+	counter := &countingReader{r: reader}
+	resp, err := r.do(ctx, http.MethodPut, r.resourceURL(resourceId, params), counter, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError(resp)
+	}
+	_ = resp.Body.Close()
+	return counter.n, nil
+}
+
+func (r *remotePieceStorage) Len(ctx context.Context, resourceId string) (int64, error) {
+	return r.head(ctx, resourceId)
+}
+
+func (r *remotePieceStorage) ListResourceIds(_ context.Context) ([]string, error) {
+	return nil, fmt.Errorf("list resources of remote piece storage %s is not supported", r.cfg.Name)
+}
+
+func (r *remotePieceStorage) GetReaderCloser(ctx context.Context, resourceId string) (io.ReadCloser, error) {
+	resp, err := r.do(ctx, http.MethodGet, r.resourceURL(resourceId, nil), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+func (r *remotePieceStorage) GetMountReader(ctx context.Context, resourceId string) (mount.Reader, error) {
+	size, err := r.head(ctx, resourceId)
+	if err != nil {
+		return nil, err
+	}
+	// the reader is used after the request to mount is finished
+	return &remoteReader{ctx: context.Background(), storage: r, resourceId: resourceId, size: size}, nil
+}
+
+func (r *remotePieceStorage) GetRedirectUrl(context.Context, string) (string, error) {
+	return "", ErrUnsupportRedirect
+}
+
+func (r *remotePieceStorage) Has(ctx context.Context, resourceId string) (bool, error) {
+	_, err := r.head(ctx, resourceId)
+	if errors.Is(err, errRemoteNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *remotePieceStorage) Delete(context.Context, string) error {
+	return fmt.Errorf("delete from remote piece storage %s is not supported", r.cfg.Name)
+}
+
+// Validate checks the remote droplet is reachable and the token is accepted
+func (r *remotePieceStorage) Validate(string) error {
+	_, err := r.Has(context.Background(), "validate")
+	return err
+}
+
+func (r *remotePieceStorage) GetStorageStatus() (market.StorageStatus, error) {
+	// the space is managed by the remote droplet
+	return market.StorageStatus{
+		Capacity:  0,
+		Available: math.MaxInt64,
+	}, nil
+}
+
+// GetPieceTransfer returns the address of this droplet, the piece is uploaded to the remote droplet through it
+func (r *remotePieceStorage) GetPieceTransfer(_ context.Context, pieceCid string) (string, error) {
+	if r.cfg.ReadOnly {
+		return "", fmt.Errorf("%s is readonly piece store", r.cfg.Name)
+	}
+	return fmt.Sprintf("market://%s/%s", r.cfg.Name, pieceCid), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var _ mount.Reader = (*remoteReader)(nil)
+
+// remoteReader reads a resource of remote droplet by range requests, sequential reads share a request
+type remoteReader struct {
+	ctx        context.Context
+	storage    *remotePieceStorage
+	resourceId string
+	size       int64
+	offset     int64
+
+	// the body of the ongoing sequential read, it starts at bodyOffset
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (rr *remoteReader) rangeGet(off, end int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if end >= 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+	}
+	resp, err := rr.storage.do(rr.ctx, http.MethodGet, rr.storage.resourceURL(rr.resourceId, nil), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, responseError(resp)
+	}
+	return resp.Body, nil
+}
+
+func (rr *remoteReader) Read(p []byte) (int, error) {
+	if rr.offset >= rr.size {
+		return 0, io.EOF
+	}
+	if rr.body == nil || rr.bodyOffset != rr.offset {
+		if rr.body != nil {
+			_ = rr.body.Close()
+		}
+		body, err := rr.rangeGet(rr.offset, -1)
+		if err != nil {
+			rr.body = nil
+			return 0, err
+		}
+		rr.body, rr.bodyOffset = body, rr.offset
+	}
+	n, err := rr.body.Read(p)
+	rr.offset += int64(n)
+	rr.bodyOffset += int64(n)
+	if errors.Is(err, io.EOF) && rr.offset < rr.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (rr *remoteReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= rr.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > rr.size {
+		end = rr.size
+	}
+	body, err := rr.rangeGet(off, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close() // nolint
+
+	n, err := io.ReadFull(body, p[:end-off])
+	if err == nil && end-off < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (rr *remoteReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rr.offset
+	case io.SeekEnd:
+		offset += rr.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	rr.offset = offset
+	return offset, nil
+}
+
+func (rr *remoteReader) Close() error {
+	if rr.body != nil {
+		return rr.body.Close()
+	}
+	return nil
+}
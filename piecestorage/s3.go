@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math"
 	"net/url"
 	"strings"
 	"time"
@@ -17,7 +16,6 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -25,6 +23,7 @@ import (
 	"github.com/filecoin-project/venus/venus-shared/types/market"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 	"github.com/ipfs-force-community/droplet/v2/utils"
 )
 
@@ -76,6 +75,7 @@ type s3PieceStorage struct {
 	uploader      *s3manager.Uploader
 	s3Cfg         *config.S3PieceStorage
 	subdirWrapper subdirWrapper
+	usage         *s3Usage
 }
 
 func NewS3PieceStorage(s3Cfg *config.S3PieceStorage) (IPieceStorage, error) {
@@ -83,8 +83,12 @@ func NewS3PieceStorage(s3Cfg *config.S3PieceStorage) (IPieceStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+	creds, err := newS3Credentials(s3Cfg)
+	if err != nil {
+		return nil, err
+	}
 	sess := session.Must(session.NewSession(&aws.Config{
-		Credentials:      credentials.NewStaticCredentials(s3Cfg.AccessKey, s3Cfg.SecretKey, s3Cfg.Token),
+		Credentials:      creds,
 		Endpoint:         aws.String(endpoint),
 		S3ForcePathStyle: aws.Bool(false),
 		Region:           aws.String(region),
@@ -104,14 +108,56 @@ func NewS3PieceStorage(s3Cfg *config.S3PieceStorage) (IPieceStorage, error) {
 	// t := s3.New(sess)
 	// t.GetObjectRequest(&s3.GetObjectInput{})
 
-	return &s3PieceStorage{
+	st := &s3PieceStorage{
 		s3Cfg:         s3Cfg,
 		bucket:        s3Cfg.Bucket,
 		subdir:        s3Cfg.SubDir,
 		s3Client:      s3.New(sess),
 		uploader:      uploader,
 		subdirWrapper: wrapper,
-	}, nil
+		usage:         newS3Usage(s3Cfg.Quota),
+	}
+	st.refreshUsage()
+	return st, nil
+}
+
+// refreshUsage lists the bucket in background if the last listing is stale
+func (s *s3PieceStorage) refreshUsage() {
+	if !s.usage.startListing(time.Now()) {
+		return
+	}
+	go func() {
+		var used, objects int64
+		err := s.listObjects(func(obj *s3.Object) {
+			used += *obj.Size
+			objects++
+		})
+		if err != nil {
+			log.Warnf("list s3 piece storage %s for usage: %v", s.s3Cfg.Name, err)
+		}
+		s.usage.finishListing(used, objects, err, time.Now())
+	}()
+}
+
+// listObjects calls fn with all the pieces under subdir of the bucket
+func (s *s3PieceStorage) listObjects(fn func(obj *s3.Object)) error {
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}
+
+	if s.subdir != "" {
+		params.Prefix = aws.String(s.subdir)
+	}
+
+	return s.s3Client.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			name := *obj.Key
+			if name[len(name)-1] != '/' && obj.Size != nil && *obj.Size != 0 {
+				fn(obj)
+			}
+		}
+		return true
+	})
 }
 
 func (s *s3PieceStorage) SaveTo(_ context.Context, resourceId string, r io.Reader) (int64, error) {
@@ -129,6 +175,8 @@ func (s *s3PieceStorage) SaveTo(_ context.Context, resourceId string, r io.Reade
 		return 0, err
 	}
 	log.Infof("update file to s3 piece storage, upload id %s", resp.UploadID)
+	// overwriting an object is counted twice until the next listing
+	s.usage.add(int64(countReader.Count()), 1)
 	return int64(countReader.Count()), nil
 }
 
@@ -146,25 +194,13 @@ func (s *s3PieceStorage) Len(_ context.Context, pieceCid string) (int64, error)
 }
 
 func (s *s3PieceStorage) ListResourceIds(_ context.Context) ([]string, error) {
-	params := &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-	}
-
-	if s.subdir != "" {
-		params.Prefix = aws.String(s.subdir)
-	}
-
-	result, err := s.s3Client.ListObjectsV2(params)
+	var pieces []string
+	err := s.listObjects(func(obj *s3.Object) {
+		pieces = append(pieces, strings.TrimPrefix(*obj.Key, s.subdir))
+	})
 	if err != nil {
 		return nil, err
 	}
-	var pieces []string
-	for _, obj := range result.Contents {
-		name := *obj.Key
-		if name[len(name)-1] != '/' && obj.Size != nil && *obj.Size != 0 {
-			pieces = append(pieces, strings.TrimPrefix(name, s.subdir))
-		}
-	}
 	return pieces, nil
 }
 
@@ -215,6 +251,135 @@ func (s *s3PieceStorage) GetPutObjectUrl(ctx context.Context, resourceId string)
 	return req.Presign(time.Hour * 24)
 }
 
+func (s *s3PieceStorage) createMultipartUpload(ctx context.Context, resourceId string) (string, error) {
+	if s.s3Cfg.ReadOnly {
+		return "", fmt.Errorf("do not write to a 'readonly' piece store")
+	}
+	out, err := s.s3Client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.subdirWrapper(resourceId)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *out.UploadId, nil
+}
+
+func (s *s3PieceStorage) presignUploadPart(resourceId, uploadId string, number int64) (string, error) {
+	req, _ := s.s3Client.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.subdirWrapper(resourceId)),
+		UploadId:   aws.String(uploadId),
+		PartNumber: aws.Int64(number),
+	})
+	return req.Presign(time.Hour * 24)
+}
+
+func (s *s3PieceStorage) completeMultipartUpload(ctx context.Context, resourceId, uploadId string, parts []*mtypes.PieceUploadPart) error {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	var size int64
+	for _, part := range parts {
+		completed = append(completed, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+		size += part.Size
+	}
+	_, err := s.s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.subdirWrapper(resourceId)),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return err
+	}
+	s.usage.add(size, 1)
+	return nil
+}
+
+func (s *s3PieceStorage) abortMultipartUpload(ctx context.Context, resourceId, uploadId string) error {
+	_, err := s.s3Client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(s.subdirWrapper(resourceId)),
+		UploadId: aws.String(uploadId),
+	})
+	return err
+}
+
+// the maximum size of object copied by a single CopyObject request, and the size of parts to copy larger objects
+const (
+	maxCopyObjectSize = 5 << 30
+	copyPartSize      = 1 << 30
+)
+
+// moveObject copies the object to the new key and deletes the old one
+func (s *s3PieceStorage) moveObject(ctx context.Context, from, to string) error {
+	if s.s3Cfg.ReadOnly {
+		return fmt.Errorf("do not write to a 'readonly' piece store")
+	}
+	size, err := s.Len(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	src := (&url.URL{Path: s.bucket + "/" + s.subdirWrapper(from)}).EscapedPath()
+	if size <= maxCopyObjectSize {
+		_, err = s.s3Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(s.subdirWrapper(to)),
+			CopySource: aws.String(src),
+		})
+	} else {
+		err = s.copyObjectByParts(ctx, src, to, size)
+	}
+	if err != nil {
+		return fmt.Errorf("copy object %s to %s: %w", from, to, err)
+	}
+	s.usage.add(size, 1)
+
+	return s.Delete(ctx, from)
+}
+
+func (s *s3PieceStorage) copyObjectByParts(ctx context.Context, src, to string, size int64) error {
+	uploadID, err := s.createMultipartUpload(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	var completed []*s3.CompletedPart
+	for number, offset := int64(1), int64(0); offset < size; number, offset = number+1, offset+copyPartSize {
+		end := offset + copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		out, err := s.s3Client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(s.subdirWrapper(to)),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      aws.Int64(number),
+			CopySource:      aws.String(src),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			_ = s.abortMultipartUpload(ctx, to, uploadID)
+			return fmt.Errorf("copy part %d: %w", number, err)
+		}
+		completed = append(completed, &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(number)})
+	}
+
+	_, err = s.s3Client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.subdirWrapper(to)),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		_ = s.abortMultipartUpload(ctx, to, uploadID)
+	}
+	return err
+}
+
 func (s *s3PieceStorage) GetPieceTransfer(ctx context.Context, pieceCid string) (string, error) {
 	if s.s3Cfg.ReadOnly {
 		return "", fmt.Errorf("%s is readonly piece store", s.s3Cfg.Name)
@@ -229,12 +394,20 @@ func (s *s3PieceStorage) GetPieceTransfer(ctx context.Context, pieceCid string)
 }
 
 func (s *s3PieceStorage) GetStorageStatus() (market.StorageStatus, error) {
+	s.refreshUsage()
+	// The available space for the object storage type is treated as unlimited without quota
 	return market.StorageStatus{
-		Capacity:  0,
-		Available: math.MaxInt64, // The available space for the object storage type is treated as unlimited
+		Capacity:  s.s3Cfg.Quota,
+		Available: s.usage.available(),
 	}, nil
 }
 
+// Usage returns the space used by the storage
+func (s *s3PieceStorage) Usage() *mtypes.PieceStorageUsage {
+	s.refreshUsage()
+	return s.usage.usage(s.s3Cfg.Name)
+}
+
 func (s *s3PieceStorage) Has(_ context.Context, piececid string) (bool, error) {
 	params := &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -256,6 +429,36 @@ func (s *s3PieceStorage) Has(_ context.Context, piececid string) (bool, error) {
 	return true, nil
 }
 
+func (s *s3PieceStorage) Delete(_ context.Context, resourceId string) error {
+	if s.s3Cfg.ReadOnly {
+		return fmt.Errorf("do not delete from a 'readonly' piece store")
+	}
+
+	// the size is used to update the usage, deleting a non-existent object succeeds in s3
+	head, err := s.s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.subdirWrapper(resourceId)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return nil
+		}
+		return err
+	}
+
+	_, err = s.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.subdirWrapper(resourceId)),
+	})
+	if err != nil {
+		return err
+	}
+	if head.ContentLength != nil {
+		s.usage.add(-*head.ContentLength, -1)
+	}
+	return nil
+}
+
 func (s *s3PieceStorage) Validate(_ string) error {
 	_, err := s.s3Client.GetBucketAcl(&s3.GetBucketAclInput{
 		Bucket: aws.String(s.bucket),
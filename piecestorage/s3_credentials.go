@@ -0,0 +1,107 @@
+package piecestorage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+)
+
+// s3CredentialsRefreshInterval is the interval to read the referenced credentials again,
+// so that the rotated credentials are used without restart
+var s3CredentialsRefreshInterval = time.Minute
+
+const redacted = "xxxxx"
+
+// resolveSecret returns the value of environment variable for `env:NAME`, the content of file for `file:/path`,
+// other values are returned as is
+func resolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, config.SecretEnvPrefix):
+		name := strings.TrimPrefix(ref, config.SecretEnvPrefix)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return val, nil
+	case strings.HasPrefix(ref, config.SecretFilePrefix):
+		path := strings.TrimPrefix(ref, config.SecretFilePrefix)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read secret file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return ref, nil
+	}
+}
+
+// referenceProvider provides the credentials configured in S3PieceStorage, the values referenced by
+// environment variables or files are read again after s3CredentialsRefreshInterval
+type referenceProvider struct {
+	credentials.Expiry
+
+	accessKey string
+	secretKey string
+	token     string
+}
+
+var _ credentials.Provider = (*referenceProvider)(nil)
+
+func (p *referenceProvider) Retrieve() (credentials.Value, error) {
+	var vals [3]string
+	for i, ref := range []string{p.accessKey, p.secretKey, p.token} {
+		val, err := resolveSecret(ref)
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		vals[i] = val
+	}
+	if vals[0] == "" || vals[1] == "" {
+		return credentials.Value{}, fmt.Errorf("access key or secret key is empty")
+	}
+	p.SetExpiration(time.Now().Add(s3CredentialsRefreshInterval), 0)
+	return credentials.Value{
+		AccessKeyID:     vals[0],
+		SecretAccessKey: vals[1],
+		SessionToken:    vals[2],
+		ProviderName:    "droplet",
+	}, nil
+}
+
+// newS3Credentials returns nil if neither access key nor secret key is configured, then the default
+// credential chain of aws is used, which reads the environment variables, the shared credentials file
+// and the role of instance
+func newS3Credentials(cfg *config.S3PieceStorage) (*credentials.Credentials, error) {
+	if cfg.AccessKey == "" && cfg.SecretKey == "" {
+		return nil, nil
+	}
+	if cfg.HasPlainSecrets() {
+		log.Warnf("the credentials of s3 piece storage %s are written in config, use references like env:NAME or file:/path instead", cfg.Name)
+	}
+	creds := credentials.NewCredentials(&referenceProvider{
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		token:     cfg.Token,
+	})
+	// fail early if the references are broken
+	if _, err := creds.Get(); err != nil {
+		return nil, fmt.Errorf("get credentials of s3 piece storage %s: %w", cfg.Name, err)
+	}
+	return creds, nil
+}
+
+// redactURL hides the user info in url, which may contain credentials
+func redactURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.User == nil {
+		return endpoint
+	}
+	u.User = url.User(redacted)
+	return u.String()
+}
@@ -0,0 +1,110 @@
+package piecestorage
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// s3UsageRefreshInterval is the interval to list the bucket, which corrects the usage tracked by writes and deletes
+var s3UsageRefreshInterval = time.Hour
+
+// s3Usage tracks the space used by an s3 piece storage, the usage is set by listing the bucket periodically,
+// and updated by writes and deletes between the listings
+type s3Usage struct {
+	lk      sync.Mutex
+	quota   int64
+	used    int64
+	objects int64
+
+	listedAt  time.Time
+	listing   bool
+	lastError string
+	// the changes made during the ongoing listing, they may not be seen by the listing
+	pendingUsed    int64
+	pendingObjects int64
+}
+
+func newS3Usage(quota int64) *s3Usage {
+	return &s3Usage{quota: quota}
+}
+
+func (u *s3Usage) add(size, objects int64) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	u.used += size
+	u.objects += objects
+	if u.listing {
+		u.pendingUsed += size
+		u.pendingObjects += objects
+	}
+}
+
+// startListing returns false if a listing is ongoing or the last listing is recent
+func (u *s3Usage) startListing(now time.Time) bool {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	if u.listing || (!u.listedAt.IsZero() && now.Sub(u.listedAt) < s3UsageRefreshInterval) {
+		return false
+	}
+	u.listing = true
+	u.pendingUsed, u.pendingObjects = 0, 0
+	return true
+}
+
+// finishListing sets the usage to the result of listing, the changes made during the listing are kept,
+// a change seen by the listing is counted twice until the next listing, which overestimates the usage
+func (u *s3Usage) finishListing(used, objects int64, err error, now time.Time) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	u.listing = false
+	// retry after the interval too if failed, to not list a broken bucket on every write
+	u.listedAt = now
+	if err != nil {
+		u.lastError = err.Error()
+		return
+	}
+	u.lastError = ""
+	u.used = used + u.pendingUsed
+	u.objects = objects + u.pendingObjects
+	if u.used < 0 {
+		u.used = 0
+	}
+	if u.objects < 0 {
+		u.objects = 0
+	}
+}
+
+// available returns the space left in quota, it's unlimited without quota
+func (u *s3Usage) available() int64 {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	if u.quota <= 0 {
+		return math.MaxInt64
+	}
+	if u.used >= u.quota {
+		return 0
+	}
+	return u.quota - u.used
+}
+
+func (u *s3Usage) usage(name string) *mtypes.PieceStorageUsage {
+	available := u.available()
+
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	out := &mtypes.PieceStorageUsage{
+		Name:      name,
+		Quota:     u.quota,
+		Used:      u.used,
+		Objects:   u.objects,
+		ListedAt:  u.listedAt,
+		LastError: u.lastError,
+	}
+	if u.quota > 0 {
+		out.Available = available
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package piecestorage
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Usage(t *testing.T) {
+	now := time.Now()
+
+	t.Run("unlimited", func(t *testing.T) {
+		u := newS3Usage(0)
+		u.add(100, 1)
+		assert.Equal(t, int64(math.MaxInt64), u.available())
+		usage := u.usage("s3")
+		assert.Equal(t, int64(100), usage.Used)
+		assert.Equal(t, int64(0), usage.Available)
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		u := newS3Usage(1000)
+		assert.True(t, u.startListing(now))
+		// the listing is ongoing
+		assert.False(t, u.startListing(now))
+		// the write during listing is kept after listing
+		u.add(100, 1)
+		u.finishListing(600, 3, nil, now)
+		assert.Equal(t, int64(300), u.available())
+		usage := u.usage("s3")
+		assert.Equal(t, int64(700), usage.Used)
+		assert.Equal(t, int64(4), usage.Objects)
+		assert.Equal(t, now, usage.ListedAt)
+
+		u.add(-200, -1)
+		assert.Equal(t, int64(500), u.available())
+		u.add(900, 1)
+		assert.Equal(t, int64(0), u.available())
+
+		// the listing is recent
+		assert.False(t, u.startListing(now.Add(time.Minute)))
+		assert.True(t, u.startListing(now.Add(s3UsageRefreshInterval)))
+		u.finishListing(0, 0, errors.New("list fail"), now.Add(s3UsageRefreshInterval))
+		// the usage is kept if the listing fails
+		usage = u.usage("s3")
+		assert.Equal(t, int64(1400), usage.Used)
+		assert.Equal(t, "list fail", usage.LastError)
+	})
+}
@@ -0,0 +1,521 @@
+package piecestorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-padreader"
+	"github.com/filecoin-project/go-state-types/abi"
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs-force-community/metrics"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/fx"
+	"golang.org/x/time/rate"
+
+	gatewayAPIV2 "github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	marketMetrics "github.com/ipfs-force-community/droplet/v2/metrics"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+	"github.com/ipfs-force-community/droplet/v2/utils"
+)
+
+// the scrubber checks the pieces which are not verified in the last PieceScrub.Interval every round
+var scrubRoundInterval = time.Hour
+
+// PieceScrubber verifies the pieces in the piece storages in background with low priority, the corrupt pieces
+// are quarantined, so they are not read until they are fetched again
+type PieceScrubber struct {
+	cfg        *config.PieceScrub
+	mgr        *PieceStorageManager
+	dealRepo   repo.StorageDealRepo
+	ds         datastore.Batching
+	unsealer   PieceUnsealer
+	metricsCtx metrics.MetricsCtx
+	// commP calculates the piece cid of the data at its natural padded size
+	commP func(r io.Reader, size uint64) (cid.Cid, error)
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	trigger chan struct{}
+
+	lk     sync.Mutex
+	status mtypes.PieceScrubStatus
+	// the records of pieces which are not ok
+	problems map[datastore.Key]*mtypes.PieceScrubRecord
+}
+
+func NewPieceScrubber(
+	mCtx metrics.MetricsCtx,
+	lc fx.Lifecycle,
+	cfg *config.PieceScrub,
+	mgr *PieceStorageManager,
+	r repo.Repo,
+	ds badger.PieceScrubDS,
+	gatewayMarketClient gatewayAPIV2.IMarketClient,
+) (*PieceScrubber, error) {
+	s, err := newPieceScrubber(mCtx, cfg, mgr, r.StorageDealRepo(), ds, gatewayMarketClient)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			s.Close()
+			return nil
+		},
+	})
+	return s, nil
+}
+
+func newPieceScrubber(
+	mCtx metrics.MetricsCtx,
+	cfg *config.PieceScrub,
+	mgr *PieceStorageManager,
+	dealRepo repo.StorageDealRepo,
+	ds datastore.Batching,
+	unsealer PieceUnsealer,
+) (*PieceScrubber, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &PieceScrubber{
+		cfg:        cfg,
+		mgr:        mgr,
+		dealRepo:   dealRepo,
+		ds:         ds,
+		unsealer:   unsealer,
+		metricsCtx: mCtx,
+		commP:      generatePieceCommitment,
+		ctx:        ctx,
+		cancel:     cancel,
+		trigger:    make(chan struct{}, 1),
+		status:     mtypes.PieceScrubStatus{Enabled: cfg.Enable},
+		problems:   make(map[datastore.Key]*mtypes.PieceScrubRecord),
+	}
+
+	res, err := ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, fmt.Errorf("query piece scrub records: %w", err)
+	}
+	defer res.Close() // nolint
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		record := &mtypes.PieceScrubRecord{}
+		if err := json.Unmarshal(r.Value, record); err != nil {
+			return nil, fmt.Errorf("unmarshal piece scrub record %s: %w", r.Key, err)
+		}
+		if record.Result == mtypes.PieceScrubOK {
+			continue
+		}
+		// the refetch is interrupted by restart
+		if record.RefetchState == mtypes.PieceRefetchRunning {
+			record.RefetchState = mtypes.PieceRefetchFailed
+			record.RefetchError = "interrupted by restart"
+		}
+		s.problems[datastore.NewKey(r.Key)] = record
+		if record.Quarantined {
+			mgr.quarantine(record.Storage, record.ResourceID)
+		}
+	}
+	return s, nil
+}
+
+// generatePieceCommitment uses the proof type of the largest sector, so that any piece fits in,
+// the commP doesn't depend on the proof type
+func generatePieceCommitment(r io.Reader, size uint64) (cid.Cid, error) {
+	return utils.GeneratePieceCommitment(abi.RegisteredSealProof_StackedDrg64GiBV1_1, r, size)
+}
+
+// Start runs the scrubber in background, the pieces are verified periodically if it's enabled,
+// otherwise they are verified only when Run is called
+func (s *PieceScrubber) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+func (s *PieceScrubber) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Run triggers a round to verify all the pieces, including the ones verified recently
+func (s *PieceScrubber) Run() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.status.Running {
+		return fmt.Errorf("piece scrub is running")
+	}
+	select {
+	case s.trigger <- struct{}{}:
+		return nil
+	default:
+		return fmt.Errorf("piece scrub is already triggered")
+	}
+}
+
+func (s *PieceScrubber) loop() {
+	defer s.wg.Done()
+
+	var tick <-chan time.Time
+	if s.cfg.Enable {
+		ticker := time.NewTicker(scrubRoundInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+		s.round(s.ctx, false)
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.trigger:
+			s.round(s.ctx, true)
+		case <-tick:
+			s.round(s.ctx, false)
+		}
+	}
+}
+
+// round verifies the pieces in all the storages, the pieces verified in the last interval are skipped unless full is true
+func (s *PieceScrubber) round(ctx context.Context, full bool) {
+	s.lk.Lock()
+	s.status.Running = true
+	s.status.RoundStartedAt = time.Now()
+	s.status.Verified, s.status.Corrupt, s.status.Errors, s.status.BytesScanned = 0, 0, 0, 0
+	s.lk.Unlock()
+	defer func() {
+		s.lk.Lock()
+		s.status.Running = false
+		s.status.LastRoundFinishedAt = time.Now()
+		log.Infof("piece scrub round finished, %d verified, %d corrupt, %d errors", s.status.Verified, s.status.Corrupt, s.status.Errors)
+		s.lk.Unlock()
+	}()
+
+	var limiter *rate.Limiter
+	if s.cfg.BytesPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(s.cfg.BytesPerSecond), int(s.cfg.BytesPerSecond))
+	}
+
+	var storages []IPieceStorage
+	_ = s.mgr.EachPieceStorage(func(st IPieceStorage) error {
+		// the pieces of remote storages are verified by the remote droplet
+		if st.Type() != Remote {
+			storages = append(storages, st)
+		}
+		return nil
+	})
+	sort.Slice(storages, func(i, j int) bool {
+		return storages[i].GetName() < storages[j].GetName()
+	})
+
+	for _, st := range storages {
+		resourceIDs, err := st.ListResourceIds(ctx)
+		if err != nil {
+			log.Warnf("list resources of piece storage %s for scrub: %v", st.GetName(), err)
+			continue
+		}
+		for _, resourceID := range resourceIDs {
+			if ctx.Err() != nil {
+				return
+			}
+			if !full && s.verifiedRecently(ctx, st.GetName(), resourceID) {
+				continue
+			}
+			if _, err := s.Verify(ctx, st, resourceID, limiter); err != nil && ctx.Err() == nil {
+				log.Warnf("scrub piece %s in %s: %v", resourceID, st.GetName(), err)
+			}
+		}
+	}
+}
+
+func (s *PieceScrubber) verifiedRecently(ctx context.Context, storage, resourceID string) bool {
+	record, err := s.getRecord(ctx, storage, resourceID)
+	if err != nil {
+		return false
+	}
+	return time.Since(record.LastVerified) < time.Duration(s.cfg.Interval)
+}
+
+// Verify recomputes the commP of the piece in the storage and records the result, it returns nil
+// if the resource is not a piece
+func (s *PieceScrubber) Verify(ctx context.Context, st IPieceStorage, resourceID string, limiter *rate.Limiter) (*mtypes.PieceScrubRecord, error) {
+	pieceCid, err := cid.Decode(resourceID)
+	if err != nil {
+		return nil, nil
+	}
+
+	record := &mtypes.PieceScrubRecord{
+		Storage:    st.GetName(),
+		ResourceID: resourceID,
+	}
+	actual, size, err := s.calcPieceCid(ctx, st, pieceCid, limiter)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	record.LastVerified = time.Now()
+	switch {
+	case err != nil:
+		record.Result = mtypes.PieceScrubError
+		record.Error = err.Error()
+	case !actual.Equals(pieceCid):
+		record.Result = mtypes.PieceScrubCorrupt
+		record.Actual = actual.String()
+	default:
+		record.Result = mtypes.PieceScrubOK
+	}
+
+	key := scrubRecordKey(record.Storage, resourceID)
+	s.lk.Lock()
+	s.status.Verified++
+	s.status.BytesScanned += size
+	prev := s.problems[key]
+	switch record.Result {
+	case mtypes.PieceScrubOK:
+		delete(s.problems, key)
+		if prev != nil && prev.Quarantined {
+			s.mgr.unquarantine(record.Storage, resourceID)
+			log.Infof("piece %s in %s is verified ok, release it from quarantine", resourceID, record.Storage)
+		}
+	case mtypes.PieceScrubCorrupt:
+		s.status.Corrupt++
+		record.Quarantined = true
+		if prev != nil {
+			record.RefetchState, record.RefetchError = prev.RefetchState, prev.RefetchError
+		}
+		s.problems[key] = record
+		s.mgr.quarantine(record.Storage, resourceID)
+		log.Errorf("piece %s in %s is corrupt, got %s, quarantine it", resourceID, record.Storage, actual)
+	case mtypes.PieceScrubError:
+		s.status.Errors++
+		// keep the corrupt piece in quarantine, it's not verified ok
+		if prev != nil {
+			record.Quarantined = prev.Quarantined
+			record.RefetchState, record.RefetchError = prev.RefetchState, prev.RefetchError
+		}
+		s.problems[key] = record
+	}
+	quarantined := s.quarantinedCountLocked()
+	s.lk.Unlock()
+
+	_ = stats.RecordWithTags(s.metricsCtx, []tag.Mutator{
+		tag.Upsert(marketMetrics.StorageNameTag, record.Storage),
+		tag.Upsert(marketMetrics.PieceScrubResultTag, string(record.Result)),
+	}, marketMetrics.PieceScrubVerifiedCount.M(1))
+	_ = stats.RecordWithTags(s.metricsCtx, []tag.Mutator{tag.Upsert(marketMetrics.StorageNameTag, record.Storage)},
+		marketMetrics.PieceScrubBytes.M(size))
+	stats.Record(s.metricsCtx, marketMetrics.PieceQuarantinedCount.M(quarantined))
+
+	if err := s.saveRecord(ctx, record); err != nil {
+		return record, err
+	}
+	if record.Result == mtypes.PieceScrubCorrupt && s.cfg.RefetchCorrupt && record.RefetchState != mtypes.PieceRefetchRunning {
+		if err := s.Refetch(ctx, record.Storage, resourceID); err != nil {
+			log.Warnf("refetch corrupt piece %s in %s: %v", resourceID, record.Storage, err)
+		}
+	}
+	return record, nil
+}
+
+// calcPieceCid returns the piece cid of the data padded to the size of deal, and the length of the data
+func (s *PieceScrubber) calcPieceCid(ctx context.Context, st IPieceStorage, pieceCid cid.Cid, limiter *rate.Limiter) (cid.Cid, int64, error) {
+	resourceID := pieceCid.String()
+	size, err := st.Len(ctx, resourceID)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("get length: %w", err)
+	}
+	r, err := st.GetReaderCloser(ctx, resourceID)
+	if err != nil {
+		return cid.Undef, 0, fmt.Errorf("read piece: %w", err)
+	}
+	defer r.Close() // nolint
+
+	actual, err := s.commP(newThrottledReader(ctx, r, limiter), uint64(size))
+	if err != nil {
+		return cid.Undef, size, fmt.Errorf("calculate commP: %w", err)
+	}
+	if actual.Equals(pieceCid) {
+		return actual, size, nil
+	}
+
+	// the piece may be padded to a larger size in the deal
+	_, dealSize, err := s.dealRepo.GetPieceSize(ctx, pieceCid)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return cid.Undef, size, fmt.Errorf("commP %s doesn't match, and no deal to determine the piece size", actual)
+		}
+		return cid.Undef, size, fmt.Errorf("get piece size: %w", err)
+	}
+	paddedSize := padreader.PaddedSize(uint64(size)).Padded()
+	if dealSize <= paddedSize {
+		return actual, size, nil
+	}
+	rawCommP, err := commcid.CIDToDataCommitmentV1(actual)
+	if err != nil {
+		return cid.Undef, size, err
+	}
+	rawCommP, err = commp.PadCommP(rawCommP, uint64(paddedSize), uint64(dealSize))
+	if err != nil {
+		return cid.Undef, size, err
+	}
+	actual, err = commcid.DataCommitmentV1ToCID(rawCommP)
+	return actual, size, err
+}
+
+// Refetch deletes the corrupt piece from the storage, and fetches it again by unsealing it from the sector
+// of an active deal, the piece is verified again after unsealing
+func (s *PieceScrubber) Refetch(ctx context.Context, storage, resourceID string) error {
+	pieceCid, err := cid.Decode(resourceID)
+	if err != nil {
+		return fmt.Errorf("resource is not a piece cid: %w", err)
+	}
+	st, err := s.mgr.GetPieceStorageByName(storage)
+	if err != nil {
+		return err
+	}
+	if st.ReadOnly() {
+		return fmt.Errorf("piece storage %s is readonly", storage)
+	}
+
+	key := scrubRecordKey(storage, resourceID)
+	s.lk.Lock()
+	record, ok := s.problems[key]
+	if !ok || record.Result != mtypes.PieceScrubCorrupt {
+		s.lk.Unlock()
+		return fmt.Errorf("piece %s in %s is not corrupt", resourceID, storage)
+	}
+	if record.RefetchState == mtypes.PieceRefetchRunning {
+		s.lk.Unlock()
+		return fmt.Errorf("piece %s in %s is being refetched", resourceID, storage)
+	}
+	deals, err := s.dealRepo.GetDealsByPieceCidAndStatus(ctx, pieceCid, storagemarket.StorageDealActive)
+	if err != nil || len(deals) == 0 {
+		s.lk.Unlock()
+		return fmt.Errorf("no active deal to unseal piece %s: %v", resourceID, err)
+	}
+	record.RefetchState, record.RefetchError = mtypes.PieceRefetchRunning, ""
+	updated := *record
+	s.lk.Unlock()
+	if err := s.saveRecord(ctx, &updated); err != nil {
+		return err
+	}
+
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.unseal(s.ctx, st, deals[0])
+		if err == nil {
+			var verified *mtypes.PieceScrubRecord
+			if verified, err = s.Verify(s.ctx, st, resourceID, nil); err == nil && verified.Result != mtypes.PieceScrubOK {
+				err = fmt.Errorf("piece is %s after unsealing", verified.Result)
+			}
+		}
+
+		s.lk.Lock()
+		record, ok := s.problems[key]
+		if !ok {
+			// verified ok
+			s.lk.Unlock()
+			log.Infof("refetch corrupt piece %s in %s successfully", resourceID, storage)
+			return
+		}
+		record.RefetchState = mtypes.PieceRefetchFailed
+		if err != nil {
+			record.RefetchError = err.Error()
+		}
+		updated := *record
+		s.lk.Unlock()
+		log.Errorf("refetch corrupt piece %s in %s: %v", resourceID, storage, err)
+		if err := s.saveRecord(context.Background(), &updated); err != nil {
+			log.Errorf("save piece scrub record: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *PieceScrubber) unseal(ctx context.Context, st IPieceStorage, deal *types.MinerDeal) error {
+	pieceCid := deal.Proposal.PieceCID
+	if err := st.Delete(ctx, pieceCid.String()); err != nil {
+		return fmt.Errorf("delete corrupt piece: %w", err)
+	}
+	s.mgr.invalidateLocation(pieceCid.String())
+	return UnsealPiece(ctx, s.unsealer, st, deal)
+}
+
+// Status returns the state of the scrubber and the pieces which are not ok
+func (s *PieceScrubber) Status() *mtypes.PieceScrubStatus {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	status := s.status
+	status.Problems = make([]*mtypes.PieceScrubRecord, 0, len(s.problems))
+	for _, record := range s.problems {
+		r := *record
+		status.Problems = append(status.Problems, &r)
+	}
+	sort.Slice(status.Problems, func(i, j int) bool {
+		if status.Problems[i].Storage != status.Problems[j].Storage {
+			return status.Problems[i].Storage < status.Problems[j].Storage
+		}
+		return status.Problems[i].ResourceID < status.Problems[j].ResourceID
+	})
+	return &status
+}
+
+func (s *PieceScrubber) quarantinedCountLocked() int64 {
+	var count int64
+	for _, record := range s.problems {
+		if record.Quarantined {
+			count++
+		}
+	}
+	return count
+}
+
+func scrubRecordKey(storage, resourceID string) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{storage, resourceID})
+}
+
+func (s *PieceScrubber) getRecord(ctx context.Context, storage, resourceID string) (*mtypes.PieceScrubRecord, error) {
+	data, err := s.ds.Get(ctx, scrubRecordKey(storage, resourceID))
+	if err != nil {
+		return nil, err
+	}
+	record := &mtypes.PieceScrubRecord{}
+	return record, json.Unmarshal(data, record)
+}
+
+func (s *PieceScrubber) saveRecord(ctx context.Context, record *mtypes.PieceScrubRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, scrubRecordKey(record.Storage, record.ResourceID), data); err != nil {
+		return fmt.Errorf("save piece scrub record: %w", err)
+	}
+	return nil
+}
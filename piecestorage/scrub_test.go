@@ -0,0 +1,191 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	vtypes "github.com/filecoin-project/venus/venus-shared/types"
+	gtypes "github.com/filecoin-project/venus/venus-shared/types/gateway"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+type mockUnsealer struct {
+	store *MemPieceStore
+	data  map[cid.Cid][]byte
+	calls int32
+}
+
+func (m *mockUnsealer) SectorsUnsealPiece(ctx context.Context, _ address.Address, pieceCid cid.Cid, _ abi.SectorNumber, _ vtypes.UnpaddedByteIndex, _ abi.UnpaddedPieceSize, _ string) (gtypes.UnsealState, error) {
+	atomic.AddInt32(&m.calls, 1)
+	if _, err := m.store.SaveTo(ctx, pieceCid.String(), bytes.NewReader(m.data[pieceCid])); err != nil {
+		return gtypes.UnsealStateFailed, err
+	}
+	return gtypes.UnsealStateFinished, nil
+}
+
+func hashCommP(r io.Reader, _ uint64) (cid.Cid, error) {
+	commP, err := calcCommP(r)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return commcid.DataCommitmentV1ToCID(commP.raw)
+}
+
+func TestPieceScrubber(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+	ds, err := badger.NewDatastore("")
+	require.NoError(t, err)
+
+	// piece 0 is intact, piece 1 is corrupt, piece 2 is padded to a larger size in the deal,
+	// piece 3 has no deal and is corrupt
+	deals := make([]markettypes.MinerDeal, 3)
+	testutil.Provide(t, &deals)
+	pieces := make([][]byte, 4)
+	ids := make([]cid.Cid, 4)
+	for i := range pieces {
+		var size abi.PaddedPieceSize
+		pieces[i], ids[i], size = randPiece(t)
+		if i < len(deals) {
+			deals[i].Proposal.PieceSize = size
+		}
+	}
+	raw, err := commcid.CIDToDataCommitmentV1(ids[2])
+	require.NoError(t, err)
+	raw, err = commp.PadCommP(raw, uint64(deals[2].Proposal.PieceSize), uint64(deals[2].Proposal.PieceSize*4))
+	require.NoError(t, err)
+	ids[2], err = commcid.DataCommitmentV1ToCID(raw)
+	require.NoError(t, err)
+	deals[2].Proposal.PieceSize *= 4
+	for i := range deals {
+		deals[i].Proposal.PieceCID = ids[i]
+		deals[i].State = storagemarket.StorageDealActive
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := NewMemPieceStore("mem", nil)
+	psm.AddMemPieceStorage(store)
+	for i, data := range pieces {
+		if i == 1 || i == 3 {
+			data = append([]byte{}, data...)
+			data[0]++
+		}
+		_, err := store.SaveTo(ctx, ids[i].String(), bytes.NewReader(data))
+		require.NoError(t, err)
+	}
+	_, err = store.SaveTo(ctx, "not-a-piece", bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+
+	unsealer := &mockUnsealer{store: store, data: map[cid.Cid][]byte{ids[1]: pieces[1]}}
+	cfg := &config.PieceScrub{Interval: config.Duration(time.Hour)}
+	s, err := newPieceScrubber(ctx, cfg, psm, r.StorageDealRepo(), ds, unsealer)
+	require.NoError(t, err)
+	s.commP = hashCommP
+	defer s.Close()
+
+	s.round(ctx, false)
+	status := s.Status()
+	assert.Equal(t, 4, status.Verified)
+	assert.Equal(t, 1, status.Corrupt)
+	assert.Equal(t, 1, status.Errors)
+	require.Len(t, status.Problems, 2)
+	problems := map[string]*mtypes.PieceScrubRecord{}
+	for _, record := range status.Problems {
+		problems[record.ResourceID] = record
+	}
+	assert.Equal(t, mtypes.PieceScrubCorrupt, problems[ids[1].String()].Result)
+	assert.True(t, problems[ids[1].String()].Quarantined)
+	assert.Equal(t, mtypes.PieceScrubError, problems[ids[3].String()].Result)
+	assert.False(t, problems[ids[3].String()].Quarantined)
+
+	// the corrupt piece is not read
+	_, err = psm.FindStorageForRead(ctx, ids[1].String())
+	assert.ErrorIs(t, err, ErrorNotFoundForRead)
+	_, err = psm.FindStorageForRead(ctx, ids[0].String())
+	assert.NoError(t, err)
+
+	// the pieces verified recently are skipped
+	s.round(ctx, false)
+	assert.Equal(t, 0, s.Status().Verified)
+
+	// the quarantine is loaded after restart
+	psm2, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	psm2.AddMemPieceStorage(store)
+	reloaded, err := newPieceScrubber(ctx, cfg, psm2, r.StorageDealRepo(), ds, unsealer)
+	require.NoError(t, err)
+	assert.Len(t, reloaded.Status().Problems, 2)
+	assert.True(t, psm2.isQuarantined("mem", ids[1].String()))
+
+	assert.Error(t, s.Refetch(ctx, "mem", ids[0].String()))
+	require.NoError(t, s.Refetch(ctx, "mem", ids[1].String()))
+	require.Eventually(t, func() bool {
+		return len(s.Status().Problems) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&unsealer.calls))
+	st, err := psm.FindStorageForRead(ctx, ids[1].String())
+	require.NoError(t, err)
+	assert.Equal(t, "mem", st.GetName())
+}
+
+func TestPieceScrubberRun(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+	ds, err := badger.NewDatastore("")
+	require.NoError(t, err)
+
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := NewMemPieceStore("mem", nil)
+	psm.AddMemPieceStorage(store)
+	data, pieceCid, _ := randPiece(t)
+	_, err = store.SaveTo(ctx, pieceCid.String(), bytes.NewReader(data))
+	require.NoError(t, err)
+
+	s, err := newPieceScrubber(ctx, &config.PieceScrub{Interval: config.Duration(time.Hour)}, psm, r.StorageDealRepo(), ds, nil)
+	require.NoError(t, err)
+	s.commP = hashCommP
+	s.Start()
+	defer s.Close()
+
+	// the scrubber is disabled, pieces are verified only when triggered
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, s.Status().LastRoundFinishedAt.IsZero())
+
+	var lastFinished time.Time
+	for i := 0; i < 2; i++ {
+		require.Eventually(t, func() bool {
+			return s.Run() == nil
+		}, 10*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool {
+			status := s.Status()
+			return status.LastRoundFinishedAt.After(lastFinished) && !status.Running
+		}, 10*time.Second, 10*time.Millisecond)
+		status := s.Status()
+		// the piece verified in the last round is verified again
+		assert.Equal(t, 1, status.Verified)
+		lastFinished = status.LastRoundFinishedAt
+	}
+}
@@ -0,0 +1,135 @@
+package piecestorage
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+)
+
+// WriteStrategy is how to select a storage to write a piece among the storages with enough space
+type WriteStrategy string
+
+const (
+	// WriteRandom selects a storage randomly
+	WriteRandom WriteStrategy = "random"
+	// WriteMostFree selects the storage with the most available space
+	WriteMostFree WriteStrategy = "most-free"
+	// WriteCapacityWeighted selects a storage randomly, weighted by the capacity of storages
+	WriteCapacityWeighted WriteStrategy = "capacity-weighted"
+	// WriteRoundRobin selects the storages in turn
+	WriteRoundRobin WriteStrategy = "round-robin"
+	// WriteFillFirst selects the storage with the highest priority until it's full
+	WriteFillFirst WriteStrategy = "fill-first"
+)
+
+func ParseWriteStrategy(s string) (WriteStrategy, error) {
+	switch strategy := WriteStrategy(s); strategy {
+	case "":
+		return WriteRandom, nil
+	case WriteRandom, WriteMostFree, WriteCapacityWeighted, WriteRoundRobin, WriteFillFirst:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown piece storage write strategy %s", s)
+	}
+}
+
+type writeCandidate struct {
+	storage IPieceStorage
+	// the status of storage, the reserved space is excluded from Available
+	status market.StorageStatus
+}
+
+// selectStorage selects a storage from candidates which are sorted by name, next is the count of
+// previous selections for round-robin
+func selectStorage(strategy WriteStrategy, candidates []writeCandidate, next uint64) IPieceStorage {
+	switch strategy {
+	case WriteMostFree:
+		best := candidates[0]
+		for _, c := range candidates[1:] {
+			if c.status.Available > best.status.Available {
+				best = c
+			}
+		}
+		return best.storage
+	case WriteCapacityWeighted:
+		return capacityWeightedSelect(candidates)
+	case WriteRoundRobin:
+		return candidates[next%uint64(len(candidates))].storage
+	case WriteFillFirst:
+		sorted := make([]writeCandidate, len(candidates))
+		copy(sorted, candidates)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return storagePriority(sorted[i].storage) > storagePriority(sorted[j].storage)
+		})
+		return sorted[0].storage
+	default:
+		return candidates[rand.Intn(len(candidates))].storage
+	}
+}
+
+// capacityWeightedSelect treats the storages with unknown capacity, eg. object storages, as having
+// the average capacity of the others
+func capacityWeightedSelect(candidates []writeCandidate) IPieceStorage {
+	var known, knownTotal int64
+	for _, c := range candidates {
+		if c.status.Capacity > 0 {
+			known++
+			knownTotal += c.status.Capacity
+		}
+	}
+	defaultWeight := int64(1)
+	if known > 0 {
+		defaultWeight = knownTotal / known
+	}
+
+	weights := make([]int64, len(candidates))
+	var total int64
+	for i, c := range candidates {
+		weights[i] = c.status.Capacity
+		if weights[i] <= 0 {
+			weights[i] = defaultWeight
+		}
+		total += weights[i]
+	}
+
+	n := rand.Int63n(total)
+	for i, w := range weights {
+		if n < w {
+			return candidates[i].storage
+		}
+		n -= w
+	}
+	return candidates[len(candidates)-1].storage
+}
+
+func storageReadPriority(st IPieceStorage) int {
+	switch s := unwrapStorage(st).(type) {
+	case *fsPieceStorage:
+		return s.fsCfg.ReadPriority
+	case *s3PieceStorage:
+		return s.s3Cfg.ReadPriority
+	case *remotePieceStorage:
+		return s.cfg.ReadPriority
+	case *MemPieceStore:
+		return s.ReadPriority
+	default:
+		return 0
+	}
+}
+
+func storagePriority(st IPieceStorage) int {
+	switch s := unwrapStorage(st).(type) {
+	case *fsPieceStorage:
+		return s.fsCfg.Priority
+	case *s3PieceStorage:
+		return s.s3Cfg.Priority
+	case *remotePieceStorage:
+		return s.cfg.Priority
+	case *MemPieceStore:
+		return s.Priority
+	default:
+		return 0
+	}
+}
@@ -3,11 +3,14 @@ package piecestorage
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"sort"
 	"sync"
 
+	"github.com/filecoin-project/go-address"
 	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 )
 
 var ErrorNotFoundForRead = fmt.Errorf("not found for read")
@@ -15,11 +18,33 @@ var ErrorNotFoundForRead = fmt.Errorf("not found for read")
 type PieceStorageManager struct {
 	lk       sync.RWMutex
 	storages map[string]IPieceStorage
+
+	writeStrategy WriteStrategy
+	// the names of storages to write the pieces of miners
+	affinity map[address.Address][]string
+
+	// writeLk protects the selection and reservation of storages for write
+	writeLk sync.Mutex
+	// the space reserved by the ongoing writes of every storage
+	reserved   map[string]int64
+	writeCount uint64
+
+	healthLk sync.Mutex
+	health   map[string]*storageHealth
+	// the cached names of storages which have the resource
+	locations *lru.Cache[string, pieceLocation]
+	// the storages which keep a corrupt copy of the resource, they are not read
+	quarantined map[string]map[string]struct{}
 }
 
 func NewPieceStorageManager(cfg *config.PieceStorage) (*PieceStorageManager, error) {
 	storages := make(map[string]IPieceStorage)
 
+	writeStrategy, err := ParseWriteStrategy(cfg.WriteStrategy)
+	if err != nil {
+		return nil, err
+	}
+
 	// todo: extract name check logic to a function and check blank in name
 
 	for _, fsCfg := range cfg.Fs {
@@ -55,55 +80,119 @@ func NewPieceStorageManager(cfg *config.PieceStorage) (*PieceStorageManager, err
 		}
 		storages[s3Cfg.Name] = st
 	}
-	return &PieceStorageManager{
-		lk:       sync.RWMutex{},
-		storages: storages,
-	}, nil
-}
 
-func (p *PieceStorageManager) FindStorageForRead(ctx context.Context, s string) (IPieceStorage, error) {
-	var storages []IPieceStorage
-	_ = p.EachPieceStorage(func(st IPieceStorage) error {
-		has, err := st.Has(ctx, s)
+	for _, remoteCfg := range cfg.Remote {
+		if remoteCfg.Name == "" {
+			return nil, fmt.Errorf("remote piece storage name is empty, must set storage name in piece storage config `name=yourname`")
+		}
+		_, ok := storages[remoteCfg.Name]
+		if ok {
+			return nil, fmt.Errorf("duplicate storage name: %s", remoteCfg.Name)
+		}
+
+		st, err := NewRemotePieceStorage(remoteCfg)
 		if err != nil {
-			log.Warnf("got error while check avaibale in storage: %s", err.Error())
-			return nil
+			return nil, fmt.Errorf("unable to create remote piece storage %w", err)
 		}
-		if has {
-			storages = append(storages, st)
+		storages[remoteCfg.Name] = st
+	}
+
+	affinity := make(map[address.Address][]string)
+	for _, aff := range cfg.Affinity {
+		for _, name := range aff.Storages {
+			if _, ok := storages[name]; !ok {
+				return nil, fmt.Errorf("affinity storage %s of miner %s not exist", name, aff.Miner.Unwrap())
+			}
 		}
-		return nil
-	})
+		affinity[aff.Miner.Unwrap()] = append(affinity[aff.Miner.Unwrap()], aff.Storages...)
+	}
 
-	if len(storages) == 0 {
-		return nil, fmt.Errorf("unable to find piece %s: %w", s, ErrorNotFoundForRead)
+	locations, err := lru.New[string, pieceLocation](locationCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	p := &PieceStorageManager{
+		lk:            sync.RWMutex{},
+		storages:      storages,
+		writeStrategy: writeStrategy,
+		affinity:      affinity,
+		reserved:      make(map[string]int64),
+		health:        make(map[string]*storageHealth),
+		locations:     locations,
+		quarantined:   make(map[string]map[string]struct{}),
+	}
+	for name, st := range storages {
+		storages[name] = p.manage(st)
 	}
+	return p, nil
+}
 
-	return randStorageSelector(storages)
+// manage wraps the storage to be tracked by the manager
+func (p *PieceStorageManager) manage(st IPieceStorage) *managedStorage {
+	m := newManagedStorage(st)
+	m.onReadError = p.invalidateLocation
+	return m
 }
 
-func (p *PieceStorageManager) FindStorageForWrite(size int64) (IPieceStorage, error) {
-	var storages []IPieceStorage
+// FindStorageForWrite selects a storage to write a piece of the miner by the write strategy, the size
+// of piece is reserved on the storage until release is called, miner can be address.Undef if unknown
+func (p *PieceStorageManager) FindStorageForWrite(miner address.Address, size int64) (IPieceStorage, func(), error) {
+	p.writeLk.Lock()
+	defer p.writeLk.Unlock()
+
+	affinity := make(map[string]struct{})
+	for _, name := range p.affinity[miner] {
+		affinity[name] = struct{}{}
+	}
+
+	var candidates []writeCandidate
 	_ = p.EachPieceStorage(func(st IPieceStorage) error {
 		if st.ReadOnly() {
 			return nil
 		}
+		if _, ok := affinity[st.GetName()]; len(affinity) > 0 && !ok {
+			return nil
+		}
 		storageSt, err := st.GetStorageStatus()
 		if err != nil {
 			log.Errorf("get available bytes from storage(%s)", st.GetName())
 			return nil
 		}
+		storageSt.Available -= p.reserved[st.GetName()]
 		if storageSt.Available > size {
-			storages = append(storages, st)
+			candidates = append(candidates, writeCandidate{storage: st, status: storageSt})
 		}
 		return nil
 	})
 
-	if len(storages) == 0 {
-		return nil, fmt.Errorf("unable to select a piece storage that have enough space for piece(%d)", size)
+	if len(candidates) == 0 {
+		if len(affinity) > 0 {
+			return nil, nil, fmt.Errorf("unable to select a piece storage of miner %s that have enough space for piece(%d)", miner, size)
+		}
+		return nil, nil, fmt.Errorf("unable to select a piece storage that have enough space for piece(%d)", size)
 	}
-	// todo better to use argorithems base on stroage capacity and usage
-	return randStorageSelector(storages)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].storage.GetName() < candidates[j].storage.GetName()
+	})
+
+	st := selectStorage(p.writeStrategy, candidates, p.writeCount)
+	p.writeCount++
+	name := st.GetName()
+	p.reserved[name] += size
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			p.writeLk.Lock()
+			defer p.writeLk.Unlock()
+
+			p.reserved[name] -= size
+			if p.reserved[name] <= 0 {
+				delete(p.reserved, name)
+			}
+		})
+	}
+	return st, release, nil
 }
 
 func (p *PieceStorageManager) GetPieceStorageByName(name string) (IPieceStorage, error) {
@@ -120,7 +209,7 @@ func (p *PieceStorageManager) AddMemPieceStorage(s IPieceStorage) {
 	p.lk.Lock()
 	defer p.lk.Unlock()
 
-	p.storages[s.GetName()] = s
+	p.storages[s.GetName()] = p.manage(s)
 }
 
 func (p *PieceStorageManager) AddPieceStorage(s IPieceStorage) error {
@@ -132,7 +221,50 @@ func (p *PieceStorageManager) AddPieceStorage(s IPieceStorage) error {
 	if ok {
 		return fmt.Errorf("duplicate storage name: %s", s.GetName())
 	}
-	p.storages[s.GetName()] = s
+	p.storages[s.GetName()] = p.manage(s)
+	return nil
+}
+
+// ReplacePieceStorage replaces the storage with the same name, eg. to apply new settings, the operations on
+// the old storage are not interrupted, and the draining state is kept
+func (p *PieceStorageManager) ReplacePieceStorage(s IPieceStorage) error {
+	p.lk.Lock()
+	old, ok := p.storages[s.GetName()]
+	if !ok {
+		p.lk.Unlock()
+		return fmt.Errorf("storage %s not exist", s.GetName())
+	}
+	st := p.manage(unwrapStorage(s))
+	st.setDraining(old.(*managedStorage).isDraining())
+	p.storages[s.GetName()] = st
+	p.lk.Unlock()
+
+	// the new storage is probed again when reading
+	p.healthLk.Lock()
+	delete(p.health, s.GetName())
+	p.healthLk.Unlock()
+	return nil
+}
+
+func (p *PieceStorageManager) managedStorage(name string) (*managedStorage, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	st, ok := p.storages[name]
+	if !ok {
+		return nil, fmt.Errorf("storage %s not exist", name)
+	}
+	return st.(*managedStorage), nil
+}
+
+// SetDraining sets whether the storage is draining, a draining storage is read only, so that it can be removed
+// after the in-flight operations finish
+func (p *PieceStorageManager) SetDraining(name string, draining bool) error {
+	st, err := p.managedStorage(name)
+	if err != nil {
+		return err
+	}
+	st.setDraining(draining)
 	return nil
 }
 
@@ -148,29 +280,48 @@ func (p *PieceStorageManager) EachPieceStorage(fn func(IPieceStorage) error) err
 	return nil
 }
 
-func randStorageSelector(storages []IPieceStorage) (IPieceStorage, error) {
-	switch len(storages) {
-	case 0:
-		return nil, fmt.Errorf("given storages is zero")
-	case 1:
-		return storages[0], nil
-	default:
-		return storages[rand.Intn(len(storages))], nil
+// RemovePieceStorage drains the storage and removes it after the in-flight operations finish, the storage is
+// kept draining if ctx is done before that
+func (p *PieceStorageManager) RemovePieceStorage(ctx context.Context, name string) error {
+	st, err := p.managedStorage(name)
+	if err != nil {
+		return err
+	}
+	st.setDraining(true)
+	if err := st.waitIdle(ctx); err != nil {
+		return err
 	}
-}
 
-func (p *PieceStorageManager) RemovePieceStorage(name string) error {
 	p.lk.Lock()
 	defer p.lk.Unlock()
-
-	_, exist := p.storages[name]
-	if !exist {
-		return fmt.Errorf("storage %s not exist", name)
+	// the storage may be replaced while waiting
+	if p.storages[name] != IPieceStorage(st) {
+		return fmt.Errorf("storage %s is changed while removing", name)
 	}
 	delete(p.storages, name)
 	return nil
 }
 
+// usageReporter is implemented by the storages which track their usage, eg. object storages
+type usageReporter interface {
+	Usage() *mtypes.PieceStorageUsage
+}
+
+// ListStorageUsage returns the usage of storages which track their usage
+func (p *PieceStorageManager) ListStorageUsage() []*mtypes.PieceStorageUsage {
+	var out []*mtypes.PieceStorageUsage
+	_ = p.EachPieceStorage(func(st IPieceStorage) error {
+		if r, ok := unwrapStorage(st).(usageReporter); ok {
+			out = append(out, r.Usage())
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
 func (p *PieceStorageManager) ListStorageInfos() types.PieceStorageInfos {
 	var fs []types.FsStorage
 	var s3 []types.S3Storage
@@ -183,10 +334,10 @@ func (p *PieceStorageManager) ListStorageInfos() types.PieceStorageInfos {
 		}
 		switch st.Type() {
 		case S3:
-			cfg := st.(*s3PieceStorage).s3Cfg
+			cfg := unwrapStorage(st).(*s3PieceStorage).s3Cfg
 			s3 = append(s3, types.S3Storage{
 				Name:     cfg.Name,
-				EndPoint: cfg.EndPoint,
+				EndPoint: redactURL(cfg.EndPoint),
 				ReadOnly: cfg.ReadOnly,
 				Bucket:   cfg.Bucket,
 				SubDir:   cfg.SubDir,
@@ -194,7 +345,7 @@ func (p *PieceStorageManager) ListStorageInfos() types.PieceStorageInfos {
 			})
 
 		case FS:
-			cfg := st.(*fsPieceStorage).fsCfg
+			cfg := unwrapStorage(st).(*fsPieceStorage).fsCfg
 			fs = append(fs, types.FsStorage{
 				Name:     cfg.Name,
 				Path:     cfg.Path,
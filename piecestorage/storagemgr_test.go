@@ -1,11 +1,13 @@
 package piecestorage
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
 	"testing"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/venus/venus-shared/types/market"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
@@ -67,12 +69,12 @@ func TestRmPieceStorage(t *testing.T) {
 	err = psm.AddPieceStorage(ps)
 	assert.Nil(t, err)
 
-	err = psm.RemovePieceStorage("test2")
+	err = psm.RemovePieceStorage(context.Background(), "test2")
 	ErrPieceStorageNotFound := fmt.Errorf("storage test2 not exist")
 
 	assert.Equal(t, ErrPieceStorageNotFound, err)
 
-	err = psm.RemovePieceStorage(name)
+	err = psm.RemovePieceStorage(context.Background(), name)
 	assert.Nil(t, err)
 
 	info := psm.ListStorageInfos()
@@ -102,9 +104,10 @@ func TestRandSelect(t *testing.T) {
 
 	var selectName []string
 	for i := 0; i < 1000; i++ {
-		st, err := psm.FindStorageForWrite(1024 * 1024)
+		st, release, err := psm.FindStorageForWrite(address.Undef, 1024*1024)
 		assert.Nil(t, err)
-		selectName = append(selectName, st.(*MemPieceStore).Name)
+		release()
+		selectName = append(selectName, st.GetName())
 	}
 	assert.Contains(t, selectName, "1")
 	assert.Contains(t, selectName, "2")
@@ -149,3 +152,93 @@ func TestEachStorage(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, 2, count)
 }
+
+func newWriteTestManager(t *testing.T, strategy WriteStrategy, affinity ...*config.PieceStorageAffinity) *PieceStorageManager {
+	psm, err := NewPieceStorageManager(&config.PieceStorage{WriteStrategy: string(strategy)})
+	assert.Nil(t, err)
+	for i, available := range []int64{100, 300, 200} {
+		st := NewMemPieceStore(fmt.Sprintf("%d", i+1), &market.StorageStatus{
+			Capacity:  available * 2,
+			Available: available,
+		})
+		st.Priority = i
+		psm.AddMemPieceStorage(st)
+	}
+	for _, aff := range affinity {
+		psm.affinity[aff.Miner.Unwrap()] = aff.Storages
+	}
+	return psm
+}
+
+func TestWriteStrategies(t *testing.T) {
+	selectNames := func(psm *PieceStorageManager, miner address.Address, size int64, count int) []string {
+		var names []string
+		for i := 0; i < count; i++ {
+			st, release, err := psm.FindStorageForWrite(miner, size)
+			assert.Nil(t, err)
+			release()
+			names = append(names, st.GetName())
+		}
+		return names
+	}
+
+	t.Run("most free", func(t *testing.T) {
+		psm := newWriteTestManager(t, WriteMostFree)
+		assert.Equal(t, []string{"2", "2"}, selectNames(psm, address.Undef, 10, 2))
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		psm := newWriteTestManager(t, WriteRoundRobin)
+		assert.Equal(t, []string{"1", "2", "3", "1"}, selectNames(psm, address.Undef, 10, 4))
+	})
+
+	t.Run("fill first", func(t *testing.T) {
+		psm := newWriteTestManager(t, WriteFillFirst)
+		assert.Equal(t, []string{"3", "3"}, selectNames(psm, address.Undef, 10, 2))
+		// storage 3 is too small
+		assert.Equal(t, []string{"2"}, selectNames(psm, address.Undef, 250, 1))
+	})
+
+	t.Run("capacity weighted", func(t *testing.T) {
+		psm := newWriteTestManager(t, WriteCapacityWeighted)
+		counts := map[string]int{}
+		for _, name := range selectNames(psm, address.Undef, 10, 3000) {
+			counts[name]++
+		}
+		assert.Greater(t, counts["2"], counts["3"])
+		assert.Greater(t, counts["3"], counts["1"])
+	})
+
+	t.Run("affinity", func(t *testing.T) {
+		miner, err := address.NewIDAddress(1000)
+		assert.Nil(t, err)
+		psm := newWriteTestManager(t, WriteMostFree, &config.PieceStorageAffinity{
+			Miner:    config.Address(miner),
+			Storages: []string{"1", "3"},
+		})
+		assert.Equal(t, []string{"3"}, selectNames(psm, miner, 10, 1))
+		assert.Equal(t, []string{"2"}, selectNames(psm, address.Undef, 10, 1))
+
+		_, _, err = psm.FindStorageForWrite(miner, 250)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("reservation", func(t *testing.T) {
+		psm := newWriteTestManager(t, WriteMostFree)
+		st1, release1, err := psm.FindStorageForWrite(address.Undef, 150)
+		assert.Nil(t, err)
+		assert.Equal(t, "2", st1.GetName())
+		// 150 bytes left in storage 2
+		st2, release2, err := psm.FindStorageForWrite(address.Undef, 150)
+		assert.Nil(t, err)
+		assert.Equal(t, "3", st2.GetName())
+		// no storage has 150 bytes now
+		_, _, err = psm.FindStorageForWrite(address.Undef, 150)
+		assert.NotNil(t, err)
+
+		release1()
+		release1()
+		release2()
+		assert.Empty(t, psm.reserved)
+	})
+}
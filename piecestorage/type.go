@@ -19,6 +19,7 @@ const (
 	FS        Protocol = "fs"
 	S3        Protocol = "s3"
 	PreSignS3 Protocol = "presigns3"
+	Remote    Protocol = "remote"
 )
 
 type IPieceStorage interface {
@@ -36,6 +37,8 @@ type IPieceStorage interface {
 	// GetRedirectUrl get url if storage support redirect
 	GetRedirectUrl(context.Context, string) (string, error)
 	Has(context.Context, string) (bool, error)
+	// Delete removes the resource from piece store, it's not an error if the resource doesn't exist
+	Delete(context.Context, string) error
 	Validate(string) error
 	GetStorageStatus() (market.StorageStatus, error)
 	GetPieceTransfer(context.Context, string) (string, error)
@@ -0,0 +1,57 @@
+package piecestorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	vtypes "github.com/filecoin-project/venus/venus-shared/types"
+	gtypes "github.com/filecoin-project/venus/venus-shared/types/gateway"
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+)
+
+var (
+	// the interval to check the state of unsealing
+	unsealCheckInterval = 5 * time.Minute
+	unsealTimeout       = 12 * time.Hour
+)
+
+// PieceUnsealer unseals a piece from a sector to the destination, it's implemented by the market client of gateway
+type PieceUnsealer interface {
+	SectorsUnsealPiece(ctx context.Context, miner address.Address, pieceCid cid.Cid, sid abi.SectorNumber, offset vtypes.UnpaddedByteIndex, size abi.UnpaddedPieceSize, dest string) (gtypes.UnsealState, error)
+}
+
+// UnsealPiece unseals the piece of deal to the storage, it blocks until the unsealing finishes
+func UnsealPiece(ctx context.Context, unsealer PieceUnsealer, st IPieceStorage, deal *types.MinerDeal) error {
+	pieceCid := deal.Proposal.PieceCID
+	transfer, err := st.GetPieceTransfer(ctx, pieceCid.String())
+	if err != nil {
+		return fmt.Errorf("get piece transfer: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, unsealTimeout)
+	defer cancel()
+	ticker := time.NewTicker(unsealCheckInterval)
+	defer ticker.Stop()
+	for {
+		state, err := unsealer.SectorsUnsealPiece(ctx, deal.Proposal.Provider, pieceCid, deal.SectorNumber,
+			vtypes.UnpaddedByteIndex(deal.Offset.Unpadded()), deal.Proposal.PieceSize.Unpadded(), transfer)
+		if err != nil {
+			return fmt.Errorf("unseal piece: %w", err)
+		}
+		switch state {
+		case gtypes.UnsealStateFinished:
+			return nil
+		case gtypes.UnsealStateFailed:
+			return fmt.Errorf("unseal piece failed")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package piecestorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// NewPieceStorage creates a piece storage by config, which is *config.FsPieceStorage, *config.S3PieceStorage
+// or *config.RemotePieceStorage
+func NewPieceStorage(cfg interface{}) (IPieceStorage, error) {
+	switch c := cfg.(type) {
+	case *config.FsPieceStorage:
+		return NewFsPieceStorage(c)
+	case *config.S3PieceStorage:
+		return NewS3PieceStorage(c)
+	case *config.RemotePieceStorage:
+		return NewRemotePieceStorage(c)
+	default:
+		return nil, fmt.Errorf("unknown piece storage config %T", cfg)
+	}
+}
+
+// ApplyPieceStorageUpdate changes the config of piece storage by params
+func ApplyPieceStorageUpdate(cfg interface{}, params *mtypes.PieceStorageUpdateParams) error {
+	var readOnly *bool
+	var priority, readPriority *int
+	switch c := cfg.(type) {
+	case *config.FsPieceStorage:
+		if params.Quota != nil || params.AccessKey != nil || params.SecretKey != nil || params.Token != nil {
+			return fmt.Errorf("only readonly and priorities can be changed for fs piece storage")
+		}
+		readOnly, priority, readPriority = &c.ReadOnly, &c.Priority, &c.ReadPriority
+	case *config.S3PieceStorage:
+		readOnly, priority, readPriority = &c.ReadOnly, &c.Priority, &c.ReadPriority
+		if params.Quota != nil {
+			c.Quota = *params.Quota
+		}
+		if params.AccessKey != nil {
+			c.AccessKey = *params.AccessKey
+		}
+		if params.SecretKey != nil {
+			c.SecretKey = *params.SecretKey
+		}
+		if params.Token != nil {
+			c.Token = *params.Token
+		}
+	case *config.RemotePieceStorage:
+		if params.Quota != nil || params.AccessKey != nil || params.SecretKey != nil {
+			return fmt.Errorf("quota and credentials except token can't be changed for remote piece storage")
+		}
+		readOnly, priority, readPriority = &c.ReadOnly, &c.Priority, &c.ReadPriority
+		if params.Token != nil {
+			c.Token = *params.Token
+		}
+	default:
+		return fmt.Errorf("unknown piece storage config %T", cfg)
+	}
+
+	if params.ReadOnly != nil {
+		*readOnly = *params.ReadOnly
+	}
+	if params.Priority != nil {
+		*priority = *params.Priority
+	}
+	if params.ReadPriority != nil {
+		*readPriority = *params.ReadPriority
+	}
+	return nil
+}
+
+// UpdatePieceStorage replaces the storage by the one created by the new config, it's probed before replacing
+func (p *PieceStorageManager) UpdatePieceStorage(ctx context.Context, cfg interface{}) error {
+	st, err := NewPieceStorage(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := p.managedStorage(st.GetName()); err != nil {
+		return err
+	}
+	if err := ProbePieceStorage(ctx, st); err != nil {
+		return err
+	}
+	return p.ReplacePieceStorage(st)
+}
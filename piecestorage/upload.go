@@ -0,0 +1,667 @@
+package piecestorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+const (
+	uploadStagingDir = "upload-staging"
+
+	// the max size of piece, which is 64GiB for the largest sector
+	maxPaddedPieceSize = 64 << 30
+
+	// s3 limits the parts of a multipart upload to 10000
+	minUploadPartSize = 64 << 20
+	maxUploadParts    = 10000
+)
+
+// uploadSessionExpiry is the time to keep an upload session since it's updated last time
+var uploadSessionExpiry = 24 * time.Hour
+
+var (
+	ErrUploadNotFound       = errors.New("upload session not found")
+	ErrUploadOffsetMismatch = errors.New("chunk doesn't start at the offset of upload")
+	ErrUploadBusy           = errors.New("another chunk of the upload is being written")
+)
+
+// multipartUploader is implemented by the object storages which the data can be uploaded to directly
+type multipartUploader interface {
+	createMultipartUpload(ctx context.Context, resourceId string) (string, error)
+	presignUploadPart(resourceId, uploadId string, number int64) (string, error)
+	completeMultipartUpload(ctx context.Context, resourceId, uploadId string, parts []*mtypes.PieceUploadPart) error
+	abortMultipartUpload(ctx context.Context, resourceId, uploadId string) error
+	// moveObject renames the object, the object at the new key is replaced
+	moveObject(ctx context.Context, from, to string) error
+}
+
+// PieceUploadParams is the parameters to create an upload session
+type PieceUploadParams struct {
+	ResourceID string
+	// The piece storage to save the resource, selected by the write strategy if empty
+	Store  string
+	Size   int64
+	Sha256 string
+	// Upload to the object storage directly by presigned urls, if the target storage is an object storage
+	Redirect bool
+}
+
+type uploadSession struct {
+	mtypes.PieceUploadSession
+	// the id of multipart upload in object storage
+	MultipartID string `json:",omitempty"`
+	// the multipart upload is completed to the staging key, and the object is moved to the resource id after
+	// it's verified, so that an existing resource isn't overwritten by broken data
+	StagingKey string `json:",omitempty"`
+
+	// a chunk is being written or the multipart upload is being completed
+	writing bool
+}
+
+func (s *uploadSession) copy() *mtypes.PieceUploadSession {
+	out := s.PieceUploadSession
+	out.Parts = append([]*mtypes.PieceUploadPart(nil), s.Parts...)
+	return &out
+}
+
+// PieceUploader receives the resources by chunks, the chunks are staged in the repo of droplet,
+// and the resource is saved to the piece storage after all the chunks are received and verified
+type PieceUploader struct {
+	mgr *PieceStorageManager
+	dir string
+
+	lk       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+func NewPieceUploader(homeDir *config.HomeDir, mgr *PieceStorageManager) (*PieceUploader, error) {
+	return newPieceUploader(filepath.Join(string(*homeDir), uploadStagingDir), mgr)
+}
+
+func newPieceUploader(dir string, mgr *PieceStorageManager) (*PieceUploader, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create upload staging dir: %w", err)
+	}
+	u := &PieceUploader{
+		mgr:      mgr,
+		dir:      dir,
+		sessions: make(map[string]*uploadSession),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read upload session %s: %w", file, err)
+		}
+		s := &uploadSession{}
+		if err := json.Unmarshal(data, s); err != nil {
+			log.Warnf("remove broken upload session %s: %v", file, err)
+			u.removeFiles(strings.TrimSuffix(filepath.Base(file), ".json"))
+			continue
+		}
+		u.sessions[s.ID] = s
+	}
+	u.expire(context.Background(), time.Now())
+
+	// the publishing interrupted by restart goes on
+	for _, s := range u.sessions {
+		if s.State == mtypes.PieceUploadPublishing {
+			go u.publish(context.Background(), s)
+		}
+	}
+	return u, nil
+}
+
+func (u *PieceUploader) dataPath(id string) string {
+	return filepath.Join(u.dir, id+".data")
+}
+
+func (u *PieceUploader) sessionPath(id string) string {
+	return filepath.Join(u.dir, id+".json")
+}
+
+func (u *PieceUploader) removeFiles(id string) {
+	for _, file := range []string{u.dataPath(id), u.sessionPath(id)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Warnf("remove upload file %s: %v", file, err)
+		}
+	}
+}
+
+func (u *PieceUploader) saveLocked(s *uploadSession) error {
+	s.UpdatedAt = time.Now()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := u.sessionPath(s.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("save upload session: %w", err)
+	}
+	return os.Rename(tmp, u.sessionPath(s.ID))
+}
+
+// expire removes the sessions which are not updated for a long time
+func (u *PieceUploader) expire(ctx context.Context, now time.Time) {
+	u.lk.Lock()
+	var expired []*uploadSession
+	for id, s := range u.sessions {
+		if !s.writing && s.State != mtypes.PieceUploadPublishing && now.Sub(s.UpdatedAt) > uploadSessionExpiry {
+			expired = append(expired, s)
+			delete(u.sessions, id)
+		}
+	}
+	u.lk.Unlock()
+
+	for _, s := range expired {
+		log.Infof("remove expired upload session %s of %s", s.ID, s.ResourceID)
+		u.abortMultipart(ctx, s)
+		u.removeFiles(s.ID)
+	}
+}
+
+func (u *PieceUploader) abortMultipart(ctx context.Context, s *uploadSession) {
+	if len(s.MultipartID) == 0 || s.State != mtypes.PieceUploadUploading {
+		return
+	}
+	st, err := u.mgr.GetPieceStorageByName(s.Store)
+	if err != nil {
+		log.Warnf("abort multipart upload of %s: %v", s.ResourceID, err)
+		return
+	}
+	if mu, ok := unwrapStorage(st).(multipartUploader); ok {
+		if err := mu.abortMultipartUpload(ctx, s.StagingKey, s.MultipartID); err != nil {
+			log.Warnf("abort multipart upload of %s: %v", s.ResourceID, err)
+		}
+	}
+}
+
+// Create starts an upload session
+func (u *PieceUploader) Create(ctx context.Context, params *PieceUploadParams) (*mtypes.PieceUploadSession, error) {
+	if len(params.ResourceID) == 0 {
+		return nil, fmt.Errorf("resource id is empty")
+	}
+	if params.Size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	if len(params.Sha256) > 0 {
+		if b, err := hex.DecodeString(params.Sha256); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 digest %s", params.Sha256)
+		}
+	}
+	u.expire(ctx, time.Now())
+
+	var target IPieceStorage
+	if len(params.Store) > 0 {
+		st, err := u.mgr.GetPieceStorageByName(params.Store)
+		if err != nil {
+			return nil, err
+		}
+		if st.ReadOnly() {
+			return nil, fmt.Errorf("piece storage %s is readonly", params.Store)
+		}
+		target = st
+	}
+
+	now := time.Now()
+	s := &uploadSession{
+		PieceUploadSession: mtypes.PieceUploadSession{
+			ID:         uuid.New().String(),
+			ResourceID: params.ResourceID,
+			Store:      params.Store,
+			Size:       params.Size,
+			Sha256:     strings.ToLower(params.Sha256),
+			State:      mtypes.PieceUploadUploading,
+			CreatedAt:  now,
+		},
+	}
+
+	if params.Redirect {
+		if target == nil {
+			st, release, err := u.mgr.FindStorageForWrite(address.Undef, params.Size)
+			if err != nil {
+				return nil, err
+			}
+			// the space can't be reserved for a long time upload
+			release()
+			target = st
+		}
+		if mu, ok := unwrapStorage(target).(multipartUploader); ok {
+			if err := u.createMultipart(ctx, s, target.GetName(), mu); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(s.MultipartID) == 0 {
+		f, err := os.Create(u.dataPath(s.ID))
+		if err != nil {
+			return nil, fmt.Errorf("create staging file: %w", err)
+		}
+		_ = f.Close()
+	}
+
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	if err := u.saveLocked(s); err != nil {
+		u.removeFiles(s.ID)
+		return nil, err
+	}
+	u.sessions[s.ID] = s
+	return s.copy(), nil
+}
+
+func (u *PieceUploader) createMultipart(ctx context.Context, s *uploadSession, store string, mu multipartUploader) error {
+	partSize := int64(minUploadPartSize)
+	if s.Size > partSize*maxUploadParts {
+		partSize = (s.Size + maxUploadParts - 1) / maxUploadParts
+	}
+
+	s.StagingKey = fmt.Sprintf("%s.upload-%s", s.ResourceID, s.ID)
+	multipartID, err := mu.createMultipartUpload(ctx, s.StagingKey)
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+	for number, offset := int64(1), int64(0); offset < s.Size; number, offset = number+1, offset+partSize {
+		size := partSize
+		if offset+size > s.Size {
+			size = s.Size - offset
+		}
+		url, err := mu.presignUploadPart(s.StagingKey, multipartID, number)
+		if err != nil {
+			_ = mu.abortMultipartUpload(ctx, s.StagingKey, multipartID)
+			return fmt.Errorf("presign part %d: %w", number, err)
+		}
+		s.Parts = append(s.Parts, &mtypes.PieceUploadPart{Number: number, Offset: offset, Size: size, Url: url})
+	}
+	s.Store = store
+	s.MultipartID = multipartID
+	return nil
+}
+
+// Get returns the state of an upload session
+func (u *PieceUploader) Get(id string) (*mtypes.PieceUploadSession, error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	s, ok := u.sessions[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	return s.copy(), nil
+}
+
+// acquire marks the session as writing, it fails if the session is not uploading
+func (u *PieceUploader) acquire(id string, multipart bool) (*uploadSession, error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	s, ok := u.sessions[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	if s.State != mtypes.PieceUploadUploading {
+		return nil, fmt.Errorf("upload is %s", s.State)
+	}
+	if multipart != (len(s.MultipartID) > 0) {
+		if multipart {
+			return nil, fmt.Errorf("upload is not a multipart upload")
+		}
+		return nil, fmt.Errorf("upload is a multipart upload, the parts should be uploaded to the presigned urls")
+	}
+	if s.writing {
+		return nil, ErrUploadBusy
+	}
+	s.writing = true
+	return s, nil
+}
+
+// Write appends a chunk to the upload, the chunk must start at the offset of upload, the resource is verified
+// and saved to piece storage in the background once all the data is received, the session is publishing until it's done.
+func (u *PieceUploader) Write(_ context.Context, id string, offset, length int64, r io.Reader) (*mtypes.PieceUploadSession, error) {
+	s, err := u.acquire(id, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		u.lk.Lock()
+		s.writing = false
+		u.lk.Unlock()
+	}()
+
+	if offset != s.Offset {
+		return s.copy(), fmt.Errorf("%w: expect %d, got %d", ErrUploadOffsetMismatch, s.Offset, offset)
+	}
+	if length <= 0 || offset+length > s.Size {
+		return s.copy(), fmt.Errorf("chunk %d-%d exceeds size %d", offset, offset+length-1, s.Size)
+	}
+
+	written, err := u.writeChunk(s, offset, length, r)
+	u.lk.Lock()
+	s.Offset += written
+	publishing := err == nil && s.Offset == s.Size
+	if publishing {
+		s.State = mtypes.PieceUploadPublishing
+		s.Error = ""
+	}
+	saveErr := u.saveLocked(s)
+	out := s.copy()
+	u.lk.Unlock()
+
+	// the verification of a large piece takes a long time, the request doesn't wait for it,
+	// the session is saved again when it's done
+	if publishing {
+		go u.publish(context.Background(), s)
+	}
+	if err != nil {
+		return out, err
+	}
+	if saveErr != nil {
+		return out, saveErr
+	}
+	if written < length {
+		return out, fmt.Errorf("chunk is incomplete, expect %d bytes, got %d", length, written)
+	}
+	return out, nil
+}
+
+func (u *PieceUploader) writeChunk(s *uploadSession, offset, length int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(u.dataPath(s.ID), os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("open staging file: %w", err)
+	}
+	defer f.Close() // nolint
+
+	// drop the data after offset, which is left by a failed write
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, io.LimitReader(r, length))
+	if err != nil {
+		return written, fmt.Errorf("write chunk: %w", err)
+	}
+	return written, f.Sync()
+}
+
+// publish verifies the staged data and saves it to piece storage, the session is failed if the verification fails,
+// and it's back to uploading if the data can't be saved, so that it can be retried by Republish
+func (u *PieceUploader) publish(ctx context.Context, s *uploadSession) {
+	setState := func(state mtypes.PieceUploadState, err error) {
+		u.lk.Lock()
+		defer u.lk.Unlock()
+		s.State = state
+		s.Error = ""
+		if err != nil {
+			s.Error = err.Error()
+		}
+		if saveErr := u.saveLocked(s); saveErr != nil {
+			log.Errorf("save upload session %s: %v", s.ID, saveErr)
+		}
+	}
+
+	f, err := os.Open(u.dataPath(s.ID))
+	if err != nil {
+		setState(mtypes.PieceUploadUploading, fmt.Errorf("open staging file: %w", err))
+		return
+	}
+	defer f.Close() // nolint
+
+	if err := verifyUpload(f, s.ResourceID, s.Sha256); err != nil {
+		log.Warnf("upload %s of %s is failed: %v", s.ID, s.ResourceID, err)
+		_ = os.Remove(u.dataPath(s.ID))
+		setState(mtypes.PieceUploadFailed, err)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		setState(mtypes.PieceUploadUploading, err)
+		return
+	}
+
+	store, err := u.save(ctx, s, f)
+	if err != nil {
+		log.Warnf("save upload %s of %s: %v", s.ID, s.ResourceID, err)
+		setState(mtypes.PieceUploadUploading, err)
+		return
+	}
+	u.lk.Lock()
+	s.Store = store
+	u.lk.Unlock()
+	u.mgr.invalidateLocation(s.ResourceID)
+	_ = os.Remove(u.dataPath(s.ID))
+	setState(mtypes.PieceUploadPublished, nil)
+	log.Infof("upload %s of %s is published to %s", s.ID, s.ResourceID, store)
+}
+
+// save saves the resource to the piece storage of the session, or the one selected by the write strategy,
+// and returns the name of the piece storage
+func (u *PieceUploader) save(ctx context.Context, s *uploadSession, r io.Reader) (string, error) {
+	var st IPieceStorage
+	if len(s.Store) > 0 {
+		var err error
+		if st, err = u.mgr.GetPieceStorageByName(s.Store); err != nil {
+			return "", err
+		}
+	} else {
+		var release func()
+		var err error
+		if st, release, err = u.mgr.FindStorageForWrite(address.Undef, s.Size); err != nil {
+			return "", err
+		}
+		defer release()
+	}
+
+	written, err := st.SaveTo(ctx, s.ResourceID, r)
+	if err != nil {
+		return "", fmt.Errorf("save to %s: %w", st.GetName(), err)
+	}
+	if written != s.Size {
+		return "", fmt.Errorf("saved %d bytes to %s, expect %d", written, st.GetName(), s.Size)
+	}
+	return st.GetName(), nil
+}
+
+// Republish retries to save the upload whose data is all received but failed to be saved, the session is publishing
+// until it's done in the background
+func (u *PieceUploader) Republish(_ context.Context, id string) (*mtypes.PieceUploadSession, error) {
+	s, err := u.acquire(id, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		u.lk.Lock()
+		s.writing = false
+		u.lk.Unlock()
+	}()
+
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	if s.Offset != s.Size {
+		return s.copy(), fmt.Errorf("upload is incomplete, %d of %d bytes received", s.Offset, s.Size)
+	}
+	s.State = mtypes.PieceUploadPublishing
+	s.Error = ""
+	err = u.saveLocked(s)
+	out := s.copy()
+	go u.publish(context.Background(), s)
+	return out, err
+}
+
+// Complete completes the multipart upload to object storage, the object is moved to the resource id after it's
+// verified, and it's deleted if it fails to be verified
+func (u *PieceUploader) Complete(ctx context.Context, id string, parts []*mtypes.PieceUploadPart) (*mtypes.PieceUploadSession, error) {
+	s, err := u.acquire(id, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		u.lk.Lock()
+		s.writing = false
+		u.lk.Unlock()
+	}()
+
+	// only the ETags are taken from the request
+	etags := make(map[int64]string, len(parts))
+	for _, part := range parts {
+		etags[part.Number] = part.ETag
+	}
+	completed := make([]*mtypes.PieceUploadPart, 0, len(s.Parts))
+	for _, part := range s.Parts {
+		etag := etags[part.Number]
+		if len(etag) == 0 {
+			return s.copy(), fmt.Errorf("missing ETag of part %d", part.Number)
+		}
+		completed = append(completed, &mtypes.PieceUploadPart{Number: part.Number, Offset: part.Offset, Size: part.Size, ETag: etag})
+	}
+	st, err := u.mgr.GetPieceStorageByName(s.Store)
+	if err != nil {
+		return s.copy(), err
+	}
+	mu, ok := unwrapStorage(st).(multipartUploader)
+	if !ok {
+		return s.copy(), fmt.Errorf("piece storage %s doesn't support multipart upload", s.Store)
+	}
+	key := s.StagingKey
+	if err := mu.completeMultipartUpload(ctx, key, s.MultipartID, completed); err != nil {
+		return s.copy(), fmt.Errorf("complete multipart upload: %w", err)
+	}
+
+	verify := func() error {
+		size, err := st.Len(ctx, key)
+		if err != nil {
+			return err
+		}
+		if size != s.Size {
+			return fmt.Errorf("uploaded %d bytes, expect %d", size, s.Size)
+		}
+		r, err := st.GetReaderCloser(ctx, key)
+		if err != nil {
+			return err
+		}
+		defer r.Close() // nolint
+		return verifyUpload(r, s.ResourceID, s.Sha256)
+	}
+
+	err = verify()
+	if err == nil {
+		if err = mu.moveObject(ctx, key, s.ResourceID); err != nil {
+			err = fmt.Errorf("move %s to %s: %w", key, s.ResourceID, err)
+		}
+	}
+
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	s.Offset = s.Size
+	if err != nil {
+		log.Warnf("upload %s of %s is failed: %v", s.ID, s.ResourceID, err)
+		if delErr := st.Delete(ctx, key); delErr != nil {
+			log.Errorf("delete unverified upload %s from %s: %v", key, s.Store, delErr)
+		}
+		s.State = mtypes.PieceUploadFailed
+		s.Error = err.Error()
+	} else {
+		s.State = mtypes.PieceUploadPublished
+		u.mgr.invalidateLocation(s.ResourceID)
+	}
+	if err := u.saveLocked(s); err != nil {
+		return s.copy(), err
+	}
+	return s.copy(), nil
+}
+
+// Abort removes an upload session and its staged data
+func (u *PieceUploader) Abort(ctx context.Context, id string) error {
+	u.lk.Lock()
+	s, ok := u.sessions[id]
+	if !ok {
+		u.lk.Unlock()
+		return ErrUploadNotFound
+	}
+	if s.writing || s.State == mtypes.PieceUploadPublishing {
+		u.lk.Unlock()
+		return ErrUploadBusy
+	}
+	delete(u.sessions, id)
+	u.lk.Unlock()
+
+	u.abortMultipart(ctx, s)
+	u.removeFiles(id)
+	return nil
+}
+
+// verifyUpload checks the sha256 digest if it's set, and the commP if the resource is a piece cid
+func verifyUpload(r io.Reader, resourceID, sha256Hex string) error {
+	var writers []io.Writer
+	digest := sha256.New()
+	if len(sha256Hex) > 0 {
+		writers = append(writers, digest)
+	}
+	pieceCid, err := cid.Decode(resourceID)
+	isPiece := err == nil && pieceCid.Prefix().Codec == cid.FilCommitmentUnsealed
+	cp := &commp.Calc{}
+	if isPiece {
+		writers = append(writers, cp)
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return fmt.Errorf("read data: %w", err)
+	}
+	if len(sha256Hex) > 0 {
+		if actual := hex.EncodeToString(digest.Sum(nil)); actual != sha256Hex {
+			return fmt.Errorf("sha256 mismatch, got %s", actual)
+		}
+	}
+	if isPiece {
+		raw, paddedSize, err := cp.Digest()
+		if err != nil {
+			return fmt.Errorf("calculate commP: %w", err)
+		}
+		if !matchCommP(raw, paddedSize, pieceCid) {
+			actual, _ := commcid.DataCommitmentV1ToCID(raw)
+			return fmt.Errorf("commP mismatch, got %s", actual)
+		}
+	}
+	return nil
+}
+
+// matchCommP checks the commP of data matches the piece cid, the data may be padded to a larger piece
+func matchCommP(raw []byte, paddedSize uint64, pieceCid cid.Cid) bool {
+	for size := paddedSize; size <= maxPaddedPieceSize; size *= 2 {
+		padded := raw
+		if size > paddedSize {
+			var err error
+			if padded, err = commp.PadCommP(raw, paddedSize, size); err != nil {
+				return false
+			}
+		}
+		if c, err := commcid.DataCommitmentV1ToCID(padded); err == nil && c.Equals(pieceCid) {
+			return true
+		}
+	}
+	return false
+}
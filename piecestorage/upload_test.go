@@ -0,0 +1,350 @@
+package piecestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// brokenReader returns an error after n bytes are read
+type brokenReader struct {
+	r io.Reader
+	n int
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.n == 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= n
+	return n, err
+}
+
+// multipartMemStore uploads the parts to memory, and saves the object when completed
+type multipartMemStore struct {
+	*MemPieceStore
+
+	lk    sync.Mutex
+	parts map[string]map[int64][]byte
+}
+
+func (m *multipartMemStore) createMultipartUpload(_ context.Context, resourceId string) (string, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	id := fmt.Sprintf("multipart-%s", resourceId)
+	m.parts[id] = make(map[int64][]byte)
+	return id, nil
+}
+
+func (m *multipartMemStore) presignUploadPart(_, uploadId string, number int64) (string, error) {
+	return fmt.Sprintf("mem://%s/%d", uploadId, number), nil
+}
+
+func (m *multipartMemStore) uploadPart(uploadId string, number int64, data []byte) string {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.parts[uploadId][number] = data
+	return fmt.Sprintf("etag-%d", number)
+}
+
+func (m *multipartMemStore) completeMultipartUpload(ctx context.Context, resourceId, uploadId string, parts []*mtypes.PieceUploadPart) error {
+	m.lk.Lock()
+	var buf bytes.Buffer
+	for _, part := range parts {
+		if part.ETag != fmt.Sprintf("etag-%d", part.Number) {
+			m.lk.Unlock()
+			return fmt.Errorf("invalid etag of part %d", part.Number)
+		}
+		buf.Write(m.parts[uploadId][part.Number])
+	}
+	delete(m.parts, uploadId)
+	m.lk.Unlock()
+	_, err := m.SaveTo(ctx, resourceId, &buf)
+	return err
+}
+
+func (m *multipartMemStore) moveObject(ctx context.Context, from, to string) error {
+	r, err := m.GetReaderCloser(ctx, from)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := m.Delete(ctx, to); err != nil {
+		return err
+	}
+	if _, err := m.SaveTo(ctx, to, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return m.Delete(ctx, from)
+}
+
+func (m *multipartMemStore) abortMultipartUpload(_ context.Context, _, uploadId string) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	delete(m.parts, uploadId)
+	return nil
+}
+
+// waitPublished waits for the upload to be published in the background, and returns the session then
+func waitPublished(t *testing.T, u *PieceUploader, id string) *mtypes.PieceUploadSession {
+	var session *mtypes.PieceUploadSession
+	require.Eventually(t, func() bool {
+		var err error
+		session, err = u.Get(id)
+		require.NoError(t, err)
+		return session.State != mtypes.PieceUploadPublishing
+	}, 10*time.Second, 10*time.Millisecond)
+	return session
+}
+
+func TestPieceUploader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := NewMemPieceStore("mem", &market.StorageStatus{Capacity: 1 << 30, Available: 1 << 30})
+	psm.AddMemPieceStorage(store)
+	u, err := newPieceUploader(dir, psm)
+	require.NoError(t, err)
+
+	t.Run("resume", func(t *testing.T) {
+		data, pieceCid, _ := randPiece(t)
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: pieceCid.String(), Size: int64(len(data))})
+		require.NoError(t, err)
+		assert.Equal(t, mtypes.PieceUploadUploading, session.State)
+
+		session, err = u.Write(ctx, session.ID, 0, 500, bytes.NewReader(data[:500]))
+		require.NoError(t, err)
+		assert.Equal(t, int64(500), session.Offset)
+
+		// the chunk is broken, the received data is kept
+		session, err = u.Write(ctx, session.ID, 500, 1000, &brokenReader{r: bytes.NewReader(data[500:1500]), n: 300})
+		require.Error(t, err)
+		assert.Equal(t, int64(800), session.Offset)
+
+		_, err = u.Write(ctx, session.ID, 500, 1000, bytes.NewReader(data[500:1500]))
+		assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+
+		// the session is loaded after restart
+		u2, err := newPieceUploader(dir, psm)
+		require.NoError(t, err)
+		session, err = u2.Get(session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(800), session.Offset)
+
+		session, err = u2.Write(ctx, session.ID, 800, int64(len(data)-800), bytes.NewReader(data[800:]))
+		require.NoError(t, err)
+		assert.Equal(t, mtypes.PieceUploadPublishing, session.State)
+		session = waitPublished(t, u2, session.ID)
+		assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+		assert.Equal(t, "mem", session.Store)
+		r, err := store.GetReaderCloser(ctx, pieceCid.String())
+		require.NoError(t, err)
+		saved, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, saved)
+
+		_, err = u2.Write(ctx, session.ID, 0, 1, bytes.NewReader(data))
+		assert.Error(t, err)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		data, pieceCid, size := randPiece(t)
+
+		// the commP mismatches
+		corrupt := append([]byte{}, data...)
+		corrupt[0]++
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: pieceCid.String(), Size: int64(len(data))})
+		require.NoError(t, err)
+		session, err = u.Write(ctx, session.ID, 0, int64(len(corrupt)), bytes.NewReader(corrupt))
+		require.NoError(t, err)
+		session = waitPublished(t, u, session.ID)
+		assert.Equal(t, mtypes.PieceUploadFailed, session.State)
+		assert.Contains(t, session.Error, "commP mismatch")
+		has, err := store.Has(ctx, pieceCid.String())
+		require.NoError(t, err)
+		assert.False(t, has)
+
+		// the piece is padded to a larger size
+		raw, err := commcid.CIDToDataCommitmentV1(pieceCid)
+		require.NoError(t, err)
+		raw, err = commp.PadCommP(raw, uint64(size), uint64(size*8))
+		require.NoError(t, err)
+		paddedCid, err := commcid.DataCommitmentV1ToCID(raw)
+		require.NoError(t, err)
+		session, err = u.Create(ctx, &PieceUploadParams{ResourceID: paddedCid.String(), Size: int64(len(data))})
+		require.NoError(t, err)
+		session, err = u.Write(ctx, session.ID, 0, int64(len(data)), bytes.NewReader(data))
+		require.NoError(t, err)
+		session = waitPublished(t, u, session.ID)
+		assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+
+		// the sha256 is verified for the resource which isn't a piece
+		digest := sha256.Sum256([]byte("resource"))
+		session, err = u.Create(ctx, &PieceUploadParams{ResourceID: "resource", Size: 8, Sha256: hex.EncodeToString(digest[:])})
+		require.NoError(t, err)
+		session, err = u.Write(ctx, session.ID, 0, 8, bytes.NewReader([]byte("resourcf")))
+		require.NoError(t, err)
+		session = waitPublished(t, u, session.ID)
+		assert.Equal(t, mtypes.PieceUploadFailed, session.State)
+		assert.Contains(t, session.Error, "sha256 mismatch")
+
+		_, err = u.Create(ctx, &PieceUploadParams{ResourceID: "resource", Size: 8, Sha256: "abc"})
+		assert.Error(t, err)
+	})
+
+	t.Run("republish", func(t *testing.T) {
+		data, pieceCid, _ := randPiece(t)
+		other := NewMemPieceStore("other", nil)
+		psm.AddMemPieceStorage(other)
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: pieceCid.String(), Store: "other", Size: int64(len(data))})
+		require.NoError(t, err)
+
+		require.NoError(t, psm.RemovePieceStorage(ctx, "other"))
+		session, err = u.Write(ctx, session.ID, 0, int64(len(data)), bytes.NewReader(data))
+		require.NoError(t, err)
+		session = waitPublished(t, u, session.ID)
+		assert.Equal(t, mtypes.PieceUploadUploading, session.State)
+		assert.NotEmpty(t, session.Error)
+
+		psm.AddMemPieceStorage(other)
+		session, err = u.Republish(ctx, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, mtypes.PieceUploadPublishing, session.State)
+		session = waitPublished(t, u, session.ID)
+		assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+		has, err := other.Has(ctx, pieceCid.String())
+		require.NoError(t, err)
+		assert.True(t, has)
+	})
+
+	t.Run("publish after restart", func(t *testing.T) {
+		data, pieceCid, _ := randPiece(t)
+		restarted := NewMemPieceStore("restarted", nil)
+		psm.AddMemPieceStorage(restarted)
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: pieceCid.String(), Store: "restarted", Size: int64(len(data))})
+		require.NoError(t, err)
+
+		require.NoError(t, psm.RemovePieceStorage(ctx, "restarted"))
+		session, err = u.Write(ctx, session.ID, 0, int64(len(data)), bytes.NewReader(data))
+		require.NoError(t, err)
+		waitPublished(t, u, session.ID)
+
+		// droplet is stopped during publishing, the publishing goes on after restart
+		u.lk.Lock()
+		s := u.sessions[session.ID]
+		s.State = mtypes.PieceUploadPublishing
+		require.NoError(t, u.saveLocked(s))
+		u.lk.Unlock()
+		assert.ErrorIs(t, u.Abort(ctx, session.ID), ErrUploadBusy)
+
+		psm.AddMemPieceStorage(restarted)
+		u2, err := newPieceUploader(dir, psm)
+		require.NoError(t, err)
+		session = waitPublished(t, u2, session.ID)
+		assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+		has, err := restarted.Has(ctx, pieceCid.String())
+		require.NoError(t, err)
+		assert.True(t, has)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: "aborted", Size: 10})
+		require.NoError(t, err)
+		require.NoError(t, u.Abort(ctx, session.ID))
+		_, err = u.Get(session.ID)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		assert.ErrorIs(t, u.Abort(ctx, session.ID), ErrUploadNotFound)
+	})
+}
+
+func TestPieceUploaderMultipart(t *testing.T) {
+	ctx := context.Background()
+	psm, err := NewPieceStorageManager(&config.PieceStorage{})
+	require.NoError(t, err)
+	store := &multipartMemStore{MemPieceStore: NewMemPieceStore("s3", &market.StorageStatus{Capacity: 1 << 30, Available: 1 << 30}), parts: make(map[string]map[int64][]byte)}
+	psm.AddMemPieceStorage(store)
+	u, err := newPieceUploader(t.TempDir(), psm)
+	require.NoError(t, err)
+
+	upload := func(t *testing.T, data []byte, resourceID string) *mtypes.PieceUploadSession {
+		session, err := u.Create(ctx, &PieceUploadParams{ResourceID: resourceID, Size: int64(len(data)), Redirect: true})
+		require.NoError(t, err)
+		require.Len(t, session.Parts, 1)
+		assert.Equal(t, "s3", session.Store)
+		part := session.Parts[0]
+		assert.NotEmpty(t, part.Url)
+		assert.Equal(t, int64(len(data)), part.Size)
+
+		// the data is uploaded to the object storage directly
+		_, err = u.Write(ctx, session.ID, 0, int64(len(data)), bytes.NewReader(data))
+		assert.Error(t, err)
+		uploadID := strings.Split(strings.TrimPrefix(part.Url, "mem://"), "/")[0]
+		etag := store.uploadPart(uploadID, part.Number, data)
+
+		_, err = u.Complete(ctx, session.ID, nil)
+		assert.Error(t, err)
+		session, err = u.Complete(ctx, session.ID, []*mtypes.PieceUploadPart{{Number: part.Number, ETag: etag}})
+		require.NoError(t, err)
+		return session
+	}
+
+	data, pieceCid, _ := randPiece(t)
+	session := upload(t, data, pieceCid.String())
+	assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+	has, err := store.Has(ctx, pieceCid.String())
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	// the object is deleted if it fails to be verified
+	data, pieceCid, _ = randPiece(t)
+	data[0]++
+	session = upload(t, data, pieceCid.String())
+	assert.Equal(t, mtypes.PieceUploadFailed, session.State)
+	has, err = store.Has(ctx, pieceCid.String())
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	// the existing resource isn't overwritten or deleted by a broken upload
+	data, pieceCid, _ = randPiece(t)
+	_, err = store.SaveTo(ctx, pieceCid.String(), bytes.NewReader(data))
+	require.NoError(t, err)
+	broken := append([]byte{}, data...)
+	broken[0]++
+	session = upload(t, broken, pieceCid.String())
+	assert.Equal(t, mtypes.PieceUploadFailed, session.State)
+	r, err := store.GetReaderCloser(ctx, pieceCid.String())
+	require.NoError(t, err)
+	saved, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, saved)
+
+	// only the resource is left in the storage
+	ids, err := store.ListResourceIds(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ids, 2)
+}
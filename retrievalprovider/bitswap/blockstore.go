@@ -0,0 +1,190 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-fil-markets/stores"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	blocks "github.com/ipfs/go-libipfs/blocks"
+)
+
+var errReadOnly = errors.New("bitswap blockstore is read-only")
+
+// blockstore is a read-only blockstore which resolves blocks through the top index
+// of the dagstore, and reads them from the shards containing the block
+type blockstore struct {
+	lookup *pieceLookup
+	shards *shardCache
+}
+
+var _ bstore.Blockstore = (*blockstore)(nil)
+
+func newBlockstore(dagStore stores.DAGStoreWrapper, maxShards int, lookup *pieceLookup) (*blockstore, error) {
+	shards, err := newShardCache(dagStore, maxShards)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blockstore{
+		lookup: lookup,
+		shards: shards,
+	}, nil
+}
+
+func (bs *blockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return len(bs.lookup.pieces(ctx, c)) > 0, nil
+}
+
+func (bs *blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	var blk blocks.Block
+	err := bs.view(ctx, c, func(shard bstore.Blockstore) error {
+		var err error
+		blk, err = shard.Get(ctx, c)
+		return err
+	})
+	return blk, err
+}
+
+func (bs *blockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	var size int
+	err := bs.view(ctx, c, func(shard bstore.Blockstore) error {
+		var err error
+		size, err = shard.GetSize(ctx, c)
+		return err
+	})
+	return size, err
+}
+
+// view calls the callback with the blockstore of the first shard which is able to serve the block
+func (bs *blockstore) view(ctx context.Context, c cid.Cid, cb func(shard bstore.Blockstore) error) error {
+	for _, piece := range bs.lookup.pieces(ctx, c) {
+		shard, err := bs.shards.acquire(ctx, piece)
+		if err != nil {
+			log.Warnf("load shard %s for block %s: %v", piece, c, err)
+			continue
+		}
+
+		err = cb(shard.bs)
+		bs.shards.release(shard)
+		if err == nil {
+			return nil
+		}
+		log.Debugf("read block %s from shard %s: %v", c, piece, err)
+	}
+
+	return ipld.ErrNotFound{Cid: c}
+}
+
+func (bs *blockstore) DeleteBlock(context.Context, cid.Cid) error {
+	return errReadOnly
+}
+
+func (bs *blockstore) Put(context.Context, blocks.Block) error {
+	return errReadOnly
+}
+
+func (bs *blockstore) PutMany(context.Context, []blocks.Block) error {
+	return errReadOnly
+}
+
+func (bs *blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	return nil, errors.New("bitswap blockstore does not support listing keys")
+}
+
+func (bs *blockstore) HashOnRead(bool) {}
+
+func (bs *blockstore) Close() error {
+	bs.shards.purge()
+	return nil
+}
+
+type cachedShard struct {
+	piece   cid.Cid
+	bs      stores.ClosableBlockstore
+	refs    int
+	evicted bool
+}
+
+// shardCache keeps a bounded number of shards open, the least recently used shard is
+// closed when the cache is full, or once the last reader releases it
+type shardCache struct {
+	lk       sync.Mutex
+	dagStore stores.DAGStoreWrapper
+	cache    *lru.Cache[cid.Cid, *cachedShard]
+}
+
+func newShardCache(dagStore stores.DAGStoreWrapper, size int) (*shardCache, error) {
+	sc := &shardCache{dagStore: dagStore}
+	cache, err := lru.NewWithEvict(size, sc.onEvict)
+	if err != nil {
+		return nil, fmt.Errorf("create shard cache: %w", err)
+	}
+	sc.cache = cache
+	return sc, nil
+}
+
+func (sc *shardCache) acquire(ctx context.Context, piece cid.Cid) (*cachedShard, error) {
+	sc.lk.Lock()
+	if shard, ok := sc.cache.Get(piece); ok {
+		shard.refs++
+		sc.lk.Unlock()
+		return shard, nil
+	}
+	sc.lk.Unlock()
+
+	bs, err := sc.dagStore.LoadShard(ctx, piece)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+
+	// the shard may have been loaded concurrently
+	if shard, ok := sc.cache.Get(piece); ok {
+		shard.refs++
+		sc.closeShard(&cachedShard{piece: piece, bs: bs})
+		return shard, nil
+	}
+
+	shard := &cachedShard{piece: piece, bs: bs, refs: 1}
+	sc.cache.Add(piece, shard)
+	return shard, nil
+}
+
+func (sc *shardCache) release(shard *cachedShard) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+
+	shard.refs--
+	if shard.evicted && shard.refs == 0 {
+		sc.closeShard(shard)
+	}
+}
+
+func (sc *shardCache) purge() {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+
+	sc.cache.Purge()
+}
+
+// onEvict is called by the lru cache with the lock held
+func (sc *shardCache) onEvict(_ cid.Cid, shard *cachedShard) {
+	shard.evicted = true
+	if shard.refs == 0 {
+		sc.closeShard(shard)
+	}
+}
+
+func (sc *shardCache) closeShard(shard *cachedShard) {
+	if err := shard.bs.Close(); err != nil {
+		log.Warnf("close shard %s: %v", shard.piece, err)
+	}
+}
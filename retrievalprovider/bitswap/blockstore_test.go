@@ -0,0 +1,167 @@
+package bitswap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-fil-markets/shared_testutil"
+	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
+)
+
+const carPath = "../../dagstore/fixtures/sample-rw-bs-v2.car"
+
+func setupDagStore(t *testing.T, ctx context.Context, pieces []cid.Cid) (*dagstore.MockDagStoreWrapper, []cid.Cid) {
+	carBs, err := carbs.OpenReadOnly(carPath)
+	require.NoError(t, err)
+	defer carBs.Close() // nolint
+
+	keys, err := carBs.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var blks []cid.Cid
+	for k := range keys {
+		blks = append(blks, k)
+	}
+	require.NotEmpty(t, blks)
+
+	dagStore := dagstore.NewMockDagStoreWrapper()
+	for _, piece := range pieces {
+		require.NoError(t, stores.RegisterShardSync(ctx, dagStore, piece, carPath, true))
+		for _, blk := range blks {
+			dagStore.AddBlockToPieceIndex(blk, piece)
+		}
+	}
+
+	return dagStore, blks
+}
+
+func TestBlockstore(t *testing.T) {
+	ctx := context.Background()
+	pieces := shared_testutil.GenerateCids(2)
+	dagStore, blks := setupDagStore(t, ctx, pieces)
+
+	cfg := config.DefaultMarketConfig
+	filter, err := newRequestFilter(cfg, dagStore)
+	require.NoError(t, err)
+
+	bs, err := newBlockstore(dagStore, 1, filter.lookup)
+	require.NoError(t, err)
+	defer bs.Close() // nolint
+
+	t.Run("get blocks", func(t *testing.T) {
+		for _, c := range blks {
+			has, err := bs.Has(ctx, c)
+			require.NoError(t, err)
+			require.True(t, has)
+
+			blk, err := bs.Get(ctx, c)
+			require.NoError(t, err)
+			require.Equal(t, c, blk.Cid())
+
+			size, err := bs.GetSize(ctx, c)
+			require.NoError(t, err)
+			require.Equal(t, len(blk.RawData()), size)
+		}
+	})
+
+	t.Run("unknown block", func(t *testing.T) {
+		unknown := shared_testutil.GenerateCids(1)[0]
+		has, err := bs.Has(ctx, unknown)
+		require.NoError(t, err)
+		require.False(t, has)
+
+		_, err = bs.Get(ctx, unknown)
+		require.True(t, ipld.IsNotFound(err))
+	})
+
+	t.Run("shard cache is bounded", func(t *testing.T) {
+		for _, piece := range pieces {
+			shard, err := bs.shards.acquire(ctx, piece)
+			require.NoError(t, err)
+			bs.shards.release(shard)
+		}
+		require.Equal(t, 1, bs.shards.cache.Len())
+	})
+
+	t.Run("blocked piece", func(t *testing.T) {
+		cfg := *config.DefaultMarketConfig
+		provider := *cfg.CommonProvider
+		provider.PieceCidBlocklist = pieces
+		cfg.CommonProvider = &provider
+
+		filter, err := newRequestFilter(&cfg, dagStore)
+		require.NoError(t, err)
+		bs, err := newBlockstore(dagStore, 1, filter.lookup)
+		require.NoError(t, err)
+		defer bs.Close() // nolint
+
+		has, err := bs.Has(ctx, blks[0])
+		require.NoError(t, err)
+		require.False(t, has)
+
+		_, err = bs.Get(ctx, blks[0])
+		require.True(t, ipld.IsNotFound(err))
+	})
+}
+
+func TestRequestFilter(t *testing.T) {
+	ctx := context.Background()
+	pieces := shared_testutil.GenerateCids(2)
+	dagStore, blks := setupDagStore(t, ctx, pieces)
+	p := peer.ID("peer")
+
+	t.Run("blocklist", func(t *testing.T) {
+		newFilter := func(blocklist []cid.Cid) *requestFilter {
+			cfg := *config.DefaultMarketConfig
+			provider := *cfg.CommonProvider
+			provider.PieceCidBlocklist = blocklist
+			cfg.Miners = []*config.MinerConfig{{ProviderConfig: &provider}}
+
+			filter, err := newRequestFilter(&cfg, dagStore)
+			require.NoError(t, err)
+			return filter
+		}
+
+		// the block is still served from the other piece
+		require.True(t, newFilter(pieces[:1]).Allow(p, blks[0]))
+		require.False(t, newFilter(pieces).Allow(p, blks[0]))
+		require.False(t, newFilter(nil).Allow(p, shared_testutil.GenerateCids(1)[0]))
+	})
+
+	t.Run("lookup is cached", func(t *testing.T) {
+		filter, err := newRequestFilter(config.DefaultMarketConfig, dagStore)
+		require.NoError(t, err)
+		require.True(t, filter.Allow(p, blks[0]))
+		res, ok := filter.lookup.cache.Get(blks[0])
+		require.True(t, ok)
+		require.ElementsMatch(t, pieces, res.pieces)
+	})
+
+	t.Run("peer rate limit", func(t *testing.T) {
+		cfg := *config.DefaultMarketConfig
+		cfg.Bitswap.PeerRequestsPerSecond = 1
+
+		filter, err := newRequestFilter(&cfg, dagStore)
+		require.NoError(t, err)
+		require.True(t, filter.Allow(p, blks[0]))
+		require.False(t, filter.Allow(p, blks[0]))
+		require.True(t, filter.Allow(peer.ID("other"), blks[0]))
+	})
+
+	t.Run("global rate limit", func(t *testing.T) {
+		cfg := *config.DefaultMarketConfig
+		cfg.Bitswap.MaxRequestsPerSecond = 1
+
+		filter, err := newRequestFilter(&cfg, dagStore)
+		require.NoError(t, err)
+		require.True(t, filter.Allow(p, blks[0]))
+		require.False(t, filter.Allow(peer.ID("other"), blks[0]))
+	})
+}
@@ -0,0 +1,114 @@
+package bitswap
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filecoin-project/go-fil-markets/stores"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+)
+
+// the maximum number of peers whose rate limiters are kept in memory
+const maxTrackedPeers = 4096
+
+// requestFilter decides whether a block requested by a peer should be served
+type requestFilter struct {
+	cfg    *config.MarketConfig
+	lookup *pieceLookup
+
+	global *rate.Limiter
+
+	lk    sync.Mutex
+	peers *lru.Cache[peer.ID, *rate.Limiter]
+}
+
+func newRequestFilter(cfg *config.MarketConfig, dagStore stores.DAGStoreWrapper) (*requestFilter, error) {
+	peers, err := lru.New[peer.ID, *rate.Limiter](maxTrackedPeers)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &requestFilter{
+		cfg:    cfg,
+		global: newLimiter(cfg.Bitswap.MaxRequestsPerSecond),
+		peers:  peers,
+	}
+	f.lookup, err = newPieceLookup(dagStore, f.blocked)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := int(perSecond)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// Allow implements decision.PeerBlockRequestFilter
+func (f *requestFilter) Allow(p peer.ID, c cid.Cid) bool {
+	if !f.peerLimiter(p).Allow() {
+		log.Debugf("peer %s exceeds the request rate limit", p)
+		return false
+	}
+
+	if !f.global.Allow() {
+		log.Debugf("reject request of %s from %s, exceeds the global request rate limit", c, p)
+		return false
+	}
+
+	// the block is served only if it's in a piece which is not blocked, the filter is called without a context,
+	// the lookup only reads the index and never waits for the pieces indexed on demand
+	if len(f.lookup.pieces(context.Background(), c)) == 0 {
+		log.Debugf("reject request of %s from %s, no piece allowed to serve it", c, p)
+		return false
+	}
+
+	return true
+}
+
+func (f *requestFilter) peerLimiter(p peer.ID) *rate.Limiter {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	limiter, ok := f.peers.Get(p)
+	if !ok {
+		limiter = newLimiter(f.cfg.Bitswap.PeerRequestsPerSecond)
+		f.peers.Add(p, limiter)
+	}
+	return limiter
+}
+
+// blocked checks the cid against the piece cid blocklists of all miners, the same
+// lists used to reject storage deals
+func (f *requestFilter) blocked(c cid.Cid) bool {
+	if f.cfg.CommonProvider != nil && contains(f.cfg.CommonProvider.PieceCidBlocklist, c) {
+		return true
+	}
+	for _, miner := range f.cfg.Miners {
+		if miner.ProviderConfig != nil && contains(miner.PieceCidBlocklist, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []cid.Cid, c cid.Cid) bool {
+	for _, item := range list {
+		if item.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package bitswap
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/stores"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
+)
+
+const (
+	// the maximum number of blocks whose pieces are kept in memory
+	maxCachedLookups = 4096
+	// a block is looked up when the request is filtered and again when it's read,
+	// the pieces found are kept for a while to look them up only once
+	lookupCacheTTL = 30 * time.Second
+)
+
+type pieceLookupResult struct {
+	pieces []cid.Cid
+	at     time.Time
+}
+
+// pieceLookup finds the pieces containing a block which are allowed to be served
+type pieceLookup struct {
+	dagStore stores.DAGStoreWrapper
+	blocked  func(c cid.Cid) bool
+	cache    *lru.Cache[cid.Cid, pieceLookupResult]
+}
+
+func newPieceLookup(dagStore stores.DAGStoreWrapper, blocked func(c cid.Cid) bool) (*pieceLookup, error) {
+	cache, err := lru.New[cid.Cid, pieceLookupResult](maxCachedLookups)
+	if err != nil {
+		return nil, err
+	}
+	return &pieceLookup{
+		dagStore: dagStore,
+		blocked:  blocked,
+		cache:    cache,
+	}, nil
+}
+
+// pieces returns the pieces containing the block which are not in the piece cid blocklists
+func (l *pieceLookup) pieces(ctx context.Context, c cid.Cid) []cid.Cid {
+	if res, ok := l.cache.Get(c); ok && time.Since(res.at) < lookupCacheTTL {
+		return res.pieces
+	}
+
+	var allowed []cid.Cid
+	if !l.blocked(c) {
+		// bitswap serves anyone without a retrieval deal, so the pieces are not indexed on demand for it
+		pieces, err := dagstore.IndexedPiecesContainingBlock(ctx, l.dagStore, c)
+		if err != nil {
+			log.Debugf("get pieces containing block %s: %v", c, err)
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+		for _, piece := range pieces {
+			if !l.blocked(piece) {
+				allowed = append(allowed, piece)
+			}
+		}
+	}
+	l.cache.Add(c, pieceLookupResult{pieces: allowed, at: time.Now()})
+	return allowed
+}
@@ -0,0 +1,110 @@
+package bitswap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-fil-markets/stores"
+	bsnetwork "github.com/ipfs/go-libipfs/bitswap/network"
+	bsserver "github.com/ipfs/go-libipfs/bitswap/server"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+
+	"github.com/ipfs-force-community/metrics"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/network"
+)
+
+var log = logging.Logger("bitswap")
+
+// Server serves the blocks of deal data over bitswap
+type Server struct {
+	host       host.Host
+	standalone bool
+	network    bsnetwork.BitSwapNetwork
+	server     *bsserver.Server
+	bs         *blockstore
+}
+
+// NewServer creates the bitswap server, it returns nil if bitswap is disabled in the config.
+// The server runs on a standalone libp2p peer if listen addresses are configured for it,
+// otherwise it shares the libp2p host of droplet.
+func NewServer(mCtx metrics.MetricsCtx, lc fx.Lifecycle, h host.Host, cfg *config.MarketConfig, dagStore stores.DAGStoreWrapper) (*Server, error) {
+	if !cfg.Bitswap.Enable {
+		return nil, nil
+	}
+
+	filter, err := newRequestFilter(cfg, dagStore)
+	if err != nil {
+		return nil, err
+	}
+
+	maxShards := cfg.Bitswap.MaxCachedShards
+	if maxShards <= 0 {
+		maxShards = config.DefaultMarketConfig.Bitswap.MaxCachedShards
+	}
+	bs, err := newBlockstore(dagStore, maxShards, filter.lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{host: h, bs: bs}
+	if len(cfg.Bitswap.Libp2p.ListenAddresses) > 0 {
+		s.host, err = network.StandaloneHost(cfg, &cfg.Bitswap.Libp2p)
+		if err != nil {
+			return nil, fmt.Errorf("create bitswap host: %w", err)
+		}
+		s.standalone = true
+	}
+
+	opts := []bsserver.Option{
+		bsserver.ProvideEnabled(false),
+		bsserver.WithPeerBlockRequestFilter(filter.Allow),
+	}
+	if cfg.Bitswap.TaskWorkerCount > 0 {
+		opts = append(opts, bsserver.TaskWorkerCount(cfg.Bitswap.TaskWorkerCount))
+	}
+
+	ctx := metrics.LifecycleCtx(mCtx, lc)
+	s.network = bsnetwork.NewFromIpfsHost(s.host, nil)
+	s.server = bsserver.New(ctx, s.network, bs, opts...)
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.network.Start(s.server)
+			log.Infof("bitswap server started, peer id: %s, addresses: %v", s.host.ID(), s.host.Addrs())
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.Close()
+		},
+	})
+
+	return s, nil
+}
+
+// Addrs returns the addresses through which the bitswap peer can be reached
+func (s *Server) Addrs() ([]multiaddr.Multiaddr, error) {
+	return peer.AddrInfoToP2pAddrs(&peer.AddrInfo{
+		ID:    s.host.ID(),
+		Addrs: s.host.Addrs(),
+	})
+}
+
+func (s *Server) Close() error {
+	s.network.Stop()
+	if err := s.server.Close(); err != nil {
+		log.Warnf("close bitswap server: %v", err)
+	}
+	if err := s.bs.Close(); err != nil {
+		log.Warnf("close bitswap blockstore: %v", err)
+	}
+	if s.standalone {
+		return s.host.Close()
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package retrievalprovider
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/ipfs-force-community/metrics"
 
+	"github.com/ipfs-force-community/sophon-auth/core"
 	"github.com/ipfs-force-community/sophon-auth/jwtclient"
 
 	"github.com/ipfs-force-community/sophon-gateway/marketevent"
@@ -12,15 +19,174 @@ import (
 	"github.com/ipfs-force-community/sophon-gateway/validator"
 
 	gatewayAPIV2 "github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
+	sharedTypes "github.com/filecoin-project/venus/venus-shared/types"
+	gtypes "github.com/filecoin-project/venus/venus-shared/types/gateway"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
 )
 
-func NewMarketEventStream(mCtx metrics.MetricsCtx, authClient *jwtclient.AuthClient) gatewayAPIV2.IMarketEvent {
+const marketEventQueueSize = 30
+
+// the sealers of a miner are skipped for a while after they failed to read a piece
+var sectorReadBackoff = 10 * time.Minute
+
+// the time to wait for a sealer to reply whether it supports reading pieces after it connects,
+// the sealers which don't support it may never reply
+var sectorReadNegotiateTimeout = 30 * time.Second
+
+var errNoSealer = errors.New("no sealer can read the sector")
+
+var _ gatewayAPIV2.IMarketEvent = (*MarketEventStream)(nil)
+
+// MarketEventStream is the market event stream of sophon-gateway which the sealers connect to,
+// it also sends the requests to read the unsealed pieces from the sectors to the sealers
+type MarketEventStream struct {
+	*marketevent.MarketEventStream
+
+	lk sync.Mutex
+	// the connections of the sealers which support reading pieces
+	channels map[address.Address]map[sharedTypes.UUID]*types.ChannelInfo
+	// the time when the sealers of the miner failed to read a piece
+	readFailed map[address.Address]time.Time
+}
 
+func NewMarketEventStream(mCtx metrics.MetricsCtx, authClient *jwtclient.AuthClient) *MarketEventStream {
 	marketStream := marketevent.NewMarketEventStream(mCtx, validator.NewMinerValidator(authClient), &types.RequestConfig{
-		RequestQueueSize: 30,
+		RequestQueueSize: marketEventQueueSize,
 		RequestTimeout:   time.Hour * 7, // wait seven hour to do unseal
 		ClearInterval:    time.Minute * 5,
 	})
 
-	return marketStream
+	return &MarketEventStream{
+		MarketEventStream: marketStream,
+		channels:          make(map[address.Address]map[sharedTypes.UUID]*types.ChannelInfo),
+		readFailed:        make(map[address.Address]time.Time),
+	}
+}
+
+// ListenMarketEvent forwards the events of sophon-gateway to the sealer, and records the connection of the sealer
+// to send the read requests, which are merged into the events
+func (s *MarketEventStream) ListenMarketEvent(ctx context.Context, policy *gtypes.MarketRegisterPolicy) (<-chan *gtypes.RequestEvent, error) {
+	in, err := s.MarketEventStream.ListenMarketEvent(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _ := core.CtxGetTokenLocation(ctx)
+	// the requests are sent to reqs instead of out, so they are never sent to out after it's closed
+	reqs := make(chan *gtypes.RequestEvent, marketEventQueueSize)
+	channel := types.NewChannelInfo(ctx, ip, reqs)
+	go s.negotiate(ctx, policy.Miner, channel)
+
+	out := make(chan *gtypes.RequestEvent, marketEventQueueSize)
+	go func() {
+		defer close(out)
+		defer s.removeChannel(policy.Miner, channel)
+
+		for {
+			var event *gtypes.RequestEvent
+			select {
+			case e, ok := <-in:
+				if !ok {
+					return
+				}
+				event = e
+			case event = <-reqs:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// negotiate asks the sealer whether it supports reading pieces, the read requests are only sent to the sealers
+// which support it, so they never wait for the sealers which don't understand them
+func (s *MarketEventStream) negotiate(ctx context.Context, miner address.Address, channel *types.ChannelInfo) {
+	ctx, cancel := context.WithTimeout(ctx, sectorReadNegotiateTimeout)
+	defer cancel()
+	if err := s.SendRequest(ctx, []*types.ChannelInfo{channel}, mtypes.SectorsReadPieceSupportedMethod, nil, nil); err != nil {
+		log.Debugf("sealer %s of %s doesn't support reading pieces: %v", channel.ChannelId, miner, err)
+		return
+	}
+	if s.addChannel(miner, channel) {
+		log.Infof("sealer %s of %s supports reading pieces", channel.ChannelId, miner)
+	}
+}
+
+// SectorsReadPiece reads a range of the unsealed piece from the sector through the sealers of the miner
+func (s *MarketEventStream) SectorsReadPiece(ctx context.Context, req *mtypes.SectorReadRequest) ([]byte, error) {
+	channels := s.readChannels(req.Miner)
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("%w %d of %s", errNoSealer, req.Sid, req.Miner)
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := s.SendRequest(ctx, channels, mtypes.SectorsReadPieceMethod, payload, &data); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			s.lk.Lock()
+			s.readFailed[req.Miner] = time.Now()
+			s.lk.Unlock()
+		}
+		return nil, fmt.Errorf("failed to read piece %s from sector %d of %s: %w", req.PieceCid, req.Sid, req.Miner, err)
+	}
+	if uint64(len(data)) != req.Size {
+		return nil, fmt.Errorf("read %d bytes of piece %s from sector %d of %s, expect %d", len(data), req.PieceCid, req.Sid, req.Miner, req.Size)
+	}
+	return data, nil
+}
+
+// readChannels returns the connections of the sealers of the miner, nil if they failed to read a piece recently
+func (s *MarketEventStream) readChannels(miner address.Address) []*types.ChannelInfo {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if failed, ok := s.readFailed[miner]; ok {
+		if time.Since(failed) < sectorReadBackoff {
+			return nil
+		}
+		delete(s.readFailed, miner)
+	}
+	channels := make([]*types.ChannelInfo, 0, len(s.channels[miner]))
+	for _, ch := range s.channels[miner] {
+		channels = append(channels, ch)
+	}
+	return channels
+}
+
+// addChannel adds the connection of the sealer, it returns false if the connection has been closed
+func (s *MarketEventStream) addChannel(miner address.Address, channel *types.ChannelInfo) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	// the connection is removed after it's closed, so it's never added back
+	if channel.Ctx.Err() != nil {
+		return false
+	}
+	if _, ok := s.channels[miner]; !ok {
+		s.channels[miner] = make(map[sharedTypes.UUID]*types.ChannelInfo)
+	}
+	s.channels[miner][channel.ChannelId] = channel
+	// a new sealer may be able to read the pieces
+	delete(s.readFailed, miner)
+	return true
+}
+
+func (s *MarketEventStream) removeChannel(miner address.Address, channel *types.ChannelInfo) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.channels[miner], channel.ChannelId)
+	if len(s.channels[miner]) == 0 {
+		delete(s.channels, miner)
+	}
 }
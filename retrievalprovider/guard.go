@@ -0,0 +1,394 @@
+package retrievalprovider
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/time/rate"
+
+	"github.com/ipfs-force-community/metrics"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	marketMetrics "github.com/ipfs-force-community/droplet/v2/metrics"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+)
+
+// the number of peers whose query rate limiter is kept
+const queryLimiterCacheSize = 4096
+
+// the violation record of a peer is dropped after the peer keeps quiet for the duration
+var violationRetention = 24 * time.Hour
+
+// RetrievalGuard enforces the limits of config.RetrievalLimits on retrieval peers, and bans
+// the peers which violate the limits repeatedly
+type RetrievalGuard struct {
+	cfg        *config.RetrievalLimits
+	metricsCtx metrics.MetricsCtx
+
+	lk            sync.Mutex
+	peers         map[peer.ID]*peerGuardState
+	queryLimiters *lru.Cache[peer.ID, *rate.Limiter]
+	// the number of blocks sent of ongoing deals
+	blocksSent map[retrievalmarket.ProviderDealIdentifier]uint64
+	lastPrune  time.Time
+}
+
+type peerGuardState struct {
+	mtypes.RetrievalPeerViolations
+
+	// the start of current violation window, and the violations in it
+	windowStart      time.Time
+	windowViolations uint64
+	active           map[retrievalmarket.DealID]struct{}
+}
+
+func NewRetrievalGuard(mCtx metrics.MetricsCtx, cfg *config.MarketConfig) (*RetrievalGuard, error) {
+	queryLimiters, err := lru.New[peer.ID, *rate.Limiter](queryLimiterCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RetrievalGuard{
+		cfg:           &cfg.RetrievalLimits,
+		metricsCtx:    mCtx,
+		peers:         make(map[peer.ID]*peerGuardState),
+		queryLimiters: queryLimiters,
+		blocksSent:    make(map[retrievalmarket.ProviderDealIdentifier]uint64),
+	}, nil
+}
+
+// AllowQuery checks whether the peer is banned or queries too frequently
+func (g *RetrievalGuard) AllowQuery(p peer.ID) error {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	if err := g.checkBanned(p); err != nil {
+		return err
+	}
+	if g.cfg.PeerQueriesPerSecond <= 0 {
+		return nil
+	}
+
+	limiter, ok := g.queryLimiters.Get(p)
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(g.cfg.PeerQueriesPerSecond), burst(g.cfg.PeerQueriesPerSecond))
+		g.queryLimiters.Add(p, limiter)
+	}
+	if !limiter.Allow() {
+		return g.violate(p, mtypes.RetrievalViolationQueryRate, fmt.Errorf("query rate exceeds %v per second", g.cfg.PeerQueriesPerSecond))
+	}
+	return nil
+}
+
+// AcquireRetrieval checks the selector of a new retrieval deal, and the number of ongoing deals of
+// the peer, the deal is counted as ongoing until ReleaseRetrieval is called
+func (g *RetrievalGuard) AcquireRetrieval(p peer.ID, dealID retrievalmarket.DealID, selector datamodel.Node) error {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	if err := g.checkBanned(p); err != nil {
+		return err
+	}
+	if limit := g.cfg.MaxSelectorDepth; limit > 0 {
+		if depth := selectorDepth(selector, limit); depth > limit {
+			return g.violate(p, mtypes.RetrievalViolationSelectorDepth, fmt.Errorf("selector depth exceeds %d", limit))
+		}
+	}
+	if limit := g.cfg.MaxRecursionDepth; limit > 0 {
+		if err := checkRecursionLimits(selector, int64(limit)); err != nil {
+			return g.violate(p, mtypes.RetrievalViolationSelectorRecursion, err)
+		}
+	}
+
+	state := g.peerState(p)
+	if limit := g.cfg.PeerMaxConcurrentRetrievals; limit > 0 && len(state.active) >= limit {
+		return g.violate(p, mtypes.RetrievalViolationConcurrentRetrievals, fmt.Errorf("ongoing retrievals exceed %d", limit))
+	}
+	state.active[dealID] = struct{}{}
+	state.ActiveRetrievals = len(state.active)
+	return nil
+}
+
+// ReleaseRetrieval is called when the deal is finished
+func (g *RetrievalGuard) ReleaseRetrieval(p peer.ID, dealID retrievalmarket.DealID) {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	delete(g.blocksSent, retrievalmarket.ProviderDealIdentifier{Receiver: p, DealID: dealID})
+	state, ok := g.peers[p]
+	if !ok {
+		return
+	}
+	delete(state.active, dealID)
+	state.ActiveRetrievals = len(state.active)
+}
+
+// OnDataSent checks the blocks and bytes sent of the deal, it is called for every block sent
+func (g *RetrievalGuard) OnDataSent(deal *types.ProviderDealState, additionalBytes uint64) error {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	id := deal.Identifier()
+	g.blocksSent[id]++
+	if limit := g.cfg.MaxBlocksPerDeal; limit > 0 && g.blocksSent[id] > limit {
+		return g.violate(deal.Receiver, mtypes.RetrievalViolationBlocksPerDeal, fmt.Errorf("blocks sent exceed %d", limit))
+	}
+	if limit := g.cfg.MaxBytesPerDeal; limit > 0 && deal.TotalSent+additionalBytes > limit {
+		return g.violate(deal.Receiver, mtypes.RetrievalViolationBytesPerDeal, fmt.Errorf("bytes sent exceed %d", limit))
+	}
+	return nil
+}
+
+// ListViolations returns the peers which violated the limits, banned peers first
+func (g *RetrievalGuard) ListViolations() []*mtypes.RetrievalPeerViolations {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	now := time.Now()
+	out := make([]*mtypes.RetrievalPeerViolations, 0, len(g.peers))
+	for _, state := range g.peers {
+		if len(state.Violations) == 0 {
+			continue
+		}
+		v := state.RetrievalPeerViolations
+		v.Violations = make(map[mtypes.RetrievalViolation]uint64, len(state.Violations))
+		for kind, count := range state.Violations {
+			v.Violations[kind] = count
+		}
+		out = append(out, &v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Banned(now) != out[j].Banned(now) {
+			return out[i].Banned(now)
+		}
+		return out[i].LastViolation.After(out[j].LastViolation)
+	})
+	return out
+}
+
+// Unban removes the peer from the ban list, and resets its violation window
+func (g *RetrievalGuard) Unban(p peer.ID) error {
+	g.lk.Lock()
+	defer g.lk.Unlock()
+
+	state, ok := g.peers[p]
+	if !ok || !state.Banned(time.Now()) {
+		return fmt.Errorf("peer %s is not banned", p)
+	}
+	state.BannedUntil = time.Time{}
+	state.windowViolations = 0
+	g.recordBannedPeers()
+	return nil
+}
+
+func (g *RetrievalGuard) peerState(p peer.ID) *peerGuardState {
+	state, ok := g.peers[p]
+	if !ok {
+		state = &peerGuardState{
+			RetrievalPeerViolations: mtypes.RetrievalPeerViolations{
+				Peer:       p,
+				Violations: make(map[mtypes.RetrievalViolation]uint64),
+			},
+			active: make(map[retrievalmarket.DealID]struct{}),
+		}
+		g.peers[p] = state
+	}
+	return state
+}
+
+func (g *RetrievalGuard) checkBanned(p peer.ID) error {
+	state, ok := g.peers[p]
+	if ok && state.Banned(time.Now()) {
+		return fmt.Errorf("peer %s is banned until %s", p, state.BannedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// violate records the violation of the peer, bans the peer if it violates too many times,
+// and returns the error to reject the request
+func (g *RetrievalGuard) violate(p peer.ID, kind mtypes.RetrievalViolation, err error) error {
+	now := time.Now()
+	if now.Sub(g.lastPrune) > time.Minute {
+		g.prune(now)
+	}
+	state := g.peerState(p)
+	state.Violations[kind]++
+	state.LastViolation = now
+	if now.Sub(state.windowStart) > time.Duration(g.cfg.ViolationWindow) {
+		state.windowStart = now
+		state.windowViolations = 0
+	}
+	state.windowViolations++
+
+	_ = stats.RecordWithTags(g.metricsCtx, []tag.Mutator{tag.Upsert(marketMetrics.RetrievalViolationTag, string(kind))},
+		marketMetrics.RetrievalViolationCount.M(1))
+	log.Warnw("retrieval limit violated", "peer", p, "violation", kind, "err", err)
+
+	if g.cfg.BanThreshold > 0 && state.windowViolations >= g.cfg.BanThreshold {
+		state.BannedUntil = now.Add(time.Duration(g.cfg.BanDuration))
+		state.windowViolations = 0
+		log.Warnf("ban retrieval peer %s until %s", p, state.BannedUntil.Format(time.RFC3339))
+		g.recordBannedPeers()
+	}
+	return fmt.Errorf("retrieval limit violated: %w", err)
+}
+
+// prune removes the peers which have no ongoing deals and no recent violations
+func (g *RetrievalGuard) prune(now time.Time) {
+	for p, state := range g.peers {
+		if len(state.active) == 0 && !state.Banned(now) && now.Sub(state.LastViolation) > violationRetention {
+			delete(g.peers, p)
+		}
+	}
+	g.lastPrune = now
+}
+
+func (g *RetrievalGuard) recordBannedPeers() {
+	now := time.Now()
+	var banned int64
+	for _, state := range g.peers {
+		if state.Banned(now) {
+			banned++
+		}
+	}
+	stats.Record(g.metricsCtx, marketMetrics.RetrievalBannedPeers.M(banned))
+}
+
+func burst(perSecond float64) int {
+	if perSecond < 1 {
+		return 1
+	}
+	return int(perSecond)
+}
+
+// selectorDepth returns the nesting depth of the selector node, the result is capped to limit+1,
+// so that a deep selector is not walked through
+func selectorDepth(n datamodel.Node, limit int) int {
+	if n.Kind() != datamodel.Kind_Map && n.Kind() != datamodel.Kind_List {
+		return 0
+	}
+	if limit <= 0 {
+		return 1
+	}
+
+	depth := 0
+	visit := func(child datamodel.Node) bool {
+		if d := selectorDepth(child, limit-1); d > depth {
+			depth = d
+		}
+		return depth < limit
+	}
+	if n.Kind() == datamodel.Kind_Map {
+		for it := n.MapIterator(); !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil || !visit(v) {
+				break
+			}
+		}
+	} else {
+		for it := n.ListIterator(); !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil || !visit(v) {
+				break
+			}
+		}
+	}
+	return depth + 1
+}
+
+// checkRecursionLimits parses the selector node, and checks that every recursion in it has a depth
+// limit which doesn't exceed maxDepth
+func checkRecursionLimits(n datamodel.Node, maxDepth int64) error {
+	if _, err := selector.ParseSelector(n); err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	return walkRecursionLimits(n, maxDepth)
+}
+
+// walkRecursionLimits walks the selector node which has been validated by selector.ParseSelector
+func walkRecursionLimits(n datamodel.Node, maxDepth int64) error {
+	if n.Kind() != datamodel.Kind_Map || n.Length() != 1 {
+		return nil
+	}
+	key, body, err := n.MapIterator().Next()
+	if err != nil {
+		return err
+	}
+	kstr, err := key.AsString()
+	if err != nil {
+		return err
+	}
+
+	switch kstr {
+	case selector.SelectorKey_ExploreRecursive:
+		limit, err := body.LookupByString(selector.SelectorKey_Limit)
+		if err != nil {
+			return err
+		}
+		if _, err := limit.LookupByString(selector.SelectorKey_LimitNone); err == nil {
+			return fmt.Errorf("recursion without depth limit is not allowed")
+		}
+		depthNode, err := limit.LookupByString(selector.SelectorKey_LimitDepth)
+		if err != nil {
+			return err
+		}
+		depth, err := depthNode.AsInt()
+		if err != nil {
+			return err
+		}
+		if depth > maxDepth {
+			return fmt.Errorf("recursion depth %d exceeds %d", depth, maxDepth)
+		}
+		return walkSelectorField(body, selector.SelectorKey_Sequence, maxDepth)
+	case selector.SelectorKey_ExploreUnion:
+		for it := body.ListIterator(); it != nil && !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			if err := walkRecursionLimits(v, maxDepth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case selector.SelectorKey_ExploreFields:
+		fields, err := body.LookupByString(selector.SelectorKey_Fields)
+		if err != nil {
+			return err
+		}
+		for it := fields.MapIterator(); it != nil && !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil {
+				return err
+			}
+			if err := walkRecursionLimits(v, maxDepth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case selector.SelectorKey_ExploreAll, selector.SelectorKey_ExploreIndex, selector.SelectorKey_ExploreRange,
+		selector.SelectorKey_ExploreInterpretAs:
+		return walkSelectorField(body, selector.SelectorKey_Next, maxDepth)
+	default:
+		return nil
+	}
+}
+
+func walkSelectorField(n datamodel.Node, field string, maxDepth int64) error {
+	next, err := n.LookupByString(field)
+	if err != nil {
+		return err
+	}
+	return walkRecursionLimits(next, maxDepth)
+}
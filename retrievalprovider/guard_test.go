@@ -0,0 +1,131 @@
+package retrievalprovider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+)
+
+func newTestGuard(t *testing.T, limits config.RetrievalLimits) *RetrievalGuard {
+	cfg := config.DefaultMarketConfig
+	cfg.RetrievalLimits = limits
+	g, err := NewRetrievalGuard(context.Background(), cfg)
+	require.NoError(t, err)
+	return g
+}
+
+func TestRetrievalGuardBan(t *testing.T) {
+	g := newTestGuard(t, config.RetrievalLimits{
+		PeerQueriesPerSecond: 1,
+		BanThreshold:         3,
+		ViolationWindow:      config.Duration(time.Minute),
+		BanDuration:          config.Duration(time.Hour),
+	})
+	p := peer.ID("peer1")
+
+	assert.NoError(t, g.AllowQuery(p))
+	for i := 0; i < 3; i++ {
+		assert.Error(t, g.AllowQuery(p))
+	}
+	assert.NoError(t, g.AllowQuery(peer.ID("peer2")))
+
+	violations := g.ListViolations()
+	require.Len(t, violations, 1)
+	assert.True(t, violations[0].Banned(time.Now()))
+	assert.Equal(t, uint64(3), violations[0].Violations[mtypes.RetrievalViolationQueryRate])
+	assert.Error(t, g.AcquireRetrieval(p, 1, selectorparse.CommonSelector_ExploreAllRecursively))
+
+	require.NoError(t, g.Unban(p))
+	assert.Error(t, g.Unban(p))
+	assert.NoError(t, g.AcquireRetrieval(p, 1, selectorparse.CommonSelector_ExploreAllRecursively))
+}
+
+func TestRetrievalGuardConcurrency(t *testing.T) {
+	g := newTestGuard(t, config.RetrievalLimits{PeerMaxConcurrentRetrievals: 2})
+	p := peer.ID("peer1")
+	sel := selectorparse.CommonSelector_ExploreAllRecursively
+
+	require.NoError(t, g.AcquireRetrieval(p, 1, sel))
+	require.NoError(t, g.AcquireRetrieval(p, 2, sel))
+	assert.Error(t, g.AcquireRetrieval(p, 3, sel))
+
+	g.ReleaseRetrieval(p, 1)
+	assert.NoError(t, g.AcquireRetrieval(p, 3, sel))
+}
+
+func TestRetrievalGuardSelectorDepth(t *testing.T) {
+	assert.Less(t, selectorDepth(selectorparse.CommonSelector_ExploreAllRecursively, 32), 32)
+
+	deep := basicnode.NewInt(0)
+	for i := 0; i < 40; i++ {
+		var err error
+		child := deep
+		deep, err = qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "a", qp.Node(child))
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 40, selectorDepth(deep, 64))
+	assert.Equal(t, 33, selectorDepth(deep, 32))
+
+	g := newTestGuard(t, config.RetrievalLimits{MaxSelectorDepth: 32})
+	assert.Error(t, g.AcquireRetrieval(peer.ID("peer1"), 1, deep))
+}
+
+func TestRetrievalGuardRecursionLimit(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	recursive := func(limit selector.RecursionLimit) builder.SelectorSpec {
+		return ssb.ExploreRecursive(limit, ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+	}
+
+	assert.NoError(t, checkRecursionLimits(recursive(selector.RecursionLimitDepth(10)).Node(), 10))
+	assert.NoError(t, checkRecursionLimits(ssb.Matcher().Node(), 10))
+	assert.Error(t, checkRecursionLimits(recursive(selector.RecursionLimitDepth(11)).Node(), 10))
+	assert.Error(t, checkRecursionLimits(selectorparse.CommonSelector_ExploreAllRecursively, 10))
+	// the recursion nested in other selectors is checked too
+	nested := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("a", ssb.ExploreUnion(ssb.Matcher(), recursive(selector.RecursionLimitNone())))
+	}).Node()
+	assert.Error(t, checkRecursionLimits(nested, 10))
+	// invalid selector
+	assert.Error(t, checkRecursionLimits(basicnode.NewString("a"), 10))
+
+	sel := selectorparse.CommonSelector_ExploreAllRecursively
+	g := newTestGuard(t, config.RetrievalLimits{})
+	assert.NoError(t, g.AcquireRetrieval(peer.ID("peer1"), 1, sel))
+
+	g = newTestGuard(t, config.RetrievalLimits{MaxRecursionDepth: 10})
+	assert.Error(t, g.AcquireRetrieval(peer.ID("peer1"), 1, sel))
+	assert.Equal(t, uint64(1), g.ListViolations()[0].Violations[mtypes.RetrievalViolationSelectorRecursion])
+	assert.NoError(t, g.AcquireRetrieval(peer.ID("peer1"), 2, recursive(selector.RecursionLimitDepth(5)).Node()))
+}
+
+func TestRetrievalGuardDealCaps(t *testing.T) {
+	g := newTestGuard(t, config.RetrievalLimits{MaxBlocksPerDeal: 2, MaxBytesPerDeal: 100})
+	deal := &types.ProviderDealState{Receiver: peer.ID("peer1")}
+	deal.ID = retrievalmarket.DealID(1)
+
+	assert.NoError(t, g.OnDataSent(deal, 10))
+	assert.NoError(t, g.OnDataSent(deal, 10))
+	assert.Error(t, g.OnDataSent(deal, 10))
+
+	g.ReleaseRetrieval(deal.Receiver, deal.ID)
+	deal.TotalSent = 95
+	assert.Error(t, g.OnDataSent(deal, 10))
+}
@@ -0,0 +1,154 @@
+package retrievalprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	v1api "github.com/filecoin-project/venus/venus-shared/api/chain/v1"
+	"github.com/filecoin-project/venus/venus-shared/types"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	"github.com/ipfs-force-community/droplet/v2/paychmgr"
+	"github.com/ipfs-force-community/droplet/v2/retrievalprovider/httpretrieval"
+)
+
+// HTTPPaymentValidator charges the http piece retrievals by the retrieval ask of the miner,
+// the vouchers are saved by paychmgr for later settlement, the same as graphsync retrievals
+type HTTPPaymentValidator struct {
+	cfg           *config.MarketConfig
+	fullNode      v1api.FullNode
+	payAPI        *paychmgr.PaychAPI
+	askRepo       repo.IRetrievalAskRepo
+	storageDeals  repo.StorageDealRepo
+	statsRecorder *RetrievalStatsRecorder
+}
+
+var _ httpretrieval.PaymentValidator = (*HTTPPaymentValidator)(nil)
+
+func NewHTTPPaymentValidator(cfg *config.MarketConfig,
+	fullNode v1api.FullNode,
+	payAPI *paychmgr.PaychAPI,
+	r repo.Repo,
+	statsRecorder *RetrievalStatsRecorder,
+) *HTTPPaymentValidator {
+	return &HTTPPaymentValidator{
+		cfg:           cfg,
+		fullNode:      fullNode,
+		payAPI:        payAPI,
+		askRepo:       r.RetrievalAskRepo(),
+		storageDeals:  r.StorageDealRepo(),
+		statsRecorder: statsRecorder,
+	}
+}
+
+// PaymentTerms returns the cheapest terms of the miners which store the piece in an active deal, the deals
+// which are not active are only considered if there is no active one. It returns nil if any of the miners
+// doesn't charge http retrievals, the ties of price are broken by the miner address.
+func (v *HTTPPaymentValidator) PaymentTerms(ctx context.Context, pieceCID cid.Cid) (*httpretrieval.PaymentTerms, error) {
+	deals, err := v.storageDeals.GetDealsByPieceCidAndStatus(ctx, pieceCID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get deals of piece %s: %w", pieceCID, err)
+	}
+
+	var active []*markettypes.MinerDeal
+	for _, deal := range deals {
+		if deal.State == storagemarket.StorageDealActive {
+			active = append(active, deal)
+		}
+	}
+	if len(active) > 0 {
+		deals = active
+	}
+
+	var (
+		cheapest *httpretrieval.PaymentTerms
+		lastErr  error
+		checked  = make(map[address.Address]struct{})
+	)
+	for _, deal := range deals {
+		miner := deal.Proposal.Provider
+		if _, ok := checked[miner]; ok {
+			continue
+		}
+		checked[miner] = struct{}{}
+
+		minerCfg, err := v.cfg.MinerProviderConfig(miner, true)
+		if err != nil {
+			continue
+		}
+		if !minerCfg.ChargeHTTPRetrieval {
+			return nil, nil
+		}
+		paymentAddr := minerCfg.RetrievalPaymentAddress.Unwrap()
+		if paymentAddr.Empty() {
+			lastErr = fmt.Errorf("miner %s charges http retrieval, but retrieval payment address is not set", miner)
+			continue
+		}
+
+		ask, err := v.askRepo.GetAsk(ctx, miner)
+		if err != nil {
+			lastErr = fmt.Errorf("get retrieval ask of %s: %w", miner, err)
+			continue
+		}
+		terms := &httpretrieval.PaymentTerms{
+			Miner:          miner,
+			PaymentAddress: paymentAddr,
+			PricePerByte:   ask.PricePerByte,
+		}
+		if cheapest == nil || terms.PricePerByte.LessThan(cheapest.PricePerByte) ||
+			(terms.PricePerByte.Equals(cheapest.PricePerByte) && terms.Miner.String() < cheapest.Miner.String()) {
+			cheapest = terms
+		}
+	}
+	if cheapest == nil {
+		return nil, lastErr
+	}
+	return cheapest, nil
+}
+
+// Pay checks that the channel pays to the payment address, and saves the voucher if it
+// brings at least owed to the channel
+func (v *HTTPPaymentValidator) Pay(ctx context.Context,
+	client string,
+	pieceCID cid.Cid,
+	terms *httpretrieval.PaymentTerms,
+	ch address.Address,
+	sv *paych.SignedVoucher,
+	owed abi.TokenAmount,
+) error {
+	// tracks the channel if it is new
+	if err := v.payAPI.PaychVoucherCheckValid(ctx, ch, sv); err != nil {
+		return fmt.Errorf("invalid voucher: %w", err)
+	}
+	status, err := v.payAPI.PaychStatus(ctx, ch)
+	if err != nil {
+		return fmt.Errorf("get status of channel %s: %w", ch, err)
+	}
+	paymentKey, err := v.fullNode.StateAccountKey(ctx, terms.PaymentAddress, types.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("resolve payment address %s: %w", terms.PaymentAddress, err)
+	}
+	if status.ControlAddr != paymentKey {
+		return fmt.Errorf("channel %s pays to %s, expect %s", ch, status.ControlAddr, terms.PaymentAddress)
+	}
+
+	received, err := v.payAPI.PaychVoucherAdd(ctx, ch, sv, nil, owed)
+	if err != nil {
+		return fmt.Errorf("add voucher: %w", err)
+	}
+	if v.statsRecorder != nil {
+		v.statsRecorder.RecordHTTPPayment(ctx, client, pieceCID, ch, received)
+	}
+	return nil
+}
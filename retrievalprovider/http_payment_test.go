@@ -0,0 +1,74 @@
+package retrievalprovider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	markettypes "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+)
+
+func TestHTTPPaymentTerms(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	newAddr := address.NewForTestGetter()
+	miners := []address.Address{newAddr(), newAddr(), newAddr()}
+	paymentAddr := newAddr()
+
+	cfg := *config.DefaultMarketConfig
+	for _, miner := range miners {
+		provider := *cfg.CommonProvider
+		provider.ChargeHTTPRetrieval = true
+		provider.RetrievalPaymentAddress = config.Address(paymentAddr)
+		cfg.Miners = append(cfg.Miners, &config.MinerConfig{Addr: config.Address(miner), ProviderConfig: &provider})
+	}
+	for i, price := range []int64{3, 1, 1} {
+		require.NoError(t, r.RetrievalAskRepo().SetAsk(ctx, &markettypes.RetrievalAsk{
+			Miner:        miners[i],
+			PricePerByte: big.NewInt(price),
+			UnsealPrice:  big.Zero(),
+		}))
+	}
+
+	deals := make([]markettypes.MinerDeal, 3)
+	testutil.Provide(t, &deals)
+	for i := range deals {
+		deals[i].Proposal.PieceCID = deals[0].Proposal.PieceCID
+		deals[i].Proposal.Provider = miners[i]
+		deals[i].State = storagemarket.StorageDealActive
+		require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[i]))
+	}
+	pieceCID := deals[0].Proposal.PieceCID
+
+	v := NewHTTPPaymentValidator(&cfg, nil, nil, r, nil)
+
+	// the cheapest active deal is picked, the tie is broken by the miner address
+	terms, err := v.PaymentTerms(ctx, pieceCID)
+	require.NoError(t, err)
+	require.NotNil(t, terms)
+	assert.Equal(t, miners[1], terms.Miner)
+	assert.Equal(t, big.NewInt(1), terms.PricePerByte)
+
+	// the deals which are not active are ignored if there are active ones
+	deals[1].State = storagemarket.StorageDealExpired
+	require.NoError(t, r.StorageDealRepo().SaveDeal(ctx, &deals[1]))
+	terms, err = v.PaymentTerms(ctx, pieceCID)
+	require.NoError(t, err)
+	assert.Equal(t, miners[2], terms.Miner)
+
+	// the piece is free if one of the miners doesn't charge
+	cfg.Miners[0].ChargeHTTPRetrieval = false
+	terms, err = v.PaymentTerms(ctx, pieceCID)
+	require.NoError(t, err)
+	assert.Nil(t, terms)
+}
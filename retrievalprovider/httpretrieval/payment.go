@@ -0,0 +1,156 @@
+package httpretrieval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	lpaych "github.com/filecoin-project/venus/venus-shared/actors/builtin/paych"
+	"github.com/ipfs/go-cid"
+)
+
+// The headers of paid http retrieval
+const (
+	// HeaderPricePerByte is the price per byte of the piece, responded by provider
+	HeaderPricePerByte = "X-Droplet-Price-Per-Byte"
+	// HeaderPaymentAddress is the address which the payment channel should pay to, responded by provider
+	HeaderPaymentAddress = "X-Droplet-Payment-Address"
+	// HeaderPaymentOwed is the amount owed for the requested range, responded by provider
+	HeaderPaymentOwed = "X-Droplet-Payment-Owed"
+	// HeaderPaymentChannel is the address of payment channel, sent by client
+	HeaderPaymentChannel = "X-Droplet-Payment-Channel"
+	// HeaderPaymentVoucher is the base64 encoded signed voucher, sent by client
+	HeaderPaymentVoucher = "X-Droplet-Payment-Voucher"
+)
+
+// PaymentTerms is the price to retrieve a piece over http
+type PaymentTerms struct {
+	Miner          address.Address
+	PaymentAddress address.Address
+	PricePerByte   abi.TokenAmount
+}
+
+// PaymentValidator charges the http retrievals with payment channel vouchers
+type PaymentValidator interface {
+	// PaymentTerms returns the price of the piece, nil means the piece is free to retrieve
+	PaymentTerms(ctx context.Context, pieceCID cid.Cid) (*PaymentTerms, error)
+	// Pay saves the voucher if it pays at least owed to the payment address of terms
+	Pay(ctx context.Context, client string, pieceCID cid.Cid, terms *PaymentTerms, ch address.Address, sv *paych.SignedVoucher, owed abi.TokenAmount) error
+}
+
+// checkPayment returns whether the request has paid for the requested range, the response
+// is written if not
+func (s *Server) checkPayment(w http.ResponseWriter, r *http.Request, pieceCID cid.Cid, size uint64) bool {
+	ctx := r.Context()
+	terms, err := s.payment.PaymentTerms(ctx, pieceCID)
+	if err != nil {
+		log.Warnf("get payment terms of %s: %v", pieceCID, err)
+		badResponse(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if terms == nil || terms.PricePerByte.IsZero() {
+		return true
+	}
+
+	w.Header().Set(HeaderPricePerByte, terms.PricePerByte.String())
+	w.Header().Set(HeaderPaymentAddress, terms.PaymentAddress.String())
+	// HEAD is used to query the price
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	// If-Range could make http.ServeContent send the whole piece instead of the paid range
+	r.Header.Del("If-Range")
+	length, err := rangeLength(r.Header.Get("Range"), size)
+	if err != nil {
+		badResponse(w, http.StatusRequestedRangeNotSatisfiable, err)
+		return false
+	}
+	owed := big.Mul(terms.PricePerByte, big.NewIntUnsigned(length))
+	w.Header().Set(HeaderPaymentOwed, owed.String())
+
+	chStr, voucherStr := r.Header.Get(HeaderPaymentChannel), r.Header.Get(HeaderPaymentVoucher)
+	if len(chStr) == 0 || len(voucherStr) == 0 {
+		badResponse(w, http.StatusPaymentRequired, fmt.Errorf("%s and %s are required", HeaderPaymentChannel, HeaderPaymentVoucher))
+		return false
+	}
+	ch, err := address.NewFromString(chStr)
+	if err != nil {
+		badResponse(w, http.StatusBadRequest, fmt.Errorf("parse payment channel: %w", err))
+		return false
+	}
+	sv, err := lpaych.DecodeSignedVoucher(voucherStr)
+	if err != nil {
+		badResponse(w, http.StatusBadRequest, fmt.Errorf("decode voucher: %w", err))
+		return false
+	}
+
+	if err := s.payment.Pay(ctx, clientHost(r), pieceCID, terms, ch, sv, owed); err != nil {
+		log.Warnf("payment of %s from %s: %v", pieceCID, ch, err)
+		badResponse(w, http.StatusPaymentRequired, err)
+		return false
+	}
+	return true
+}
+
+// rangeLength returns the number of bytes requested by the range header, only a single
+// range is supported
+func rangeLength(s string, size uint64) (uint64, error) {
+	if len(s) == 0 {
+		return size, nil
+	}
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return 0, fmt.Errorf("invalid range %s", s)
+	}
+	spec := strings.TrimSpace(s[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return 0, errors.New("multiple ranges are not supported")
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid range %s", s)
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	// bytes=-n, the last n bytes
+	if len(startStr) == 0 {
+		n, err := strconv.ParseUint(endStr, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid range %s: %w", s, err)
+		}
+		if n > size {
+			n = size
+		}
+		return n, nil
+	}
+
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %s: %w", s, err)
+	}
+	if start >= size {
+		return 0, fmt.Errorf("range %s exceeds the size %d", s, size)
+	}
+	if len(endStr) == 0 {
+		return size - start, nil
+	}
+	end, err := strconv.ParseUint(endStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %s: %w", s, err)
+	}
+	if end < start {
+		return 0, fmt.Errorf("invalid range %s", s)
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return end - start + 1, nil
+}
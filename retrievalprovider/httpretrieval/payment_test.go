@@ -0,0 +1,155 @@
+package httpretrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v8/paych"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeLength(t *testing.T) {
+	cases := []struct {
+		rng    string
+		expect uint64
+		err    bool
+	}{
+		{rng: "", expect: 100},
+		{rng: "bytes=0-9", expect: 10},
+		{rng: "bytes=90-", expect: 10},
+		{rng: "bytes=-20", expect: 20},
+		{rng: "bytes=-200", expect: 100},
+		{rng: "bytes=50-200", expect: 50},
+		{rng: "bytes=100-", err: true},
+		{rng: "bytes=9-0", err: true},
+		{rng: "bytes=0-9,20-29", err: true},
+		{rng: "items=0-9", err: true},
+	}
+
+	for _, c := range cases {
+		length, err := rangeLength(c.rng, 100)
+		if c.err {
+			assert.Error(t, err, c.rng)
+			continue
+		}
+		assert.NoError(t, err, c.rng)
+		assert.Equal(t, c.expect, length, c.rng)
+	}
+}
+
+type mockPayment struct {
+	terms *PaymentTerms
+	// the total amount of the vouchers received
+	received abi.TokenAmount
+}
+
+func (m *mockPayment) PaymentTerms(context.Context, cid.Cid) (*PaymentTerms, error) {
+	return m.terms, nil
+}
+
+func (m *mockPayment) Pay(_ context.Context, _ string, _ cid.Cid, _ *PaymentTerms, _ address.Address, sv *paych.SignedVoucher, owed abi.TokenAmount) error {
+	delta := big.Sub(sv.Amount, m.received)
+	if delta.LessThan(owed) {
+		return errors.New("insufficient payment")
+	}
+	m.received = sv.Amount
+	return nil
+}
+
+func TestPaidRetrieval(t *testing.T) {
+	cfg, pieceStr, buf := setupPieceStorage(t)
+
+	ch, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	paymentAddr, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+	payment := &mockPayment{
+		terms:    &PaymentTerms{PaymentAddress: paymentAddr, PricePerByte: big.NewInt(2)},
+		received: big.Zero(),
+	}
+	s, err := NewServer(&cfg.PieceStorage, nil, payment)
+	require.NoError(t, err)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	doRequest := func(method, rng string, amount int64) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/piece/"+pieceStr, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "identity")
+		if len(rng) > 0 {
+			req.Header.Set("Range", rng)
+		}
+		if amount > 0 {
+			voucher := &paych.SignedVoucher{ChannelAddr: ch, Amount: big.NewInt(amount)}
+			data := &bytes.Buffer{}
+			require.NoError(t, voucher.MarshalCBOR(data))
+			req.Header.Set(HeaderPaymentChannel, ch.String())
+			req.Header.Set(HeaderPaymentVoucher, base64.RawURLEncoding.EncodeToString(data.Bytes()))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("quote", func(t *testing.T) {
+		resp := doRequest(http.MethodHead, "", 0)
+		defer resp.Body.Close() // nolint
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int64(buf.Len()), resp.ContentLength)
+		assert.Equal(t, "2", resp.Header.Get(HeaderPricePerByte))
+		assert.Equal(t, paymentAddr.String(), resp.Header.Get(HeaderPaymentAddress))
+	})
+
+	t.Run("payment required", func(t *testing.T) {
+		resp := doRequest(http.MethodGet, "bytes=0-99", 0)
+		defer resp.Body.Close() // nolint
+		assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+		assert.Equal(t, "200", resp.Header.Get(HeaderPaymentOwed))
+	})
+
+	t.Run("insufficient payment", func(t *testing.T) {
+		resp := doRequest(http.MethodGet, "bytes=0-99", 100)
+		defer resp.Body.Close() // nolint
+		assert.Equal(t, http.StatusPaymentRequired, resp.StatusCode)
+	})
+
+	t.Run("paid ranges", func(t *testing.T) {
+		resp := doRequest(http.MethodGet, "bytes=0-99", 200)
+		defer resp.Body.Close() // nolint
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, buf.Bytes()[:100], data)
+
+		resp2 := doRequest(http.MethodGet, "bytes=100-", 200+int64(buf.Len()-100)*2)
+		defer resp2.Body.Close() // nolint
+		assert.Equal(t, http.StatusPartialContent, resp2.StatusCode)
+		data, err = io.ReadAll(resp2.Body)
+		require.NoError(t, err)
+		assert.Equal(t, buf.Bytes()[100:], data)
+	})
+
+	t.Run("free piece", func(t *testing.T) {
+		s, err := NewServer(&cfg.PieceStorage, nil, &mockPayment{})
+		require.NoError(t, err)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/piece/" + pieceStr)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(HeaderPricePerByte))
+	})
+}
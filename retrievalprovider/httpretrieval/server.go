@@ -1,8 +1,10 @@
 package httpretrieval
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -18,18 +20,27 @@ import (
 
 var log = logging.Logger("httpserver")
 
+// StatsRecorder records the accounting of http retrievals
+type StatsRecorder interface {
+	RecordHTTPRetrieval(ctx context.Context, client string, pieceCID cid.Cid, bytes uint64, succeeded bool)
+}
+
 type Server struct {
 	// path     string
 	pieceMgr *piecestorage.PieceStorageManager
+	recorder StatsRecorder
+	payment  PaymentValidator
 }
 
-func NewServer(cfg *config.PieceStorage) (*Server, error) {
+// NewServer creates the http retrieval server, recorder could be nil if not need to record stats,
+// and payment could be nil if all retrievals are free
+func NewServer(cfg *config.PieceStorage, recorder StatsRecorder, payment PaymentValidator) (*Server, error) {
 	pieceMgr, err := piecestorage.NewPieceStorageManager(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{pieceMgr: pieceMgr}, nil
+	return &Server{pieceMgr: pieceMgr, recorder: recorder, payment: payment}, nil
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -62,6 +73,10 @@ func (s *Server) RetrievalByPieceCID(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Infof("piece size: %v", len)
 
+	if s.payment != nil && !s.checkPayment(w, r, pieceCID, uint64(len)) {
+		return
+	}
+
 	mountReader, err := store.GetMountReader(ctx, pieceCIDStr)
 	if err != nil {
 		log.Warn(err)
@@ -70,11 +85,23 @@ func (s *Server) RetrievalByPieceCID(w http.ResponseWriter, r *http.Request) {
 	}
 	defer mountReader.Close() // nolint
 
-	serveContent(w, r, mountReader, log)
+	sent, err := serveContent(w, r, mountReader, log)
+	if s.recorder != nil && r.Method != http.MethodHead {
+		s.recorder.RecordHTTPRetrieval(ctx, clientHost(r), pieceCID, sent, err == nil)
+	}
 	log.Info("end retrieval deal")
 }
 
-func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, log *zap.SugaredLogger) {
+func clientHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// serveContent returns the number of bytes sent and the error occurred when writing to the stream
+func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, log *zap.SugaredLogger) (uint64, error) {
 	// Set the Content-Type header explicitly so that http.ServeContent doesn't
 	// try to do it implicitly
 	w.Header().Set("Content-Type", "application/piece")
@@ -95,25 +122,31 @@ func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker,
 	start := time.Now()
 	log.Infof("start %s\t %d\tGET %s", start, http.StatusOK, r.URL)
 	isGzipped := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+	closeWriter := func() {}
 	if isGzipped {
 		// If Accept-Encoding header contains gzip then send a gzipped response
 		gzwriter := gziphandler.GzipResponseWriter{
 			ResponseWriter: writeErrWatcher,
 		}
-		// Close the writer to flush buffer
-		defer gzwriter.Close() // nolint
 		writer = &gzwriter
+		// Close the writer to flush buffer
+		closeWriter = func() {
+			gzwriter.Close() // nolint
+		}
 	}
 
 	if r.Method == "HEAD" {
 		// For an HTTP HEAD request ServeContent doesn't send any data (just headers)
 		http.ServeContent(writer, r, "", time.Time{}, content)
+		closeWriter()
 		log.Infof("%d\tHEAD %s", http.StatusOK, r.URL)
-		return
+		return 0, nil
 	}
 
 	// Send the content
 	http.ServeContent(writer, r, "", time.Unix(1, 0), content)
+	// flush the buffer before counting the bytes sent
+	closeWriter()
 
 	// Write a line to the log
 	end := time.Now()
@@ -128,6 +161,7 @@ func serveContent(w http.ResponseWriter, r *http.Request, content io.ReadSeeker,
 	} else {
 		log.Warnf("%s %s\n%s", completeMsg, "FAIL", err)
 	}
+	return writeErrWatcher.count, err
 }
 
 func convertPieceCID(path string) (cid.Cid, error) {
@@ -15,6 +15,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,6 +54,36 @@ func TestRetrievalByPiece(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	cfg, pieceStr, buf := setupPieceStorage(t)
+
+	recorder := &mockRecorder{records: make(chan httpRecord, 1)}
+	s, err := NewServer(&cfg.PieceStorage, recorder, nil)
+	assert.NoError(t, err)
+	port := "34897"
+	startHTTPServer(ctx, t, port, s)
+
+	url := fmt.Sprintf("http://127.0.0.1:%s/piece/%s", port, pieceStr)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	// disable gzip, so the bytes sent equal the size of piece
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close() // nolint
+
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, buf.Bytes(), data)
+
+	select {
+	case record := <-recorder.records:
+		assert.Equal(t, httpRecord{client: "127.0.0.1", pieceCID: pieceStr, bytes: uint64(buf.Len()), succeeded: true}, record)
+	case <-time.After(time.Second * 5):
+		t.Fatal("http retrieval not recorded")
+	}
+}
+
+func setupPieceStorage(t *testing.T) (*config.MarketConfig, string, *bytes.Buffer) {
 	tmpDri := t.TempDir()
 	cfg := config.DefaultMarketConfig
 	cfg.Home.HomeDir = tmpDri
@@ -76,21 +107,22 @@ func TestRetrievalByPiece(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, f.Close())
 
-	s, err := NewServer(&cfg.PieceStorage)
-	assert.NoError(t, err)
-	port := "34897"
-	startHTTPServer(ctx, t, port, s)
+	return cfg, pieceStr, buf
+}
 
-	url := fmt.Sprintf("http://127.0.0.1:%s/piece/%s", port, pieceStr)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	assert.NoError(t, err)
-	resp, err := http.DefaultClient.Do(req)
-	assert.NoError(t, err)
-	defer resp.Body.Close() // nolint
+type httpRecord struct {
+	client    string
+	pieceCID  string
+	bytes     uint64
+	succeeded bool
+}
 
-	data, err := io.ReadAll(resp.Body)
-	assert.NoError(t, err)
-	assert.Equal(t, buf.Bytes(), data)
+type mockRecorder struct {
+	records chan httpRecord
+}
+
+func (m *mockRecorder) RecordHTTPRetrieval(_ context.Context, client string, pieceCID cid.Cid, bytes uint64, succeeded bool) {
+	m.records <- httpRecord{client: client, pieceCID: pieceCID.String(), bytes: bytes, succeeded: succeeded}
 }
 
 func startHTTPServer(ctx context.Context, t *testing.T, port string, s *Server) {
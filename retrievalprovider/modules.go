@@ -14,8 +14,10 @@ import (
 	"github.com/ipfs-force-community/venus-common-utils/journal"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
 	"github.com/ipfs-force-community/droplet/v2/dealfilter"
 	_ "github.com/ipfs-force-community/droplet/v2/network"
+	"github.com/ipfs-force-community/droplet/v2/retrievalprovider/bitswap"
 
 	gatewayAPIV2 "github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
 	types "github.com/filecoin-project/venus/venus-shared/types/market"
@@ -76,12 +78,18 @@ var RetrievalProviderOpts = func(cfg *config.MarketConfig) builder.Option {
 	return builder.Options(
 		// Markets (retrieval)
 		builder.Override(new(rmnet.RetrievalMarketNetwork), RetrievalNetwork),
+		builder.Override(new(*RetrievalStatsRecorder), NewRetrievalStatsRecorder),
+		builder.Override(new(*HTTPPaymentValidator), NewHTTPPaymentValidator),
+		builder.Override(new(*RetrievalGuard), NewRetrievalGuard),
 		builder.Override(new(IRetrievalProvider), NewProvider), // save to metadata /retrievals/provider
 		builder.Override(HandleRetrievalKey, HandleRetrieval),
 		builder.Override(new(config.RetrievalDealFilter), RetrievalDealFilter(dealfilter.CliRetrievalDealFilter(cfg))),
-		builder.Override(new(gatewayAPIV2.IMarketEvent), NewMarketEventStream),
+		builder.Override(new(*MarketEventStream), NewMarketEventStream),
+		builder.Override(new(gatewayAPIV2.IMarketEvent), builder.From(new(*MarketEventStream))),
+		builder.Override(new(dagstore.SectorReader), builder.From(new(*MarketEventStream))),
 		builder.Override(new(gatewayAPIV2.IMarketClient), builder.From(new(gatewayAPIV2.IMarketEvent))),
 		builder.Override(new(gatewayAPIV2.IMarketServiceProvider), builder.From(new(gatewayAPIV2.IMarketEvent))),
+		builder.Override(new(*bitswap.Server), bitswap.NewServer),
 		builder.Override(new(*TransportsListener), NewTransportsListener),
 	)
 }
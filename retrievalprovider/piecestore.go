@@ -9,6 +9,7 @@ import (
 
 	"github.com/filecoin-project/go-fil-markets/stores"
 	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
 	"github.com/ipfs-force-community/droplet/v2/storageprovider"
 	"github.com/ipfs/go-cid"
@@ -19,8 +20,22 @@ type PieceInfo struct {
 	dealRepo repo.StorageDealRepo
 }
 
-// GetPieceInfoFromCid take `pieceCid` priority, then `payloadCid`
+// GetPieceInfoFromCid take `pieceCid` priority, then `payloadCid`, the pieces containing `payloadCid` may be indexed on demand
 func (pinfo *PieceInfo) GetPieceInfoFromCid(ctx context.Context, payloadCID cid.Cid, piececid *cid.Cid) ([]*types.MinerDeal, error) {
+	return pinfo.getPieceInfoFromCid(ctx, payloadCID, piececid, dagstore.PiecesContainingBlock)
+}
+
+// QueryPieceInfoFromCid is the same as GetPieceInfoFromCid, but only finds the pieces indexed already,
+// it answers the queries which anyone is able to send without a retrieval deal
+func (pinfo *PieceInfo) QueryPieceInfoFromCid(ctx context.Context, payloadCID cid.Cid, piececid *cid.Cid) ([]*types.MinerDeal, error) {
+	return pinfo.getPieceInfoFromCid(ctx, payloadCID, piececid, dagstore.IndexedPiecesContainingBlock)
+}
+
+func (pinfo *PieceInfo) getPieceInfoFromCid(ctx context.Context,
+	payloadCID cid.Cid,
+	piececid *cid.Cid,
+	piecesContainingBlock func(context.Context, stores.DAGStoreWrapper, cid.Cid) ([]cid.Cid, error),
+) ([]*types.MinerDeal, error) {
 	if piececid != nil && (*piececid).Defined() {
 		minerDeals, err := pinfo.dealRepo.GetDealsByPieceCidAndStatus(ctx, (*piececid), storageprovider.ReadyRetrievalDealStatus...)
 		if err != nil {
@@ -49,7 +64,7 @@ func (pinfo *PieceInfo) GetPieceInfoFromCid(ctx context.Context, payloadCID cid.
 	}
 
 	// Get all pieces that contain the target block
-	piecesWithTargetBlock, err := pinfo.dagstore.GetPiecesContainingBlock(payloadCID)
+	piecesWithTargetBlock, err := piecesContainingBlock(ctx, pinfo.dagstore, payloadCID)
 	if err != nil && !errors.Is(err, repo.ErrNotFound) {
 		return nil, fmt.Errorf("getting pieces for cid %s: %w", payloadCID, err)
 	}
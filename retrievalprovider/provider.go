@@ -1,22 +1,38 @@
 package retrievalprovider
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
+	"go.uber.org/fx"
 
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channels"
+	"github.com/filecoin-project/go-data-transfer/message"
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/impl/dtutils"
 	"github.com/filecoin-project/go-fil-markets/retrievalmarket/migrations"
 	rmnet "github.com/filecoin-project/go-fil-markets/retrievalmarket/network"
+	"github.com/filecoin-project/go-fil-markets/shared"
 	"github.com/filecoin-project/go-fil-markets/stores"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/host"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
 	"github.com/ipfs-force-community/droplet/v2/network"
 	"github.com/ipfs-force-community/droplet/v2/paychmgr"
 	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	"github.com/ipfs-force-community/metrics"
 
 	v1api "github.com/filecoin-project/venus/venus-shared/api/chain/v1"
 	"github.com/filecoin-project/venus/venus-shared/api/gateway/v2"
@@ -37,7 +53,10 @@ var _ IRetrievalProvider = (*RetrievalProvider)(nil)
 
 // RetrievalProvider is the production implementation of the RetrievalProvider interface
 type RetrievalProvider struct {
+	ctx context.Context
+
 	dataTransfer     network.ProviderDataTransfer
+	dtNet            dtnet.DataTransferNetwork
 	network          rmnet.RetrievalMarketNetwork
 	requestValidator *ProviderRequestValidator
 	reValidator      *ProviderRevalidator
@@ -49,12 +68,17 @@ type RetrievalProvider struct {
 	storageDealRepo   repo.StorageDealRepo
 
 	retrievalStreamHandler *RetrievalStreamHandler
+	retrievalDealHandler   IRetrievalHandler
+	guard                  *RetrievalGuard
 
 	transportListener *TransportsListener
 }
 
 // NewProvider returns a new retrieval Provider
 func NewProvider(
+	mCtx metrics.MetricsCtx,
+	lc fx.Lifecycle,
+	h host.Host,
 	network rmnet.RetrievalMarketNetwork,
 	dagStore stores.DAGStoreWrapper,
 	dataTransfer network.ProviderDataTransfer,
@@ -66,6 +90,8 @@ func NewProvider(
 	pieceStorageMgr *piecestorage.PieceStorageManager,
 	gatewayMarketClient gateway.IMarketClient,
 	transportLister *TransportsListener,
+	statsRecorder *RetrievalStatsRecorder,
+	guard *RetrievalGuard,
 ) (*RetrievalProvider, error) {
 	storageDealsRepo := repo.StorageDealRepo()
 	retrievalDealRepo := repo.RetrievalDealRepo()
@@ -73,20 +99,24 @@ func NewProvider(
 
 	pieceInfo := &PieceInfo{dagStore, storageDealsRepo}
 	p := &RetrievalProvider{
+		ctx:                    metrics.LifecycleCtx(mCtx, lc),
 		dataTransfer:           dataTransfer,
+		dtNet:                  dtnet.NewFromLibp2pHost(h),
 		network:                network,
 		dagStore:               dagStore,
 		retrievalDealRepo:      retrievalDealRepo,
 		storageDealRepo:        storageDealsRepo,
 		stores:                 stores.NewReadOnlyBlockstores(),
-		retrievalStreamHandler: NewRetrievalStreamHandler(cfg, retrievalAskRepo, retrievalDealRepo, storageDealsRepo, pieceInfo),
+		retrievalStreamHandler: NewRetrievalStreamHandler(cfg, retrievalAskRepo, retrievalDealRepo, storageDealsRepo, pieceInfo, guard),
 		transportListener:      transportLister,
+		guard:                  guard,
 	}
 
-	retrievalHandler := NewRetrievalDealHandler(&providerDealEnvironment{p}, retrievalDealRepo, storageDealsRepo, gatewayMarketClient, pieceStorageMgr)
-	p.requestValidator = NewProviderRequestValidator(cfg, storageDealsRepo, retrievalDealRepo, retrievalAskRepo, pieceInfo, rdf)
+	retrievalHandler := NewRetrievalDealHandler(&providerDealEnvironment{p}, retrievalDealRepo, storageDealsRepo, gatewayMarketClient, pieceStorageMgr, statsRecorder, guard)
+	p.requestValidator = NewProviderRequestValidator(cfg, storageDealsRepo, retrievalDealRepo, retrievalAskRepo, pieceInfo, rdf, guard)
 	transportConfigurer := dtutils.TransportConfigurer(network.ID(), &providerStoreGetter{retrievalDealRepo, p.stores})
-	p.reValidator = NewProviderRevalidator(fullNode, payAPI, retrievalDealRepo, retrievalHandler)
+	p.reValidator = NewProviderRevalidator(fullNode, payAPI, retrievalDealRepo, retrievalHandler, statsRecorder, guard)
+	p.retrievalDealHandler = retrievalHandler
 
 	var err error
 	if p.disableNewDeals {
@@ -146,11 +176,148 @@ func (p *RetrievalProvider) Stop() error {
 	return p.network.StopHandlingRequests()
 }
 
-// Start begins listening for deals on the given host.
+// Start begins listening for deals on the given host and restarts in progress deals.
 // Start must be called in order to accept incoming deals.
 func (p *RetrievalProvider) Start(ctx context.Context) error {
 	p.transportListener.Start()
-	return p.network.SetDelegate(p.retrievalStreamHandler)
+	if err := p.network.SetDelegate(p.retrievalStreamHandler); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := p.restartDeals(p.ctx); err != nil {
+			log.Errorf("failed to restart retrieval deals: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// IsTerminatedState returns true if the retrieval deal will not make any progress anymore
+func IsTerminatedState(status retrievalmarket.DealStatus) bool {
+	switch status {
+	case retrievalmarket.DealStatusCompleted, retrievalmarket.DealStatusErrored,
+		retrievalmarket.DealStatusRejected, retrievalmarket.DealStatusCancelled,
+		retrievalmarket.DealStatusDealNotFound:
+		return true
+	}
+	return false
+}
+
+func (p *RetrievalProvider) restartDeals(ctx context.Context) error {
+	deals, err := p.retrievalDealRepo.ListDeals(ctx, &types.RetrievalDealQueryParams{Page: types.Page{Limit: math.MaxInt32}})
+	if err != nil {
+		return err
+	}
+
+	for _, deal := range deals {
+		if IsTerminatedState(deal.Status) {
+			continue
+		}
+
+		go func(deal *types.ProviderDealState) {
+			log := log.With("receiver", deal.Receiver, "dealId", deal.ID, "status", retrievalmarket.DealStatuses[deal.Status])
+			if err := p.restartDeal(ctx, deal); err != nil {
+				log.Errorf("unable to restart deal: %v", err)
+				// let client know that the deal can not continue
+				if deal.ChannelID != nil {
+					if err := p.sendDealError(ctx, deal, err); err != nil {
+						log.Warnf("unable to send deal error to client: %v", err)
+					}
+					if err := (&providerDealEnvironment{p}).CloseDataTransfer(ctx, *deal.ChannelID); err != nil {
+						log.Warnf("unable to close data transfer channel: %v", err)
+					}
+				}
+				if err := p.retrievalDealHandler.Error(ctx, deal, fmt.Errorf("unable to resume deal after droplet restarted: %w", err)); err != nil {
+					log.Errorf("unable to save deal: %v", err)
+				}
+				return
+			}
+			log.Infof("restart deal success")
+		}(deal)
+	}
+	return nil
+}
+
+// restartDeal tries to continue a deal that was in progress when droplet stopped.
+// The read-only blockstore of a deal only lives in memory, so it has to be prepared again
+// before data transfer can be restarted.
+// dealSelector returns the selector of the deal proposal, or the selector exploring the whole dag if it's not specified
+func dealSelector(deal *types.ProviderDealState) (ipld.Node, error) {
+	if !deal.SelectorSpecified() {
+		return selectorparse.CommonSelector_ExploreAllRecursively, nil
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(deal.Selector.Raw)); err != nil {
+		return nil, fmt.Errorf("decode selector: %w", err)
+	}
+	return nb.Build(), nil
+}
+
+func (p *RetrievalProvider) restartDeal(ctx context.Context, deal *types.ProviderDealState) error {
+	if deal.Status == retrievalmarket.DealStatusFailing || deal.Status == retrievalmarket.DealStatusCancelling {
+		return p.retrievalDealHandler.CancelDeal(ctx, deal)
+	}
+
+	if deal.ChannelID == nil {
+		return errors.New("no data transfer channel was opened for the deal")
+	}
+	chst, err := p.dataTransfer.ChannelState(ctx, *deal.ChannelID)
+	if err != nil {
+		return fmt.Errorf("get data transfer channel %s: %w", deal.ChannelID, err)
+	}
+	if channels.IsChannelTerminated(chst.Status()) {
+		return fmt.Errorf("data transfer channel %s already terminated with status %s", deal.ChannelID, datatransfer.Statuses[chst.Status()])
+	}
+
+	// the resumed deal is counted by the guard as a new one, it's released when the deal finished
+	if p.guard != nil {
+		selector, err := dealSelector(deal)
+		if err != nil {
+			return err
+		}
+		if err := p.guard.AcquireRetrieval(deal.Receiver, deal.ID, selector); err != nil {
+			return err
+		}
+	}
+
+	switch deal.Status {
+	case retrievalmarket.DealStatusFundsNeededUnseal:
+		// the unseal will be started by revalidator after the client resends the payment
+	case retrievalmarket.DealStatusNew, retrievalmarket.DealStatusUnsealing:
+		// the restart request of the client is paused by the request validator while the deal is unsealing,
+		// the data transfer is resumed by the unseal after the data is ready
+		if err := p.dataTransfer.RestartDataTransferChannel(ctx, *deal.ChannelID); err != nil {
+			return fmt.Errorf("restart data transfer channel %s: %w", deal.ChannelID, err)
+		}
+		if err := p.retrievalDealHandler.UnsealData(ctx, deal); err != nil {
+			return fmt.Errorf("unseal data: %w", err)
+		}
+		return nil
+	default:
+		storageDeal, err := p.storageDealRepo.GetDeal(ctx, deal.SelStorageProposalCid)
+		if err != nil {
+			return fmt.Errorf("get storage deal %s: %w", deal.SelStorageProposalCid, err)
+		}
+		if err := (&providerDealEnvironment{p}).PrepareBlockstore(ctx, deal.ID, storageDeal.Proposal.PieceCID); err != nil {
+			return err
+		}
+	}
+
+	return p.dataTransfer.RestartDataTransferChannel(ctx, *deal.ChannelID)
+}
+
+// sendDealError sends the reason why the deal can not continue to the client, otherwise the client only sees
+// the data transfer channel closed
+func (p *RetrievalProvider) sendDealError(ctx context.Context, deal *types.ProviderDealState, dealErr error) error {
+	resp := finalResponse(errorDealResponse(deal.Identifier(), dealErr), deal.LegacyProtocol)
+	msg, err := message.CompleteResponse(deal.ChannelID.ID, false, false, resp.Type(), resp)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, shared.CloseDataTransferTimeout)
+	defer cancel()
+	return p.dtNet.SendMessage(ctx, deal.Receiver, msg)
 }
 
 // ListDeals lists all known retrieval deals
@@ -0,0 +1,209 @@
+package retrievalprovider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	dtnet "github.com/filecoin-project/go-data-transfer/network"
+	"github.com/filecoin-project/go-fil-markets/retrievalmarket"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+)
+
+// restartRecorder records the calls made while restarting deals in order
+type restartRecorder struct {
+	lk    sync.Mutex
+	calls []string
+	msgs  []datatransfer.Message
+}
+
+func (r *restartRecorder) record(call string) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *restartRecorder) recorded() []string {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+type restartTestChannel struct {
+	datatransfer.ChannelState
+	status datatransfer.Status
+}
+
+func (c restartTestChannel) Status() datatransfer.Status {
+	return c.status
+}
+
+type restartTestDataTransfer struct {
+	datatransfer.Manager
+	r      *restartRecorder
+	status datatransfer.Status
+}
+
+func (dt *restartTestDataTransfer) ChannelState(context.Context, datatransfer.ChannelID) (datatransfer.ChannelState, error) {
+	return restartTestChannel{status: dt.status}, nil
+}
+
+func (dt *restartTestDataTransfer) RestartDataTransferChannel(context.Context, datatransfer.ChannelID) error {
+	dt.r.record("restart")
+	return nil
+}
+
+func (dt *restartTestDataTransfer) CloseDataTransferChannel(context.Context, datatransfer.ChannelID) error {
+	dt.r.record("close")
+	return nil
+}
+
+type restartTestNetwork struct {
+	dtnet.DataTransferNetwork
+	r *restartRecorder
+}
+
+func (n *restartTestNetwork) SendMessage(_ context.Context, _ peer.ID, msg datatransfer.Message) error {
+	n.r.record("send")
+	n.r.lk.Lock()
+	defer n.r.lk.Unlock()
+	n.r.msgs = append(n.r.msgs, msg)
+	return nil
+}
+
+type restartTestHandler struct {
+	IRetrievalHandler
+	r         *restartRecorder
+	dealRepo  repo.IRetrievalDealRepo
+	unsealErr error
+}
+
+func (h *restartTestHandler) UnsealData(ctx context.Context, deal *types.ProviderDealState) error {
+	h.r.record("unseal")
+	if h.unsealErr != nil {
+		return h.unsealErr
+	}
+	deal.Status = retrievalmarket.DealStatusOngoing
+	return h.dealRepo.SaveDeal(ctx, deal)
+}
+
+func (h *restartTestHandler) Error(ctx context.Context, deal *types.ProviderDealState, err error) error {
+	deal.Status = retrievalmarket.DealStatusErrored
+	deal.Message = err.Error()
+	return h.dealRepo.SaveDeal(ctx, deal)
+}
+
+func newRestartTestProvider(t *testing.T, status datatransfer.Status, unsealErr error) (*RetrievalProvider, *restartRecorder, *types.ProviderDealState) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+
+	deal := &types.ProviderDealState{
+		DealProposal: retrievalmarket.DealProposal{ID: 1},
+		Receiver:     peer.ID("client"),
+		Status:       retrievalmarket.DealStatusUnsealing,
+		ChannelID:    &datatransfer.ChannelID{Initiator: peer.ID("client"), Responder: peer.ID("provider"), ID: 1},
+	}
+	require.NoError(t, r.RetrievalDealRepo().SaveDeal(ctx, deal))
+
+	rec := &restartRecorder{}
+	p := &RetrievalProvider{
+		dataTransfer:      &restartTestDataTransfer{r: rec, status: status},
+		dtNet:             &restartTestNetwork{r: rec},
+		retrievalDealRepo: r.RetrievalDealRepo(),
+		storageDealRepo:   r.StorageDealRepo(),
+		retrievalDealHandler: &restartTestHandler{
+			r:         rec,
+			dealRepo:  r.RetrievalDealRepo(),
+			unsealErr: unsealErr,
+		},
+	}
+	return p, rec, deal
+}
+
+func TestRestartDeals(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unseal after restarting channel", func(t *testing.T) {
+		p, rec, deal := newRestartTestProvider(t, datatransfer.ResponderPaused, nil)
+		require.NoError(t, p.restartDeals(ctx))
+
+		require.Eventually(t, func() bool {
+			return len(rec.recorded()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"restart", "unseal"}, rec.recorded())
+
+		res, err := p.retrievalDealRepo.GetDeal(ctx, deal.Receiver, deal.ID)
+		require.NoError(t, err)
+		assert.Equal(t, retrievalmarket.DealStatusOngoing, res.Status)
+	})
+
+	checkFailed := func(t *testing.T, p *RetrievalProvider, rec *restartRecorder, deal *types.ProviderDealState) {
+		var res *types.ProviderDealState
+		require.Eventually(t, func() bool {
+			var err error
+			res, err = p.retrievalDealRepo.GetDeal(ctx, deal.Receiver, deal.ID)
+			require.NoError(t, err)
+			return res.Status == retrievalmarket.DealStatusErrored
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, res.Message, "unable to resume deal after droplet restarted")
+
+		// the client is told why the deal failed before the channel is closed
+		calls := rec.recorded()
+		require.GreaterOrEqual(t, len(calls), 2)
+		assert.Equal(t, []string{"send", "close"}, calls[len(calls)-2:])
+		require.Len(t, rec.msgs, 1)
+		resp, ok := rec.msgs[0].(datatransfer.Response)
+		require.True(t, ok)
+		assert.False(t, resp.Accepted())
+		assert.True(t, resp.IsComplete())
+		assert.Equal(t, deal.ChannelID.ID, resp.TransferID())
+		assert.Equal(t, (&retrievalmarket.DealResponse{}).Type(), resp.VoucherResultType())
+	}
+
+	t.Run("channel terminated", func(t *testing.T) {
+		p, rec, deal := newRestartTestProvider(t, datatransfer.Cancelled, nil)
+		require.NoError(t, p.restartDeals(ctx))
+		checkFailed(t, p, rec, deal)
+		assert.NotContains(t, rec.recorded(), "restart")
+	})
+
+	t.Run("resumed deal counted by guard", func(t *testing.T) {
+		p, rec, deal := newRestartTestProvider(t, datatransfer.ResponderPaused, nil)
+		p.guard = newTestGuard(t, config.RetrievalLimits{PeerMaxConcurrentRetrievals: 1})
+		require.NoError(t, p.restartDeals(ctx))
+
+		require.Eventually(t, func() bool {
+			return len(rec.recorded()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Error(t, p.guard.AcquireRetrieval(deal.Receiver, deal.ID+1, selectorparse.CommonSelector_ExploreAllRecursively))
+	})
+
+	t.Run("guard rejected", func(t *testing.T) {
+		p, rec, deal := newRestartTestProvider(t, datatransfer.ResponderPaused, nil)
+		p.guard = newTestGuard(t, config.RetrievalLimits{PeerMaxConcurrentRetrievals: 1})
+		require.NoError(t, p.guard.AcquireRetrieval(deal.Receiver, deal.ID+1, selectorparse.CommonSelector_ExploreAllRecursively))
+		require.NoError(t, p.restartDeals(ctx))
+		checkFailed(t, p, rec, deal)
+		assert.NotContains(t, rec.recorded(), "restart")
+	})
+
+	t.Run("unseal failed", func(t *testing.T) {
+		p, rec, deal := newRestartTestProvider(t, datatransfer.ResponderPaused, errors.New("no sealer"))
+		require.NoError(t, p.restartDeals(ctx))
+		checkFailed(t, p, rec, deal)
+		assert.Equal(t, []string{"restart", "unseal", "send", "close"}, rec.recorded())
+	})
+}
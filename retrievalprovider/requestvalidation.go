@@ -42,6 +42,7 @@ type ProviderRequestValidator struct {
 	retrievalDeal repo.IRetrievalDealRepo
 	retrievalAsk  repo.IRetrievalAskRepo
 	rdf           config.RetrievalDealFilter
+	guard         *RetrievalGuard
 }
 
 // NewProviderRequestValidator returns a new instance of the ProviderRequestValidator
@@ -52,6 +53,7 @@ func NewProviderRequestValidator(
 	retrievalAsk repo.IRetrievalAskRepo,
 	pieceInfo *PieceInfo,
 	rdf config.RetrievalDealFilter,
+	guard *RetrievalGuard,
 ) *ProviderRequestValidator {
 	return &ProviderRequestValidator{
 		cfg:           cfg,
@@ -60,6 +62,7 @@ func NewProviderRequestValidator(
 		retrievalAsk:  retrievalAsk,
 		pieceInfo:     pieceInfo,
 		rdf:           rdf,
+		guard:         guard,
 	}
 }
 
@@ -124,12 +127,36 @@ func (rv *ProviderRequestValidator) validatePull(ctx context.Context, isRestart
 	}
 
 	// If the validation is for a restart request, return nil, which means
-	// the data-transfer should not be explicitly paused or resumed
+	// the data-transfer should not be explicitly paused or resumed, unless
+	// the data is not ready yet (eg. droplet restarted while unsealing)
 	if isRestart {
+		deal, err := rv.retrievalDeal.GetDeal(ctx, receiver, proposal.ID)
+		if err != nil {
+			log.Warnf("unable to find retrieval deal %d for restart request from %s: %v", proposal.ID, receiver, err)
+			return nil, nil
+		}
+		switch deal.Status {
+		case retrievalmarket.DealStatusNew, retrievalmarket.DealStatusFundsNeededUnseal, retrievalmarket.DealStatusUnsealing:
+			return nil, datatransfer.ErrPause
+		}
 		return nil, nil
 	}
 
 	// This is a new graphsync request (not a restart)
+	if err := rv.guard.AcquireRetrieval(receiver, proposal.ID, selector); err != nil {
+		return &retrievalmarket.DealResponse{
+			ID:      proposal.ID,
+			Status:  retrievalmarket.DealStatusRejected,
+			Message: err.Error(),
+		}, err
+	}
+	accepted := false
+	defer func() {
+		if !accepted {
+			rv.guard.ReleaseRetrieval(receiver, proposal.ID)
+		}
+	}()
+
 	pds := types.ProviderDealState{
 		DealProposal:    *proposal,
 		Receiver:        receiver,
@@ -169,6 +196,7 @@ func (rv *ProviderRequestValidator) validatePull(ctx context.Context, isRestart
 		return &response, err
 	}
 
+	accepted = true
 	// Pause the data transfer while unsealing the data.
 	// The state machine will unpause the transfer when unsealing completes.
 	return &response, datatransfer.ErrPause
@@ -32,15 +32,19 @@ type RetrievalDealHandler struct {
 	storageDealRepo     repo.StorageDealRepo
 	gatewayMarketClient gateway.IMarketClient
 	pieceStorageMgr     *piecestorage.PieceStorageManager
+	statsRecorder       *RetrievalStatsRecorder
+	guard               *RetrievalGuard
 }
 
-func NewRetrievalDealHandler(env ProviderDealEnvironment, retrievalDealStore repo.IRetrievalDealRepo, storageDealRepo repo.StorageDealRepo, gatewayMarketClient gateway.IMarketClient, pieceStorageMgr *piecestorage.PieceStorageManager) IRetrievalHandler {
+func NewRetrievalDealHandler(env ProviderDealEnvironment, retrievalDealStore repo.IRetrievalDealRepo, storageDealRepo repo.StorageDealRepo, gatewayMarketClient gateway.IMarketClient, pieceStorageMgr *piecestorage.PieceStorageManager, statsRecorder *RetrievalStatsRecorder, guard *RetrievalGuard) IRetrievalHandler {
 	return &RetrievalDealHandler{
 		env:                 env,
 		retrievalDealStore:  retrievalDealStore,
 		storageDealRepo:     storageDealRepo,
 		gatewayMarketClient: gatewayMarketClient,
 		pieceStorageMgr:     pieceStorageMgr,
+		statsRecorder:       statsRecorder,
+		guard:               guard,
 	}
 }
 
@@ -73,11 +77,14 @@ func (p *RetrievalDealHandler) UnsealData(ctx context.Context, providerDeal *mkt
 	} else {
 		// try unseal
 		var wps piecestorage.IPieceStorage
-		wps, err = p.pieceStorageMgr.FindStorageForWrite(int64(deal.Proposal.PieceSize))
+		var release func()
+		wps, release, err = p.pieceStorageMgr.FindStorageForWrite(deal.Proposal.Provider, int64(deal.Proposal.PieceSize))
 		if err != nil {
 			err = fmt.Errorf("failed to find storage to write %s: %w", deal.Proposal.PieceCID, err)
 			return
 		}
+		// the piece is uploaded to the storage during unsealing
+		defer release()
 
 		var pieceTransfer string
 		pieceTransfer, err = wps.GetPieceTransfer(ctx, pieceCid.String())
@@ -171,6 +178,7 @@ func (p *RetrievalDealHandler) CancelDeal(ctx context.Context, deal *mktypes.Pro
 			return p.Error(ctx, deal, nil)
 		}
 	}
+	p.recordFinished(ctx, deal, false)
 	deal.Status = rm.DealStatusCancelled
 	return p.retrievalDealStore.SaveDeal(ctx, deal)
 }
@@ -181,14 +189,28 @@ func (p *RetrievalDealHandler) CleanupDeal(ctx context.Context, deal *mktypes.Pr
 	if err != nil {
 		return p.Error(ctx, deal, nil)
 	}
+	p.recordFinished(ctx, deal, true)
 	deal.Status = rm.DealStatusCompleted
 	return p.retrievalDealStore.SaveDeal(ctx, deal)
 }
 
 func (p *RetrievalDealHandler) Error(ctx context.Context, deal *mktypes.ProviderDealState, err error) error {
+	p.recordFinished(ctx, deal, false)
 	deal.Status = rm.DealStatusErrored
 	if err != nil {
 		deal.Message = err.Error()
 	}
 	return p.retrievalDealStore.SaveDeal(ctx, deal)
 }
+
+// recordFinished releases the deal from the guard, and records the result of the deal if it has not
+// been finished before
+func (p *RetrievalDealHandler) recordFinished(ctx context.Context, deal *mktypes.ProviderDealState, succeeded bool) {
+	if p.guard != nil {
+		p.guard.ReleaseRetrieval(deal.Receiver, deal.ID)
+	}
+	if p.statsRecorder == nil || IsTerminatedState(deal.Status) {
+		return
+	}
+	p.statsRecorder.RecordDealFinished(ctx, deal, succeeded)
+}
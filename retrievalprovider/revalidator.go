@@ -23,15 +23,19 @@ type ProviderRevalidator struct {
 	payAPI               *paychmgr.PaychAPI
 	deals                repo.IRetrievalDealRepo
 	retrievalDealHandler IRetrievalHandler
+	statsRecorder        *RetrievalStatsRecorder
+	guard                *RetrievalGuard
 }
 
 // NewProviderRevalidator returns a new instance of a ProviderRevalidator
-func NewProviderRevalidator(fullNode v1api.FullNode, payAPI *paychmgr.PaychAPI, deals repo.IRetrievalDealRepo, retrievalDealHandler IRetrievalHandler) *ProviderRevalidator {
+func NewProviderRevalidator(fullNode v1api.FullNode, payAPI *paychmgr.PaychAPI, deals repo.IRetrievalDealRepo, retrievalDealHandler IRetrievalHandler, statsRecorder *RetrievalStatsRecorder, guard *RetrievalGuard) *ProviderRevalidator {
 	return &ProviderRevalidator{
 		fullNode:             fullNode,
 		payAPI:               payAPI,
 		deals:                deals,
 		retrievalDealHandler: retrievalDealHandler,
+		statsRecorder:        statsRecorder,
+		guard:                guard,
 	}
 }
 
@@ -73,6 +77,9 @@ func (pr *ProviderRevalidator) processPayment(ctx context.Context, deal *types.P
 		_ = pr.retrievalDealHandler.CancelDeal(ctx, deal)
 		return errorDealResponse(deal.Identifier(), err), err
 	}
+	if pr.statsRecorder != nil {
+		pr.statsRecorder.RecordPayment(ctx, deal, payment.PaymentChannel, received)
+	}
 
 	totalPaid := big.Add(deal.FundsReceived, received)
 
@@ -198,6 +205,14 @@ func (pr *ProviderRevalidator) OnPullDataSent(chid datatransfer.ChannelID, addit
 		return true, nil, err
 	}
 
+	if pr.statsRecorder != nil {
+		pr.statsRecorder.RecordDataSent(ctx, deal, additionalBytesSent)
+	}
+	if err := pr.guard.OnDataSent(deal, additionalBytesSent); err != nil {
+		_ = pr.retrievalDealHandler.Error(ctx, deal, err)
+		return true, finalResponse(errorDealResponse(deal.Identifier(), err), deal.LegacyProtocol), err
+	}
+
 	totalSent := deal.TotalSent
 	totalPaidFor := deal.TotalPaidFor()
 
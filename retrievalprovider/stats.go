@@ -0,0 +1,298 @@
+package retrievalprovider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	types "github.com/filecoin-project/venus/venus-shared/types/market"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+
+	"github.com/ipfs-force-community/metrics"
+
+	"github.com/ipfs-force-community/droplet/v2/models/repo"
+	"github.com/ipfs-force-community/droplet/v2/paychmgr"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+var statsFlushInterval = time.Minute
+
+// RetrievalStatsRecorder accumulates the retrieval accounting per client, payload and miner
+// in memory, and flushes it to the time buckets in the repo periodically
+type RetrievalStatsRecorder struct {
+	statsRepo    repo.IRetrievalStatsRepo
+	storageDeals repo.StorageDealRepo
+
+	lk      sync.Mutex
+	pending map[mtypes.RetrievalStatsKey]*mtypes.RetrievalStats
+
+	// payment channel -> the vouchers received through it which have not been redeemed, in order
+	unsettled map[address.Address][]*unsettledFunds
+
+	// proposal cid or piece cid -> miner
+	miners *lru.Cache[cid.Cid, address.Address]
+}
+
+// unsettledFunds is the value of the vouchers received for a stats key which have not been redeemed
+type unsettledFunds struct {
+	key    mtypes.RetrievalStatsKey
+	amount abi.TokenAmount
+}
+
+func NewRetrievalStatsRecorder(mCtx metrics.MetricsCtx, lc fx.Lifecycle, r repo.Repo, payMgr *paychmgr.Manager) (*RetrievalStatsRecorder, error) {
+	miners, err := lru.New[cid.Cid, address.Address](1024)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &RetrievalStatsRecorder{
+		statsRepo:    r.RetrievalStatsRepo(),
+		storageDeals: r.StorageDealRepo(),
+		pending:      make(map[mtypes.RetrievalStatsKey]*mtypes.RetrievalStats),
+		unsettled:    make(map[address.Address][]*unsettledFunds),
+		miners:       miners,
+	}
+	if payMgr != nil {
+		payMgr.SubscribeVoucherRedeemed(recorder.RecordSettlement)
+	}
+
+	ctx := metrics.LifecycleCtx(mCtx, lc)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go recorder.loop(ctx)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return recorder.Flush(ctx)
+		},
+	})
+
+	return recorder, nil
+}
+
+func (r *RetrievalStatsRecorder) loop(ctx context.Context) {
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Warnf("flush retrieval stats: %v", err)
+			}
+		}
+	}
+}
+
+// RecordDataSent records the bytes sent over graphsync for a retrieval deal
+func (r *RetrievalStatsRecorder) RecordDataSent(ctx context.Context, deal *types.ProviderDealState, bytes uint64) {
+	r.update(ctx, deal, func(s *mtypes.RetrievalStats) {
+		s.GraphsyncBytesSent += bytes
+	})
+}
+
+// RecordPayment records a voucher received through the payment channel for a retrieval deal
+func (r *RetrievalStatsRecorder) RecordPayment(ctx context.Context, deal *types.ProviderDealState, paych address.Address, amount abi.TokenAmount) {
+	key := r.dealKey(ctx, deal)
+	r.add(key, func(s *mtypes.RetrievalStats) {
+		s.VouchersReceived++
+		s.FundsReceived = big.Add(s.FundsReceived, amount)
+	})
+	r.addUnsettled(paych, key, amount)
+}
+
+// RecordDealFinished records the result of a retrieval deal
+func (r *RetrievalStatsRecorder) RecordDealFinished(ctx context.Context, deal *types.ProviderDealState, succeeded bool) {
+	r.update(ctx, deal, func(s *mtypes.RetrievalStats) {
+		if succeeded {
+			s.Succeeded++
+		} else {
+			s.Failed++
+		}
+	})
+}
+
+// RecordHTTPRetrieval records a piece retrieval over http
+func (r *RetrievalStatsRecorder) RecordHTTPRetrieval(ctx context.Context, client string, pieceCID cid.Cid, bytes uint64, succeeded bool) {
+	miner := r.minerOfPiece(ctx, pieceCID)
+	r.add(mtypes.RetrievalStatsKey{Miner: miner, Client: client, PayloadCID: pieceCID}, func(s *mtypes.RetrievalStats) {
+		s.HTTPBytesSent += bytes
+		if succeeded {
+			s.Succeeded++
+		} else {
+			s.Failed++
+		}
+	})
+}
+
+// RecordHTTPPayment records a voucher received through the payment channel for a piece retrieval over http
+func (r *RetrievalStatsRecorder) RecordHTTPPayment(ctx context.Context, client string, pieceCID cid.Cid, paych address.Address, amount abi.TokenAmount) {
+	key := mtypes.RetrievalStatsKey{Miner: r.minerOfPiece(ctx, pieceCID), Client: client, PayloadCID: pieceCID}
+	r.add(key, func(s *mtypes.RetrievalStats) {
+		s.VouchersReceived++
+		s.FundsReceived = big.Add(s.FundsReceived, amount)
+	})
+	r.addUnsettled(paych, key, amount)
+}
+
+// RecordSettlement records the value redeemed from the payment channel, it's attributed to the vouchers
+// received through the channel in order. The vouchers received before droplet started are unknown,
+// the value redeemed for them is recorded without miner, client and payload.
+func (r *RetrievalStatsRecorder) RecordSettlement(_ context.Context, paych address.Address, amount abi.TokenAmount) {
+	var settled []unsettledFunds
+	left := amount
+
+	r.lk.Lock()
+	queue := r.unsettled[paych]
+	for len(queue) > 0 && left.GreaterThan(big.Zero()) {
+		head := queue[0]
+		part := big.Min(head.amount, left)
+		settled = append(settled, unsettledFunds{key: head.key, amount: part})
+		left = big.Sub(left, part)
+		head.amount = big.Sub(head.amount, part)
+		if !head.amount.GreaterThan(big.Zero()) {
+			queue = queue[1:]
+		}
+	}
+	if len(queue) == 0 {
+		delete(r.unsettled, paych)
+	} else {
+		r.unsettled[paych] = queue
+	}
+	r.lk.Unlock()
+
+	if left.GreaterThan(big.Zero()) {
+		log.Debugf("no voucher received through channel %s for the redeemed value %s", paych, left)
+		settled = append(settled, unsettledFunds{amount: left})
+	}
+	for _, funds := range settled {
+		amount := funds.amount
+		r.add(funds.key, func(s *mtypes.RetrievalStats) {
+			s.FundsSettled = big.Add(s.FundsSettled, amount)
+		})
+	}
+}
+
+func (r *RetrievalStatsRecorder) addUnsettled(paych address.Address, key mtypes.RetrievalStatsKey, amount abi.TokenAmount) {
+	if paych.Empty() || !amount.GreaterThan(big.Zero()) {
+		return
+	}
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	// the consecutive vouchers of the same key are merged
+	queue := r.unsettled[paych]
+	if n := len(queue); n > 0 && queue[n-1].key == key {
+		queue[n-1].amount = big.Add(queue[n-1].amount, amount)
+		return
+	}
+	r.unsettled[paych] = append(queue, &unsettledFunds{key: key, amount: amount})
+}
+
+func (r *RetrievalStatsRecorder) update(ctx context.Context, deal *types.ProviderDealState, cb func(s *mtypes.RetrievalStats)) {
+	r.add(r.dealKey(ctx, deal), cb)
+}
+
+func (r *RetrievalStatsRecorder) dealKey(ctx context.Context, deal *types.ProviderDealState) mtypes.RetrievalStatsKey {
+	return mtypes.RetrievalStatsKey{
+		Miner:      r.minerOfDeal(ctx, deal),
+		Client:     deal.Receiver.String(),
+		PayloadCID: deal.PayloadCID,
+	}
+}
+
+func (r *RetrievalStatsRecorder) add(key mtypes.RetrievalStatsKey, cb func(s *mtypes.RetrievalStats)) {
+	key.Bucket = mtypes.RetrievalStatsBucket(time.Now())
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	s, ok := r.pending[key]
+	if !ok {
+		s = &mtypes.RetrievalStats{RetrievalStatsKey: key, FundsReceived: big.Zero(), FundsSettled: big.Zero()}
+		r.pending[key] = s
+	}
+	cb(s)
+}
+
+func (r *RetrievalStatsRecorder) minerOfDeal(ctx context.Context, deal *types.ProviderDealState) address.Address {
+	if !deal.SelStorageProposalCid.Defined() {
+		return address.Undef
+	}
+	if miner, ok := r.miners.Get(deal.SelStorageProposalCid); ok {
+		return miner
+	}
+
+	storageDeal, err := r.storageDeals.GetDeal(ctx, deal.SelStorageProposalCid)
+	if err != nil {
+		log.Debugf("get storage deal %s of retrieval deal %d: %v", deal.SelStorageProposalCid, deal.ID, err)
+		return address.Undef
+	}
+	r.miners.Add(deal.SelStorageProposalCid, storageDeal.Proposal.Provider)
+	return storageDeal.Proposal.Provider
+}
+
+func (r *RetrievalStatsRecorder) minerOfPiece(ctx context.Context, pieceCID cid.Cid) address.Address {
+	if miner, ok := r.miners.Get(pieceCID); ok {
+		return miner
+	}
+
+	deals, err := r.storageDeals.GetDealsByPieceCidAndStatus(ctx, pieceCID)
+	if err != nil || len(deals) == 0 {
+		log.Debugf("get storage deals of piece %s: %v", pieceCID, err)
+		return address.Undef
+	}
+	r.miners.Add(pieceCID, deals[0].Proposal.Provider)
+	return deals[0].Proposal.Provider
+}
+
+// Flush writes the pending stats to the repo
+func (r *RetrievalStatsRecorder) Flush(ctx context.Context) error {
+	r.lk.Lock()
+	pending := r.pending
+	r.pending = make(map[mtypes.RetrievalStatsKey]*mtypes.RetrievalStats)
+	r.lk.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	stats := make([]*mtypes.RetrievalStats, 0, len(pending))
+	for _, s := range pending {
+		stats = append(stats, s)
+	}
+	if err := r.statsRepo.AddStats(ctx, stats); err != nil {
+		// put the stats back, so they can be written next time
+		r.lk.Lock()
+		for key, s := range pending {
+			if cur, ok := r.pending[key]; ok {
+				s.Add(cur)
+			}
+			r.pending[key] = s
+		}
+		r.lk.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ListStats flushes the pending stats, and lists the stats in the repo aggregated by GroupBy of params
+func (r *RetrievalStatsRecorder) ListStats(ctx context.Context, params *mtypes.RetrievalStatsQueryParams) ([]*mtypes.RetrievalStats, error) {
+	if err := r.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	stats, err := r.statsRepo.ListStats(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return mtypes.AggregateRetrievalStats(stats, params.GroupBy), nil
+}
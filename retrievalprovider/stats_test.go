@@ -0,0 +1,73 @@
+package retrievalprovider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/venus/venus-shared/testutil"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/models/badger"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func TestRecordSettlement(t *testing.T) {
+	ctx := context.Background()
+	r, err := badger.NewMemRepo()
+	require.NoError(t, err)
+	miners, err := lru.New[cid.Cid, address.Address](1024)
+	require.NoError(t, err)
+	recorder := &RetrievalStatsRecorder{
+		statsRepo:    r.RetrievalStatsRepo(),
+		storageDeals: r.StorageDealRepo(),
+		pending:      make(map[mtypes.RetrievalStatsKey]*mtypes.RetrievalStats),
+		unsettled:    make(map[address.Address][]*unsettledFunds),
+		miners:       miners,
+	}
+
+	var pieces [2]cid.Cid
+	var paychs [2]address.Address
+	testutil.Provide(t, &pieces)
+	testutil.Provide(t, &paychs)
+
+	recorder.RecordHTTPPayment(ctx, "client1", pieces[0], paychs[0], big.NewInt(100))
+	recorder.RecordHTTPPayment(ctx, "client1", pieces[0], paychs[0], big.NewInt(50))
+	recorder.RecordHTTPPayment(ctx, "client1", pieces[1], paychs[0], big.NewInt(100))
+	recorder.RecordHTTPPayment(ctx, "client2", pieces[0], paychs[1], big.NewInt(100))
+
+	// the value redeemed is attributed to the vouchers of the channel in order
+	recorder.RecordSettlement(ctx, paychs[0], big.NewInt(200))
+	// the value of the vouchers received before droplet started is recorded without client
+	recorder.RecordSettlement(ctx, paychs[0], big.NewInt(80))
+
+	stats, err := recorder.ListStats(ctx, &mtypes.RetrievalStatsQueryParams{GroupBy: mtypes.RetrievalStatsGroupByClient})
+	require.NoError(t, err)
+	settled := make(map[string]big.Int)
+	received := make(map[string]big.Int)
+	for _, s := range stats {
+		settled[s.Client] = s.FundsSettled
+		received[s.Client] = s.FundsReceived
+	}
+	assert.Equal(t, "250", received["client1"].String())
+	assert.Equal(t, "100", received["client2"].String())
+	assert.Equal(t, "250", settled["client1"].String())
+	assert.Equal(t, "0", settled["client2"].String())
+	assert.Equal(t, "30", settled[""].String())
+
+	stats, err = recorder.ListStats(ctx, &mtypes.RetrievalStatsQueryParams{Client: "client1", GroupBy: mtypes.RetrievalStatsGroupByPayload})
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	for _, s := range stats {
+		if s.PayloadCID == pieces[0] {
+			assert.Equal(t, "150", s.FundsSettled.String())
+		} else {
+			assert.Equal(t, "100", s.FundsSettled.String())
+		}
+	}
+	assert.Empty(t, recorder.unsettled)
+}
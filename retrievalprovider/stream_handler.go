@@ -30,10 +30,11 @@ type RetrievalStreamHandler struct {
 	retrievalDealStore repo.IRetrievalDealRepo
 	storageDealStore   repo.StorageDealRepo
 	pieceInfo          *PieceInfo
+	guard              *RetrievalGuard
 }
 
-func NewRetrievalStreamHandler(cfg *config.MarketConfig, askRepo repo.IRetrievalAskRepo, retrievalDealStore repo.IRetrievalDealRepo, storageDealStore repo.StorageDealRepo, pieceInfo *PieceInfo) *RetrievalStreamHandler {
-	return &RetrievalStreamHandler{cfg: cfg, askRepo: askRepo, retrievalDealStore: retrievalDealStore, storageDealStore: storageDealStore, pieceInfo: pieceInfo}
+func NewRetrievalStreamHandler(cfg *config.MarketConfig, askRepo repo.IRetrievalAskRepo, retrievalDealStore repo.IRetrievalDealRepo, storageDealStore repo.StorageDealRepo, pieceInfo *PieceInfo, guard *RetrievalGuard) *RetrievalStreamHandler {
+	return &RetrievalStreamHandler{cfg: cfg, askRepo: askRepo, retrievalDealStore: retrievalDealStore, storageDealStore: storageDealStore, pieceInfo: pieceInfo, guard: guard}
 }
 
 /*
@@ -86,7 +87,14 @@ func (p *RetrievalStreamHandler) HandleQueryStream(stream rmnet.RetrievalQuerySt
 		UnsealPrice:     big.Zero(),
 	}
 
-	minerDeals, err := p.pieceInfo.GetPieceInfoFromCid(ctx, query.PayloadCID, query.PieceCID)
+	if err := p.guard.AllowQuery(stream.RemotePeer()); err != nil {
+		answer.Status = retrievalmarket.QueryResponseError
+		answer.Message = err.Error()
+		sendResp(answer)
+		return
+	}
+
+	minerDeals, err := p.pieceInfo.QueryPieceInfoFromCid(ctx, query.PayloadCID, query.PieceCID)
 	if err != nil {
 		answer.Status = retrievalmarket.QueryResponseError
 		if errors.Is(err, repo.ErrNotFound) {
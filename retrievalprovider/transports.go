@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/ipfs-force-community/droplet/v2/config"
+	"github.com/ipfs-force-community/droplet/v2/retrievalprovider/bitswap"
 	"github.com/ipfs-force-community/droplet/v2/types"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -18,7 +19,7 @@ type TransportsListener struct {
 	protocols []types.Protocol
 }
 
-func NewTransportsListener(h host.Host, cfg *config.MarketConfig) (*TransportsListener, error) {
+func NewTransportsListener(h host.Host, cfg *config.MarketConfig, bs *bitswap.Server) (*TransportsListener, error) {
 	var protos []types.Protocol
 
 	// Get the libp2p addresses from the Host
@@ -44,6 +45,19 @@ func NewTransportsListener(h host.Host, cfg *config.MarketConfig) (*TransportsLi
 		})
 	}
 
+	// If bitswap retrieval is enabled, add bitswap to the list of supported protocols
+	if bs != nil {
+		maddrs, err := bs.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("could not get bitswap addresses: %w", err)
+		}
+
+		protos = append(protos, types.Protocol{
+			Name:      "bitswap",
+			Addresses: maddrs,
+		})
+	}
+
 	return &TransportsListener{
 		host:      h,
 		protocols: protos,
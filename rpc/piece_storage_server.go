@@ -5,6 +5,9 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
 
 	"github.com/ipfs-force-community/droplet/v2/piecestorage"
 	logging "github.com/ipfs/go-log/v2"
@@ -16,18 +19,33 @@ var _ http.Handler = (*PieceStorageServer)(nil)
 
 type PieceStorageServer struct {
 	pieceStorageMgr *piecestorage.PieceStorageManager
+	uploader        *piecestorage.PieceUploader
 }
 
-func NewPieceStorageServer(pieceStorageMgr *piecestorage.PieceStorageManager) *PieceStorageServer {
-	return &PieceStorageServer{pieceStorageMgr: pieceStorageMgr}
+func NewPieceStorageServer(pieceStorageMgr *piecestorage.PieceStorageManager, uploader *piecestorage.PieceUploader) *PieceStorageServer {
+	return &PieceStorageServer{pieceStorageMgr: pieceStorageMgr, uploader: uploader}
 }
 
 func (p *PieceStorageServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	switch req.Method {
-	case http.MethodGet:
+	// the requests with upload-id are for the resumable uploads
+	isUpload := req.URL.Query().Has("upload-id")
+	switch {
+	case req.Method == http.MethodGet && isUpload:
+		p.handleUploadStatus(res, req)
+	case req.Method == http.MethodGet:
 		p.handleGet(res, req)
-	case http.MethodPut:
+	case req.Method == http.MethodHead:
+		p.handleHead(res, req)
+	case req.Method == http.MethodPost && isUpload:
+		p.handleUploadComplete(res, req)
+	case req.Method == http.MethodPost:
+		p.handleUploadCreate(res, req)
+	case req.Method == http.MethodPut && isUpload:
+		p.handleUploadChunk(res, req)
+	case req.Method == http.MethodPut:
 		p.handlePut(res, req)
+	case req.Method == http.MethodDelete && isUpload:
+		p.handleUploadAbort(res, req)
 	default:
 		// handle error
 		logErrorAndResonse(res, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -50,6 +68,12 @@ func (p *PieceStorageServer) handleGet(res http.ResponseWriter, req *http.Reques
 		return
 	}
 
+	// range requests are used by remote piece storage to mount a piece, serve them directly
+	if rangeHeader := req.Header.Get("Range"); len(rangeHeader) > 0 {
+		p.handleRangeGet(res, req, pieceStorage, resourceID, rangeHeader)
+		return
+	}
+
 	redirectUrl, err := pieceStorage.GetRedirectUrl(ctx, resourceID)
 	if err != nil && err != piecestorage.ErrUnsupportRedirect {
 		logErrorAndResonse(res, fmt.Sprintf("fail to get redirect url of piece  %s: %s", resourceID, err), http.StatusInternalServerError)
@@ -87,6 +111,110 @@ func (p *PieceStorageServer) handleGet(res http.ResponseWriter, req *http.Reques
 	_, _ = io.Copy(res, r)
 }
 
+// handleHead returns the size of resource without redirecting
+func (p *PieceStorageServer) handleHead(res http.ResponseWriter, req *http.Request) {
+	resourceID := req.URL.Query().Get("resource-id")
+	if len(resourceID) == 0 {
+		logErrorAndResonse(res, "resource is empty", http.StatusBadRequest)
+		return
+	}
+
+	pieceStorage, err := p.pieceStorageMgr.FindStorageForRead(req.Context(), resourceID)
+	if err != nil {
+		// not found is expected when checking whether a resource exists, so don't log it
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	flen, err := pieceStorage.Len(req.Context(), resourceID)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("call piecestore.Len for %s: %s", resourceID, err), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Length", strconv.FormatInt(flen, 10))
+	res.Header().Set("Accept-Ranges", "bytes")
+	res.WriteHeader(http.StatusOK)
+}
+
+// handleRangeGet serves a single range of resource, eg. `Range: bytes=0-1023` or `Range: bytes=1024-`
+func (p *PieceStorageServer) handleRangeGet(res http.ResponseWriter, req *http.Request, pieceStorage piecestorage.IPieceStorage, resourceID, rangeHeader string) {
+	flen, err := pieceStorage.Len(req.Context(), resourceID)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("call piecestore.Len for %s: %s", resourceID, err), http.StatusInternalServerError)
+		return
+	}
+
+	start, end, err := parseRange(rangeHeader, flen)
+	if err != nil {
+		res.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", flen))
+		logErrorAndResonse(res, fmt.Sprintf("invalid range %s of %s: %s", rangeHeader, resourceID, err), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	r, err := pieceStorage.GetMountReader(req.Context(), resourceID)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("failed to open reader for %s: %s", resourceID, err), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err = r.Close(); err != nil {
+			log.Errorf("unable to close http %v", err)
+		}
+	}()
+
+	res.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	res.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, flen))
+	res.WriteHeader(http.StatusPartialContent)
+	_, _ = io.Copy(res, io.NewSectionReader(r, start, end-start+1))
+}
+
+// parseRange parses a single range of http Range header, multiple ranges are not supported
+func parseRange(rangeHeader string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return 0, 0, fmt.Errorf("only bytes range is supported")
+	}
+	spec := strings.TrimPrefix(rangeHeader, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("only a single bytes range is supported")
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed range")
+	}
+
+	var start, end int64
+	var err error
+	if len(startStr) == 0 {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("malformed suffix range")
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+	} else {
+		start, err = strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("malformed range start")
+		}
+		end = size - 1
+		if len(endStr) > 0 {
+			end, err = strconv.ParseInt(endStr, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, fmt.Errorf("malformed range end")
+			}
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range start %d exceeds size %d", start, size)
+	}
+	return start, end, nil
+}
+
 // handlePut save resource to piece storage
 // url example: http://market/resource?resource-id=xxx&store=xxx or http://market/resource?resource-id=xxx&size=xxx
 func (p *PieceStorageServer) handlePut(res http.ResponseWriter, req *http.Request) {
@@ -108,6 +236,7 @@ func (p *PieceStorageServer) handlePut(res http.ResponseWriter, req *http.Reques
 	}
 
 	var store piecestorage.IPieceStorage
+	release := func() {}
 	if req.URL.Query().Has("store") {
 		storeName := req.URL.Query().Get("store")
 
@@ -125,13 +254,15 @@ func (p *PieceStorageServer) handlePut(res http.ResponseWriter, req *http.Reques
 			logErrorAndResonse(res, fmt.Sprintf("size %s is invalid", sizeStr), http.StatusBadRequest)
 			return
 		}
-		store, err = p.pieceStorageMgr.FindStorageForWrite(size)
+		store, release, err = p.pieceStorageMgr.FindStorageForWrite(address.Undef, size)
 		if err != nil {
 			logErrorAndResonse(res, fmt.Sprintf("fail to find store for write: %s", err), http.StatusInternalServerError)
 			return
 		}
 	}
 
+	defer release()
+
 	_, err := store.SaveTo(ctx, resourceID, req.Body)
 	if err != nil {
 		logErrorAndResonse(res, fmt.Sprintf("fail to save resource %s to store %s: %s", resourceID, store.GetName(), err), http.StatusInternalServerError)
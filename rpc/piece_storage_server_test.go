@@ -20,7 +20,10 @@ func setupTestServer(t *testing.T) (*piecestorage.MemPieceStore, http.Handler) {
 	require.NoError(t, err)
 	ps := piecestorage.NewMemPieceStore("memtest", nil)
 	pm.AddMemPieceStorage(ps)
-	pss := NewPieceStorageServer(pm)
+	homeDir := config.HomeDir(t.TempDir())
+	uploader, err := piecestorage.NewPieceUploader(&homeDir, pm)
+	require.NoError(t, err)
+	pss := NewPieceStorageServer(pm, uploader)
 	return ps, pss
 }
 
@@ -64,3 +67,112 @@ func TestResouceDownload(t *testing.T) {
 		assert.Equal(t, "mock resource2 content", string(result))
 	})
 }
+
+func TestResourceHeadAndRange(t *testing.T) {
+	ctx := context.Background()
+	ps, psm := setupTestServer(t)
+
+	resourceId := "s1"
+	content := "mock resource1 content"
+	_, err := ps.SaveTo(ctx, resourceId, bytes.NewBufferString(content))
+	require.NoError(t, err)
+	// range and head requests are not redirected
+	ps.RedirectResources[resourceId] = true
+
+	t.Run("head", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, fmt.Sprintf("http://127.0.0.1:3030?resource-id=%s", resourceId), nil)
+		w := httptest.NewRecorder()
+		psm.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprint(len(content)), w.Header().Get("Content-Length"))
+
+		req = httptest.NewRequest(http.MethodHead, "http://127.0.0.1:3030?resource-id=not-exist", nil)
+		w = httptest.NewRecorder()
+		psm.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		for rangeHeader, expect := range map[string]string{
+			"bytes=0-3":    content[:4],
+			"bytes=5-":     content[5:],
+			"bytes=-7":     content[len(content)-7:],
+			"bytes=10-999": content[10:],
+		} {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:3030?resource-id=%s", resourceId), nil)
+			req.Header.Set("Range", rangeHeader)
+			w := httptest.NewRecorder()
+			psm.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusPartialContent, w.Code, rangeHeader)
+			assert.Equal(t, expect, w.Body.String(), rangeHeader)
+		}
+
+		for _, rangeHeader := range []string{"bytes=100-", "bytes=3-1", "bytes=0-1,3-4", "items=0-1"} {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:3030?resource-id=%s", resourceId), nil)
+			req.Header.Set("Range", rangeHeader)
+			w := httptest.NewRecorder()
+			psm.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code, rangeHeader)
+		}
+	})
+}
+
+func TestRemotePieceStorage(t *testing.T) {
+	ctx := context.Background()
+	ps, psm := setupTestServer(t)
+	srv := httptest.NewServer(psm)
+	defer srv.Close()
+
+	remote, err := piecestorage.NewRemotePieceStorage(&config.RemotePieceStorage{Name: "remote", Url: srv.URL, Store: "memtest"})
+	require.NoError(t, err)
+	require.NoError(t, remote.Validate(""))
+
+	content := []byte("mock remote resource content")
+	n, err := remote.SaveTo(ctx, "r1", bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), n)
+	has, err := ps.Has(ctx, "r1")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	has, err = remote.Has(ctx, "r1")
+	require.NoError(t, err)
+	assert.True(t, has)
+	has, err = remote.Has(ctx, "not-exist")
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	size, err := remote.Len(ctx, "r1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	r, err := remote.GetReaderCloser(ctx, "r1")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, data)
+
+	mr, err := remote.GetMountReader(ctx, "r1")
+	require.NoError(t, err)
+	defer mr.Close() // nolint
+	buf := make([]byte, 6)
+	_, err = mr.ReadAt(buf, 5)
+	require.NoError(t, err)
+	assert.Equal(t, content[5:11], buf)
+	_, err = mr.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+	data, err = io.ReadAll(mr)
+	require.NoError(t, err)
+	assert.Equal(t, content[7:], data)
+	_, err = mr.Seek(-4, io.SeekEnd)
+	require.NoError(t, err)
+	data, err = io.ReadAll(mr)
+	require.NoError(t, err)
+	assert.Equal(t, content[len(content)-4:], data)
+
+	readonly, err := piecestorage.NewRemotePieceStorage(&config.RemotePieceStorage{Name: "remote", Url: srv.URL, ReadOnly: true})
+	require.NoError(t, err)
+	_, err = readonly.SaveTo(ctx, "r2", bytes.NewReader(content))
+	assert.Error(t, err)
+}
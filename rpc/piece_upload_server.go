@@ -0,0 +1,174 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs-force-community/droplet/v2/piecestorage"
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+// The resumable upload through /resource:
+//
+//	POST   /resource?resource-id=xxx&size=xxx[&store=xxx][&sha256=xxx][&redirect=true]  create an upload session
+//	PUT    /resource?upload-id=xxx with `Content-Range: bytes start-end/size`           upload a chunk
+//	PUT    /resource?upload-id=xxx without Content-Range                              retry to publish a received upload
+//	GET    /resource?upload-id=xxx                                                    get the state of upload
+//	POST   /resource?upload-id=xxx with the parts in body                             complete a multipart upload
+//	DELETE /resource?upload-id=xxx                                                    abort the upload
+//
+// The responses of upload are the upload session in json, and the offset to resume is also set in the header `Upload-Offset`.
+// The upload is publishing after the last chunk is received, it's verified and saved in the background, and the state
+// is polled until it's published or failed, it's uploading with the error if it fails to be saved, which is retried by PUT.
+
+const uploadOffsetHeader = "Upload-Offset"
+
+func writeUploadSession(res http.ResponseWriter, session *mtypes.PieceUploadSession, code int) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	res.WriteHeader(code)
+	if err := json.NewEncoder(res).Encode(session); err != nil {
+		resourceLog.Errorf("write upload session %s: %v", session.ID, err)
+	}
+}
+
+func uploadErrorCode(err error) int {
+	switch {
+	case errors.Is(err, piecestorage.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, piecestorage.ErrUploadOffsetMismatch), errors.Is(err, piecestorage.ErrUploadBusy):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeUploadError responds the error, the offset to resume is set if the session is known
+func writeUploadError(res http.ResponseWriter, session *mtypes.PieceUploadSession, err error) {
+	if session != nil {
+		res.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	}
+	logErrorAndResonse(res, err.Error(), uploadErrorCode(err))
+}
+
+func (p *PieceStorageServer) handleUploadCreate(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("size %s is invalid", query.Get("size")), http.StatusBadRequest)
+		return
+	}
+	params := &piecestorage.PieceUploadParams{
+		ResourceID: query.Get("resource-id"),
+		Store:      query.Get("store"),
+		Size:       size,
+		Sha256:     query.Get("sha256"),
+		Redirect:   query.Get("redirect") == "true",
+	}
+	session, err := p.uploader.Create(req.Context(), params)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("create upload of %s: %s", params.ResourceID, err), http.StatusBadRequest)
+		return
+	}
+	writeUploadSession(res, session, http.StatusCreated)
+}
+
+func (p *PieceStorageServer) handleUploadStatus(res http.ResponseWriter, req *http.Request) {
+	session, err := p.uploader.Get(req.URL.Query().Get("upload-id"))
+	if err != nil {
+		writeUploadError(res, nil, err)
+		return
+	}
+	writeUploadSession(res, session, http.StatusOK)
+}
+
+func (p *PieceStorageServer) handleUploadChunk(res http.ResponseWriter, req *http.Request) {
+	id := req.URL.Query().Get("upload-id")
+	session, err := p.uploader.Get(id)
+	if err != nil {
+		writeUploadError(res, nil, err)
+		return
+	}
+
+	contentRange := req.Header.Get("Content-Range")
+	if len(contentRange) == 0 {
+		session, err = p.uploader.Republish(req.Context(), id)
+		if err != nil {
+			writeUploadError(res, session, err)
+			return
+		}
+		writeUploadSession(res, session, http.StatusOK)
+		return
+	}
+
+	start, end, total, err := parseContentRange(contentRange)
+	if err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("invalid content range %s: %s", contentRange, err), http.StatusBadRequest)
+		return
+	}
+	if total != session.Size {
+		logErrorAndResonse(res, fmt.Sprintf("total size %d of content range mismatch the upload size %d", total, session.Size), http.StatusBadRequest)
+		return
+	}
+
+	session, err = p.uploader.Write(req.Context(), id, start, end-start+1, req.Body)
+	if err != nil {
+		writeUploadError(res, session, err)
+		return
+	}
+	writeUploadSession(res, session, http.StatusOK)
+}
+
+func (p *PieceStorageServer) handleUploadComplete(res http.ResponseWriter, req *http.Request) {
+	var parts []*mtypes.PieceUploadPart
+	if err := json.NewDecoder(req.Body).Decode(&parts); err != nil {
+		logErrorAndResonse(res, fmt.Sprintf("decode parts: %s", err), http.StatusBadRequest)
+		return
+	}
+	session, err := p.uploader.Complete(req.Context(), req.URL.Query().Get("upload-id"), parts)
+	if err != nil {
+		writeUploadError(res, session, err)
+		return
+	}
+	writeUploadSession(res, session, http.StatusOK)
+}
+
+func (p *PieceStorageServer) handleUploadAbort(res http.ResponseWriter, req *http.Request) {
+	if err := p.uploader.Abort(req.Context(), req.URL.Query().Get("upload-id")); err != nil {
+		writeUploadError(res, nil, err)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+// parseContentRange parses the header like `bytes 0-1023/4096`
+func parseContentRange(contentRange string) (int64, int64, int64, error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("only bytes range is supported")
+	}
+	spec, totalStr, ok := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("missing total size")
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed range")
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, fmt.Errorf("malformed range start")
+	}
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed range end")
+	}
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil || total <= end {
+		return 0, 0, 0, fmt.Errorf("malformed total size")
+	}
+	return start, end, total, nil
+}
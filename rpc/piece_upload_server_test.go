@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mtypes "github.com/ipfs-force-community/droplet/v2/types"
+)
+
+func TestResourceUpload(t *testing.T) {
+	ps, psm := setupTestServer(t)
+	content := []byte("mock resource uploaded by chunks")
+	digest := sha256.Sum256(content)
+
+	do := func(method, query string, header map[string]string, body []byte) (*httptest.ResponseRecorder, *mtypes.PieceUploadSession) {
+		req := httptest.NewRequest(method, "http://127.0.0.1:3030?"+query, bytes.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		psm.ServeHTTP(w, req)
+		if w.Header().Get("Content-Type") != "application/json" {
+			return w, nil
+		}
+		session := &mtypes.PieceUploadSession{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), session))
+		return w, session
+	}
+
+	w, session := do(http.MethodPost, fmt.Sprintf("resource-id=s1&size=%d&store=memtest&sha256=%s", len(content), hex.EncodeToString(digest[:])), nil, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	uploadQuery := "upload-id=" + session.ID
+
+	w, session = do(http.MethodPut, uploadQuery, map[string]string{"Content-Range": "bytes 0-9/32"}, content[:10])
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(10), session.Offset)
+	assert.Equal(t, "10", w.Header().Get(uploadOffsetHeader))
+
+	// the chunk doesn't start at the offset
+	w, _ = do(http.MethodPut, uploadQuery, map[string]string{"Content-Range": "bytes 5-9/32"}, content[5:10])
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "10", w.Header().Get(uploadOffsetHeader))
+	// the total size mismatches
+	w, _ = do(http.MethodPut, uploadQuery, map[string]string{"Content-Range": "bytes 10-31/64"}, content[10:])
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, session = do(http.MethodGet, uploadQuery, nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(10), session.Offset)
+
+	// the upload is published in the background, the state is polled until it's done
+	w, session = do(http.MethodPut, uploadQuery, map[string]string{"Content-Range": "bytes 10-31/32"}, content[10:])
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mtypes.PieceUploadPublishing, session.State)
+	require.Eventually(t, func() bool {
+		w, session = do(http.MethodGet, uploadQuery, nil, nil)
+		return w.Code == http.StatusOK && session.State != mtypes.PieceUploadPublishing
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, mtypes.PieceUploadPublished, session.State)
+	has, err := ps.Has(context.Background(), "s1")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	w, _ = do(http.MethodDelete, uploadQuery, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = do(http.MethodGet, uploadQuery, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, total, err := parseContentRange("bytes 10-19/100")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10, 19, 100}, []int64{start, end, total})
+
+	for _, contentRange := range []string{"bytes 10-19", "bytes 19-10/100", "bytes 10-100/100", "items 0-1/2", "bytes -1/2"} {
+		_, _, _, err := parseContentRange(contentRange)
+		assert.Error(t, err, contentRange)
+	}
+}
@@ -556,10 +556,11 @@ func (storageDealPorcess *StorageDealProcessImpl) savePieceFile(ctx context.Cont
 
 	_, err := storageDealPorcess.pieceStorageMgr.FindStorageForRead(ctx, pieceCid.String())
 	if err != nil {
-		ps, err := storageDealPorcess.pieceStorageMgr.FindStorageForWrite(int64(payloadSize))
+		ps, release, err := storageDealPorcess.pieceStorageMgr.FindStorageForWrite(deal.Proposal.Provider, int64(payloadSize))
 		if err != nil {
 			return err
 		}
+		defer release()
 		_, err = ps.SaveTo(ctx, pieceCid.String(), reader)
 		if err != nil {
 			return err
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipld/go-car/v2"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/urfave/cli/v2"
+)
+
+const checkpointFile = "gen-index.checkpoint"
+
+var generateIndexCmd = &cli.Command{
+	Name:  "gen-index",
+	Usage: "generate car index",
+	Description: `Generate the indexes of the pieces of active deals from the car files in parallel,
+the pieces which already have a shard are skipped, and the index files already generated are reused.
+The finished pieces are recorded in the checkpoint file, they are skipped when it's run again.`,
+	Flags: []cli.Flag{
+		topIndexFlag,
+		mongoURLFlag,
+		badgerDirFlag,
+		mysqlURLFlag,
+		indexDirFlag,
+		carDirFlag,
+		dropletTokenFlag,
+		dropletURLFlag,
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "the number of pieces indexed at the same time",
+			Value: 4,
+		},
+		&cli.StringFlag{
+			Name:  "checkpoint",
+			Usage: fmt.Sprintf("the file recording the finished pieces, defaults to %s in index-dir", checkpointFile),
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only show the work to do",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		carDir := cctx.String("car-dir")
+		indexDir := cctx.String(indexDirFlag.Name)
+		p, err := paramsFromContext(cctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("car dir:", carDir, "index dir:", indexDir)
+
+		if closer, ok := p.topIndexRepo.(io.Closer); ok {
+			defer closer.Close() //nolint
+		}
+
+		checkpoint := cctx.String("checkpoint")
+		if len(checkpoint) == 0 {
+			checkpoint = filepath.Join(indexDir, checkpointFile)
+		}
+		fmt.Println("checkpoint:", checkpoint)
+
+		plan, err := planGenerateIndex(ctx, carDir, indexDir, checkpoint, p)
+		if err != nil {
+			return err
+		}
+		plan.print()
+		if cctx.Bool("dry-run") {
+			return nil
+		}
+
+		return generateIndex(ctx, carDir, indexDir, checkpoint, cctx.Int("workers"), plan, p)
+	},
+}
+
+// generatePlan is the pieces of active deals classified by the work to do
+type generatePlan struct {
+	// pieces recorded in the checkpoint
+	finished []string
+	// pieces which have a shard
+	hasShard []string
+	// pieces whose car files are not found
+	missingCar []string
+	// pieces whose index files are reused, only the top index and the shard are saved
+	reuseIndex []string
+	// pieces whose indexes are generated from the car files
+	generate  []string
+	carBytes  int64
+	generated map[string]struct{}
+}
+
+func (plan *generatePlan) print() {
+	fmt.Printf("finished in checkpoint: %d\n", len(plan.finished))
+	fmt.Printf("already have shard:     %d\n", len(plan.hasShard))
+	fmt.Printf("car file not found:     %d\n", len(plan.missingCar))
+	fmt.Printf("reuse index file:       %d\n", len(plan.reuseIndex))
+	fmt.Printf("generate index:         %d, car bytes: %d\n", len(plan.generate), plan.carBytes)
+}
+
+func planGenerateIndex(ctx context.Context, carDir, indexDir, checkpoint string, p *params) (*generatePlan, error) {
+	finished, err := loadCheckpoint(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	pieces := make([]string, 0, len(p.pieces))
+	for piece := range p.pieces {
+		pieces = append(pieces, piece)
+	}
+	sort.Strings(pieces)
+
+	plan := &generatePlan{generated: make(map[string]struct{})}
+	for _, piece := range pieces {
+		if _, ok := finished[piece]; ok {
+			plan.finished = append(plan.finished, piece)
+			continue
+		}
+		has, err := p.shardRepo.HasShard(ctx, piece)
+		if err != nil {
+			return nil, fmt.Errorf("check shard of %s failed: %v", piece, err)
+		}
+		if has {
+			plan.hasShard = append(plan.hasShard, piece)
+			continue
+		}
+		has, err = hasIndex(ctx, piece, indexDir)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			plan.reuseIndex = append(plan.reuseIndex, piece)
+			continue
+		}
+		info, err := os.Stat(filepath.Join(carDir, piece))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			plan.missingCar = append(plan.missingCar, piece)
+			continue
+		}
+		plan.generate = append(plan.generate, piece)
+		plan.generated[piece] = struct{}{}
+		plan.carBytes += info.Size()
+	}
+
+	return plan, nil
+}
+
+func generateIndex(ctx context.Context, carDir, indexDir, checkpoint string, workers int, plan *generatePlan, p *params) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	cp, err := os.OpenFile(checkpoint, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open checkpoint failed: %v", err)
+	}
+	defer cp.Close() //nolint
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		lk       sync.Mutex
+		firstErr error
+		done     int
+		failed   int
+		total    = len(plan.reuseIndex) + len(plan.generate)
+	)
+	finish := func(piece string, err error) {
+		lk.Lock()
+		defer lk.Unlock()
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			return
+		}
+		if _, err := fmt.Fprintln(cp, piece); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("write checkpoint failed: %v", err)
+			cancel()
+		}
+		done++
+		fmt.Printf("[%d/%d] index saved: %s\n", done+failed, total, piece)
+	}
+
+	pieceCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for piece := range pieceCh {
+				_, generate := plan.generated[piece]
+				idx, err := loadOrGenerateIndex(carDir, indexDir, piece, generate)
+				if err != nil {
+					// the car file may be broken, retry it when it's run again
+					fmt.Printf("generate index failed, piece: %s, error: %v\n", piece, err)
+					lk.Lock()
+					failed++
+					lk.Unlock()
+					continue
+				}
+				finish(piece, saveGeneratedIndex(ctx, indexDir, piece, idx, generate, p))
+			}
+		}()
+	}
+
+feed:
+	for _, piece := range append(append([]string{}, plan.reuseIndex...), plan.generate...) {
+		select {
+		case pieceCh <- piece:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pieceCh)
+	wg.Wait()
+
+	fmt.Printf("finished %d pieces, failed %d pieces\n", done, failed)
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func loadOrGenerateIndex(carDir, indexDir, piece string, generate bool) (carindex.Index, error) {
+	if !generate {
+		return readIndex(indexDir, piece)
+	}
+
+	f, err := os.Open(filepath.Join(carDir, piece))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+
+	return car.ReadOrGenerateIndex(f, car.ZeroLengthSectionAsEOF(true), car.StoreIdentityCIDs(true))
+}
+
+func saveGeneratedIndex(ctx context.Context, indexDir, piece string, idx carindex.Index, generate bool, p *params) error {
+	if generate {
+		if err := saveIndex(idx, indexDir, piece); err != nil {
+			return fmt.Errorf("save index failed, piece: %s, error: %v", piece, err)
+		}
+	}
+	if err := saveTopIndex(ctx, piece, idx, p.topIndexRepo); err != nil {
+		return fmt.Errorf("save top index failed, piece: %s, error: %v", piece, err)
+	}
+	if err := saveShardToMysql(ctx, piece, p.shardRepo); err != nil {
+		return fmt.Errorf("save shard to mysql failed, piece: %s, error: %v", piece, err)
+	}
+	return nil
+}
+
+func loadCheckpoint(path string) (map[string]struct{}, error) {
+	finished := make(map[string]struct{})
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return finished, nil
+		}
+		return nil, fmt.Errorf("open checkpoint failed: %v", err)
+	}
+	defer f.Close() //nolint
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if piece := strings.TrimSpace(scanner.Text()); len(piece) > 0 {
+			finished[piece] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read checkpoint failed: %v", err)
+	}
+	return finished, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -19,19 +20,27 @@ import (
 	"github.com/ipfs-force-community/droplet/v2/dagstore"
 	"github.com/ipfs-force-community/droplet/v2/models/mysql"
 	"github.com/ipfs-force-community/droplet/v2/models/repo"
-	"github.com/ipld/go-car/v2"
 	carindex "github.com/ipld/go-car/v2/index"
 	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
 )
 
 const indexSuffix = ".full.idx"
 
 var (
+	topIndexFlag = &cli.StringFlag{
+		Name:  "top-index",
+		Usage: "where to store topIndex, mongo, mysql or badger",
+		Value: dagstore.TopIndexMongo,
+	}
 	mongoURLFlag = &cli.StringFlag{
-		Name:     "mongo-url",
-		Usage:    "mongo url, use for store topIndex",
-		Required: true,
+		Name:  "mongo-url",
+		Usage: "mongo url, use for store topIndex",
+	}
+	badgerDirFlag = &cli.StringFlag{
+		Name:  "badger-dir",
+		Usage: "badger directory, use for store topIndex, `droplet` uses ~/.droplet/dagstore/topindex by default",
 	}
 	mysqlURLFlag = &cli.StringFlag{
 		Name:     "mysql-url",
@@ -66,7 +75,9 @@ func main() {
 		Usage: "Used to generate indexes and migrate indexes",
 		Commands: []*cli.Command{
 			generateIndexCmd,
+			verifyIndexCmd,
 			migrateIndexCmd,
+			migrateTopIndexCmd,
 		},
 	}
 
@@ -75,48 +86,21 @@ func main() {
 	}
 }
 
-var generateIndexCmd = &cli.Command{
-	Name:  "gen-index",
-	Usage: "generate car index",
-	Flags: []cli.Flag{
-		mongoURLFlag,
-		mysqlURLFlag,
-		indexDirFlag,
-		carDirFlag,
-		dropletTokenFlag,
-		dropletURLFlag,
-	},
-	Action: func(cctx *cli.Context) error {
-		ctx := cctx.Context
-		carDir := cctx.String("car-dir")
-		indexDir := cctx.String(indexDirFlag.Name)
-		p, err := paramsFromContext(cctx)
-		if err != nil {
-			return err
-		}
-
-		fmt.Println("car dir:", carDir, "index dir:", indexDir)
-
-		return generateIndex(ctx, carDir, indexDir, p)
-	},
-}
-
 type params struct {
 	api          marketapi.IMarket
 	close        jsonrpc.ClientCloser
-	topIndexRepo *dagstore.MongoTopIndex
+	topIndexRepo dagstore.TopIndexRepo
 	shardRepo    repo.IShardRepo
 	pieces       map[string]struct{}
 }
 
 func paramsFromContext(cctx *cli.Context) (*params, error) {
 	ctx := cctx.Context
-	mongoURL := cctx.String(mongoURLFlag.Name)
 	mysqlURL := cctx.String(mysqlURLFlag.Name)
 	url := cctx.String(dropletURLFlag.Name)
 	token := cctx.String(dropletTokenFlag.Name)
 
-	fmt.Println("mongo url:", mongoURL)
+	fmt.Println("top index:", cctx.String(topIndexFlag.Name))
 	fmt.Println("mysql url:", mysqlURL)
 	fmt.Println("droplet url:", url, "token:", token)
 
@@ -137,16 +121,13 @@ func paramsFromContext(cctx *cli.Context) (*params, error) {
 	}
 	fmt.Printf("had %d active deals, had %d piece\n", len(deals), len(pieces))
 
-	topIndexRepo, err := dagstore.NewMongoTopIndex(ctx, mongoURL)
+	repo, err := openMysql(mysqlURL)
 	if err != nil {
-		return nil, fmt.Errorf("connect to mongo failed: %v", err)
+		return nil, err
 	}
-
-	cfg := config.DefaultMarketConfig
-	cfg.Mysql.ConnectionString = mysqlURL
-	repo, err := mysql.InitMysql(&cfg.Mysql)
+	topIndexRepo, err := openTopIndex(cctx, cctx.String(topIndexFlag.Name), repo)
 	if err != nil {
-		return nil, fmt.Errorf("connect to mysql failed: %v", err)
+		return nil, err
 	}
 
 	return &params{
@@ -158,44 +139,6 @@ func paramsFromContext(cctx *cli.Context) (*params, error) {
 	}, nil
 }
 
-func generateIndex(ctx context.Context, carDir string, indexDir string, p *params) error {
-	for piece := range p.pieces {
-		has, err := hasIndex(ctx, piece, indexDir)
-		if err != nil {
-			return err
-		}
-		if has {
-			continue
-		}
-
-		f, err := os.Open(filepath.Join(carDir, piece))
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		defer f.Close() //nolint
-
-		idx, err := car.ReadOrGenerateIndex(f, car.ZeroLengthSectionAsEOF(true), car.StoreIdentityCIDs(true))
-		if err == nil {
-			fmt.Printf("generate index success: %s\n", piece)
-
-			if err := saveIndex(idx, indexDir, piece); err != nil {
-				return fmt.Errorf("save index failed, piece: %s, error: %v", piece, err)
-			}
-			if err := saveTopIndexToMongo(ctx, piece, idx, p.topIndexRepo); err != nil {
-				return fmt.Errorf("save top index to mongo failed, piece: %s, error: %v", piece, err)
-			}
-			if err := saveShardToMysql(ctx, piece, p.shardRepo); err != nil {
-				return fmt.Errorf("save shard to mysql failed, piece: %s, error: %vs", piece, err)
-			}
-		} else {
-			fmt.Printf("generate index failed, piece: %s, error: %v\n", piece, err)
-		}
-	}
-
-	return nil
-}
-
 func hasIndex(ctx context.Context, piece string, indexDir string) (bool, error) {
 	_, err := os.Stat(filepath.Join(indexDir, piece+indexSuffix))
 	if err != nil {
@@ -208,18 +151,35 @@ func hasIndex(ctx context.Context, piece string, indexDir string) (bool, error)
 	return true, nil
 }
 
+// saveIndex writes the index to a temporary file and renames it, so a broken index file isn't left if it's interrupted
 func saveIndex(idx carindex.Index, dir string, piece string) error {
-	f, err := os.Create(filepath.Join(dir, piece+indexSuffix))
+	path := filepath.Join(dir, piece+indexSuffix)
+	f, err := os.Create(path + ".tmp")
 	if err != nil {
 		return err
 	}
+
+	if _, err = carindex.WriteTo(idx, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readIndex(dir string, piece string) (carindex.Index, error) {
+	f, err := os.Open(filepath.Join(dir, piece+indexSuffix))
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close() //nolint
 
-	_, err = carindex.WriteTo(idx, f)
-	return err
+	return carindex.ReadFrom(f)
 }
 
-func saveTopIndexToMongo(ctx context.Context, key string, idx carindex.Index, indexRepo *dagstore.MongoTopIndex) error {
+func saveTopIndex(ctx context.Context, key string, idx carindex.Index, indexRepo dagstore.TopIndexRepo) error {
 	// add all cids in the shard to the inverted (cid -> []Shard Keys) index.
 	iterableIdx, ok := idx.(carindex.IterableIndex)
 	if ok {
@@ -245,9 +205,11 @@ func saveShardToMysql(ctx context.Context, piece string, shardRepo repo.IShardRe
 
 var migrateIndexCmd = &cli.Command{
 	Name:  "migrate-index",
-	Usage: "migrate top index to MongoDB and migrate shard state to mysql",
+	Usage: "migrate top index to MongoDB, mysql or badger and migrate shard state to mysql",
 	Flags: []cli.Flag{
+		topIndexFlag,
 		mongoURLFlag,
+		badgerDirFlag,
 		mysqlURLFlag,
 		indexDirFlag,
 		dropletURLFlag,
@@ -263,6 +225,10 @@ var migrateIndexCmd = &cli.Command{
 
 		fmt.Println("index dir:", indexDir)
 
+		if closer, ok := p.topIndexRepo.(io.Closer); ok {
+			defer closer.Close() //nolint
+		}
+
 		return migrateIndex(ctx, indexDir, p)
 	},
 }
@@ -300,8 +266,8 @@ func migrateIndex(ctx context.Context, indexDir string, p *params) error {
 			return err
 		}
 
-		if err := saveTopIndexToMongo(ctx, piece, idx, p.topIndexRepo); err != nil {
-			return fmt.Errorf("save top index to mongo failed, piece: %s, error: %v", piece, err)
+		if err := saveTopIndex(ctx, piece, idx, p.topIndexRepo); err != nil {
+			return fmt.Errorf("save top index failed, piece: %s, error: %v", piece, err)
 		}
 		if err := saveShardToMysql(ctx, piece, p.shardRepo); err != nil {
 			return fmt.Errorf("save shard to mysql failed, piece: %s, error: %vs", piece, err)
@@ -312,6 +278,104 @@ func migrateIndex(ctx context.Context, indexDir string, p *params) error {
 	})
 }
 
+func openMysql(mysqlURL string) (repo.Repo, error) {
+	cfg := config.DefaultMarketConfig
+	cfg.Mysql.ConnectionString = mysqlURL
+	r, err := mysql.InitMysql(&cfg.Mysql)
+	if err != nil {
+		return nil, fmt.Errorf("connect to mysql failed: %v", err)
+	}
+	return r, nil
+}
+
+func openTopIndex(cctx *cli.Context, kind string, r repo.Repo) (dagstore.TopIndexRepo, error) {
+	switch kind {
+	case dagstore.TopIndexMongo:
+		mongoURL := cctx.String(mongoURLFlag.Name)
+		if mongoURL == "" {
+			return nil, fmt.Errorf("mongo-url is required")
+		}
+		fmt.Println("mongo url:", mongoURL)
+		topIndexRepo, err := dagstore.NewMongoTopIndex(cctx.Context, mongoURL)
+		if err != nil {
+			return nil, fmt.Errorf("connect to mongo failed: %v", err)
+		}
+		return topIndexRepo, nil
+	case dagstore.TopIndexMysql:
+		if r == nil {
+			var err error
+			if r, err = openMysql(cctx.String(mysqlURLFlag.Name)); err != nil {
+				return nil, err
+			}
+		}
+		db, ok := r.(interface{ GetDb() *gorm.DB })
+		if !ok {
+			return nil, fmt.Errorf("top index can be saved to mysql only when the repo is mysql")
+		}
+		return dagstore.NewSQLTopIndex(db.GetDb())
+	case dagstore.TopIndexBadger:
+		badgerDir := cctx.String(badgerDirFlag.Name)
+		if badgerDir == "" {
+			return nil, fmt.Errorf("badger-dir is required")
+		}
+		fmt.Println("badger dir:", badgerDir)
+		return dagstore.NewBadgerTopIndex(badgerDir)
+	default:
+		return nil, fmt.Errorf("unknown top index %s", kind)
+	}
+}
+
+var migrateTopIndexCmd = &cli.Command{
+	Name:  "migrate-top-index",
+	Usage: "copy top index between MongoDB, mysql and badger",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "where top index is copied from, mongo, mysql or badger",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "where top index is copied to, mongo, mysql or badger",
+			Required: true,
+		},
+		mongoURLFlag,
+		badgerDirFlag,
+		&cli.StringFlag{
+			Name:  mysqlURLFlag.Name,
+			Usage: "mysql url, use for store topIndex",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		from, to := cctx.String("from"), cctx.String("to")
+		if from == to {
+			return fmt.Errorf("top index can't be copied to where it's from")
+		}
+		src, err := openTopIndex(cctx, from, nil)
+		if err != nil {
+			return err
+		}
+		dst, err := openTopIndex(cctx, to, nil)
+		if err != nil {
+			return err
+		}
+		for _, topIndex := range []dagstore.TopIndexRepo{src, dst} {
+			if closer, ok := topIndex.(io.Closer); ok {
+				defer closer.Close() //nolint
+			}
+		}
+
+		err = dagstore.MigrateTopIndex(cctx.Context, src, dst, func(copied int) {
+			fmt.Printf("copied %d multihashes\n", copied)
+		})
+		if err != nil {
+			return fmt.Errorf("migrate top index failed: %v", err)
+		}
+		fmt.Println("migrate top index success")
+		return nil
+	},
+}
+
 // Convenience struct for converting from CAR index.IterableIndex to the
 // iterator required by the dag store inverted index.
 type mhIdx struct {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
+	"github.com/ipfs-force-community/droplet/v2/models/mysql"
+)
+
+func TestOpenTopIndex(t *testing.T) {
+	ctx := context.Background()
+	cctx := cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	cctx.Context = ctx
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sqlite.db")))
+	require.NoError(t, err)
+	// the repo returned by mysql.InitMysql
+	topIndex, err := openTopIndex(cctx, dagstore.TopIndexMysql, &mysql.MysqlRepo{DB: db})
+	require.NoError(t, err)
+
+	key := shard.KeyFromString("piece")
+	entries := []*dagstore.TopIndexEntry{{Multihash: []byte("multihash"), Shards: []shard.Key{key}}}
+	require.NoError(t, topIndex.AddEntries(ctx, entries))
+	has, err := topIndex.HasShard(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	_, err = openTopIndex(cctx, "unknown", nil)
+	assert.Error(t, err)
+}
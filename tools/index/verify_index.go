@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipld/go-car/v2"
+	carindex "github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
+)
+
+var verifyIndexCmd = &cli.Command{
+	Name:      "verify-index",
+	Usage:     "verify the index files against the car files and the top index",
+	ArgsUsage: "[piece cid ...]",
+	Description: `Verify the index files of the pieces, all the index files in index-dir are verified if no piece is specified.
+The index is compared with the one generated from the car file if car-dir is set, and its multihashes
+are looked up in the top index if top-index is set.`,
+	Flags: []cli.Flag{
+		indexDirFlag,
+		&cli.StringFlag{
+			Name:  carDirFlag.Name,
+			Usage: "directory for car files, the index isn't compared with the car file if it's not set",
+		},
+		&cli.StringFlag{
+			Name:  topIndexFlag.Name,
+			Usage: "where topIndex is stored, mongo, mysql or badger, the top index isn't verified if it's not set",
+		},
+		mongoURLFlag,
+		badgerDirFlag,
+		&cli.StringFlag{
+			Name:  mysqlURLFlag.Name,
+			Usage: "mysql url, use for store topIndex",
+		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "the number of pieces verified at the same time",
+			Value: 4,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		indexDir := cctx.String(indexDirFlag.Name)
+		carDir := cctx.String(carDirFlag.Name)
+
+		var topIndex dagstore.TopIndexRepo
+		if kind := cctx.String(topIndexFlag.Name); len(kind) > 0 {
+			var err error
+			topIndex, err = openTopIndex(cctx, kind, nil)
+			if err != nil {
+				return err
+			}
+			if closer, ok := topIndex.(io.Closer); ok {
+				defer closer.Close() //nolint
+			}
+		}
+
+		pieces := cctx.Args().Slice()
+		if len(pieces) == 0 {
+			files, err := os.ReadDir(indexDir)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if name := file.Name(); strings.HasSuffix(name, indexSuffix) {
+					pieces = append(pieces, strings.TrimSuffix(name, indexSuffix))
+				}
+			}
+		}
+
+		workers := cctx.Int("workers")
+		if workers < 1 {
+			workers = 1
+		}
+		var (
+			lk      sync.Mutex
+			invalid int
+			wg      sync.WaitGroup
+		)
+		pieceCh := make(chan string)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for piece := range pieceCh {
+					res, err := verifyIndex(ctx, carDir, indexDir, piece, topIndex)
+					lk.Lock()
+					if err != nil {
+						invalid++
+						fmt.Printf("verify %s failed: %v\n", piece, err)
+					} else {
+						if !res.ok() {
+							invalid++
+						}
+						fmt.Println(res)
+					}
+					lk.Unlock()
+				}
+			}()
+		}
+		for _, piece := range pieces {
+			pieceCh <- piece
+		}
+		close(pieceCh)
+		wg.Wait()
+
+		fmt.Printf("verified %d pieces, %d invalid\n", len(pieces), invalid)
+		if invalid > 0 {
+			return fmt.Errorf("%d pieces are invalid", invalid)
+		}
+		return nil
+	},
+}
+
+type verifyResult struct {
+	piece   string
+	entries int
+	// the number of blocks in the car file, -1 if it isn't checked
+	carEntries int
+	// the number of blocks in the car file whose offsets are not found in the index
+	mismatched int
+	// the number of multihashes not mapped to the piece by the top index, -1 if it isn't checked
+	missingInTopIndex int
+}
+
+func (res *verifyResult) ok() bool {
+	carOK := res.carEntries < 0 || (res.carEntries == res.entries && res.mismatched == 0)
+	return carOK && res.missingInTopIndex <= 0
+}
+
+func (res *verifyResult) String() string {
+	state := "ok"
+	if !res.ok() {
+		state = "invalid"
+	}
+	s := fmt.Sprintf("%s: %s, entries: %d", res.piece, state, res.entries)
+	if res.carEntries >= 0 {
+		s += fmt.Sprintf(", car blocks: %d, mismatched: %d", res.carEntries, res.mismatched)
+	}
+	if res.missingInTopIndex >= 0 {
+		s += fmt.Sprintf(", missing in top index: %d", res.missingInTopIndex)
+	}
+	return s
+}
+
+func verifyIndex(ctx context.Context, carDir, indexDir, piece string, topIndex dagstore.TopIndexRepo) (*verifyResult, error) {
+	idx, err := readIndex(indexDir, piece)
+	if err != nil {
+		return nil, fmt.Errorf("read index failed: %v", err)
+	}
+	iterableIdx, ok := idx.(carindex.IterableIndex)
+	if !ok {
+		return nil, fmt.Errorf("index %s is not iterable", idx.Codec())
+	}
+
+	res := &verifyResult{piece: piece, carEntries: -1, missingInTopIndex: -1}
+	if err := iterableIdx.ForEach(func(multihash.Multihash, uint64) error {
+		res.entries++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(carDir) > 0 {
+		f, err := os.Open(filepath.Join(carDir, piece))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close() //nolint
+
+		carIdx, err := car.GenerateIndex(f, car.ZeroLengthSectionAsEOF(true), car.StoreIdentityCIDs(true))
+		if err != nil {
+			return nil, fmt.Errorf("generate index from car failed: %v", err)
+		}
+		carIterableIdx, ok := carIdx.(carindex.IterableIndex)
+		if !ok {
+			return nil, fmt.Errorf("index %s is not iterable", carIdx.Codec())
+		}
+		res.carEntries = 0
+		if err := carIterableIdx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			res.carEntries++
+			found := false
+			err := idx.GetAll(cid.NewCidV1(cid.Raw, mh), func(o uint64) bool {
+				found = o == offset
+				return !found
+			})
+			if err != nil && !errors.Is(err, carindex.ErrNotFound) {
+				return err
+			}
+			if !found {
+				res.mismatched++
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if topIndex != nil {
+		key := shard.KeyFromString(piece)
+		res.missingInTopIndex = 0
+		if err := iterableIdx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+			shards, err := topIndex.GetShardsForMultihash(ctx, mh)
+			if err != nil && !errors.Is(err, ds.ErrNotFound) && !errors.Is(err, mongo.ErrNoDocuments) {
+				return fmt.Errorf("get shards of %s from top index failed: %v", mh, err)
+			}
+			for _, s := range shards {
+				if s == key {
+					return nil
+				}
+			}
+			res.missingInTopIndex++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/dagstore/shard"
+	"github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs-force-community/droplet/v2/dagstore"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	ctx := context.Background()
+	carDir, indexDir := t.TempDir(), t.TempDir()
+	piece := "baga6ea4seaqpiece"
+
+	// the index in the carv2 file isn't iterable, so the carv1 payload is indexed
+	v2r, err := car.OpenReader("../../dagstore/fixtures/sample-rw-bs-v2.car")
+	require.NoError(t, err)
+	defer v2r.Close() //nolint
+	dr, err := v2r.DataReader()
+	require.NoError(t, err)
+	data, err := io.ReadAll(dr)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(carDir, piece), data, 0o644))
+
+	idx, err := loadOrGenerateIndex(carDir, indexDir, piece, true)
+	require.NoError(t, err)
+	require.NoError(t, saveIndex(idx, indexDir, piece))
+
+	topIndex, err := dagstore.NewBadgerTopIndex(t.TempDir())
+	require.NoError(t, err)
+	defer topIndex.Close() //nolint
+
+	// the multihashes are not in the top index
+	res, err := verifyIndex(ctx, carDir, indexDir, piece, topIndex)
+	require.NoError(t, err)
+	assert.Greater(t, res.entries, 0)
+	assert.Equal(t, res.entries, res.carEntries)
+	assert.Equal(t, 0, res.mismatched)
+	assert.Equal(t, res.entries, res.missingInTopIndex)
+	assert.False(t, res.ok())
+
+	require.NoError(t, saveTopIndex(ctx, piece, idx, topIndex))
+	res, err = verifyIndex(ctx, carDir, indexDir, piece, topIndex)
+	require.NoError(t, err)
+	assert.True(t, res.ok(), res.String())
+
+	// the multihashes removed from the top index are missing
+	require.NoError(t, topIndex.RemoveShard(ctx, shard.KeyFromString(piece)))
+	res, err = verifyIndex(ctx, "", indexDir, piece, topIndex)
+	require.NoError(t, err)
+	assert.Equal(t, -1, res.carEntries)
+	assert.Equal(t, res.entries, res.missingInTopIndex)
+
+	// the broken car file is reported
+	require.NoError(t, os.WriteFile(filepath.Join(carDir, piece), data[:len(data)/2], 0o644))
+	_, err = verifyIndex(ctx, carDir, indexDir, piece, nil)
+	require.Error(t, err)
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), checkpointFile)
+	finished, err := loadCheckpoint(path)
+	require.NoError(t, err)
+	assert.Empty(t, finished)
+
+	require.NoError(t, os.WriteFile(path, []byte("a\nb\n\n"), 0o644))
+	finished, err = loadCheckpoint(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"a": {}, "b": {}}, finished)
+}
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// CIDLocationSource is where the piece containing a cid is found
+type CIDLocationSource string
+
+const (
+	// the piece is found in the top index of dagstore
+	CIDLocationTopIndex CIDLocationSource = "top-index"
+	// the piece is found in the cid info repo, it's not indexed by dagstore
+	CIDLocationCIDInfo CIDLocationSource = "cid-info"
+)
+
+// CIDLocation is a piece containing a cid, and the deals of the piece
+type CIDLocation struct {
+	PieceCID cid.Cid
+	Source   CIDLocationSource
+	// The offsets of the block in the car file of the piece, empty if they are unknown
+	Offsets []uint64
+	// The size of the block, 0 if it's unknown
+	BlockSize uint64
+	Deals     []*CIDDeal
+}
+
+// CIDDeal is a deal of the piece containing a cid
+type CIDDeal struct {
+	ProposalCid cid.Cid
+	DealID      abi.DealID
+	Miner       address.Address
+	State       string
+	PieceStatus string
+	// The sector of the deal and the offset of the piece in the sector, they are 0 before the deal is packed
+	SectorNumber abi.SectorNumber
+	Offset       abi.PaddedPieceSize
+}
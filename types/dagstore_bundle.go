@@ -0,0 +1,46 @@
+package types
+
+// DagstoreExportResult is the result of exporting the shards, the index files and the top index of dagstore to a bundle
+type DagstoreExportResult struct {
+	Dir string
+	// The number of shards in the bundle
+	Shards int
+	// The number of shards exported this time
+	Exported int
+	// The number of shards exported before the export was interrupted
+	Resumed int
+	// The shards without index file, they are indexed again after imported
+	NoIndex []string
+	// The number of entries of the top index, 0 if the top index can't be exported
+	TopIndexEntries int
+}
+
+// DagstoreImportParams are the options to import a bundle exported by DagstoreExport
+type DagstoreImportParams struct {
+	// The directory of the bundle on the host of droplet
+	Dir string
+	// The prefixes of the mount urls replaced, eg. "market://" => "market://"
+	URLRewrites map[string]string
+	// The piece storages renamed on the new host, the pieces are checked in the renamed storages
+	StorageRenames map[string]string
+	// Only verify the checksums of the bundle
+	VerifyOnly bool
+}
+
+// DagstoreImportResult is the result of importing a bundle
+type DagstoreImportResult struct {
+	// The number of shards in the bundle
+	Shards int
+	// The number of shards registered this time
+	Imported int
+	// The number of shards imported before the import was interrupted, or already registered
+	Skipped int
+	// The files whose sizes or checksums don't match the manifest, nothing is imported if any file is corrupt
+	Corrupt []string
+	// The pieces not found in the piece storages they were in when exported
+	MissingPieces []string
+	// The shards failed to be imported, they are retried when it's run again
+	Errors []string
+	// The number of entries of the top index imported
+	TopIndexEntries int
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"time"
+)
+
+// ReconcileCategory is how the pieces, deals and dagstore shards disagree
+type ReconcileCategory string
+
+const (
+	// the piece of an active deal is in a piece storage, but it has no shard
+	ReconcileMissingShard ReconcileCategory = "missing-shard"
+	// the piece of the shard is in no piece storage, and it's not referenced by any deal
+	ReconcileOrphanShard ReconcileCategory = "orphan-shard"
+	// the deal is active, but its piece is in no piece storage
+	ReconcileMissingPiece ReconcileCategory = "missing-piece"
+)
+
+// ReconcileFix is what is done to fix a problem
+type ReconcileFix string
+
+const (
+	ReconcileFixRegisterShard ReconcileFix = "register-shard"
+	ReconcileFixDestroyShard  ReconcileFix = "destroy-shard"
+	ReconcileFixQueueUnseal   ReconcileFix = "queue-unseal"
+)
+
+// ReconcileItem is a piece on which the pieces, deals and shards disagree
+type ReconcileItem struct {
+	Category ReconcileCategory
+	PieceCid string
+	// The fix applied, empty if it's not fixed
+	Fix   ReconcileFix
+	Error string
+}
+
+// ReconcileReport is the result of cross-checking the pieces in piece storages, the deals and the dagstore shards
+type ReconcileReport struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Fix        bool
+
+	// The number of the pieces in piece storages, the pieces referenced by deals and the shards checked
+	Pieces     int
+	DealPieces int
+	Shards     int
+
+	Items []*ReconcileItem
+}
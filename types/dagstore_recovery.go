@@ -0,0 +1,52 @@
+package types
+
+import (
+	"time"
+)
+
+// ShardErrorKind is the cause of a shard failure, it determines how the shard is recovered
+type ShardErrorKind string
+
+const (
+	// the piece is not found in any piece storage, it's unsealed from the sector after retries
+	ShardErrorPieceMissing ShardErrorKind = "piece-missing"
+	// the piece storage can't be read, eg. the network or the storage is down
+	ShardErrorStorageUnavailable ShardErrorKind = "storage-unavailable"
+	// the index of the shard can't be generated or loaded
+	ShardErrorIndexCorrupt ShardErrorKind = "index-corrupt"
+)
+
+// ShardRecoveryAction is what is done to recover a shard in an attempt
+type ShardRecoveryAction string
+
+const (
+	ShardRecoveryRetry  ShardRecoveryAction = "recover"
+	ShardRecoveryUnseal ShardRecoveryAction = "unseal"
+)
+
+// ShardRecoveryAttempt is an attempt to recover an errored shard
+type ShardRecoveryAttempt struct {
+	Time     time.Time
+	Kind     ShardErrorKind
+	Action   ShardRecoveryAction
+	Duration time.Duration
+	// empty if the attempt succeeded
+	Error string
+}
+
+// ShardRecovery is the recovery history of a shard which has errored since droplet started
+type ShardRecovery struct {
+	Key       string
+	Kind      ShardErrorKind
+	LastError string
+	// The failed attempts since the shard errored last time
+	Attempts    int
+	Recovering  bool
+	NextAttempt time.Time
+	// The shard is not recovered automatically after the max attempts, it can still be recovered manually
+	GaveUp      bool
+	RecoveredAt time.Time
+
+	// The latest attempts, the oldest first
+	History []*ShardRecoveryAttempt
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"time"
+)
+
+// IndexProviderStatus is the state of the index provider which announces the data of deals to IPNI indexers
+type IndexProviderStatus struct {
+	Enabled bool
+	// The peer id which signs the advertisements
+	PeerID string
+	// The addresses indexers pull the advertisements from
+	PublisherAddresses []string
+	// The indexers notified of new advertisements
+	AnnounceURLs []string
+	// The cid of the latest advertisement, empty if nothing is published
+	Head string
+	// The number of the pieces of miners announced and not removed
+	Announced int
+
+	LastSync      time.Time
+	LastSyncError string
+}
+
+// IndexProviderSyncResult is the result of publishing the advertisements of deals once
+type IndexProviderSyncResult struct {
+	// The number of advertisements of new active deals
+	Published int
+	// The number of removal advertisements of expired or slashed deals
+	Removed int
+	// The number of pieces which have no index yet or failed to be published, they are retried in the next sync
+	Skipped int
+	Head    string
+	// The indexers failed to be notified
+	AnnounceErrors []string
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"time"
+)
+
+// PieceGCParams is the params of a piece storage garbage collection
+type PieceGCParams struct {
+	// Only the pieces which are unreferenced for longer than GracePeriod are collected
+	GracePeriod time.Duration
+	// Report the pieces to collect without deleting them
+	DryRun bool
+	// The names of the piece storages to collect, empty means all writable piece storages
+	Storages []string
+}
+
+// PieceGCReason is why a piece is collectable
+type PieceGCReason string
+
+const (
+	// all the deals of the piece are in terminal states
+	PieceGCReasonTerminalDeals PieceGCReason = "terminal-deals"
+	// the piece isn't referenced by any deal
+	PieceGCReasonNoDeals PieceGCReason = "no-deals"
+)
+
+// PieceGCItem is a collectable piece in a piece storage
+type PieceGCItem struct {
+	Storage    string
+	ResourceID string
+	Size       int64
+	Reason     PieceGCReason
+	// The time since when the piece is unreferenced, it's the last update time of the deals for
+	// PieceGCReasonTerminalDeals, and the first time the piece was found by gc for PieceGCReasonNoDeals
+	UnreferencedSince time.Time
+	Deleted           bool
+	Error             string
+}
+
+// PieceGCReport is the result of a piece storage garbage collection
+type PieceGCReport struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	DryRun     bool
+	// The collectable pieces
+	Pieces []*PieceGCItem
+	// The number of unreferenced pieces which are still in grace period
+	InGracePeriod int
+	// The number of resources which are not a piece cid, they are never collected
+	Unrecognized int
+	// The bytes freed, or to be freed in dry run
+	ReclaimedBytes int64
+}
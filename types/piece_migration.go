@@ -0,0 +1,66 @@
+package types
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-fil-markets/storagemarket"
+)
+
+// PieceMigrationParams is the params of a job which migrates pieces between piece storages
+type PieceMigrationParams struct {
+	Source string
+	Target string
+
+	// Filters of the deals of pieces, a piece is migrated if any of its deals matches all the filters.
+	// If no filter is set, all the pieces of the source storage are migrated, including the ones without deal
+	Miner      address.Address
+	DealStates []storagemarket.StorageDealStatus
+	// The deal is created at least MinAge ago
+	MinAge time.Duration
+
+	// Verify the commP of the piece in the target storage, besides the length
+	VerifyCommP bool
+	// Delete the piece from the source storage after it's verified
+	DeleteSource bool
+	// The maximum bytes copied per second, 0 means unlimited
+	BytesPerSecond int64
+}
+
+// PieceMigrationState is the state of a piece migration job
+type PieceMigrationState string
+
+const (
+	PieceMigrationRunning   PieceMigrationState = "running"
+	PieceMigrationCompleted PieceMigrationState = "completed"
+	PieceMigrationCanceled  PieceMigrationState = "canceled"
+	PieceMigrationFailed    PieceMigrationState = "failed"
+)
+
+// PieceMigrationFailure is a piece which failed to migrate
+type PieceMigrationFailure struct {
+	ResourceID string
+	Error      string
+}
+
+// PieceMigrationJob is the progress of a piece migration job
+type PieceMigrationJob struct {
+	ID     string
+	Params PieceMigrationParams
+	State  PieceMigrationState
+	// The error which stops the job
+	Error string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// The number of pieces selected by the filters
+	Total int
+	// The number of pieces processed, the job resumes from the next piece
+	Processed int
+	Migrated  int
+	// The pieces which exist in the target storage with the same length, they are not copied again
+	AlreadyInTarget int
+	Failures        []PieceMigrationFailure
+	BytesCopied     int64
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"time"
+)
+
+// PieceScrubResult is the result of verifying a piece in a piece storage
+type PieceScrubResult string
+
+const (
+	PieceScrubOK PieceScrubResult = "ok"
+	// the commP of the piece doesn't match the piece cid
+	PieceScrubCorrupt PieceScrubResult = "corrupt"
+	// the piece can't be verified, eg. it can't be read or it has no deal to determine the piece size
+	PieceScrubError PieceScrubResult = "error"
+)
+
+// PieceRefetchState is the state of fetching a corrupt piece again by unsealing it
+type PieceRefetchState string
+
+const (
+	PieceRefetchRunning  PieceRefetchState = "running"
+	PieceRefetchFinished PieceRefetchState = "finished"
+	PieceRefetchFailed   PieceRefetchState = "failed"
+)
+
+// PieceScrubRecord is the last verification of a piece in a piece storage
+type PieceScrubRecord struct {
+	Storage      string
+	ResourceID   string
+	Result       PieceScrubResult
+	LastVerified time.Time
+	// The piece cid calculated from the data, set if the piece is corrupt
+	Actual string
+	Error  string
+	// The piece is not read from the storage until it's verified ok again
+	Quarantined bool
+
+	RefetchState PieceRefetchState
+	RefetchError string
+}
+
+// PieceScrubStatus is the state of the piece scrubber
+type PieceScrubStatus struct {
+	Enabled bool
+	Running bool
+
+	RoundStartedAt      time.Time
+	LastRoundFinishedAt time.Time
+	// The progress of the current round, or the last round if not running
+	Verified     int
+	Corrupt      int
+	Errors       int
+	BytesScanned int64
+
+	// The pieces which are not ok in their last verification
+	Problems []*PieceScrubRecord
+}
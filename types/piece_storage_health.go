@@ -0,0 +1,26 @@
+package types
+
+import (
+	"time"
+)
+
+// PieceStorageHealth is the read health state of a piece storage
+type PieceStorageHealth struct {
+	Name string
+	// The protocol of the storage, eg. fs, s3, remote
+	Type     string
+	ReadOnly bool
+	// The draining storage is read only, and it's removed after the in-flight operations finish
+	Draining bool
+	// The operations and opened readers of the storage
+	InFlight int64
+	Healthy  bool
+	// The unhealthy storage is skipped when reading pieces, until a probe succeeds
+	UnhealthySince    time.Time
+	ConsecutiveErrors int
+	LastError         string
+	LastErrorAt       time.Time
+	// The moving average of the latency of probes
+	Latency      time.Duration
+	ReadPriority int
+}
@@ -0,0 +1,16 @@
+package types
+
+// PieceStorageUpdateParams is the settings to change of a piece storage, the nil fields are not changed
+type PieceStorageUpdateParams struct {
+	ReadOnly     *bool
+	Priority     *int
+	ReadPriority *int
+
+	// The quota of s3 piece storage
+	Quota *int64
+	// The credentials of s3 piece storage
+	AccessKey *string
+	SecretKey *string
+	// The session token of s3 piece storage, or the token of remote piece storage
+	Token *string
+}
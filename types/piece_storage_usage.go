@@ -0,0 +1,19 @@
+package types
+
+import (
+	"time"
+)
+
+// PieceStorageUsage is the space used by a piece storage, it's reported by object storages
+type PieceStorageUsage struct {
+	Name string
+	// The quota of the storage in bytes, 0 means unlimited
+	Quota int64
+	Used  int64
+	// The space left in quota, 0 if there is no quota
+	Available int64
+	Objects   int64
+	// The time of the last listing of the storage, the usage is updated by writes and deletes between listings
+	ListedAt  time.Time
+	LastError string
+}
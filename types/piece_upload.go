@@ -0,0 +1,48 @@
+package types
+
+import (
+	"time"
+)
+
+// PieceUploadState is the state of a resumable upload to piece storage
+type PieceUploadState string
+
+const (
+	PieceUploadUploading PieceUploadState = "uploading"
+	// All the data is received, and it's being verified and saved to the piece storage
+	PieceUploadPublishing PieceUploadState = "publishing"
+	PieceUploadPublished  PieceUploadState = "published"
+	PieceUploadFailed     PieceUploadState = "failed"
+)
+
+// PieceUploadSession is a resumable upload through the /resource endpoint, the data is staged in droplet,
+// and saved to the piece storage after it's verified
+type PieceUploadSession struct {
+	ID         string
+	ResourceID string
+	// The piece storage to save the resource, empty means selected by the write strategy when publishing
+	Store string
+	Size  int64
+	// The bytes received, the next chunk must start at it
+	Offset int64
+	// The sha256 digest in hex to verify the data, the commP is verified too if the resource is a piece cid
+	Sha256 string
+
+	State     PieceUploadState
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// The presigned urls to upload the parts to the object storage directly, set if redirect is requested
+	Parts []*PieceUploadPart `json:",omitempty"`
+}
+
+// PieceUploadPart is a part of the multipart upload to object storage
+type PieceUploadPart struct {
+	Number int64
+	Offset int64
+	Size   int64
+	Url    string `json:",omitempty"`
+	// The ETag responded by the object storage, it's sent back to complete the upload
+	ETag string `json:",omitempty"`
+}
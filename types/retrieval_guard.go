@@ -0,0 +1,36 @@
+package types
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// RetrievalViolation is a kind of abusive retrieval behavior
+type RetrievalViolation string
+
+const (
+	RetrievalViolationSelectorDepth        RetrievalViolation = "selector-depth"
+	RetrievalViolationSelectorRecursion    RetrievalViolation = "selector-recursion"
+	RetrievalViolationBlocksPerDeal        RetrievalViolation = "blocks-per-deal"
+	RetrievalViolationBytesPerDeal         RetrievalViolation = "bytes-per-deal"
+	RetrievalViolationQueryRate            RetrievalViolation = "query-rate"
+	RetrievalViolationConcurrentRetrievals RetrievalViolation = "concurrent-retrievals"
+)
+
+// RetrievalPeerViolations is the violation record of a retrieval peer
+type RetrievalPeerViolations struct {
+	Peer peer.ID
+	// The number of violations of every kind, the record is dropped after the peer keeps quiet for a while
+	Violations    map[RetrievalViolation]uint64
+	LastViolation time.Time
+	// The peer is banned before BannedUntil
+	BannedUntil time.Time
+	// The number of ongoing retrieval deals of the peer
+	ActiveRetrievals int
+}
+
+// Banned returns whether the peer is banned at the time
+func (v *RetrievalPeerViolations) Banned(now time.Time) bool {
+	return now.Before(v.BannedUntil)
+}
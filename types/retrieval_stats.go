@@ -0,0 +1,161 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+)
+
+// RetrievalStatsBucketDuration is the time span of a retrieval stats bucket
+const RetrievalStatsBucketDuration = time.Hour
+
+// RetrievalStatsBucket returns the start time of the bucket which contains t
+func RetrievalStatsBucket(t time.Time) int64 {
+	return t.Truncate(RetrievalStatsBucketDuration).Unix()
+}
+
+// RetrievalStatsKey identifies the retrieval accounting of a client for a payload in a time bucket
+type RetrievalStatsKey struct {
+	// The start time of the bucket, unix seconds
+	Bucket int64
+	Miner  address.Address
+	// The peer id of graphsync client, or the remote host of http client
+	Client string
+	// The payload cid of graphsync retrieval, or the piece cid of http retrieval
+	PayloadCID cid.Cid
+}
+
+// RetrievalStats is the retrieval accounting of a client for a payload in a time bucket
+type RetrievalStats struct {
+	RetrievalStatsKey
+
+	GraphsyncBytesSent uint64
+	HTTPBytesSent      uint64
+	VouchersReceived   uint64
+	// The value of the received vouchers which can be redeemed
+	FundsReceived abi.TokenAmount
+	// The value redeemed from the payment channels on chain
+	FundsSettled abi.TokenAmount
+	Succeeded    uint64
+	Failed       uint64
+}
+
+// Add accumulates the values of other into s
+func (s *RetrievalStats) Add(other *RetrievalStats) {
+	s.GraphsyncBytesSent += other.GraphsyncBytesSent
+	s.HTTPBytesSent += other.HTTPBytesSent
+	s.VouchersReceived += other.VouchersReceived
+	s.FundsReceived = big.Add(orZero(s.FundsReceived), orZero(other.FundsReceived))
+	s.FundsSettled = big.Add(orZero(s.FundsSettled), orZero(other.FundsSettled))
+	s.Succeeded += other.Succeeded
+	s.Failed += other.Failed
+}
+
+func orZero(amount abi.TokenAmount) abi.TokenAmount {
+	if amount.Int == nil {
+		return big.Zero()
+	}
+	return amount
+}
+
+// BytesSent returns the bytes sent over all transports
+func (s *RetrievalStats) BytesSent() uint64 {
+	return s.GraphsyncBytesSent + s.HTTPBytesSent
+}
+
+// SuccessRate returns the ratio of succeeded retrievals to finished retrievals
+func (s *RetrievalStats) SuccessRate() float64 {
+	total := s.Succeeded + s.Failed
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Succeeded) / float64(total)
+}
+
+type RetrievalStatsGroupBy string
+
+const (
+	// RetrievalStatsGroupByNone keeps the records of every time bucket
+	RetrievalStatsGroupByNone    RetrievalStatsGroupBy = ""
+	RetrievalStatsGroupByClient  RetrievalStatsGroupBy = "client"
+	RetrievalStatsGroupByPayload RetrievalStatsGroupBy = "payload"
+	RetrievalStatsGroupByMiner   RetrievalStatsGroupBy = "miner"
+)
+
+func ParseRetrievalStatsGroupBy(s string) (RetrievalStatsGroupBy, error) {
+	switch g := RetrievalStatsGroupBy(s); g {
+	case RetrievalStatsGroupByNone, RetrievalStatsGroupByClient, RetrievalStatsGroupByPayload, RetrievalStatsGroupByMiner:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown group by %s, expect one of client, payload and miner", s)
+	}
+}
+
+type RetrievalStatsQueryParams struct {
+	// From and To are unix seconds, the buckets which start in [From, To) are included,
+	// zero means unlimited
+	From int64
+	To   int64
+
+	// filters, empty value means no filter
+	Miner      address.Address
+	Client     string
+	PayloadCID cid.Cid
+
+	GroupBy RetrievalStatsGroupBy
+}
+
+// Match returns whether the key satisfies the filters of the params
+func (p *RetrievalStatsQueryParams) Match(key *RetrievalStatsKey) bool {
+	if p.From != 0 && key.Bucket < p.From {
+		return false
+	}
+	if p.To != 0 && key.Bucket >= p.To {
+		return false
+	}
+	if !p.Miner.Empty() && key.Miner != p.Miner {
+		return false
+	}
+	if len(p.Client) > 0 && key.Client != p.Client {
+		return false
+	}
+	if p.PayloadCID.Defined() && !key.PayloadCID.Equals(p.PayloadCID) {
+		return false
+	}
+	return true
+}
+
+// AggregateRetrievalStats merges the stats by the dimension of group by, the fields of the
+// key which are not grouped by are left empty
+func AggregateRetrievalStats(stats []*RetrievalStats, groupBy RetrievalStatsGroupBy) []*RetrievalStats {
+	if groupBy == RetrievalStatsGroupByNone {
+		return stats
+	}
+
+	var out []*RetrievalStats
+	index := make(map[RetrievalStatsKey]*RetrievalStats)
+	for _, s := range stats {
+		var key RetrievalStatsKey
+		switch groupBy {
+		case RetrievalStatsGroupByClient:
+			key.Client = s.Client
+		case RetrievalStatsGroupByPayload:
+			key.PayloadCID = s.PayloadCID
+		case RetrievalStatsGroupByMiner:
+			key.Miner = s.Miner
+		}
+
+		agg, ok := index[key]
+		if !ok {
+			agg = &RetrievalStats{RetrievalStatsKey: key, FundsReceived: big.Zero(), FundsSettled: big.Zero()}
+			index[key] = agg
+			out = append(out, agg)
+		}
+		agg.Add(s)
+	}
+	return out
+}
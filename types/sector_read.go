@@ -0,0 +1,29 @@
+package types
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+
+	sharedTypes "github.com/filecoin-project/venus/venus-shared/types"
+)
+
+// SectorsReadPieceMethod is the market event sent to the sealers to read a range of an unsealed piece from the sector,
+// the payload of the event is SectorReadRequest, and the payload of the response is the bytes read
+const SectorsReadPieceMethod = "SectorsReadPiece"
+
+// SectorsReadPieceSupportedMethod is the market event sent to a sealer once it connects, the sealers which support
+// SectorsReadPieceMethod reply to it without error, the other sealers never receive the read requests
+const SectorsReadPieceSupportedMethod = "SectorsReadPieceSupported"
+
+// SectorReadRequest reads a range of the unsealed piece from the sector of a deal
+type SectorReadRequest struct {
+	Miner    address.Address
+	PieceCid cid.Cid
+	Sid      abi.SectorNumber
+	// The offset of the piece in the sector
+	PieceOffset sharedTypes.UnpaddedByteIndex
+	// The range to read in the unpadded piece
+	Offset uint64
+	Size   uint64
+}